@@ -6,7 +6,12 @@ import (
 
 func GetNumTokensMinted() int64 {
 	var result float64
-	for i, block := range Blockchain {
+	// Before Kyoto, mining rewards were not recorded in blocks, so they are recomputed
+	legacyHeight := len(Blockchain)
+	if Env.Upgrades.Kyoto < legacyHeight {
+		legacyHeight = Env.Upgrades.Kyoto
+	}
+	for i, block := range Blockchain[:legacyHeight] {
 		if i > 0 {
 			lastBlock := Blockchain[i-1]
 			result += float64(len(block.TimeVerifiers)-len(lastBlock.TimeVerifiers)) * 0.1
@@ -15,8 +20,11 @@ func GetNumTokensMinted() int64 {
 		reward := CalculateBlockReward(minerCount, i)
 		result += reward
 	}
-	if len(Blockchain) > 50 {
-		result -= float64(int(GetMinerCount(len(Blockchain))) * BlocksBeforeReward) // First n blocks for each miner don't have a reward
+	if legacyHeight > RewardsStartHeight {
+		result -= float64(int(GetMinerCount(legacyHeight)) * BlocksBeforeReward) // First n blocks for each miner don't have a reward
+	}
+	for i := legacyHeight; i < len(Blockchain); i++ {
+		result += CoinbaseIssuance(i)
 	}
 	return int64(result)
 }
@@ -6,7 +6,7 @@ import (
 )
 
 func GetTPS(duration time.Duration) float64 {
-	now := time.Now()
+	now := Now()
 	txCount := 0
 	for i := len(Blockchain) - 1; i >= 0; i-- {
 		block := Blockchain[i]
@@ -24,6 +24,13 @@ func TestBlock(t *testing.T) {
 		b := []byte("321")
 		// Act
 		block := Block{
+			BlockHeader: BlockHeader{
+				Miner:             PublicKey{},
+				Nonce:             24,
+				Difficulty:        0,
+				PreviousBlockHash: [64]byte{},
+				Timestamp:         time.Time{},
+			},
 			Transactions: []Transaction{
 				{
 					Sender:    PublicKey{Y: a},
@@ -31,12 +38,7 @@ func TestBlock(t *testing.T) {
 					Amount:    2024,
 				},
 			},
-			Miner:                  PublicKey{},
-			Nonce:                  24,
 			MiningTime:             0,
-			Difficulty:             0,
-			PreviousBlockHash:      [64]byte{},
-			Timestamp:              time.Time{},
 			TimeVerifierSignatures: nil,
 			TimeVerifiers:          nil,
 		}
@@ -51,6 +53,13 @@ func TestBlock(t *testing.T) {
 		a := []byte("123")
 		b := []byte("321")
 		block := Block{
+			BlockHeader: BlockHeader{
+				Miner:             PublicKey{Y: a},
+				Nonce:             24,
+				Difficulty:        0,
+				PreviousBlockHash: [64]byte{},
+				Timestamp:         time.Now(),
+			},
 			Transactions: []Transaction{
 				{
 					Sender:    PublicKey{Y: a},
@@ -59,12 +68,7 @@ func TestBlock(t *testing.T) {
 					Timestamp: time.Now(),
 				},
 			},
-			Miner:                  PublicKey{Y: a},
-			Nonce:                  24,
 			MiningTime:             0,
-			Difficulty:             0,
-			PreviousBlockHash:      [64]byte{},
-			Timestamp:              time.Now(),
 			TimeVerifierSignatures: nil,
 			TimeVerifiers:          nil,
 		}
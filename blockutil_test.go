@@ -9,9 +9,13 @@ You should have received a copy of the GNU General Public License along with thi
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
 	"net/http"
 	"testing"
 
+	"cryptocurrency/harness"
 	. "cryptocurrency/node_util"
 	"github.com/stretchr/testify/assert"
 )
@@ -38,6 +42,60 @@ func TestSyncBlockchain(t *testing.T) {
 		}()
 		SyncBlockchain(-1)
 	})
+	t.Run("It ignores a longer peer chain that is invalid", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		honest, syncing := h.Nodes[0], h.Nodes[1]
+		h.Mine(honest, 3)
+		h.Connect(honest, syncing)
+		h.Intercept = func(from *harness.Node, to *harness.Node, req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/blockchain" {
+				return nil, nil
+			}
+			res := h.Deliver(from, to, req)
+			var chain []Block
+			assert.Nil(t, json.NewDecoder(res.Body).Decode(&chain))
+			chain[2].Difficulty++
+			tampered, err := json.Marshal(chain)
+			assert.Nil(t, err)
+			res.Body = io.NopCloser(bytes.NewReader(tampered))
+			res.ContentLength = int64(len(tampered))
+			return res, nil
+		}
+		// Act
+		syncing.Run(func() {
+			SyncBlockchain(-1)
+		})
+		// Assert
+		assert.Equal(t, 0, syncing.Height())
+	})
+	t.Run("It ignores a peer chain with a block before the median time past", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		honest, syncing := h.Nodes[0], h.Nodes[1]
+		h.Mine(honest, 3)
+		h.Connect(honest, syncing)
+		h.Intercept = func(from *harness.Node, to *harness.Node, req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/blockchain" {
+				return nil, nil
+			}
+			res := h.Deliver(from, to, req)
+			var chain []Block
+			assert.Nil(t, json.NewDecoder(res.Body).Decode(&chain))
+			chain[3].Timestamp = chain[1].Timestamp
+			tampered, err := json.Marshal(chain)
+			assert.Nil(t, err)
+			res.Body = io.NopCloser(bytes.NewReader(tampered))
+			res.ContentLength = int64(len(tampered))
+			return res, nil
+		}
+		// Act
+		syncing.Run(func() {
+			SyncBlockchain(-1)
+		})
+		// Assert
+		assert.Equal(t, 0, syncing.Height())
+	})
 }
 
 func TestGetBalance(t *testing.T) {
@@ -62,6 +120,9 @@ func TestGetBalance(t *testing.T) {
 			Y: key,
 		}
 		Append(Block{
+			BlockHeader: BlockHeader{
+				Miner: sender,
+			},
 			Transactions: []Transaction{
 				{
 					Sender:    sender,
@@ -69,7 +130,6 @@ func TestGetBalance(t *testing.T) {
 					Amount:    100,
 				},
 			},
-			Miner: sender,
 		})
 		// Act
 		balance := GetBalance(key)
@@ -97,7 +157,9 @@ func TestGetLastMinedBlock(t *testing.T) {
 		Append(GenesisBlock())
 		key := GetKey("").PublicKey
 		block := Block{
-			Miner: key,
+			BlockHeader: BlockHeader{
+				Miner: key,
+			},
 		}
 		Append(block)
 		// Act
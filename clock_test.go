@@ -0,0 +1,146 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "cryptocurrency/analysis"
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+var simulatedStart = time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+// useSimulatedClock replaces the node's clock until the test ends.
+func useSimulatedClock(t *testing.T) *SimulatedClock {
+	clock := NewSimulatedClock(simulatedStart)
+	CurrentClock = clock
+	t.Cleanup(func() {
+		CurrentClock = SystemClock{}
+	})
+	return clock
+}
+
+func verifyTime(t *testing.T, block Block) string {
+	blockJson, err := json.Marshal(block)
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	HandleVerifyTimeRequest(w, httptest.NewRequest("GET", "/verifyTime", strings.NewReader(string(blockJson))))
+	return w.Body.String()
+}
+
+func TestHandleVerifyTimeRequest(t *testing.T) {
+	t.Run("It signs blocks inside the verification window", func(t *testing.T) {
+		// Arrange
+		clock := useSimulatedClock(t)
+		block := Block{BlockHeader: BlockHeader{Timestamp: clock.Now()}}
+		clock.Advance(TimeVerificationWindow - time.Second)
+		// Act
+		response := verifyTime(t, block)
+		// Assert
+		assert.NotEqual(t, "invalid", response)
+	})
+	t.Run("It rejects blocks older than the verification window", func(t *testing.T) {
+		// Arrange
+		clock := useSimulatedClock(t)
+		block := Block{BlockHeader: BlockHeader{Timestamp: clock.Now()}}
+		clock.Advance(TimeVerificationWindow + time.Second)
+		// Act
+		response := verifyTime(t, block)
+		// Assert
+		assert.Equal(t, "invalid", response)
+	})
+	t.Run("It rejects blocks from the future", func(t *testing.T) {
+		// Arrange
+		clock := useSimulatedClock(t)
+		block := Block{BlockHeader: BlockHeader{Timestamp: clock.Now().Add(time.Second)}, MiningTime: time.Second}
+		// Act
+		response := verifyTime(t, block)
+		// Assert
+		assert.Equal(t, "invalid", response)
+	})
+}
+
+func TestFutureBlockTimestamps(t *testing.T) {
+	t.Run("It accepts future timestamps only within MaxFutureBlockTime", func(t *testing.T) {
+		// Arrange
+		clock := useSimulatedClock(t)
+		h := harness.New(t, 2)
+		clock.Advance(5 * time.Second)
+		block := h.Mine(h.Nodes[0], 1)[0]
+		clock.Advance(-5 * time.Second)
+		defer func() {
+			MaxFutureBlockTime = 0
+		}()
+		// Act
+		var strict, tolerant bool
+		h.Nodes[1].Run(func() {
+			strict = VerifyBlock(block)
+			MaxFutureBlockTime = 10 * time.Second
+			tolerant = VerifyBlock(block)
+		})
+		// Assert
+		assert.False(t, strict)
+		assert.True(t, tolerant)
+	})
+}
+
+func TestMedianTimePast(t *testing.T) {
+	t.Run("It returns the median of the previous blocks' timestamps", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		for _, seconds := range []int{0, 50, 10, 40, 20} {
+			Append(Block{BlockHeader: BlockHeader{Timestamp: simulatedStart.Add(time.Duration(seconds) * time.Second)}})
+		}
+		// Act
+		median := MedianTimePast(len(Blockchain))
+		// Assert
+		assert.Equal(t, simulatedStart.Add(20*time.Second), median)
+	})
+	t.Run("It moves new block timestamps past the median when the clock is behind", func(t *testing.T) {
+		// Arrange
+		clock := useSimulatedClock(t)
+		Blockchain = nil
+		for i := 1; i <= 3; i++ {
+			Append(Block{BlockHeader: BlockHeader{Timestamp: simulatedStart.Add(time.Duration(i) * time.Minute)}})
+		}
+		// Act
+		timestamp := NextBlockTimestamp()
+		clock.Advance(time.Hour)
+		later := NextBlockTimestamp()
+		// Assert
+		assert.Equal(t, simulatedStart.Add(2*time.Minute+time.Nanosecond), timestamp)
+		assert.Equal(t, simulatedStart.Add(time.Hour), later)
+	})
+}
+
+func TestGetTPS(t *testing.T) {
+	t.Run("It counts transactions in blocks within the duration", func(t *testing.T) {
+		// Arrange
+		clock := useSimulatedClock(t)
+		defer func() {
+			Blockchain = nil
+		}()
+		Blockchain = []Block{
+			{BlockHeader: BlockHeader{Timestamp: clock.Now().Add(-2 * time.Minute)}, Transactions: make([]Transaction, 30)},
+			{BlockHeader: BlockHeader{Timestamp: clock.Now().Add(-30 * time.Second)}, Transactions: make([]Transaction, 10)},
+			{BlockHeader: BlockHeader{Timestamp: clock.Now().Add(-10 * time.Second)}, Transactions: make([]Transaction, 20)},
+		}
+		// Act
+		tps := GetTPS(time.Minute)
+		// Assert
+		assert.Equal(t, 0.5, tps)
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"testing"
+
+	"cryptocurrency/analysis"
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoinbase(t *testing.T) {
+	t.Run("It starts every block with a coinbase that pays the miner", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		// Act
+		_, err := GenerateBlocks(3, key)
+		// Assert
+		assert.Nil(t, err)
+		total := 0.0
+		for i := 1; i < len(Blockchain); i++ {
+			transaction := Blockchain[i].Transactions[0]
+			assert.True(t, IsCoinbase(transaction))
+			assert.Equal(t, key.Y, transaction.Recipient.Y)
+			assert.Equal(t, BlockMiningReward(i), transaction.Amount)
+			assert.True(t, VerifyCoinbase(Blockchain[i], i))
+			total += transaction.Amount
+		}
+		assert.Greater(t, total, 0.0)
+		assert.InDelta(t, total, GetBalance(key.Y), 1e-6)
+		assert.Equal(t, int64(total), analysis.GetNumTokensMinted())
+	})
+	t.Run("It rejects a coinbase that pays more than the block earns", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		_, err := GenerateBlocks(1, key)
+		assert.Nil(t, err)
+		block := NewBlockTemplate(key).Block(0)
+		valid := VerifyCoinbase(block, len(Blockchain))
+		// Act
+		block.Transactions[0].Amount += 1
+		// Assert
+		assert.True(t, valid)
+		assert.False(t, VerifyCoinbase(block, len(Blockchain)))
+	})
+	t.Run("It rejects a coinbase that pays someone else", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		other, err := GenerateKey()
+		assert.Nil(t, err)
+		block := NewBlockTemplate(key).Block(0)
+		// Act
+		block.Transactions[0].Recipient = other.PublicKey
+		// Assert
+		assert.False(t, VerifyCoinbase(block, len(Blockchain)))
+	})
+	t.Run("It rejects coinbase transactions before Kyoto and after other transactions", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		block := NewBlockTemplate(key).Block(0)
+		transaction := Transaction{Sender: key, Recipient: key, Amount: 1}
+		misplaced := block
+		misplaced.Transactions = []Transaction{transaction, block.Transactions[0]}
+		// Act
+		misplacedValid := VerifyCoinbase(misplaced, len(Blockchain))
+		Env.Upgrades.Kyoto = 100
+		beforeKyotoValid := VerifyCoinbase(block, len(Blockchain))
+		// Assert
+		assert.False(t, misplacedValid)
+		assert.False(t, beforeKyotoValid)
+	})
+	t.Run("It pays each pre-mining time verifier and merges outputs to the same key", func(t *testing.T) {
+		// Arrange
+		miner := useRegtest(t)
+		verifier, err := GenerateKey()
+		assert.Nil(t, err)
+		// Act
+		coinbase := CoinbaseTransactions(1, miner, []PublicKey{verifier.PublicKey, miner, verifier.PublicKey}, nil)
+		// Assert
+		assert.Len(t, coinbase, 2)
+		assert.Equal(t, miner.Y, coinbase[0].Recipient.Y)
+		assert.InDelta(t, BlockSubsidy(1, miner)+VerifierReward, coinbase[0].Amount, 1e-6)
+		assert.Equal(t, verifier.PublicKey.Y, coinbase[1].Recipient.Y)
+		assert.InDelta(t, 2*VerifierReward, coinbase[1].Amount, 1e-6)
+	})
+	t.Run("It refuses transactions sent from the coinbase key", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		// Act
+		valid := VerifyTransaction(CoinbaseKey, key, "1", Now(), []byte("coinbase"))
+		// Assert
+		assert.False(t, valid)
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("It uses the defaults without a config file", func(t *testing.T) {
+		// Arrange
+		dataDir := t.TempDir()
+		// Act
+		config, path, err := LoadConfig(flag.NewFlagSet("node", flag.ContinueOnError), []string{"-datadir", dataDir})
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, "", path)
+		assert.Equal(t, dataDir, config.DataDir)
+		assert.Equal(t, "", config.Port)
+		assert.False(t, config.Serve)
+	})
+	t.Run("It lets flags override the environment and the environment override the config file", func(t *testing.T) {
+		// Arrange
+		dataDir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dataDir, "config.json"), []byte(`{"port": "9000", "logFormat": "json", "logLevel": "warn"}`), 0644)
+		assert.Nil(t, err)
+		t.Setenv("POLYCASH_PORT", "9001")
+		t.Setenv("POLYCASH_LOG_LEVEL", "debug")
+		// Act
+		config, path, err := LoadConfig(flag.NewFlagSet("node", flag.ContinueOnError), []string{"-datadir", dataDir, "-port", "9002", "-mine"})
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, filepath.Join(dataDir, "config.json"), path)
+		assert.Equal(t, "json", config.LogFormat)
+		assert.Equal(t, "debug", config.LogLevel)
+		assert.Equal(t, "9002", config.Port)
+		assert.True(t, config.Mine)
+		assert.True(t, config.Serve)
+	})
+	t.Run("It rejects unknown keys in the config file", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "node.json")
+		err := os.WriteFile(path, []byte(`{"prot": "9000"}`), 0644)
+		assert.Nil(t, err)
+		// Act
+		_, _, err = LoadConfig(flag.NewFlagSet("node", flag.ContinueOnError), []string{"-config", path})
+		// Assert
+		assert.ErrorContains(t, err, "prot")
+	})
+	t.Run("It reports every invalid setting", func(t *testing.T) {
+		// Act
+		_, _, err := LoadConfig(flag.NewFlagSet("node", flag.ContinueOnError), []string{"-datadir", t.TempDir(), "-port", "70000", "-log-level", "loud"})
+		// Assert
+		assert.ErrorContains(t, err, "port")
+		assert.ErrorContains(t, err, "loud")
+	})
+	t.Run("It serves when running a mining pool and checks the pool settings", func(t *testing.T) {
+		// Act
+		config, _, err := LoadConfig(flag.NewFlagSet("node", flag.ContinueOnError), []string{"-datadir", t.TempDir(), "-pool", "-pool-fee", "1.5"})
+		// Assert
+		assert.True(t, config.Serve)
+		assert.ErrorContains(t, err, "poolFee")
+	})
+	t.Run("It rejects light nodes that mine", func(t *testing.T) {
+		// Act
+		config, _, err := LoadConfig(flag.NewFlagSet("node", flag.ContinueOnError), []string{"-datadir", t.TempDir(), "-light", "-mine"})
+		// Assert
+		assert.True(t, config.Light)
+		assert.ErrorContains(t, err, "light nodes")
+	})
+}
+
+func TestDataPath(t *testing.T) {
+	t.Run("It resolves node files in the data directory", func(t *testing.T) {
+		// Arrange
+		DataDir = "nodes/a"
+		defer func() {
+			DataDir = "."
+		}()
+		// Act
+		path := DataPath("key.json")
+		// Assert
+		assert.Equal(t, filepath.Join("nodes", "a", "key.json"), path)
+		assert.Equal(t, "/tmp/receipts.json", DataPath("/tmp/receipts.json"))
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeContractRuntime writes a shell script standing in for the contract runtime. It is run with the contract's path and hash, like the real one.
+func fakeContractRuntime(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "contracts")
+	err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	assert.Nil(t, err)
+	return path
+}
+
+// useContractExecutor makes executor run the node's contracts for the test.
+func useContractExecutor(t *testing.T, executor ContractExecutor) {
+	previous := CurrentContractExecutor
+	t.Cleanup(func() {
+		CurrentContractExecutor = previous
+	})
+	CurrentContractExecutor = executor
+}
+
+func TestContractExecutor(t *testing.T) {
+	t.Run("It runs contracts in parallel, each from its own file", func(t *testing.T) {
+		// Arrange
+		executor := NewProcessContractExecutor(fakeContractRuntime(t, `sleep 0.2; echo "$1" >> "$(dirname "$0")/paths"; echo "Gas used: $(( $(wc -c < "$1") ))"`), 4)
+		useContractExecutor(t, executor)
+		contracts := []Contract{{Contents: "a"}, {Contents: "bb"}, {Contents: "dddd"}, {Contents: "eeeee"}}
+		// Act
+		start := time.Now()
+		results := ExecuteContracts(contracts)
+		elapsed := time.Since(start)
+		paths, err := os.ReadFile(filepath.Join(filepath.Dir(executor.Executable), "paths"))
+		// Assert
+		assert.Nil(t, err)
+		seen := make(map[string]bool)
+		for _, path := range strings.Fields(string(paths)) {
+			assert.False(t, seen[path])
+			seen[path] = true
+			assert.NoFileExists(t, path)
+		}
+		assert.Len(t, seen, len(contracts))
+		for i, result := range results {
+			assert.Nil(t, result.Err)
+			assert.Equal(t, float64(len(contracts[i].Contents)), result.GasUsed)
+		}
+		assert.Less(t, elapsed, 800*time.Millisecond)
+	})
+	t.Run("It runs no more contracts at once than it has workers", func(t *testing.T) {
+		// Arrange
+		useContractExecutor(t, NewProcessContractExecutor(fakeContractRuntime(t, `sleep 0.2; echo "Gas used: 1"`), 2))
+		// Act
+		start := time.Now()
+		results := ExecuteContracts([]Contract{{Contents: "a"}, {Contents: "bb"}, {Contents: "dddd"}, {Contents: "eeeee"}})
+		elapsed := time.Since(start)
+		// Assert
+		for _, result := range results {
+			assert.Nil(t, result.Err)
+		}
+		assert.GreaterOrEqual(t, elapsed, 400*time.Millisecond)
+	})
+	t.Run("It stops contracts that run too long or print too much", func(t *testing.T) {
+		// Arrange
+		slow := NewProcessContractExecutor(fakeContractRuntime(t, `sleep 5`), 1)
+		slow.Timeout = 100 * time.Millisecond
+		loud := NewProcessContractExecutor(fakeContractRuntime(t, `while true; do echo "Gas used: 1"; done`), 1)
+		loud.OutputLimit = 1000
+		// The hash is passed as it is, so it can't have a zero byte
+		hash := sha256.Sum256([]byte("a"))
+		// Act
+		start := time.Now()
+		_, slowErr := slow.Run("a", hash)
+		elapsed := time.Since(start)
+		_, loudErr := loud.Run("a", hash)
+		// Assert
+		assert.ErrorIs(t, slowErr, ErrContractTimeout)
+		assert.Less(t, elapsed, 2*time.Second)
+		assert.ErrorIs(t, loudErr, ErrContractOutputLimit)
+	})
+	t.Run("It sets the memory limit before the runtime starts", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("the memory limit is only enforced on Linux")
+		}
+		// Arrange
+		executor := NewProcessContractExecutor(fakeContractRuntime(t, `echo "Limit: $(ulimit -v)"`), 1)
+		executor.MemoryLimit = 1 << 30
+		// Act
+		out, err := executor.Run("a", sha256.Sum256([]byte("a")))
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, "Limit: 1048576\n", string(out))
+	})
+	t.Run("It tells contracts that were stopped from contracts that failed", func(t *testing.T) {
+		// Arrange
+		failed := NewProcessContractExecutor(fakeContractRuntime(t, `exit 1`), 1)
+		killed := NewProcessContractExecutor(fakeContractRuntime(t, `kill -9 $$`), 1)
+		hash := sha256.Sum256([]byte("a"))
+		// Act
+		_, failedErr := failed.Run("a", hash)
+		_, killedErr := killed.Run("a", hash)
+		// Assert
+		assert.NotNil(t, failedErr)
+		assert.NotErrorIs(t, failedErr, ErrContractUnfinished)
+		assert.ErrorIs(t, killedErr, ErrContractUnfinished)
+	})
+	t.Run("It rejects blocks with a contract that was stopped, and leaves out contracts that failed", func(t *testing.T) {
+		// Arrange
+		slow := NewProcessContractExecutor(fakeContractRuntime(t, `sleep 5`), 1)
+		slow.Timeout = 100 * time.Millisecond
+		block := Block{
+			Transactions: []Transaction{{Contracts: []Contract{{Contents: "a"}}}},
+			Transition:   StateTransition{UpdatedData: map[string][]byte{}},
+		}
+		// Act
+		useContractExecutor(t, slow)
+		stoppedValid := VerifySmartContractTransactions(block)
+		useContractExecutor(t, NewProcessContractExecutor(fakeContractRuntime(t, `exit 1`), 1))
+		failedValid := VerifySmartContractTransactions(block)
+		// Assert
+		assert.False(t, stoppedValid)
+		assert.True(t, failedValid)
+	})
+	t.Run("It is configured by the contract settings", func(t *testing.T) {
+		// Arrange
+		config := DefaultConfig()
+		config.ContractsExecutable = "/opt/polycash/contracts"
+		config.ContractWorkers = 3
+		// Act
+		executor := NewConfigContractExecutor(config)
+		// Assert
+		assert.Equal(t, "/opt/polycash/contracts", executor.Executable)
+		assert.Equal(t, 10*time.Second, executor.Timeout)
+		assert.Equal(t, uint64(2048<<20), executor.MemoryLimit)
+		assert.Equal(t, 1024<<10, executor.OutputLimit)
+	})
+}
@@ -15,9 +15,11 @@ pub struct BlockUtilInterface {
 
 impl BlockUtilInterface {
     pub fn new() -> Self {
-        // Read the executable path from the executable path file
-        let node_executable_path = std::fs::read_to_string("node_executable_path.txt")
-            .expect("Could not read node_executable_path.txt");
+        // Use the executable path the node passed in, or read it from the executable path file
+        let node_executable_path = std::env::var("POLYCASH_NODE_EXECUTABLE").unwrap_or_else(|_| {
+            std::fs::read_to_string("node_executable_path.txt")
+                .expect("Could not read node_executable_path.txt")
+        });
         // Remove the newline character
         // This is necessary because the path is read from a file
         let node_executable_path = node_executable_path.trim().to_string();
@@ -0,0 +1,146 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+// The canonical binary encoding of docs/encoding.md, matching node_util/encoding.go. Hashing is left to the caller.
+
+#[derive(Debug, Clone)]
+pub struct ContractParty {
+    pub signature: Vec<u8>,
+    pub public_key: Vec<u8>,
+}
+
+#[derive(Debug, Clone)]
+pub struct Contract {
+    pub contents: String,
+    pub parties: Vec<ContractParty>,
+    pub gas_used: f64,
+}
+
+#[derive(Debug, Clone)]
+pub struct Transaction {
+    pub sender: Vec<u8>,
+    pub recipient: Vec<u8>,
+    pub amount: f64,
+    pub sender_signature: Vec<u8>,
+    pub timestamp: i64,
+    pub fee: f64,
+    pub from_smart_contract: bool,
+    pub contracts: Vec<Contract>,
+    // The body as it was sent, not its JSON encoding
+    pub body: Vec<u8>,
+}
+
+#[derive(Debug, Clone)]
+pub struct BlockHeader {
+    pub version: u32,
+    pub previous_block_hash: [u8; 64],
+    pub merkle_root: [u8; 32],
+    // The SHA-256 hash of the encoded state transition
+    pub state_transition_hash: [u8; 32],
+    // The root of the state tree, only encoded from version 2
+    pub state_root: [u8; 32],
+    pub timestamp: i64,
+    pub difficulty: u64,
+    pub nonce: i64,
+    pub miner: Vec<u8>,
+}
+
+// Amounts and fees are rounded to 6 decimals, like the node's wire encoding
+fn wire_amount(x: f64) -> f64 {
+    format!("{:.6}", x).parse().unwrap_or(x)
+}
+
+fn push_u32(b: &mut Vec<u8>, v: u32) {
+    b.extend_from_slice(&v.to_be_bytes());
+}
+
+fn push_u64(b: &mut Vec<u8>, v: u64) {
+    b.extend_from_slice(&v.to_be_bytes());
+}
+
+fn push_i64(b: &mut Vec<u8>, v: i64) {
+    b.extend_from_slice(&v.to_be_bytes());
+}
+
+fn push_f64(b: &mut Vec<u8>, v: f64) {
+    push_u64(b, v.to_bits());
+}
+
+fn push_bytes(b: &mut Vec<u8>, v: &[u8]) {
+    push_u32(b, v.len() as u32);
+    b.extend_from_slice(v);
+}
+
+pub fn encode_transaction(transaction: &Transaction) -> Vec<u8> {
+    let mut b = Vec::new();
+    push_bytes(&mut b, &transaction.sender);
+    push_bytes(&mut b, &transaction.recipient);
+    push_f64(&mut b, wire_amount(transaction.amount));
+    push_bytes(&mut b, &transaction.sender_signature);
+    push_i64(&mut b, transaction.timestamp);
+    push_f64(&mut b, wire_amount(transaction.fee));
+    b.push(transaction.from_smart_contract as u8);
+    push_u32(&mut b, transaction.contracts.len() as u32);
+    for contract in &transaction.contracts {
+        push_bytes(&mut b, contract.contents.as_bytes());
+        push_u32(&mut b, contract.parties.len() as u32);
+        for party in &contract.parties {
+            push_bytes(&mut b, &party.signature);
+            push_bytes(&mut b, &party.public_key);
+        }
+        push_f64(&mut b, contract.gas_used);
+    }
+    push_bytes(&mut b, &transaction.body);
+    b
+}
+
+pub fn encode_signing_payload(sender: &[u8], recipient: &[u8], amount: f64, timestamp: i64, fee: f64) -> Vec<u8> {
+    let mut b = Vec::new();
+    push_bytes(&mut b, b"transaction");
+    push_bytes(&mut b, sender);
+    push_bytes(&mut b, recipient);
+    push_f64(&mut b, wire_amount(amount));
+    push_i64(&mut b, timestamp);
+    push_f64(&mut b, wire_amount(fee));
+    b
+}
+
+pub fn encode_state_transition(updated_data: &[(String, Vec<u8>)]) -> Vec<u8> {
+    let mut entries: Vec<&(String, Vec<u8>)> = updated_data.iter().collect();
+    entries.sort_by(|a, b| a.0.as_bytes().cmp(b.0.as_bytes()));
+    let mut b = Vec::new();
+    push_u32(&mut b, entries.len() as u32);
+    for (key, value) in entries {
+        push_bytes(&mut b, key.as_bytes());
+        push_bytes(&mut b, value);
+    }
+    b
+}
+
+pub fn encode_block_header(header: &BlockHeader) -> Vec<u8> {
+    let mut b = Vec::new();
+    push_u32(&mut b, header.version);
+    b.extend_from_slice(&header.previous_block_hash);
+    b.extend_from_slice(&header.merkle_root);
+    b.extend_from_slice(&header.state_transition_hash);
+    if header.version >= 2 {
+        b.extend_from_slice(&header.state_root);
+    }
+    push_i64(&mut b, header.timestamp);
+    push_u64(&mut b, header.difficulty);
+    push_i64(&mut b, header.nonce);
+    push_bytes(&mut b, &header.miner);
+    b
+}
+
+pub fn encode_time_verification(timestamp: i64) -> Vec<u8> {
+    let mut b = Vec::new();
+    push_bytes(&mut b, b"time");
+    push_i64(&mut b, timestamp);
+    b
+}
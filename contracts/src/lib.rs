@@ -8,6 +8,7 @@ You should have received a copy of the GNU General Public License along with thi
 */
 mod blockutil;
 pub mod buffer;
+pub mod encoding;
 pub mod math;
 pub mod read_contract;
 pub mod sanitization;
@@ -0,0 +1,100 @@
+extern crate contracts;
+
+#[cfg(test)]
+mod encoding_test {
+    use contracts::encoding::*;
+    use serde_json::Value;
+
+    fn vectors() -> Value {
+        let path = concat!(env!("CARGO_MANIFEST_DIR"), "/../docs/encoding_vectors.json");
+        let data = std::fs::read_to_string(path).unwrap();
+        serde_json::from_str(&data).unwrap()
+    }
+
+    fn bytes(v: &Value) -> Vec<u8> {
+        hex::decode(v.as_str().unwrap()).unwrap()
+    }
+
+    fn transaction(v: &Value) -> Transaction {
+        Transaction {
+            sender: bytes(&v["sender"]),
+            recipient: bytes(&v["recipient"]),
+            amount: v["amount"].as_f64().unwrap(),
+            sender_signature: bytes(&v["senderSignature"]),
+            timestamp: v["timestamp"].as_i64().unwrap(),
+            fee: v["fee"].as_f64().unwrap(),
+            from_smart_contract: v["fromSmartContract"].as_bool().unwrap(),
+            contracts: v["contracts"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|c| Contract {
+                    contents: c["contents"].as_str().unwrap().to_string(),
+                    parties: c["parties"]
+                        .as_array()
+                        .unwrap()
+                        .iter()
+                        .map(|p| ContractParty {
+                            signature: bytes(&p["signature"]),
+                            public_key: bytes(&p["publicKey"]),
+                        })
+                        .collect(),
+                    gas_used: c["gasUsed"].as_f64().unwrap(),
+                })
+                .collect(),
+            body: bytes(&v["body"]),
+        }
+    }
+
+    #[test]
+    fn test_encode_transaction() {
+        for v in vectors()["transactions"].as_array().unwrap() {
+            let transaction = transaction(v);
+            let payload = encode_signing_payload(
+                &transaction.sender,
+                &transaction.recipient,
+                transaction.amount,
+                transaction.timestamp,
+                transaction.fee,
+            );
+            assert_eq!(hex::encode(encode_transaction(&transaction)), v["encoding"].as_str().unwrap());
+            assert_eq!(hex::encode(payload), v["signingPayload"].as_str().unwrap());
+        }
+    }
+    #[test]
+    fn test_encode_state_transition() {
+        for v in vectors()["stateTransitions"].as_array().unwrap() {
+            let updated_data: Vec<(String, Vec<u8>)> = v["updatedData"]
+                .as_object()
+                .unwrap()
+                .iter()
+                .rev()
+                .map(|(key, value)| (key.clone(), bytes(value)))
+                .collect();
+            assert_eq!(hex::encode(encode_state_transition(&updated_data)), v["encoding"].as_str().unwrap());
+        }
+    }
+    #[test]
+    fn test_encode_block_header() {
+        let vectors = vectors();
+        for v in vectors["headers"].as_array().unwrap() {
+            let transition = &vectors["stateTransitions"][v["stateTransition"].as_u64().unwrap() as usize];
+            let header = BlockHeader {
+                version: v["version"].as_u64().unwrap() as u32,
+                previous_block_hash: bytes(&v["previousBlockHash"]).try_into().unwrap(),
+                merkle_root: bytes(&v["merkleRoot"]).try_into().unwrap(),
+                state_transition_hash: bytes(&transition["hash"]).try_into().unwrap(),
+                state_root: v.get("stateRoot").map_or([0; 32], |root| bytes(root).try_into().unwrap()),
+                timestamp: v["timestamp"].as_i64().unwrap(),
+                difficulty: v["difficulty"].as_u64().unwrap(),
+                nonce: v["nonce"].as_i64().unwrap(),
+                miner: bytes(&v["miner"]),
+            };
+            assert_eq!(hex::encode(encode_block_header(&header)), v["encoding"].as_str().unwrap());
+        }
+        for v in vectors["timeVerifications"].as_array().unwrap() {
+            let encoding = encode_time_verification(v["timestamp"].as_i64().unwrap());
+            assert_eq!(hex::encode(encoding), v["encoding"].as_str().unwrap());
+        }
+    }
+}
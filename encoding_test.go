@@ -0,0 +1,279 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+// The test vectors are shared with other implementations of the encoding, see docs/encoding.md.
+const encodingVectorsPath = "docs/encoding_vectors.json"
+
+type encodingVectors struct {
+	Transactions      []transactionVector      `json:"transactions"`
+	MerkleRoots       []merkleRootVector       `json:"merkleRoots"`
+	StateTransitions  []stateTransitionVector  `json:"stateTransitions"`
+	Headers           []headerVector           `json:"headers"`
+	StateRoots        []stateRootVector        `json:"stateRoots"`
+	TimeVerifications []timeVerificationVector `json:"timeVerifications"`
+}
+
+type transactionVector struct {
+	Name              string           `json:"name"`
+	Sender            string           `json:"sender"`
+	Recipient         string           `json:"recipient"`
+	Amount            float64          `json:"amount"`
+	SenderSignature   string           `json:"senderSignature"`
+	Timestamp         int64            `json:"timestamp"`
+	Fee               float64          `json:"fee"`
+	FromSmartContract bool             `json:"fromSmartContract"`
+	Contracts         []contractVector `json:"contracts"`
+	Body              string           `json:"body"`
+	Encoding          string           `json:"encoding"`
+	Leaf              string           `json:"leaf"`
+	SigningPayload    string           `json:"signingPayload"`
+	SigningHash       string           `json:"signingHash"`
+}
+
+type contractVector struct {
+	Contents string `json:"contents"`
+	Parties  []struct {
+		Signature string `json:"signature"`
+		PublicKey string `json:"publicKey"`
+	} `json:"parties"`
+	GasUsed float64 `json:"gasUsed"`
+}
+
+type merkleRootVector struct {
+	// Transactions are indexes into the transaction vectors
+	Transactions []int  `json:"transactions"`
+	Root         string `json:"root"`
+}
+
+type stateTransitionVector struct {
+	Name        string            `json:"name"`
+	UpdatedData map[string]string `json:"updatedData"`
+	Encoding    string            `json:"encoding"`
+	Hash        string            `json:"hash"`
+}
+
+type headerVector struct {
+	Name              string `json:"name"`
+	Version           int    `json:"version"`
+	PreviousBlockHash string `json:"previousBlockHash"`
+	MerkleRoot        string `json:"merkleRoot"`
+	// StateTransition is an index into the state transition vectors
+	StateTransition int `json:"stateTransition"`
+	// StateRoot is only set from StateRootBlockVersion
+	StateRoot  string `json:"stateRoot"`
+	Timestamp  int64  `json:"timestamp"`
+	Difficulty uint64 `json:"difficulty"`
+	Nonce      int64  `json:"nonce"`
+	Miner      string `json:"miner"`
+	Encoding   string `json:"encoding"`
+	Hash       string `json:"hash"`
+}
+
+type stateRootVector struct {
+	Name   string            `json:"name"`
+	Data   map[string]string `json:"data"`
+	Root   string            `json:"root"`
+	Proofs []struct {
+		Key      string   `json:"key"`
+		Included bool     `json:"included"`
+		Value    string   `json:"value"`
+		Bitmap   string   `json:"bitmap"`
+		Siblings []string `json:"siblings"`
+	} `json:"proofs"`
+}
+
+type timeVerificationVector struct {
+	Timestamp int64  `json:"timestamp"`
+	Encoding  string `json:"encoding"`
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.Nil(t, err)
+	return b
+}
+
+func loadEncodingVectors(t *testing.T) encodingVectors {
+	data, err := os.ReadFile(encodingVectorsPath)
+	assert.Nil(t, err)
+	var vectors encodingVectors
+	assert.Nil(t, json.Unmarshal(data, &vectors))
+	return vectors
+}
+
+func (v transactionVector) transaction(t *testing.T) Transaction {
+	transaction := Transaction{
+		Sender:            PublicKey{Y: decodeHex(t, v.Sender)},
+		Recipient:         PublicKey{Y: decodeHex(t, v.Recipient)},
+		Amount:            v.Amount,
+		SenderSignature:   Signature{S: decodeHex(t, v.SenderSignature)},
+		Timestamp:         time.Unix(0, v.Timestamp),
+		Fee:               v.Fee,
+		FromSmartContract: v.FromSmartContract,
+		Body:              decodeHex(t, v.Body),
+	}
+	for _, c := range v.Contracts {
+		contract := Contract{Contents: c.Contents, GasUsed: c.GasUsed}
+		for _, party := range c.Parties {
+			contract.Parties = append(contract.Parties, ContractParty{
+				Signature: Signature{S: decodeHex(t, party.Signature)},
+				PublicKey: PublicKey{Y: decodeHex(t, party.PublicKey)},
+			})
+		}
+		transaction.Contracts = append(transaction.Contracts, contract)
+	}
+	return transaction
+}
+
+func (v stateTransitionVector) stateTransition(t *testing.T) StateTransition {
+	transition := StateTransition{UpdatedData: make(map[string][]byte)}
+	for key, value := range v.UpdatedData {
+		transition.UpdatedData[key] = decodeHex(t, value)
+	}
+	return transition
+}
+
+func (v headerVector) block(t *testing.T, transitions []stateTransitionVector) Block {
+	block := Block{
+		BlockHeader: BlockHeader{
+			Version:    v.Version,
+			Timestamp:  time.Unix(0, v.Timestamp),
+			Difficulty: v.Difficulty,
+			Nonce:      v.Nonce,
+			Miner:      PublicKey{Y: decodeHex(t, v.Miner)},
+		},
+		Transition: transitions[v.StateTransition].stateTransition(t),
+	}
+	copy(block.PreviousBlockHash[:], decodeHex(t, v.PreviousBlockHash))
+	copy(block.MerkleRoot[:], decodeHex(t, v.MerkleRoot))
+	copy(block.TransitionHash[:], decodeHex(t, transitions[v.StateTransition].Hash))
+	copy(block.StateRoot[:], decodeHex(t, v.StateRoot))
+	return block
+}
+
+func (v stateRootVector) state(t *testing.T) State {
+	state := State{Data: make(map[string][]byte)}
+	for key, value := range v.Data {
+		state.Data[key] = decodeHex(t, value)
+	}
+	return state
+}
+
+func TestEncoding(t *testing.T) {
+	t.Run("It encodes and hashes transactions like the test vectors", func(t *testing.T) {
+		// Arrange
+		vectors := loadEncodingVectors(t)
+		for _, v := range vectors.Transactions {
+			transaction := v.transaction(t)
+			// Act
+			encoding := EncodeTransaction(transaction)
+			leaf := MerkleLeaf(transaction, BinaryBlockVersion)
+			payload := EncodeSigningPayload(transaction.Sender, transaction.Recipient, transaction.Amount, v.Timestamp, transaction.Fee)
+			signingHash := sha256.Sum256(payload)
+			// Assert
+			assert.Equal(t, v.Encoding, hex.EncodeToString(encoding), v.Name)
+			assert.Equal(t, v.Leaf, hex.EncodeToString(leaf[:]), v.Name)
+			assert.Equal(t, v.SigningPayload, hex.EncodeToString(payload), v.Name)
+			assert.Equal(t, v.SigningHash, hex.EncodeToString(signingHash[:]), v.Name)
+		}
+	})
+	t.Run("It builds Merkle roots and headers like the test vectors", func(t *testing.T) {
+		// Arrange
+		vectors := loadEncodingVectors(t)
+		for _, v := range vectors.MerkleRoots {
+			var transactions []Transaction
+			for _, i := range v.Transactions {
+				transactions = append(transactions, vectors.Transactions[i].transaction(t))
+			}
+			// Act
+			root := TransactionsMerkleRoot(transactions, BinaryBlockVersion)
+			// Assert
+			assert.Equal(t, v.Root, hex.EncodeToString(root[:]))
+		}
+		for _, v := range vectors.StateTransitions {
+			// Act
+			encoding := EncodeStateTransition(v.stateTransition(t))
+			hash := sha256.Sum256(encoding)
+			// Assert
+			assert.Equal(t, v.Encoding, hex.EncodeToString(encoding), v.Name)
+			assert.Equal(t, v.Hash, hex.EncodeToString(hash[:]), v.Name)
+		}
+		for _, v := range vectors.Headers {
+			block := v.block(t, vectors.StateTransitions)
+			// Act
+			encoding := EncodeBlockHeader(block.BlockHeader)
+			hash := HashBlock(block)
+			// Assert
+			assert.Equal(t, v.Encoding, hex.EncodeToString(encoding), v.Name)
+			assert.Equal(t, v.Hash, hex.EncodeToString(hash[:]), v.Name)
+		}
+		for _, v := range vectors.TimeVerifications {
+			// Act
+			encoding := EncodeTimeVerification(time.Unix(0, v.Timestamp))
+			// Assert
+			assert.Equal(t, v.Encoding, hex.EncodeToString(encoding))
+		}
+	})
+	t.Run("It builds state roots and proofs like the test vectors", func(t *testing.T) {
+		// Arrange
+		vectors := loadEncodingVectors(t)
+		for _, v := range vectors.StateRoots {
+			state := v.state(t)
+			// Act
+			root := StateRoot(state)
+			// Assert
+			assert.Equal(t, v.Root, hex.EncodeToString(root[:]), v.Name)
+			for _, p := range v.Proofs {
+				proof := NewStateMerkleProof(state, p.Key)
+				assert.Equal(t, StateMerkleProof{Bitmap: p.Bitmap, Siblings: p.Siblings}, proof, p.Key)
+				assert.True(t, VerifyStateMerkleProof(p.Key, decodeHex(t, p.Value), p.Included, proof, root), p.Key)
+			}
+		}
+	})
+	t.Run("It leaves out what changes when a block is relayed or attested", func(t *testing.T) {
+		// Arrange
+		env := Env
+		t.Cleanup(func() {
+			Env = env
+		})
+		Env.Upgrades.Oslo, Env.Upgrades.Paris = 5, 10
+		body, err := json.Marshal([]byte("hello"))
+		assert.Nil(t, err)
+		transaction := Transaction{Sender: PublicKey{Y: []byte("alice")}, Recipient: PublicKey{Y: []byte("bob")}, Amount: 1, Timestamp: time.Unix(0, 1), Body: body}
+		signed := transaction
+		signed.BodySignatures = []Signature{{S: []byte("signature")}}
+		block := Block{BlockHeader: BlockHeader{Version: BinaryBlockVersion, Timestamp: time.Unix(0, 1), Difficulty: 1}, Transactions: []Transaction{transaction}}
+		block.MerkleRoot = TransactionsMerkleRoot(block.Transactions, block.Version)
+		attested := block
+		attested.MiningTime = time.Minute
+		attested.TimeVerifiers = []PublicKey{{Y: []byte("verifier")}}
+		attested.TimeVerifierSignatures = []Signature{{S: []byte("signature")}}
+		// Act
+		relayedBody := EncodeTransaction(Transaction{Sender: transaction.Sender, Recipient: transaction.Recipient, Amount: 1, Timestamp: time.Unix(0, 1), Body: []byte("hello")})
+		// Assert
+		assert.Equal(t, EncodeTransaction(transaction), EncodeTransaction(signed))
+		assert.Equal(t, EncodeTransaction(transaction), relayedBody)
+		assert.Equal(t, HashBlock(block), HashBlock(attested))
+		assert.Equal(t, BinaryBlockVersion, BlockVersionAt(Env.Upgrades.Oslo))
+		assert.Equal(t, StateRootBlockVersion, BlockVersionAt(Env.Upgrades.Paris))
+	})
+}
@@ -35,3 +35,16 @@ func TestDecryptKey(t *testing.T) {
 		assert.False(t, IsKeyEncrypted())
 	})
 }
+
+func TestDecryptKeyWithWrongPassword(t *testing.T) {
+	t.Run("It returns an error and leaves the key encrypted", func(t *testing.T) {
+		// Arrange
+		assert.Nil(t, EncryptKey("0123456789abcdef"))
+		defer DecryptKey("0123456789abcdef")
+		// Act
+		err := DecryptKey("fedcba9876543210")
+		// Assert
+		assert.NotNil(t, err)
+		assert.True(t, IsKeyEncrypted())
+	})
+}
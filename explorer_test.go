@@ -0,0 +1,106 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func explorerTestTransaction(amount float64) Transaction {
+	return Transaction{
+		Sender:    PublicKey{Y: []byte("321")},
+		Recipient: PublicKey{Y: []byte("123")},
+		Amount:    amount,
+	}
+}
+
+func TestGetTransactionInfo(t *testing.T) {
+	t.Run("It finds a transaction with its position and confirmations", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		transaction := explorerTestTransaction(1)
+		Append(Block{Transactions: []Transaction{explorerTestTransaction(2), transaction}})
+		Append(Block{BlockHeader: BlockHeader{Difficulty: 1}})
+		// Act
+		info, ok := GetTransactionInfo(TransactionId(transaction))
+		// Assert
+		assert.True(t, ok)
+		assert.Equal(t, 1, info.BlockHeight)
+		assert.Equal(t, 1, info.Index)
+		assert.Equal(t, 2, info.Confirmations)
+	})
+	t.Run("It forgets transactions removed by a reorg", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		transaction := explorerTestTransaction(3)
+		Append(Block{Transactions: []Transaction{transaction}})
+		// Act
+		Blockchain = Blockchain[:1]
+		Append(Block{BlockHeader: BlockHeader{Difficulty: 2}})
+		_, ok := GetTransactionInfo(TransactionId(transaction))
+		// Assert
+		assert.False(t, ok)
+	})
+}
+
+func TestGetAddressHistoryInfo(t *testing.T) {
+	t.Run("It pages through an address's transactions, newest first", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		for i := 1; i <= 5; i++ {
+			Append(Block{Transactions: []Transaction{explorerTestTransaction(float64(i))}})
+		}
+		// Act
+		history := GetAddressHistoryInfo([]byte("123"), 1, 2)
+		// Assert
+		assert.Equal(t, 5, history.Total)
+		assert.Equal(t, 2, len(history.Transactions))
+		assert.Equal(t, float64(3), history.Transactions[0].Transaction.Amount)
+		assert.Equal(t, float64(2), history.Transactions[1].Transaction.Amount)
+	})
+}
+
+func TestHandleExplorerAddressRequest(t *testing.T) {
+	t.Run("It returns the address history as JSON", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		Append(Block{Transactions: []Transaction{explorerTestTransaction(1)}})
+		key := url.QueryEscape(base64.StdEncoding.EncodeToString([]byte("321")))
+		req := httptest.NewRequest(http.MethodGet, "/explorer/address?key="+key, nil)
+		w := httptest.NewRecorder()
+		// Act
+		HandleExplorerAddressRequest(w, req)
+		var history AddressHistory
+		err := json.Unmarshal(w.Body.Bytes(), &history)
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, 1, history.Total)
+	})
+	t.Run("It rejects a missing key", func(t *testing.T) {
+		// Arrange
+		req := httptest.NewRequest(http.MethodGet, "/explorer/address", nil)
+		w := httptest.NewRecorder()
+		// Act
+		HandleExplorerAddressRequest(w, req)
+		// Assert
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
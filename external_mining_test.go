@@ -0,0 +1,121 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"testing"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	. "cryptocurrency/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// solveRemote solves a template fetched over RPC, as external mining software would.
+func solveRemote(t *testing.T, result BlockTemplateResult) int64 {
+	t.Helper()
+	template, err := result.Template()
+	assert.Nil(t, err)
+	nonce, err := NewMiningEngine(2).Solve(context.Background(), template)
+	assert.Nil(t, err)
+	return nonce
+}
+
+func TestExternalMining(t *testing.T) {
+	t.Run("It mines a submitted block and broadcasts it", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		h.Mine(h.Nodes[0], 2)
+		id, err := h.Send(h.Nodes[0], h.Nodes[1], 0.5)
+		assert.Nil(t, err)
+		result, err := h.Nodes[0].Client.GetBlockTemplate(nil)
+		assert.Nil(t, err)
+		nonce := solveRemote(t, result)
+		// Act
+		block, err := h.Nodes[0].Client.SubmitBlock(result.Id, nonce)
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, 3, block.Height)
+		assert.Equal(t, h.Nodes[0].Key.PublicKey.Y, block.Block.Miner.Y)
+		h.AssertConverged()
+		receipt, err := h.Nodes[1].Client.GetReceipt(id)
+		assert.Nil(t, err)
+		assert.Equal(t, MinedStatus, receipt.Status)
+	})
+	t.Run("It pays a miner whose key the node does not hold", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		h.Mine(h.Nodes[0], 2)
+		_, err := h.Send(h.Nodes[0], h.Nodes[1], 0.5)
+		assert.Nil(t, err)
+		miner, err := GenerateKey()
+		assert.Nil(t, err)
+		result, err := h.Nodes[0].Client.GetBlockTemplate(miner.PublicKey.Y)
+		assert.Nil(t, err)
+		// Act
+		block, err := h.Nodes[0].Client.SubmitBlock(result.Id, solveRemote(t, result))
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, miner.PublicKey.Y, block.Block.Miner.Y)
+		h.AssertConverged()
+	})
+	t.Run("It rejects templates that a new block has made stale", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		h.Mine(h.Nodes[0], 2)
+		_, err := h.Send(h.Nodes[0], h.Nodes[1], 0.5)
+		assert.Nil(t, err)
+		result, err := h.Nodes[0].Client.GetBlockTemplate(nil)
+		assert.Nil(t, err)
+		nonce := solveRemote(t, result)
+		h.Mine(h.Nodes[1], 1)
+		// Act
+		_, err = h.Nodes[0].Client.SubmitBlock(result.Id, nonce)
+		// Assert
+		rpcErr, ok := err.(*RPCError)
+		assert.True(t, ok)
+		assert.Equal(t, RejectedCode, rpcErr.Code)
+		assert.Equal(t, ErrStaleTemplate.Error(), rpcErr.Message)
+	})
+	t.Run("It rejects templates it did not issue", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		// Act
+		_, err := h.Nodes[0].Client.SubmitBlock("unknown", 0)
+		// Assert
+		assert.Equal(t, ErrUnknownTemplate.Error(), err.Error())
+		assert.Equal(t, 0, h.Nodes[0].Height())
+	})
+	t.Run("It refuses to issue templates when there is nothing to mine", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		// Act
+		_, err := h.Nodes[0].Client.GetBlockTemplate(nil)
+		// Assert
+		assert.Equal(t, ErrPoolDry.Error(), err.Error())
+	})
+	t.Run("It mines remotely with a client-side engine", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		h.Mine(h.Nodes[0], 2)
+		_, err := h.Send(h.Nodes[0], h.Nodes[1], 0.5)
+		assert.Nil(t, err)
+		// Act
+		result, err := h.Nodes[0].Client.MineBlock(context.Background(), NewMiningEngine(2), h.Nodes[1].Key.PublicKey.Y)
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, 3, result.Height)
+		h.AssertConverged()
+	})
+}
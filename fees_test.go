@@ -0,0 +1,217 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	"cryptocurrency/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFees(t *testing.T) {
+	t.Run("It charges the sender the fee it signed and pays it to the miner", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		sender, miner := h.Nodes[0], h.Nodes[1]
+		h.Mine(sender, 2)
+		var before float64
+		sender.Run(func() {
+			before = GetBalance(sender.Key.PublicKey.Y)
+		})
+		// Act
+		_, err := sender.Client.SendTransaction(sender.Key, miner.Key.PublicKey, 1, 0.5, nil)
+		assert.Nil(t, err)
+		h.Mine(miner, 1)
+		// Assert
+		h.AssertConverged()
+		sender.Run(func() {
+			assert.InDelta(t, before-1.5, GetBalance(sender.Key.PublicKey.Y), 1e-6)
+			block := Blockchain[len(Blockchain)-1]
+			assert.Equal(t, 0.5, block.Transactions[1].Fee)
+			assert.InDelta(t, BlockSubsidy(len(Blockchain)-1, miner.Key.PublicKey)+0.5, BlockMiningReward(len(Blockchain)-1), 1e-6)
+		})
+	})
+	t.Run("It puts the best-paying transactions first", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		node := h.Nodes[0]
+		h.Mine(node, 2)
+		cheap, err := node.Client.SendTransaction(node.Key, h.Nodes[1].Key.PublicKey, 1, 0, nil)
+		assert.Nil(t, err)
+		expensive, err := node.Client.SendTransaction(node.Key, h.Nodes[1].Key.PublicKey, 1, 0.01, nil)
+		assert.Nil(t, err)
+		// Act
+		var template BlockTemplate
+		node.Run(func() {
+			template = NewBlockTemplate(node.Key.PublicKey)
+		})
+		// Assert
+		assert.Len(t, template.Transactions, 2)
+		assert.Equal(t, expensive, TransactionId(template.Transactions[0]))
+		assert.Equal(t, cheap, TransactionId(template.Transactions[1]))
+	})
+	t.Run("It rejects fees below the minimum and fees that were not signed", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		node := h.Nodes[0]
+		h.Mine(node, 2)
+		params, err := rpc.SignTransaction(node.Key, node.Key.PublicKey, 1, 1, nil, BinaryBlockVersion)
+		assert.Nil(t, err)
+		params.Fee = 0.5
+		// Act
+		_, lowErr := node.Client.SendTransaction(node.Key, node.Key.PublicKey, 1, TransactionFee/2, nil)
+		var result rpc.SubmitResult
+		tamperedErr := node.Client.Call("sendTransaction", params, &result)
+		// Assert
+		assert.ErrorContains(t, lowErr, "below the minimum fee")
+		assert.ErrorContains(t, tamperedErr, "Transaction is invalid")
+	})
+	t.Run("It rejects blocks with transactions paying less than the minimum fee", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		node := h.Nodes[0]
+		h.Mine(node, 2)
+		sign := func(fee float64) Transaction {
+			params, err := rpc.SignTransaction(node.Key, node.Key.PublicKey, 1, fee, nil, BinaryBlockVersion)
+			assert.Nil(t, err)
+			return Transaction{
+				Sender:          node.Key.PublicKey,
+				Recipient:       node.Key.PublicKey,
+				Amount:          params.Amount,
+				SenderSignature: Signature{S: params.Signature},
+				Timestamp:       time.Unix(0, params.Timestamp),
+				Fee:             params.Fee,
+			}
+		}
+		low := sign(TransactionFee / 2)
+		enough := sign(TransactionFee)
+		var lowValid, enoughValid bool
+		// Act
+		node.Run(func() {
+			lowValid = VerifyTransactions([]Transaction{low})
+			enoughValid = VerifyTransactions([]Transaction{enough})
+		})
+		// Assert
+		assert.False(t, lowValid)
+		assert.True(t, enoughValid)
+	})
+	t.Run("It refuses transactions whose sender can't pay the fee", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		node := h.Nodes[0]
+		h.Mine(node, 1)
+		var balance float64
+		node.Run(func() {
+			balance = GetBalance(node.Key.PublicKey.Y)
+		})
+		// Act
+		_, err := node.Client.SendTransaction(node.Key, node.Key.PublicKey, balance, 1, nil)
+		// Assert
+		assert.ErrorContains(t, err, "Transaction is invalid")
+	})
+	t.Run("It keeps the set fee when a transaction is sent over the network", func(t *testing.T) {
+		// Arrange
+		transaction := Transaction{Sender: CoinbaseKey, Recipient: CoinbaseKey, Amount: 1, Fee: 0.25}
+		legacy := Transaction{Sender: CoinbaseKey, Recipient: CoinbaseKey, Amount: 1}
+		// Act
+		encoded, err := json.Marshal(transaction)
+		assert.Nil(t, err)
+		legacyEncoded, err := json.Marshal(legacy)
+		assert.Nil(t, err)
+		var decoded Transaction
+		err = json.Unmarshal(encoded, &decoded)
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, 0.25, decoded.Fee)
+		assert.NotContains(t, string(legacyEncoded), "0.250000")
+		assert.Len(t, legacyEncoded, len(encoded)-len("^0.250000"))
+	})
+}
+
+func TestEstimateFee(t *testing.T) {
+	t.Run("It caps the number of blocks", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		h.Mine(h.Nodes[0], 2)
+		// Act
+		estimate, err := h.Nodes[0].Client.EstimateFee(math.MaxInt)
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, FeeEstimateBlocks, estimate.Blocks)
+		assert.Equal(t, 1.0, estimate.FeeRate)
+	})
+	t.Run("It recommends the minimum fee without any history", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		// Act
+		estimate, err := h.Nodes[0].Client.EstimateFee(1)
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, 1, estimate.Blocks)
+		assert.Equal(t, 1.0, estimate.FeeRate)
+		assert.InDelta(t, TransactionFee, estimate.Fee, 1e-9)
+	})
+	t.Run("It recommends what recent blocks accepted", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		node := h.Nodes[0]
+		h.Mine(node, 2)
+		_, err := node.Client.SendTransaction(node.Key, node.Key.PublicKey, 1, 10*TransactionFee, nil)
+		assert.Nil(t, err)
+		h.Mine(node, 1)
+		_, err = node.Client.SendTransaction(node.Key, node.Key.PublicKey, 1, 2*TransactionFee, nil)
+		assert.Nil(t, err)
+		h.Mine(node, 1)
+		// Act
+		fast, err := node.Client.EstimateFee(1)
+		assert.Nil(t, err)
+		slow, err := node.Client.EstimateFee(2)
+		assert.Nil(t, err)
+		// Assert
+		node.Run(func() {
+			// Empty bodies are sent as "null", which counts towards the minimum fee
+			assert.Equal(t, FeeRate(Blockchain[3].Transactions[1]), fast.FeeRate)
+			assert.Equal(t, FeeRate(Blockchain[4].Transactions[1]), slow.FeeRate)
+		})
+		assert.Greater(t, fast.FeeRate, 9.0)
+		assert.InDelta(t, fast.FeeRate*TransactionFee, fast.Fee, 1e-6)
+		assert.Greater(t, slow.FeeRate, 1.0)
+		assert.Less(t, slow.FeeRate, 2.0)
+	})
+	t.Run("It outbids a backlog in the mining pool", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		node := h.Nodes[0]
+		h.Mine(node, 2)
+		_, err := node.Client.SendTransaction(node.Key, h.Nodes[1].Key.PublicKey, 1, 3*TransactionFee, nil)
+		assert.Nil(t, err)
+		_, err = node.Client.SendTransaction(node.Key, h.Nodes[1].Key.PublicKey, 1, 5*TransactionFee, nil)
+		assert.Nil(t, err)
+		// Act
+		var estimate FeeEstimate
+		node.Run(func() {
+			estimate = EstimateFee(1)
+		})
+		// Assert
+		node.Run(func() {
+			// One block's worth of transactions pays more than the history's rate
+			assert.Equal(t, FeeRate(MiningTransactions[1]), estimate.FeeRate)
+		})
+		assert.Greater(t, estimate.FeeRate, 4.0)
+	})
+}
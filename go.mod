@@ -6,6 +6,7 @@ require (
 	github.com/open-quantum-safe/liboqs-go v0.0.0-20240412174151-8a109c3b4878
 	github.com/stretchr/testify v1.9.0
 	golang.org/x/crypto v0.24.0
+	golang.org/x/net v0.26.0
 )
 
 require (
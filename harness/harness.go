@@ -0,0 +1,322 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package harness runs several nodes in one process for network-level tests.
+//
+// Nodes talk over an in-memory HTTP transport instead of sockets. Node state lives in the node_util package globals, so only one node runs at a time: the harness swaps a node's NodeState in before it handles a request and out again afterwards. Requests a node makes to its peers are served synchronously in the same goroutine, so a harness must only be used from one goroutine.
+package harness
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	. "cryptocurrency/node_util"
+	. "cryptocurrency/rpc"
+)
+
+// Node is one node in a harness. Its files, including key.json and peers.txt, are in DataDir.
+type Node struct {
+	Name    string
+	Url     string
+	DataDir string
+	Key     PrivateKey
+	// Client calls the node's JSON-RPC API over the harness transport.
+	Client *Client
+
+	harness *Harness
+	state   NodeState
+	mux     *http.ServeMux
+}
+
+// Interceptor sees every request a node sends to another node. It returns a response or an error to use instead of serving the request, or nil and nil to serve it normally.
+type Interceptor func(from *Node, to *Node, req *http.Request) (*http.Response, error)
+
+type Harness struct {
+	Nodes []*Node
+	// Intercept, if set, is called for requests between nodes before the partition is checked. The simulation package uses it to add latency and loss.
+	Intercept Interceptor
+
+	tb        testing.TB
+	mutex     sync.Mutex
+	active    *Node
+	outer     NodeState
+	nodes     map[string]*Node
+	groups    map[*Node]int
+	transport http.RoundTripper
+	network   string
+	env       Environment
+}
+
+// New starts count nodes on the regtest network, each with its own data directory and key. The nodes are not connected; call ConnectAll or Connect. The harness is closed when the test finishes.
+func New(tb testing.TB, count int) *Harness {
+	tb.Helper()
+	h := &Harness{
+		tb:        tb,
+		nodes:     make(map[string]*Node),
+		transport: http.DefaultClient.Transport,
+		network:   CurrentNetwork.Name,
+		env:       Env,
+	}
+	http.DefaultClient.Transport = harnessTransport{h}
+	tb.Cleanup(h.Close)
+	if err := ApplyNetworkProfile("regtest"); err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		h.Nodes = append(h.Nodes, h.newNode(fmt.Sprintf("node%d", i)))
+	}
+	return h
+}
+
+func (h *Harness) newNode(name string) *Node {
+	h.tb.Helper()
+	dataDir := h.tb.TempDir()
+	key, err := GenerateKey()
+	if err != nil {
+		h.tb.Fatal(err)
+	}
+	keyJson, err := json.Marshal(key)
+	if err != nil {
+		h.tb.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "key.json"), keyJson, 0644); err != nil {
+		h.tb.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "peers.txt"), nil, 0600); err != nil {
+		h.tb.Fatal(err)
+	}
+	node := &Node{
+		Name:    name,
+		Url:     "http://" + name,
+		DataDir: dataDir,
+		Key:     key,
+		harness: h,
+		state:   NewNodeState(dataDir),
+		mux:     http.NewServeMux(),
+	}
+	node.state.IsMining = true
+	node.Client = &Client{Url: node.Url + "/rpc", HttpClient: &http.Client{Transport: harnessTransport{h}}}
+	RegisterHandlers(node.mux, true)
+	node.mux.HandleFunc("/rpc", HandleRPCRequest)
+	h.nodes[name] = node
+	node.Run(func() {
+		Append(GenesisBlock())
+	})
+	return node
+}
+
+// Close restores the process's own node state, network profile and HTTP transport.
+func (h *Harness) Close() {
+	http.DefaultClient.Transport = h.transport
+	_ = ApplyNetworkProfile(h.network)
+	Env = h.env
+}
+
+// Run calls fn with n's state in the node_util globals. Calls may nest: a node handling a request can send requests to other nodes.
+func (n *Node) Run(fn func()) {
+	h := n.harness
+	if h.active == nil {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		h.outer = CaptureNodeState()
+		defer func() {
+			RestoreNodeState(h.outer)
+		}()
+	}
+	previous := h.active
+	if previous != nil {
+		previous.state = CaptureNodeState()
+	}
+	RestoreNodeState(n.state)
+	h.active = n
+	defer func() {
+		n.state = CaptureNodeState()
+		h.active = previous
+		if previous != nil {
+			RestoreNodeState(previous.state)
+		}
+	}()
+	fn()
+}
+
+// Handle registers an extra handler on n's HTTP server, such as a mining pool's.
+func (n *Node) Handle(pattern string, handler http.Handler) {
+	n.mux.Handle(pattern, handler)
+}
+
+// Blockchain returns a copy of n's blockchain.
+func (n *Node) Blockchain() []Block {
+	return append([]Block(nil), n.state.Blockchain...)
+}
+
+// Height returns the height of n's last block.
+func (n *Node) Height() int {
+	return len(n.state.Blockchain) - 1
+}
+
+// Tip returns the hex hash of n's last block.
+func (n *Node) Tip() string {
+	hash := HashBlock(n.state.Blockchain[len(n.state.Blockchain)-1])
+	return hex.EncodeToString(hash[:])
+}
+
+// Connect makes a and b peers of each other.
+func (h *Harness) Connect(a *Node, b *Node) {
+	a.Run(func() {
+		if !PeerKnown(b.Url) {
+			AddPeer(b.Url + "\n")
+		}
+	})
+	b.Run(func() {
+		if !PeerKnown(a.Url) {
+			AddPeer(a.Url + "\n")
+		}
+	})
+}
+
+// ConnectAll makes every node a peer of every other node.
+func (h *Harness) ConnectAll() {
+	for i, a := range h.Nodes {
+		for _, b := range h.Nodes[i+1:] {
+			h.Connect(a, b)
+		}
+	}
+}
+
+// Partition splits the network so nodes can only reach nodes in their own group. Nodes not in any group cannot reach anyone.
+func (h *Harness) Partition(groups ...[]*Node) {
+	h.groups = make(map[*Node]int)
+	for i, group := range groups {
+		for _, node := range group {
+			h.groups[node] = i + 1
+		}
+	}
+}
+
+// Reachable reports whether from can currently send requests to to. Requests from outside the harness (from is nil) always get through.
+func (h *Harness) Reachable(from *Node, to *Node) bool {
+	if h.groups == nil || from == nil {
+		return true
+	}
+	return h.groups[from] != 0 && h.groups[from] == h.groups[to]
+}
+
+// NodeByUrl returns the node with the given URL, e.g. from a peers.txt entry.
+func (h *Harness) NodeByUrl(url string) (*Node, bool) {
+	node, ok := h.nodes[strings.TrimPrefix(strings.TrimSpace(url), "http://")]
+	return node, ok
+}
+
+// Heal removes the partition. Nodes do not catch up by themselves until they receive a block; call Sync to make them.
+func (h *Harness) Heal() {
+	h.groups = nil
+}
+
+// Send submits a signed transaction from one node's key to another's through from's JSON-RPC API and returns its ID.
+func (h *Harness) Send(from *Node, to *Node, amount float64) (string, error) {
+	return from.Client.SendTransaction(from.Key, to.Key.PublicKey, amount, 0, nil)
+}
+
+// Mine makes n generate count blocks paid to its key and broadcast them to its peers.
+func (h *Harness) Mine(n *Node, count int) []Block {
+	h.tb.Helper()
+	var blocks []Block
+	var err error
+	n.Run(func() {
+		blocks, err = GenerateBlocks(count, n.Key.PublicKey)
+	})
+	if err != nil {
+		h.tb.Fatal(err)
+	}
+	return blocks
+}
+
+// Sync makes every node sync its blockchain with its reachable peers.
+func (h *Harness) Sync() {
+	for _, node := range h.Nodes {
+		node.Run(func() {
+			SyncBlockchain(-1)
+		})
+	}
+}
+
+// Converged reports whether every node has the same last block.
+func (h *Harness) Converged() bool {
+	for _, node := range h.Nodes[1:] {
+		if node.Tip() != h.Nodes[0].Tip() {
+			return false
+		}
+	}
+	return true
+}
+
+// AssertConverged fails the test if the nodes do not all have the same last block.
+func (h *Harness) AssertConverged() {
+	h.tb.Helper()
+	if h.Converged() {
+		return
+	}
+	var tips []string
+	for _, node := range h.Nodes {
+		tips = append(tips, fmt.Sprintf("%s: height %d, tip %s", node.Name, node.Height(), node.Tip()[:16]))
+	}
+	h.tb.Fatalf("nodes have not converged:\n%s", strings.Join(tips, "\n"))
+}
+
+// harnessTransport serves requests to harness nodes by calling their handlers directly.
+type harnessTransport struct {
+	h *Harness
+}
+
+func (t harnessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := t.h
+	target, ok := h.nodes[req.URL.Host]
+	if !ok {
+		return nil, fmt.Errorf("harness: no node at %s", req.URL.Host)
+	}
+	from := h.active
+	if h.Intercept != nil && from != nil {
+		res, err := h.Intercept(from, target, req)
+		if res != nil || err != nil {
+			return res, err
+		}
+	}
+	if !h.Reachable(from, target) {
+		return nil, fmt.Errorf("harness: %s cannot reach %s", from.Name, target.Name)
+	}
+	return h.Deliver(from, target, req), nil
+}
+
+// Deliver serves req on to as if it came from from, ignoring Intercept and the partition.
+func (h *Harness) Deliver(from *Node, to *Node, req *http.Request) *http.Response {
+	serverReq := req.Clone(req.Context())
+	serverReq.RequestURI = req.URL.RequestURI()
+	serverReq.RemoteAddr = "test:0"
+	if from != nil {
+		serverReq.RemoteAddr = from.Name + ":0"
+	}
+	if serverReq.Body == nil {
+		serverReq.Body = http.NoBody
+	}
+	recorder := httptest.NewRecorder()
+	to.Run(func() {
+		to.mux.ServeHTTP(recorder, serverReq)
+	})
+	res := recorder.Result()
+	res.Request = req
+	return res
+}
@@ -0,0 +1,75 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"testing"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHarness(t *testing.T) {
+	t.Run("It propagates mined blocks to every peer", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 3)
+		h.ConnectAll()
+		// Act
+		h.Mine(h.Nodes[0], 2)
+		h.Mine(h.Nodes[1], 1)
+		// Assert
+		h.AssertConverged()
+		assert.Equal(t, 3, h.Nodes[2].Height())
+	})
+	t.Run("It propagates transactions and mines them", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		h.Mine(h.Nodes[0], 2)
+		// Act
+		id, err := h.Send(h.Nodes[0], h.Nodes[1], 0.5)
+		assert.Nil(t, err)
+		h.Mine(h.Nodes[1], 1)
+		// Assert
+		h.AssertConverged()
+		receipt, err := h.Nodes[0].Client.GetReceipt(id)
+		assert.Nil(t, err)
+		assert.Equal(t, MinedStatus, receipt.Status)
+		assert.Equal(t, 3, receipt.BlockHeight)
+	})
+	t.Run("It converges on the longest chain after a partition heals", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 4)
+		h.ConnectAll()
+		h.Mine(h.Nodes[0], 1)
+		h.Partition(h.Nodes[:2], h.Nodes[2:])
+		h.Mine(h.Nodes[0], 3)
+		h.Mine(h.Nodes[2], 1)
+		assert.False(t, h.Converged())
+		// Act
+		h.Heal()
+		h.Sync()
+		// Assert
+		h.AssertConverged()
+		assert.Equal(t, 4, h.Nodes[3].Height())
+	})
+	t.Run("It leaves the process's own node state alone", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		// Act
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		h.Mine(h.Nodes[0], 2)
+		// Assert
+		assert.Len(t, Blockchain, 1)
+		assert.Equal(t, ".", DataDir)
+	})
+}
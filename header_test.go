@@ -0,0 +1,80 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockHeader(t *testing.T) {
+	t.Run("It hashes blocks from their header alone", func(t *testing.T) {
+		// Arrange
+		transition := StateTransition{UpdatedData: map[string][]byte{"a": []byte("b")}}
+		transactions := []Transaction{explorerTestTransaction(1)}
+		block := Block{
+			BlockHeader: BlockHeader{
+				Version:        BinaryBlockVersion,
+				MerkleRoot:     TransactionsMerkleRoot(transactions, BinaryBlockVersion),
+				TransitionHash: HashStateTransition(transition),
+				Timestamp:      time.Unix(0, 1),
+				Difficulty:     1,
+			},
+			Transactions: transactions,
+			Transition:   transition,
+		}
+		headerOnly := Block{BlockHeader: block.BlockHeader}
+		changedHeader := block
+		changedHeader.Nonce++
+		// Act
+		hash := HashBlock(block)
+		// Assert
+		assert.Equal(t, hash, HashBlockHeader(block.BlockHeader))
+		assert.Equal(t, hash, HashBlock(headerOnly))
+		assert.NotEqual(t, hash, HashBlock(changedHeader))
+	})
+	t.Run("It keeps the header's fields inline in the block's JSON", func(t *testing.T) {
+		// Arrange
+		block := Block{BlockHeader: BlockHeader{Nonce: 42, Difficulty: 7}, Transactions: []Transaction{}}
+		// Act
+		encoded, err := json.Marshal(block)
+		assert.Nil(t, err)
+		var fields map[string]json.RawMessage
+		assert.Nil(t, json.Unmarshal(encoded, &fields))
+		var decoded Block
+		assert.Nil(t, json.Unmarshal([]byte(`{"nonce": 42, "difficulty": 7}`), &decoded))
+		// Assert
+		assert.Equal(t, "42", string(fields["nonce"]))
+		assert.NotContains(t, fields, "BlockHeader")
+		assert.Equal(t, block.BlockHeader, decoded.BlockHeader)
+	})
+	t.Run("It rejects blocks with a wrong transition hash, or one before Oslo", func(t *testing.T) {
+		// Arrange
+		useRegtest(t)
+		Env.Upgrades.Oslo = 5
+		transition := StateTransition{UpdatedData: map[string][]byte{"a": []byte("b")}}
+		block := Block{BlockHeader: BlockHeader{TransitionHash: HashStateTransition(transition)}, Transition: transition}
+		wrong := Block{BlockHeader: block.BlockHeader, Transition: StateTransition{UpdatedData: map[string][]byte{"a": []byte("c")}}}
+		// Act
+		valid := VerifyTransitionHash(block, 5)
+		wrongValid := VerifyTransitionHash(wrong, 5)
+		missingValid := VerifyTransitionHash(Block{Transition: transition}, 5)
+		beforeOsloValid := VerifyTransitionHash(block, 4)
+		// Assert
+		assert.True(t, valid)
+		assert.False(t, wrongValid)
+		assert.False(t, missingValid)
+		assert.False(t, beforeOsloValid)
+		assert.True(t, VerifyTransitionHash(Block{Transition: transition}, 4))
+	})
+}
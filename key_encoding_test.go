@@ -55,3 +55,12 @@ func TestEncodePublicKey(t *testing.T) {
 		assert.Equal(t, originalKey, DecodePublicKey(key))
 	})
 }
+
+func TestParsePublicKey(t *testing.T) {
+	t.Run("It returns an error when the key is malformed", func(t *testing.T) {
+		// Act
+		_, err := ParsePublicKey("[50 x]")
+		// Assert
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,213 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+// newLightHarness returns a harness whose first node is a full node and whose second is a light node that only knows the full node.
+func newLightHarness(t *testing.T) (*harness.Harness, *harness.Node, *harness.Node) {
+	h := harness.New(t, 2)
+	full, light := h.Nodes[0], h.Nodes[1]
+	light.Run(func() {
+		CurrentConfig.Light = true
+		AddPeer(full.Url + "\n")
+		LoadHeaders()
+	})
+	return h, full, light
+}
+
+// rewriteResponse returns res with its JSON body passed through rewrite.
+func rewriteResponse(t *testing.T, res *http.Response, rewrite func(body []byte) []byte) *http.Response {
+	body, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	body = rewrite(body)
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	return res
+}
+
+func TestLightNode(t *testing.T) {
+	t.Run("It syncs headers without storing the blockchain", func(t *testing.T) {
+		// Arrange
+		h, full, light := newLightHarness(t)
+		h.Mine(full, 3)
+		// Act
+		var length int
+		var tip [64]byte
+		var blockchainLength int
+		light.Run(func() {
+			SyncHeaders()
+			length = ChainLength()
+			tip = Headers[len(Headers)-1].Hash
+			blockchainLength = len(Blockchain)
+		})
+		// Assert
+		assert.Equal(t, full.Height()+1, length)
+		assert.Equal(t, full.Tip(), hex.EncodeToString(tip[:]))
+		assert.Equal(t, 1, blockchainLength)
+	})
+	t.Run("It proves balances and history against its headers", func(t *testing.T) {
+		for _, upgradeHeight := range []int{0, 3} {
+			// Arrange
+			h, full, light := newLightHarness(t)
+			Env.Upgrades.Kyoto, Env.Upgrades.Manila, Env.Upgrades.Oslo = upgradeHeight, upgradeHeight, upgradeHeight
+			h.Mine(full, 5)
+			var fullBalance float64
+			full.Run(func() {
+				fullBalance = GetBalance(full.Key.PublicKey.Y)
+			})
+			// Act
+			var balance float64
+			var history []TransactionInfo
+			var balanceErr, historyErr error
+			light.Run(func() {
+				SyncHeaders()
+				balance, balanceErr = LightBalance(full.Key.PublicKey.Y)
+				history, historyErr = LightHistory(full.Key.PublicKey.Y)
+			})
+			// Assert
+			assert.Nil(t, balanceErr)
+			assert.Nil(t, historyErr)
+			assert.Equal(t, fullBalance, balance, "upgrades at %d", upgradeHeight)
+			assert.NotEmpty(t, history)
+			for _, info := range history {
+				assert.Equal(t, full.Key.PublicKey.Y, info.Transaction.Recipient.Y)
+			}
+		}
+	})
+	t.Run("It rejects tampered headers", func(t *testing.T) {
+		// Arrange
+		h, full, light := newLightHarness(t)
+		h.Mine(full, 3)
+		h.Intercept = func(from *harness.Node, to *harness.Node, req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/headers" {
+				return nil, nil
+			}
+			return rewriteResponse(t, h.Deliver(from, to, req), func(body []byte) []byte {
+				var blocks []Block
+				assert.Nil(t, json.Unmarshal(body, &blocks))
+				blocks[len(blocks)-1].Difficulty++
+				tampered, err := json.Marshal(blocks)
+				assert.Nil(t, err)
+				return tampered
+			}), nil
+		}
+		// Act
+		var length int
+		light.Run(func() {
+			SyncHeaders()
+			length = ChainLength()
+		})
+		// Assert
+		assert.Equal(t, 1, length)
+	})
+	t.Run("It rejects headers before the median time past", func(t *testing.T) {
+		// Arrange
+		h, full, light := newLightHarness(t)
+		h.Mine(full, 3)
+		h.Intercept = func(from *harness.Node, to *harness.Node, req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/headers" {
+				return nil, nil
+			}
+			return rewriteResponse(t, h.Deliver(from, to, req), func(body []byte) []byte {
+				var blocks []Block
+				assert.Nil(t, json.Unmarshal(body, &blocks))
+				blocks[len(blocks)-1].Timestamp = blocks[0].Timestamp
+				tampered, err := json.Marshal(blocks)
+				assert.Nil(t, err)
+				return tampered
+			}), nil
+		}
+		// Act
+		var length int
+		light.Run(func() {
+			SyncHeaders()
+			length = ChainLength()
+		})
+		// Assert
+		assert.Equal(t, 1, length)
+	})
+	t.Run("It leaves out transactions a peer can't prove", func(t *testing.T) {
+		// Arrange
+		h, full, light := newLightHarness(t)
+		h.Mine(full, 2)
+		light.Run(func() {
+			SyncHeaders()
+		})
+		h.Intercept = func(from *harness.Node, to *harness.Node, req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/proof" {
+				return nil, nil
+			}
+			return rewriteResponse(t, h.Deliver(from, to, req), func(body []byte) []byte {
+				var proof TransactionProof
+				assert.Nil(t, json.Unmarshal(body, &proof))
+				proof.Transaction.Amount *= 10
+				forged, err := json.Marshal(proof)
+				assert.Nil(t, err)
+				return forged
+			}), nil
+		}
+		// Act
+		var history []TransactionInfo
+		var err error
+		light.Run(func() {
+			history, err = LightHistory(full.Key.PublicKey.Y)
+		})
+		// Assert
+		assert.Nil(t, err)
+		assert.Empty(t, history)
+	})
+	t.Run("It proves state values against its headers", func(t *testing.T) {
+		// Arrange
+		h, full, light := newLightHarness(t)
+		h.Mine(full, 2)
+		// Act
+		var included bool
+		var err error
+		light.Run(func() {
+			SyncHeaders()
+			_, included, err = LightStateValue("missing")
+		})
+		// Assert
+		assert.Nil(t, err)
+		assert.False(t, included)
+	})
+	t.Run("It proves the status of mined transactions", func(t *testing.T) {
+		// Arrange
+		h, full, light := newLightHarness(t)
+		h.Mine(full, 2)
+		id, err := h.Send(full, light, 0.5)
+		assert.Nil(t, err)
+		h.Mine(full, 1)
+		// Act
+		var receipt, unknown Receipt
+		var waitErr error
+		light.Run(func() {
+			receipt, waitErr = WaitForLightConfirmations(id, 1, 0)
+			unknown = ProveTransactionStatus(strings.Repeat("0", len(id)))
+		})
+		// Assert
+		assert.Nil(t, waitErr)
+		assert.Equal(t, MinedStatus, receipt.Status)
+		assert.Equal(t, 3, receipt.BlockHeight)
+		assert.Equal(t, UnknownStatus, unknown.Status)
+	})
+}
@@ -0,0 +1,130 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockLimits(t *testing.T) {
+	t.Run("It fills blocks with the best-paying transactions up to the limit and leaves the rest pending", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		node := h.Nodes[0]
+		h.Mine(node, 2)
+		CurrentNetwork.BlockLimits.MaxTransactions = 2
+		recipient := h.Nodes[1].Key.PublicKey
+		cheap, err := node.Client.SendTransaction(node.Key, recipient, 1, 0, nil)
+		assert.Nil(t, err)
+		better, err := node.Client.SendTransaction(node.Key, recipient, 1, 0.01, nil)
+		assert.Nil(t, err)
+		best, err := node.Client.SendTransaction(node.Key, recipient, 1, 0.02, nil)
+		assert.Nil(t, err)
+		// Act
+		full := h.Mine(node, 1)[0]
+		rest := h.Mine(node, 1)[0]
+		// Assert
+		h.AssertConverged()
+		assert.Len(t, full.Transactions, 3)
+		assert.Equal(t, best, TransactionId(full.Transactions[1]))
+		assert.Equal(t, better, TransactionId(full.Transactions[2]))
+		assert.Len(t, rest.Transactions, 2)
+		assert.Equal(t, cheap, TransactionId(rest.Transactions[1]))
+	})
+	t.Run("It leaves the state changes of transactions that don't fit out of the template", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		node := h.Nodes[0]
+		h.Mine(node, 2)
+		CurrentNetwork.BlockLimits.MaxTransactions = 2
+		recipient := h.Nodes[1].Key.PublicKey
+		for _, fee := range []float64{0, 0.01, 0.02} {
+			_, err := node.Client.SendTransaction(node.Key, recipient, 1, fee, nil)
+			assert.Nil(t, err)
+		}
+		var template BlockTemplate
+		// Act
+		node.Run(func() {
+			for _, transaction := range MiningTransactions {
+				address := FormatFee(transaction.Fee)
+				NextTransitions[TransactionHash(transaction)] = StateTransition{UpdatedData: map[string][]byte{address: []byte("updated")}}
+			}
+			template = NewBlockTemplate(node.Key.PublicKey)
+		})
+		// Assert
+		assert.Len(t, template.Transactions, 2)
+		assert.Len(t, template.Transition.UpdatedData, 2)
+		assert.Contains(t, template.Transition.UpdatedData, FormatFee(0.02))
+		assert.Contains(t, template.Transition.UpdatedData, FormatFee(0.01))
+		assert.NotContains(t, template.Transition.UpdatedData, FormatFee(0))
+	})
+	t.Run("It rejects blocks over the size, transaction or gas limit", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		CurrentNetwork.BlockLimits = BlockLimits{MaxSize: 1000, MaxTransactions: 2, MaxGas: 10}
+		transaction := Transaction{Sender: key, Recipient: key, Amount: 1, Timestamp: Now()}
+		coinbase := CoinbaseTransactions(1, key, nil, nil)
+		big := transaction
+		big.Body = []byte(strings.Repeat("a", 1000))
+		expensive := transaction
+		expensive.Contracts = []Contract{{GasUsed: 11}}
+		// Act
+		withinLimits := VerifyBlockLimits(Block{Transactions: append(coinbase, transaction, transaction)}, 1)
+		tooMany := VerifyBlockLimits(Block{Transactions: []Transaction{transaction, transaction, transaction}}, 1)
+		tooBig := VerifyBlockLimits(Block{Transactions: []Transaction{big}}, 1)
+		tooMuchGas := VerifyBlockLimits(Block{Transactions: []Transaction{expensive}}, 1)
+		// Assert
+		assert.True(t, withinLimits)
+		assert.False(t, tooMany)
+		assert.False(t, tooBig)
+		assert.False(t, tooMuchGas)
+		assert.False(t, FitsInBlock(big, 1))
+	})
+	t.Run("It only applies the limits from the Lima upgrade", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		Env.Upgrades.Lima = 5
+		CurrentNetwork.BlockLimits = BlockLimits{MaxTransactions: 1}
+		transaction := Transaction{Sender: key, Recipient: key, Amount: 1, Timestamp: Now()}
+		block := Block{Transactions: []Transaction{transaction, transaction}}
+		// Act
+		before := VerifyBlockLimits(block, 4)
+		after := VerifyBlockLimits(block, 5)
+		// Assert
+		assert.True(t, before)
+		assert.False(t, after)
+		assert.Equal(t, BlockLimits{}, BlockLimitsAt(4))
+		assert.Equal(t, CurrentNetwork.BlockLimits, BlockLimitsAt(5))
+	})
+	t.Run("It counts a relayed transaction at its original size", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		body, err := json.Marshal([]byte("hello"))
+		assert.Nil(t, err)
+		transaction := Transaction{Sender: key, Recipient: key, Amount: 1, Timestamp: Now(), Body: body, Contracts: []Contract{}}
+		relayed := transaction
+		// Act
+		for i := 0; i < 3; i++ {
+			encoded, err := json.Marshal(relayed)
+			assert.Nil(t, err)
+			relayed = Transaction{}
+			assert.Nil(t, json.Unmarshal(encoded, &relayed))
+		}
+		// Assert
+		assert.Greater(t, len(relayed.Body), len(transaction.Body))
+		assert.Equal(t, TransactionSize(transaction), TransactionSize(relayed))
+	})
+}
@@ -9,6 +9,10 @@ You should have received a copy of the GNU General Public License along with thi
 package main
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	. "cryptocurrency/node_util"
@@ -46,14 +50,90 @@ func TestError(t *testing.T) {
 		}()
 		Error("test", false)
 	})
-	t.Run("It panics when fatal is true", func(t *testing.T) {
-		panicOccurred := false
+	t.Run("It runs the shutdown hooks and exits when fatal is true", func(t *testing.T) {
+		// Arrange
+		exitCode := 0
+		hookRan := false
+		ExitFunc = func(code int) {
+			exitCode = code
+		}
 		defer func() {
-			if r := recover(); r != nil {
-				panicOccurred = true
-			}
+			ExitFunc = os.Exit
 		}()
+		OnShutdown(func() {
+			hookRan = true
+		})
+		// Act
 		Error("test", true)
-		assert.True(t, panicOccurred)
+		// Assert
+		assert.Equal(t, 1, exitCode)
+		assert.True(t, hookRan)
+	})
+}
+
+func TestLogger(t *testing.T) {
+	t.Run("It writes JSON entries with fields", func(t *testing.T) {
+		// Arrange
+		var builder strings.Builder
+		LogOutput = &builder
+		LogFormat = "json"
+		defer func() {
+			LogOutput = os.Stdout
+			LogFormat = "text"
+		}()
+		// Act
+		NewLogger("p2p").Info("Peer down.", Fields{"peer": "http://localhost:8080", "height": 3})
+		var entry map[string]interface{}
+		err := json.Unmarshal([]byte(builder.String()), &entry)
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, "info", entry["level"])
+		assert.Equal(t, "p2p", entry["subsystem"])
+		assert.Equal(t, "Peer down.", entry["msg"])
+		assert.Equal(t, "http://localhost:8080", entry["peer"])
+		assert.Equal(t, float64(3), entry["height"])
+	})
+	t.Run("It respects per-subsystem levels", func(t *testing.T) {
+		// Arrange
+		var builder strings.Builder
+		LogOutput = &builder
+		verbose := *Verbose
+		*Verbose = false
+		defer func() {
+			*Verbose = verbose
+			LogOutput = os.Stdout
+			SubsystemLogLevels = make(map[string]LogLevel)
+			DefaultLogLevel = InfoLevel
+		}()
+		err := ParseLogLevels("warn,mining=debug")
+		assert.Nil(t, err)
+		// Act
+		NewLogger("p2p").Info("hidden", nil)
+		NewLogger("mining").Debug("shown", nil)
+		// Assert
+		assert.NotContains(t, builder.String(), "hidden")
+		assert.Contains(t, builder.String(), "shown")
+	})
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("It rotates the file when it grows too large", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "node.log")
+		file, err := OpenRotatingFile(path, 10, 2)
+		assert.Nil(t, err)
+		defer file.Close()
+		// Act
+		_, err = file.Write([]byte("0123456789"))
+		assert.Nil(t, err)
+		_, err = file.Write([]byte("abc"))
+		assert.Nil(t, err)
+		// Assert
+		rotated, err := os.ReadFile(path + ".1")
+		assert.Nil(t, err)
+		current, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "0123456789", string(rotated))
+		assert.Equal(t, "abc", string(current))
 	})
 }
@@ -9,36 +9,60 @@ You should have received a copy of the GNU General Public License along with thi
 package main
 
 import (
+	"context"
 	. "cryptocurrency/node_interface"
 	. "cryptocurrency/node_util"
+	"cryptocurrency/pool"
 	. "cryptocurrency/rollup"
+	. "cryptocurrency/rpc"
 	. "cryptocurrency/testing"
 	"flag"
 	"net/http"
+	"os"
 )
 
 func main() {
-	mine := flag.Bool("mine", false, "Set to true to start node as miner")
-	serve := flag.Bool("serve", *mine, "Set to true to start node as server")
-	port := flag.String("port", "8080", "Port to listen on (server only)")
 	command := flag.String("command", "exit", "Run a command and exit")
-	Verbose = flag.Bool("verbose", false, "Set to true to enable verbose logging")
-	flag.Parse()
-	LoadStateCmd(nil)
-	SyncBlockchain(-1)
-	if len(Blockchain) == 0 {
-		Append(GenesisBlock())
+	config, configPath, err := LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		Error("Invalid configuration: "+err.Error(), true)
 	}
-	LoadEnv()
-	if *mine {
-		*serve = true
+	if err := ApplyConfig(config, configPath); err != nil {
+		Error(err.Error(), true)
 	}
-	if *serve {
-		if *mine {
+	if config.Light {
+		LoadHeaders()
+		SyncHeaders()
+		SaveHeaders()
+	} else {
+		LoadStateCmd(nil)
+		if len(Blockchain) > 0 && HashBlock(Blockchain[0]) != HashBlock(GenesisBlock()) {
+			Warn("The saved blockchain belongs to a different network. Starting from the genesis block.")
+			Blockchain = nil
+		}
+		SyncBlockchain(-1)
+		if len(Blockchain) == 0 {
+			Append(GenesisBlock())
+		}
+		LoadReceipts()
+	}
+	if config.Serve {
+		if config.Mine {
 			go Mine()
 		}
 		http.HandleFunc("/l2Transaction", HandleTransactionRequest)
-		Serve(*mine, *port)
+		http.HandleFunc("/rpc", HandleRPCRequest)
+		http.HandleFunc("/ws", HandleWebSocketRequest)
+		if config.Pool {
+			miningPool, err := pool.New(GetKey(""), pool.OptionsFromConfig(config))
+			if err != nil {
+				Error("Could not start the mining pool: "+err.Error(), true)
+			}
+			http.Handle("/pool/", miningPool.Handler())
+			go miningPool.Run(context.Background())
+		}
+		// A pool hands out the transactions in the node's mining pool, so it accepts /mine requests like a miner.
+		Serve(config.Mine || config.Pool, config.Port)
 	} else {
 		if *command == "exit" {
 			StartCmdLine()
@@ -0,0 +1,132 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleProof(t *testing.T) {
+	t.Run("It proves every transaction of blocks of any size", func(t *testing.T) {
+		for count := 1; count <= 7; count++ {
+			// Arrange
+			var transactions []Transaction
+			for i := 0; i < count; i++ {
+				transactions = append(transactions, explorerTestTransaction(float64(i)))
+			}
+			root := TransactionsMerkleRoot(transactions, BinaryBlockVersion)
+			for i, transaction := range transactions {
+				// Act
+				proof := NewMerkleProof(transactions, i, BinaryBlockVersion)
+				wrongIndex := proof
+				wrongIndex.Index = (i + 1) % count
+				// Assert
+				assert.True(t, VerifyMerkleProof(MerkleLeaf(transaction, BinaryBlockVersion), proof, root))
+				if count > 1 {
+					assert.False(t, VerifyMerkleProof(MerkleLeaf(transaction, BinaryBlockVersion), wrongIndex, root))
+					assert.False(t, VerifyMerkleProof(MerkleLeaf(transactions[(i+1)%count], BinaryBlockVersion), proof, root))
+				}
+			}
+		}
+	})
+	t.Run("It rejects proofs with tampered siblings", func(t *testing.T) {
+		// Arrange
+		transactions := []Transaction{explorerTestTransaction(1), explorerTestTransaction(2), explorerTestTransaction(3)}
+		root := TransactionsMerkleRoot(transactions, BinaryBlockVersion)
+		proof := NewMerkleProof(transactions, 0, BinaryBlockVersion)
+		tampered := MerkleProof{Index: proof.Index, Count: proof.Count, Siblings: append([]string{}, proof.Siblings...)}
+		tampered.Siblings[0] = hex.EncodeToString(make([]byte, 32))
+		// Act
+		valid := VerifyMerkleProof(MerkleLeaf(transactions[0], BinaryBlockVersion), tampered, root)
+		// Assert
+		assert.False(t, valid)
+		assert.False(t, VerifyMerkleProof(MerkleLeaf(transactions[0], BinaryBlockVersion), MerkleProof{Index: 0, Count: 3, Siblings: proof.Siblings[:1]}, root))
+	})
+	t.Run("It gives a relayed transaction the same leaf", func(t *testing.T) {
+		// Arrange
+		key := PublicKey{Y: []byte("123")}
+		body, err := json.Marshal([]byte("hello"))
+		assert.Nil(t, err)
+		transaction := Transaction{Sender: key, Recipient: key, Amount: 1, Timestamp: Now(), Body: body, BodySignatures: []Signature{{S: []byte("signature")}}}
+		relayed := transaction
+		// Act
+		for i := 0; i < 3; i++ {
+			encoded, err := json.Marshal(relayed)
+			assert.Nil(t, err)
+			relayed = Transaction{}
+			assert.Nil(t, json.Unmarshal(encoded, &relayed))
+		}
+		// Assert
+		assert.Equal(t, MerkleLeaf(transaction, LegacyBlockVersion), MerkleLeaf(relayed, LegacyBlockVersion))
+		assert.Equal(t, MerkleLeaf(transaction, BinaryBlockVersion), MerkleLeaf(relayed, BinaryBlockVersion))
+	})
+}
+
+func TestMerkleRoot(t *testing.T) {
+	t.Run("It commits mined blocks to their transactions and serves proofs for them", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		node, peer := h.Nodes[0], h.Nodes[1]
+		h.Mine(node, 2)
+		id, err := h.Send(node, peer, 1)
+		assert.Nil(t, err)
+		block := h.Mine(node, 1)[0]
+		h.AssertConverged()
+		// Act
+		var proof TransactionProof
+		node.Run(func() {
+			proof, err = RequestTransactionProof(peer.Url, id)
+		})
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, TransactionsMerkleRoot(block.Transactions, block.Version), block.MerkleRoot)
+		assert.Equal(t, hex.EncodeToString(block.MerkleRoot[:]), proof.MerkleRoot)
+		assert.Equal(t, 3, proof.BlockHeight)
+		assert.True(t, VerifyTransactionProof(proof))
+	})
+	t.Run("It refuses to prove transactions it doesn't have", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		node := h.Nodes[0]
+		// Act
+		var err error
+		node.Run(func() {
+			_, err = RequestTransactionProof(h.Nodes[1].Url, TransactionId(explorerTestTransaction(1)))
+		})
+		// Assert
+		assert.ErrorContains(t, err, "transaction not found")
+	})
+	t.Run("It rejects blocks with a wrong Merkle root, or one before Manila", func(t *testing.T) {
+		// Arrange
+		useRegtest(t)
+		Env.Upgrades.Manila = 5
+		transactions := []Transaction{explorerTestTransaction(1), explorerTestTransaction(2)}
+		block := Block{BlockHeader: BlockHeader{MerkleRoot: TransactionsMerkleRoot(transactions, LegacyBlockVersion)}, Transactions: transactions}
+		wrong := Block{BlockHeader: BlockHeader{MerkleRoot: block.MerkleRoot}, Transactions: transactions[:1]}
+		// Act
+		valid := VerifyMerkleRoot(block, 5)
+		wrongValid := VerifyMerkleRoot(wrong, 5)
+		missingValid := VerifyMerkleRoot(Block{Transactions: transactions}, 5)
+		beforeManilaValid := VerifyMerkleRoot(block, 4)
+		// Assert
+		assert.True(t, valid)
+		assert.False(t, wrongValid)
+		assert.False(t, missingValid)
+		assert.False(t, beforeManilaValid)
+		assert.True(t, VerifyMerkleRoot(Block{Transactions: transactions}, 4))
+	})
+}
@@ -0,0 +1,57 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	t.Run("It counts observations into cumulative buckets", func(t *testing.T) {
+		// Arrange
+		histogram := NewHistogram("test_histogram_seconds", "A test histogram.", []float64{1, 5})
+		// Act
+		histogram.Observe(0.5)
+		histogram.Observe(2)
+		histogram.Observe(10)
+		var builder strings.Builder
+		WriteMetrics(&builder)
+		output := builder.String()
+		// Assert
+		assert.Contains(t, output, "# TYPE test_histogram_seconds histogram")
+		assert.Contains(t, output, `test_histogram_seconds_bucket{le="1"} 1`)
+		assert.Contains(t, output, `test_histogram_seconds_bucket{le="5"} 2`)
+		assert.Contains(t, output, `test_histogram_seconds_bucket{le="+Inf"} 3`)
+		assert.Contains(t, output, "test_histogram_seconds_sum 12.5")
+		assert.Contains(t, output, "test_histogram_seconds_count 3")
+	})
+}
+
+func TestHandleMetricsRequest(t *testing.T) {
+	t.Run("It reports the block height and labeled counters", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		Append(Block{BlockHeader: BlockHeader{Difficulty: 1}})
+		TimeVerificationCounter.WithLabel("signed").Inc()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		// Act
+		HandleMetricsRequest(w, req)
+		// Assert
+		assert.Contains(t, w.Body.String(), "polycash_block_height 1\n")
+		assert.Contains(t, w.Body.String(), `polycash_time_verification_responses_total{result="signed"}`)
+	})
+}
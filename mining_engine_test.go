@@ -0,0 +1,113 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiningEngine(t *testing.T) {
+	t.Run("It finds a nonce that solves the template with several workers", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		template := NewBlockTemplate(key)
+		template.Difficulty = 200
+		engine := NewMiningEngine(4)
+		// Act
+		nonce, err := engine.Solve(context.Background(), template)
+		// Assert
+		assert.Nil(t, err)
+		assert.True(t, template.Solves(nonce))
+		assert.Greater(t, engine.Hashes(), uint64(0))
+	})
+	t.Run("It stops promptly when cancelled and reports its hashrate", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		template := NewBlockTemplate(key)
+		template.Difficulty = MaximumUint64
+		engine := NewMiningEngine(2)
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		// Act
+		_, err := engine.Solve(ctx, template)
+		// Assert
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 2*time.Second)
+		assert.Greater(t, engine.Hashrate(), 0.0)
+		assert.Equal(t, engine.Hashrate(), MiningHashrateGauge.Value())
+	})
+	t.Run("It uses one worker per CPU by default", func(t *testing.T) {
+		// Act
+		engine := NewMiningEngine(0)
+		// Assert
+		assert.Greater(t, engine.Workers, 0)
+	})
+}
+
+func TestBlockTemplate(t *testing.T) {
+	t.Run("It snapshots the chain tip and mining pool", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		MiningTransactions = []Transaction{{Sender: key, Recipient: key, Amount: 1}}
+		defer func() {
+			MiningTransactions = nil
+		}()
+		// Act
+		template := NewBlockTemplate(key)
+		MiningTransactions = append(MiningTransactions, Transaction{Sender: key, Recipient: key, Amount: 2})
+		_, err := GenerateBlocks(1, key)
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, 1, template.Height)
+		assert.Equal(t, HashBlock(Blockchain[0]), template.PreviousBlockHash)
+		assert.Len(t, template.Transactions, 1)
+		assert.Equal(t, 2, NewBlockTemplate(key).Height)
+	})
+	t.Run("It leaves out transactions that have been mined", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		mined := Transaction{Sender: key, Recipient: key, Amount: 3, Timestamp: time.Unix(3, 0)}
+		pending := Transaction{Sender: key, Recipient: key, Amount: 4, Timestamp: time.Unix(4, 0)}
+		TransactionHashes[TransactionHash(mined)] = 2
+		MiningTransactions = []Transaction{mined, pending}
+		defer func() {
+			MiningTransactions = nil
+		}()
+		// Act
+		template := NewBlockTemplate(key)
+		// Assert
+		assert.Equal(t, []Transaction{pending}, template.Transactions)
+		assert.Equal(t, []Transaction{pending}, MiningTransactions)
+	})
+	t.Run("It builds blocks that the network accepts", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		var block Block
+		var err error
+		h.Nodes[0].Run(func() {
+			template := NewBlockTemplate(h.Nodes[0].Key.PublicKey)
+			nonce, solveErr := NewMiningEngine(2).Solve(context.Background(), template)
+			assert.Nil(t, solveErr)
+			// Act
+			block, err = FinalizeBlock(template, nonce)
+		})
+		// Assert
+		assert.Nil(t, err)
+		h.Nodes[1].Run(func() {
+			assert.True(t, VerifyBlock(block))
+		})
+	})
+}
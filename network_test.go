@@ -0,0 +1,94 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyNetworkProfile(t *testing.T) {
+	env := Env
+	defer func() {
+		_ = ApplyNetworkProfile(DefaultNetwork)
+		Env = env
+	}()
+	t.Run("It sets the consensus parameters of the network", func(t *testing.T) {
+		// Act
+		err := ApplyNetworkProfile("devnet")
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, "devnet", CurrentNetwork.Name)
+		assert.Equal(t, "devnet", Env.Network)
+		assert.Equal(t, NetworkProfiles["devnet"].MinimumBlockDifficulty, MinimumBlockDifficulty)
+		assert.Equal(t, NetworkProfiles["devnet"].InitialBlockDifficulty, InitialBlockDifficulty)
+		assert.Equal(t, NetworkProfiles["devnet"].FeesStartHeight, FeesStartHeight)
+		assert.Equal(t, NetworkProfiles["devnet"].Upgrades, Env.Upgrades)
+	})
+	t.Run("It gives every network its own genesis block", func(t *testing.T) {
+		// Act
+		hashes := make(map[[64]byte]string)
+		for _, name := range NetworkNames() {
+			_ = ApplyNetworkProfile(name)
+			hashes[HashBlock(GenesisBlock())] = name
+		}
+		// Assert
+		assert.Len(t, hashes, len(NetworkProfiles))
+	})
+	t.Run("It returns an error for an unknown network", func(t *testing.T) {
+		// Act
+		err := ApplyNetworkProfile("moonnet")
+		// Assert
+		assert.ErrorContains(t, err, "moonnet")
+	})
+	t.Run("It overrides only the upgrade heights env.json sets", func(t *testing.T) {
+		// Arrange
+		dataDir := DataDir
+		DataDir = t.TempDir()
+		defer func() {
+			_ = os.WriteFile(filepath.Join(DataDir, "env.json"), []byte("{}"), 0644)
+			LoadEnv()
+			DataDir = dataDir
+		}()
+		err := os.WriteFile(filepath.Join(DataDir, "env.json"), []byte(`{"network": "testnet", "upgrades": {"paris": 100}}`), 0644)
+		assert.Nil(t, err)
+		LoadEnv()
+		// Act
+		err = ApplyNetworkProfile("testnet")
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, 100, Env.Upgrades.Paris)
+		assert.Equal(t, NetworkProfiles["testnet"].Upgrades.Manila, Env.Upgrades.Manila)
+		assert.Equal(t, NetworkProfiles["testnet"].Upgrades.Oslo, Env.Upgrades.Oslo)
+	})
+	t.Run("It refuses upgrade heights that activate Oslo before Manila", func(t *testing.T) {
+		// Arrange
+		dataDir := DataDir
+		DataDir = t.TempDir()
+		defer func() {
+			_ = os.WriteFile(filepath.Join(DataDir, "env.json"), []byte("{}"), 0644)
+			LoadEnv()
+			DataDir = dataDir
+			_ = ApplyNetworkProfile(DefaultNetwork)
+		}()
+		err := os.WriteFile(filepath.Join(DataDir, "env.json"), []byte(`{"network": "testnet", "upgrades": {"manila": 65}}`), 0644)
+		assert.Nil(t, err)
+		LoadEnv()
+		upgrades := Env.Upgrades
+		// Act
+		err = ApplyNetworkProfile("testnet")
+		// Assert
+		assert.ErrorContains(t, err, "Oslo")
+		assert.Equal(t, upgrades, Env.Upgrades)
+	})
+}
@@ -10,9 +10,12 @@ package node_interface
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	. "cryptocurrency/analysis"
 	. "cryptocurrency/node_util"
 	. "cryptocurrency/rollup"
+	"cryptocurrency/rpc"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -20,8 +23,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
-
-	"github.com/open-quantum-safe/liboqs-go/oqs"
+	"time"
 )
 
 var commands = map[string]func([]string){
@@ -46,34 +48,133 @@ var commands = map[string]func([]string){
 	"startAnalysisConsole": StartAnalysisConsoleCmd,
 	"sendWithBody":         SendWithBodyCmd,
 	"getBlockchainLen":     GetBlockchainLenCmd,
+	"txstatus":             TxStatusCmd,
+	"config":               ConfigCmd,
+	"generate":             GenerateCmd,
+	"remotemine":           RemoteMineCmd,
+	"estimatefee":          EstimateFeeCmd,
+	"history":              HistoryCmd,
 }
 
+// SendWaitTimeout is how long send waits for the requested confirmation depth.
+var SendWaitTimeout = 30 * time.Minute
+
 func SyncCmd(fields []string) {
+	if CurrentConfig.Light {
+		Log("Syncing headers...", false)
+		SyncHeaders()
+		SaveHeaders()
+		Log(fmt.Sprintf("Length: %d", len(Headers)), false)
+		return
+	}
 	Log("Syncing blockchain...", false)
 	SyncBlockchain(-1)
 	Log("Blockchain successfully synced!", false)
 	Log(fmt.Sprintf("Length: %d", len(Blockchain)), false)
 }
 
-func BalanceCmd(fields []string) {
-	if len(fields) == 1 {
-		publicKey := GetKey("").PublicKey.Y
-		balance := GetBalance(publicKey)
-		fmt.Println(fmt.Sprintf("Balance: %f", balance))
-		return
+// keyArgument returns the public key given in fields, or the node's own key if there is none.
+func keyArgument(fields []string) []byte {
+	if len(fields) == 0 {
+		return GetKey("").PublicKey.Y
 	}
-	keyStrFields := fields[1:]
-	keyStr := strings.Join(keyStrFields, " ")
 	var key []byte
-	err := json.Unmarshal([]byte(keyStr), &key)
+	err := json.Unmarshal([]byte(strings.Join(fields, " ")), &key)
 	if err != nil {
 		panic(err)
 	}
+	return key
+}
+
+func BalanceCmd(fields []string) {
+	key := keyArgument(fields[1:])
+	if CurrentConfig.Light {
+		balance, err := LightBalance(key)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(fmt.Sprintf("Balance: %f", balance))
+		return
+	}
 	balance := GetBalance(key)
 	fmt.Println(fmt.Sprintf("Balance: %f", balance))
 }
 
+func HistoryCmd(fields []string) {
+	key := keyArgument(fields[1:])
+	var history []TransactionInfo
+	if CurrentConfig.Light {
+		var err error
+		history, err = LightHistory(key)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	} else {
+		for page := 0; ; page++ {
+			info := GetAddressHistoryInfo(key, page, MaxHistoryPageSize)
+			history = append(history, info.Transactions...)
+			if len(info.Transactions) == 0 || len(history) >= info.Total {
+				break
+			}
+		}
+	}
+	for _, info := range history {
+		direction := "Received"
+		if bytes.Equal(info.Transaction.Sender.Y, key) {
+			direction = "Sent"
+		}
+		fmt.Printf("Block %d: %s %f (%s, %d confirmations)\n", info.BlockHeight, direction, info.Transaction.Amount, info.Id, info.Confirmations)
+	}
+}
+
+// parseSendFlags removes trailing --wait=<confirmations> and --fee=<set fee> fields, in either order.
+func parseSendFlags(fields []string) ([]string, int, float64) {
+	confirmations := 0
+	fee := 0.0
+	for {
+		last := fields[len(fields)-1]
+		switch {
+		case strings.HasPrefix(last, "--wait="):
+			var err error
+			confirmations, err = strconv.Atoi(strings.TrimPrefix(last, "--wait="))
+			if err != nil || confirmations < 0 {
+				panic("Invalid confirmation depth " + last)
+			}
+		case strings.HasPrefix(last, "--fee="):
+			var err error
+			fee, err = strconv.ParseFloat(strings.TrimPrefix(last, "--fee="), 64)
+			if err != nil || fee < 0 {
+				panic("Invalid fee " + last)
+			}
+		default:
+			return fields, confirmations, fee
+		}
+		fields = fields[:len(fields)-1]
+	}
+}
+
+func waitForTransaction(id string, confirmations int) {
+	fmt.Println("Transaction ID:", id)
+	if confirmations == 0 {
+		return
+	}
+	var receipt Receipt
+	var err error
+	if CurrentConfig.Light {
+		receipt, err = WaitForLightConfirmations(id, confirmations, SendWaitTimeout)
+	} else {
+		receipt, err = WaitForConfirmations(id, confirmations, SendWaitTimeout)
+	}
+	if err != nil {
+		Warn(err.Error())
+	}
+	fmt.Printf("Status: %s (%d confirmations)\n", receipt.Status, receipt.Confirmations)
+}
+
 func SendCmd(fields []string) {
+	fields, confirmations, fee := parseSendFlags(fields)
 	receiverStrFields := fields[1 : len(fields)-1]
 	receiverStr := strings.Join(receiverStrFields, " ")
 	var receiver []byte
@@ -83,13 +184,15 @@ func SendCmd(fields []string) {
 	}
 	amount := fields[len(fields)-1]
 	var transactionBody []byte
-	Send(string(receiver), amount, transactionBody)
+	id := Send(string(receiver), amount, fee, transactionBody)
 	Log("Waiting for all workers to finish", true)
 	Wg.Wait()
 	Log("All workers have finished", true)
+	waitForTransaction(id, confirmations)
 }
 
 func SendWithBodyCmd(fields []string) {
+	fields, confirmations, fee := parseSendFlags(fields)
 	receiverStrFields := fields[2 : len(fields)-1]
 	receiverStr := strings.Join(receiverStrFields, " ")
 	var receiver []byte
@@ -99,10 +202,11 @@ func SendWithBodyCmd(fields []string) {
 	}
 	amount := fields[len(fields)-1]
 	transactionBody := []byte(fields[1])
-	Send(string(receiver), amount, transactionBody)
+	id := Send(string(receiver), amount, fee, transactionBody)
 	Log("Waiting for all workers to finish", true)
 	Wg.Wait()
 	Log("All workers have finished", true)
+	waitForTransaction(id, confirmations)
 }
 
 func SendL2Cmd(fields []string) {
@@ -135,26 +239,17 @@ func DeploySmartContractCmd(fields []string) {
 }
 
 func KeygenCmd(fields []string) {
-	var privateKey PrivateKey
-	sigName := "Dilithium3"
-	signer := oqs.Signature{}
-	if err := signer.Init(sigName, nil); err != nil {
-		Error("Could not initialize Dilithium2 signer", true)
-	}
-	privateKey.X = signer
-	pubKey, err := privateKey.X.GenerateKeyPair()
+	privateKey, err := GenerateKey()
 	if err != nil {
-		panic(err)
+		Error("Could not initialize Dilithium2 signer", false)
+		return
 	}
 	fmt.Println(string(privateKey.X.ExportSecretKey()))
-	privateKey.PublicKey = PublicKey{
-		Y: pubKey,
-	}
 	keyJson, err := json.Marshal(privateKey)
 	if err != nil {
 		panic(err)
 	}
-	err = os.WriteFile("key.json", keyJson, 0644)
+	err = os.WriteFile(DataPath("key.json"), keyJson, 0644)
 	if err != nil {
 		panic(err)
 	}
@@ -178,7 +273,9 @@ func EncryptCmd(fields []string) {
 	password, _ := inputReader.ReadString('\n')
 	password = password[:len(password)-1]
 	// Encrypt the key
-	EncryptKey(password)
+	if err := EncryptKey(password); err != nil {
+		Error("Could not encrypt the key: "+err.Error(), false)
+	}
 }
 
 func DecryptCmd(fields []string) {
@@ -188,24 +285,35 @@ func DecryptCmd(fields []string) {
 	password, _ := inputReader.ReadString('\n')
 	password = password[:len(password)-1]
 	// Decrypt the key
-	DecryptKey(password)
+	if err := DecryptKey(password); err != nil {
+		Error("Could not decrypt the key: "+err.Error(), false)
+	}
 }
 
 func SaveStateCmd(fields []string) {
+	if CurrentConfig.Light {
+		SaveHeaders()
+		return
+	}
 	blockchainJson, err := json.Marshal(Blockchain)
 	// Save the blockchain to a file
 	if err != nil {
 		panic(err)
 	}
-	err = os.WriteFile("blockchain.json", blockchainJson, 0644)
+	err = os.WriteFile(DataPath("blockchain.json"), blockchainJson, 0644)
 	if err != nil {
 		panic(err)
 	}
+	SaveStateDB()
 }
 
 func LoadStateCmd(fields []string) {
+	if CurrentConfig.Light {
+		LoadHeaders()
+		return
+	}
 	// Load the blockchain from a file
-	blockchainJson, err := os.ReadFile("blockchain.json")
+	blockchainJson, err := os.ReadFile(DataPath("blockchain.json"))
 	if err != nil {
 		panic(err)
 	}
@@ -213,11 +321,12 @@ func LoadStateCmd(fields []string) {
 	if err != nil {
 		panic(err)
 	}
+	LoadStateDB()
 }
 
 func AddPeerCmd(fields []string) {
 	// Add the peer to the local peer list
-	AddPeer("http://" + fields[1] + ":8080\n")
+	AddPeer("http://" + fields[1] + ":" + CurrentNetwork.DefaultPort + "\n")
 	// Add the peer to the peer's peer list
 	peerServer := fields[1]
 	localIp := fields[2]
@@ -240,8 +349,29 @@ func BootstrapCmd(fields []string) {
 
 func GetFromStateCmd(fields []string) {
 	address := fields[1]
-	state := CalculateCurrentState()
-	dataBytes := state.Data[address]
+	var dataBytes []byte
+	if CurrentConfig.Light {
+		var err error
+		dataBytes, _, err = LightStateValue(address)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	} else if len(fields) > 2 {
+		height, err := strconv.Atoi(fields[2])
+		if err != nil {
+			fmt.Println("Invalid height:", fields[2])
+			return
+		}
+		state, err := StateAt(height)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		dataBytes = state.Data[address]
+	} else {
+		dataBytes, _ = GetStateValue(address)
+	}
 	dataHex := hex.EncodeToString(dataBytes)
 	fmt.Println("Data:", dataHex)
 }
@@ -255,18 +385,114 @@ func HelpCmd(fields []string) {
 	fmt.Println("showPublicKey - Print your public key")
 	fmt.Println("encrypt - Encrypt your keys for extra security")
 	fmt.Println("decrypt - Decrypt your keys so you can use them")
-	fmt.Println("send <public key> <amount> - Send an amount to a public key")
+	fmt.Println("send <public key> <amount> [--fee=<set fee>] [--wait=<confirmations>] - Send an amount to a public key, optionally setting its fee and waiting until it has enough confirmations")
+	fmt.Println("txstatus <transaction id> - Show the status and receipt of a transaction")
 	fmt.Println("sendL2 <public key> <amount> - Send an amount to a public key via L2 rollups (alpha)")
-	fmt.Println("balance <public key> - Get the balance of a public key")
+	fmt.Println("balance [public key] - Get the balance of a public key, or of your key")
+	fmt.Println("history [public key] - List the transactions of a public key, or of your key")
 	fmt.Println("savestate - Save the blockchain to a file")
 	fmt.Println("loadstate - Load the blockchain from a file")
 	fmt.Println("deploySmartContract <blockasm path> - Deploy a smart contract to the blockchain")
 	fmt.Println("addPeer <ip> - Connect to a peer")
 	fmt.Println("startAnalysisConsole - Start a specialized console for analyzing the blockchain and network")
 	fmt.Println("bootstrap - Connect to more peers")
+	fmt.Println("config show - Print the effective node configuration")
+	fmt.Println("generate <count> [public key] - Mine blocks immediately, paying your key or the given one (regtest only)")
+	fmt.Println("remotemine <node url> [count] - Mine blocks paying your key on another node through its RPC API")
+	fmt.Println("estimatefee [blocks] - Recommend a fee for a transaction to be mined within the given number of blocks (default 1)")
 	fmt.Println("exit - Exit the console")
 }
 
+func EstimateFeeCmd(fields []string) {
+	blocks := 1
+	if len(fields) > 1 {
+		var err error
+		blocks, err = strconv.Atoi(fields[1])
+		if err != nil || blocks < 1 {
+			fmt.Println("Invalid block count " + fields[1])
+			return
+		}
+	}
+	estimate := EstimateFee(blocks)
+	fmt.Printf("Fee rate: %f (multiple of the minimum fee)\n", estimate.FeeRate)
+	fmt.Printf("Fee for a plain transfer: %s\n", FormatFee(estimate.Fee))
+}
+
+func RemoteMineCmd(fields []string) {
+	if len(fields) < 2 {
+		fmt.Println("Usage: remotemine <node url> [count]")
+		return
+	}
+	count := 1
+	if len(fields) > 2 {
+		var err error
+		count, err = strconv.Atoi(fields[2])
+		if err != nil {
+			fmt.Println("Invalid block count " + fields[2])
+			return
+		}
+	}
+	client := rpc.NewClient(fields[1])
+	engine := NewMiningEngine(CurrentConfig.MiningWorkers)
+	miner := GetKey("").PublicKey
+	for i := 0; i < count; i++ {
+		result, err := client.MineBlock(context.Background(), engine, miner.Y)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Mined block", result.Height, result.Hash)
+	}
+	fmt.Printf("Hashrate: %.0f H/s\n", engine.Hashrate())
+}
+
+func GenerateCmd(fields []string) {
+	if len(fields) < 2 {
+		fmt.Println("Usage: generate <count> [public key]")
+		return
+	}
+	count, err := strconv.Atoi(fields[1])
+	if err != nil {
+		fmt.Println("Invalid block count " + fields[1])
+		return
+	}
+	var miner PublicKey
+	if len(fields) > 2 {
+		err := json.Unmarshal([]byte(strings.Join(fields[2:], " ")), &miner.Y)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		miner = GetKey("").PublicKey
+	}
+	blocks, err := GenerateBlocks(count, miner)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, block := range blocks {
+		hash := HashBlock(block)
+		fmt.Println(hex.EncodeToString(hash[:]))
+	}
+}
+
+func ConfigCmd(fields []string) {
+	if len(fields) < 2 || fields[1] != "show" {
+		fmt.Println("Usage: config show")
+		return
+	}
+	configJson, err := json.MarshalIndent(CurrentConfig, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	if CurrentConfigPath == "" {
+		fmt.Println("# No config file; using defaults, environment variables and flags")
+	} else {
+		fmt.Printf("# Config file: %s\n", CurrentConfigPath)
+	}
+	fmt.Println(string(configJson))
+}
+
 func LicenseCmd(fields []string) {
 	license, err := os.ReadFile("COPYING")
 	if err != nil {
@@ -276,11 +502,10 @@ func LicenseCmd(fields []string) {
 }
 
 func GetNthBlockCmd(fields []string) {
-	n64, err := strconv.ParseInt(fields[1]+".0", 10, 32)
-	if err == nil {
+	n, err := strconv.Atoi(fields[1])
+	if err != nil {
 		panic("Invalid block number " + fields[1])
 	}
-	n := int(n64)
 	if len(Blockchain)-1 < n || n < 0 {
 		panic("Block out of range")
 	}
@@ -323,12 +548,34 @@ func GetNthTransactionCmd(fields []string) {
 	}
 }
 
+func TxStatusCmd(fields []string) {
+	var receipt Receipt
+	if CurrentConfig.Light {
+		receipt = ProveTransactionStatus(fields[1])
+	} else {
+		receipt = RequestReceipt(fields[1])
+	}
+	fmt.Println("Status:", receipt.Status)
+	if receipt.BlockHeight >= 0 {
+		fmt.Println("Block:", receipt.BlockHeight, receipt.BlockHash)
+		fmt.Println("Confirmations:", receipt.Confirmations)
+		fmt.Println("Fee:", receipt.Fee)
+		fmt.Println("Gas used:", receipt.GasUsed)
+	}
+	for _, output := range receipt.ContractOutputs.Transactions {
+		fmt.Println("Contract transaction:", output)
+	}
+	for key, value := range receipt.ContractOutputs.StateChanges {
+		fmt.Println("State change:", key, value)
+	}
+}
+
 func StartAnalysisConsoleCmd(fields []string) {
 	StartAnalysisCmdline()
 }
 
 func GetBlockchainLenCmd(fields []string) {
-	fmt.Println(len(Blockchain))
+	fmt.Println(ChainLength())
 }
 
 func RunCmd(input string) {
@@ -49,7 +49,7 @@ func RequestAuthentication(peer_ip string) (PublicKey, bool, error) {
 	// Request the signature from the peer
 	req, err := http.NewRequest("GET", peer_ip+"/identify", bytes.NewBuffer(data))
 	if err != nil {
-		panic(err)
+		return PublicKey{}, false, err
 	}
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -57,14 +57,15 @@ func RequestAuthentication(peer_ip string) (PublicKey, bool, error) {
 	}
 	// Read the response
 	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
 	if err != nil {
-		panic(err)
+		return PublicKey{}, false, err
 	}
 	// Unmarshal the response
 	var proof AuthenticationProof
 	err = json.Unmarshal(body, &proof)
 	if err != nil {
-		panic(err)
+		return PublicKey{}, false, nil
 	}
 	// Verify the signature
 	isValid := VerifyAuthenticationProof(&proof, digest[:])
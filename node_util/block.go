@@ -9,6 +9,8 @@ You should have received a copy of the GNU General Public License along with thi
 package node_util
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -54,6 +56,20 @@ type Transaction struct {
 	FromSmartContract bool
 	Body              []byte
 	BodySignatures    []Signature
+	// Fee is the fee the sender signed for, which the transaction pays in full. Transactions without one pay the network's minimum fee once fees start.
+	Fee float64
+}
+
+// TransactionHash returns the hash used to identify a transaction, both in TransactionHashes and in external APIs.
+func TransactionHash(transaction Transaction) [32]byte {
+	transactionString := fmt.Sprintf("%s:%s:%f:%d", EncodePublicKey(transaction.Sender), EncodePublicKey(transaction.Recipient), transaction.Amount, transaction.Timestamp.UnixNano())
+	return sha256.Sum256([]byte(transactionString))
+}
+
+// TransactionId returns the hex-encoded hash of a transaction.
+func TransactionId(transaction Transaction) string {
+	hash := TransactionHash(transaction)
+	return hex.EncodeToString(hash[:])
 }
 
 func (i Transaction) MarshalJSON() ([]byte, error) {
@@ -86,6 +102,10 @@ func (i Transaction) MarshalJSON() ([]byte, error) {
 	}
 	bodySignatures := string(bodySignaturesBytes)
 	result := []byte(EncodePublicKey(i.Sender) + "^" + EncodePublicKey(i.Recipient) + "^" + fmt.Sprintf("%f", i.Amount) + "^" + signature + "^" + strconv.FormatInt(i.Timestamp.UnixNano(), 10) + "^" + contracts + "^" + strconv.FormatBool(i.FromSmartContract) + "^" + string(bodyBytes) + "^" + bodySignatures)
+	// Transactions without a set fee keep the old encoding, so the hashes of existing blocks don't change
+	if i.Fee != 0 {
+		result = append(result, []byte("^"+FormatFee(i.Fee))...)
+	}
 	result = []byte(strings.Replace(string(result), `"`, "", -1))
 	result = []byte(`"` + string(result) + `"`)
 	return result, nil
@@ -142,20 +162,24 @@ func (i *Transaction) UnmarshalJSON(data []byte) error {
 		}
 		bodySignatures = append(bodySignatures, bodySignature)
 	}
+	if len(parts) > 9 {
+		i.Fee, err = strconv.ParseFloat(parts[9], 64)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Block is a header followed by its body, which the header commits to, and the attestations collected around mining, which it doesn't. The header's fields are encoded inline, so blocks look the same on the wire as before the split.
 type Block struct {
-	Transactions                    []Transaction   `json:"transactions"`
-	Miner                           PublicKey       `json:"miner"`
-	Nonce                           int64           `json:"nonce"`
-	MiningTime                      time.Duration   `json:"miningTime"`
-	Difficulty                      uint64          `json:"difficulty"`
-	PreviousBlockHash               [64]byte        `json:"previousBlockHash"`
-	Timestamp                       time.Time       `json:"timestamp"`
-	PreMiningTimeVerifierSignatures []Signature     `json:"preMiningTimeVerifierSignatures"`
-	PreMiningTimeVerifiers          []PublicKey     `json:"preMiningTimeVerifiers"`
-	TimeVerifierSignatures          []Signature     `json:"timeVerifierSignature"`
-	TimeVerifiers                   []PublicKey     `json:"timeVerifiers"`
-	Transition                      StateTransition `json:"transition"`
+	BlockHeader
+	Transactions []Transaction   `json:"transactions"`
+	Transition   StateTransition `json:"transition"`
+	// The pre-mining time verifiers sign the timestamp before the nonce is found, and the time verifiers after
+	MiningTime                      time.Duration `json:"miningTime"`
+	PreMiningTimeVerifierSignatures []Signature   `json:"preMiningTimeVerifierSignatures"`
+	PreMiningTimeVerifiers          []PublicKey   `json:"preMiningTimeVerifiers"`
+	TimeVerifierSignatures          []Signature   `json:"timeVerifierSignature"`
+	TimeVerifiers                   []PublicKey   `json:"timeVerifiers"`
 }
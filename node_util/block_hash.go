@@ -17,6 +17,9 @@ import (
 )
 
 func HashBlock(block Block) [64]byte {
+	if block.Version >= BinaryBlockVersion {
+		return HashBlockHeader(block.BlockHeader)
+	}
 	marshaled, err := json.Marshal(block)
 	if err != nil {
 		panic(err)
@@ -34,7 +37,94 @@ func HashBlock(block Block) [64]byte {
 		blockCpy.Transactions[i].Timestamp = time.Time{}
 		blockCpy.Transactions[i].Body = []byte{}
 	}
-	blockBytes := []byte(fmt.Sprintf("%v", blockCpy))
+	blockBytes := []byte(fmt.Sprintf("%v", newHashedBlock(blockCpy)))
+	if block.MerkleRoot != [32]byte{} {
+		blockBytes = append(blockBytes, block.MerkleRoot[:]...)
+	}
 	sum := sha3.Sum512(blockBytes)
 	return sum
 }
+
+// hashedBlock has the fields Block had when blocks were first hashed. HashBlock prints it instead of the Block, so adding a field to Block doesn't change the hashes of existing blocks.
+type hashedBlock struct {
+	Transactions                    []hashedTransaction
+	Miner                           PublicKey
+	Nonce                           int64
+	MiningTime                      time.Duration
+	Difficulty                      uint64
+	PreviousBlockHash               [64]byte
+	Timestamp                       time.Time
+	PreMiningTimeVerifierSignatures []Signature
+	PreMiningTimeVerifiers          []PublicKey
+	TimeVerifierSignatures          []Signature
+	TimeVerifiers                   []PublicKey
+	Transition                      StateTransition
+}
+
+// hashedTransaction has the fields Transaction had when blocks were first hashed, for the same reason as hashedBlock.
+type hashedTransaction struct {
+	Sender            PublicKey
+	Recipient         PublicKey
+	Amount            float64
+	SenderSignature   Signature
+	Timestamp         time.Time
+	Contracts         []Contract
+	FromSmartContract bool
+	Body              []byte
+	BodySignatures    []Signature
+	fee               float64
+}
+
+// String prints the transaction like a struct, followed by its set fee if it has one.
+func (t hashedTransaction) String() string {
+	printed := fmt.Sprintf("%v", struct {
+		Sender            PublicKey
+		Recipient         PublicKey
+		Amount            float64
+		SenderSignature   Signature
+		Timestamp         time.Time
+		Contracts         []Contract
+		FromSmartContract bool
+		Body              []byte
+		BodySignatures    []Signature
+	}{t.Sender, t.Recipient, t.Amount, t.SenderSignature, t.Timestamp, t.Contracts, t.FromSmartContract, t.Body, t.BodySignatures})
+	if t.fee != 0 {
+		printed += " fee:" + FormatFee(t.fee)
+	}
+	return printed
+}
+
+func newHashedBlock(block Block) hashedBlock {
+	var transactions []hashedTransaction
+	if block.Transactions != nil {
+		transactions = make([]hashedTransaction, len(block.Transactions))
+	}
+	for i, transaction := range block.Transactions {
+		transactions[i] = hashedTransaction{
+			Sender:            transaction.Sender,
+			Recipient:         transaction.Recipient,
+			Amount:            transaction.Amount,
+			SenderSignature:   transaction.SenderSignature,
+			Timestamp:         transaction.Timestamp,
+			Contracts:         transaction.Contracts,
+			FromSmartContract: transaction.FromSmartContract,
+			Body:              transaction.Body,
+			BodySignatures:    transaction.BodySignatures,
+			fee:               transaction.Fee,
+		}
+	}
+	return hashedBlock{
+		Transactions:                    transactions,
+		Miner:                           block.Miner,
+		Nonce:                           block.Nonce,
+		MiningTime:                      block.MiningTime,
+		Difficulty:                      block.Difficulty,
+		PreviousBlockHash:               block.PreviousBlockHash,
+		Timestamp:                       block.Timestamp,
+		PreMiningTimeVerifierSignatures: block.PreMiningTimeVerifierSignatures,
+		PreMiningTimeVerifiers:          block.PreMiningTimeVerifiers,
+		TimeVerifierSignatures:          block.TimeVerifierSignatures,
+		TimeVerifiers:                   block.TimeVerifiers,
+		Transition:                      block.Transition,
+	}
+}
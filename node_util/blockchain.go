@@ -8,26 +8,25 @@ You should have received a copy of the GNU General Public License along with thi
 */
 package node_util
 
-import (
-	"time"
-)
+import "sync"
 
 var Blockchain []Block
 
+// ChainMutex is held while the node connects or removes blocks and transactions, or builds a block template from them: it guards Blockchain, MiningTransactions, TransactionHashes and NextTransitions against the miner, the HTTP handlers and a mining pool running at the same time. It is never held while requests are sent to peers, because a peer's reply may be a request back to this node.
+var ChainMutex sync.Mutex
+
+// GenesisBlock returns the first block of the current network.
 func GenesisBlock() Block {
-	return Block{
-		Transactions:           nil,
-		Miner:                  PublicKey{},
-		Nonce:                  0,
-		MiningTime:             0,
-		Difficulty:             0,
-		PreviousBlockHash:      [64]byte{},
-		Timestamp:              time.Time{},
-		TimeVerifierSignatures: []Signature{},
-		TimeVerifiers:          []PublicKey{},
-	}
+	return CurrentNetwork.Genesis
 }
 
 func Append(block Block) {
 	Blockchain = append(Blockchain, block)
+	SyncIndexes()
+	SyncState()
+	PublishEvent(Event{
+		Type:   BlockEvent,
+		Height: len(Blockchain) - 1,
+		Block:  block,
+	})
 }
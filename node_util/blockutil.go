@@ -19,6 +19,7 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,56 +27,134 @@ import (
 
 var Wg sync.WaitGroup
 
+// GetKey returns the key in path, or in key.json if path is empty. It panics if the key can't be read, so it is meant for startup and console commands; request handlers use LoadKey.
 func GetKey(path string) PrivateKey {
+	key, err := LoadKey(path)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// LoadKey returns the key in path, or in key.json if path is empty.
+func LoadKey(path string) (PrivateKey, error) {
 	if path == "" {
-		path = "key.json"
+		path = DataPath("key.json")
 	}
 	keyJson, err := os.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return PrivateKey{}, err
 	}
 	var key PrivateKey
-	err = json.Unmarshal(keyJson, &key)
-	if err != nil {
-		panic(err)
+	if err := json.Unmarshal(keyJson, &key); err != nil {
+		return PrivateKey{}, err
 	}
-	return key
+	return key, nil
 }
 
+// SyncBlockchain replaces the blockchain with the longest valid chain of the peers, if it is longer. If the new chain forks from the local one, it must be at least finalityBlockHeight blocks long. The peers' chains are downloaded first; ChainMutex is only held while they are checked and the blockchain is replaced.
 func SyncBlockchain(finalityBlockHeight int) {
-	longestLength := 0
-	var longestBlockchain []Block
 	errCount := 0
+	var peers []string
+	var peerBlockchains [][]Block
 	for _, peer := range GetPeers() {
 		res, err := http.Get(fmt.Sprintf("%s/blockchain", peer))
 		if err != nil {
+			p2pLog.Debug("Peer down.", Fields{"peer": peer, "error": err})
 			errCount++
 			continue
 		}
 		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
 		if err != nil {
-			panic(err)
+			p2pLog.Debug("Peer down.", Fields{"peer": peer, "error": err})
+			errCount++
+			continue
 		}
 		var peerBlockchain []Block
 		err = json.Unmarshal(body, &peerBlockchain)
 		if err != nil {
-			panic(err)
+			p2pLog.Debug("Peer sent an invalid blockchain.", Fields{"peer": peer, "error": err})
+			continue
 		}
+		peers = append(peers, peer)
+		peerBlockchains = append(peerBlockchains, peerBlockchain)
+	}
+	if errCount >= len(GetPeers()) {
+		Log("Failed to sync blockchain with any peers.", true)
+		return
+	}
+	ChainMutex.Lock()
+	defer ChainMutex.Unlock()
+	longestLength := 0
+	var longestBlockchain []Block
+	for p, peerBlockchain := range peerBlockchains {
+		peer := peers[p]
 		length := len(peerBlockchain)
 		// Check to ensure proof of work is valid
 		createsFork := false
+		genesisHash := HashBlock(GenesisBlock())
+		var peerStateTree StateTree
 		for i, block := range peerBlockchain {
+			peerStateTree = peerStateTree.Update(block.Transition)
 			if i == 0 {
+				if HashBlock(block) != genesisHash {
+					p2pLog.Debug("Peer is on a different network.", Fields{"peer": peer, "network": CurrentNetwork.Name})
+					length = 0
+					break
+				}
 				continue
 			}
 			previousBlockHash := HashBlock(peerBlockchain[i-1])
 			if !bytes.Equal(block.PreviousBlockHash[:], previousBlockHash[:]) {
-				Log("Invalid blockchain received from peer.", true)
+				p2pLog.Debug("Invalid blockchain received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
 				break
 			}
 			blockHash := HashBlock(block)
 			if binary.BigEndian.Uint64(blockHash[:]) > MaximumUint64/block.Difficulty {
-				Log("Invalid blockchain received from peer.", true)
+				p2pLog.Debug("Invalid blockchain received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
+				break
+			}
+			if !VerifyTimeVerifiers(block, block.TimeVerifiers, block.TimeVerifierSignatures, false) || !VerifyTimeVerifiers(block, block.PreMiningTimeVerifiers, block.PreMiningTimeVerifierSignatures, true) {
+				p2pLog.Debug("Invalid time verifiers received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
+				break
+			}
+			if !verifyBlockTimeOn(peerBlockchain[:i], block) {
+				p2pLog.Debug("Invalid timestamp received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
+				break
+			}
+			if !verifyCoinbaseOn(peerBlockchain, block, i) {
+				p2pLog.Debug("Invalid coinbase received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
+				break
+			}
+			if !VerifyBlockLimits(block, i) {
+				p2pLog.Debug("Oversized block received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
+				break
+			}
+			if !VerifyMerkleRoot(block, i) {
+				p2pLog.Debug("Invalid Merkle root received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
+				break
+			}
+			if !VerifyBlockVersion(block, i) {
+				p2pLog.Debug("Invalid block version received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
+				break
+			}
+			if !VerifyTransitionHash(block, i) {
+				p2pLog.Debug("Invalid transition hash received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
+				break
+			}
+			if !verifyStateRootOn(block, i, peerStateTree) {
+				p2pLog.Debug("Invalid state root received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
 				break
 			}
 			if i < len(Blockchain) - 1 {
@@ -83,20 +162,9 @@ func SyncBlockchain(finalityBlockHeight int) {
 					createsFork = true
 				}
 			}
-			// Get the correct difficulty for the block
-			lastMinedBlock := peerBlockchain[i-1]
-			var lastTime time.Duration
-			var lastDifficulty uint64
-			if i == 1 {
-				lastTime = time.Minute
-				lastDifficulty = MinimumBlockDifficulty
-			} else {
-				lastTime = lastMinedBlock.MiningTime
-				lastDifficulty = lastMinedBlock.Difficulty
-			}
-			correctDifficulty := GetDifficulty(lastTime, lastDifficulty)
-			if block.Difficulty != correctDifficulty {
-				Log("Invalid blockchain received from peer.", true)
+			if !verifyDifficultyOn(peerBlockchain[:i], block) {
+				p2pLog.Debug("Invalid difficulty received from peer.", Fields{"peer": peer, "height": i})
+				length = 0
 				break
 			}
 		}
@@ -111,24 +179,39 @@ func SyncBlockchain(finalityBlockHeight int) {
 			longestLength = length
 			longestBlockchain = peerBlockchain
 		}
-	}
-	if errCount >= len(GetPeers()) {
-		Log("Failed to sync blockchain with any peers.", true)
-		return
+		if length-1 > BestPeerHeight {
+			BestPeerHeight = length - 1
+		}
 	}
 	Log("Blockchain successfully synced!", false)
 	Log(fmt.Sprintf("%d out of %d peers responded.", len(GetPeers())-errCount, len(GetPeers())), false)
 	if longestLength > len(Blockchain) {
 		Blockchain = longestBlockchain
+		// Publish every block past the point where the chains diverge
+		forkHeight := SyncIndexes()
+		SyncState()
+		for i := forkHeight; i < len(Blockchain); i++ {
+			RecordBlockReceipts(i)
+			PublishEvent(Event{
+				Type:   BlockEvent,
+				Height: i,
+				Block:  Blockchain[i],
+			})
+		}
 	}
 }
 
 func GetBalance(key []byte) float64 {
+	return getBalanceOn(Blockchain, key)
+}
+
+// getBalanceOn returns key's balance on chain. Only the transactions involving key are needed, except in blocks key mined before Kyoto, whose fees are part of the mining reward.
+func getBalanceOn(chain []Block, key []byte) float64 {
 	total := 0.0
 	miningTotal := 0.0
 	isGenesis := true
 	blocksMined := 0
-	for i, block := range Blockchain {
+	for i, block := range chain {
 		if isGenesis {
 			isGenesis = false
 			continue
@@ -136,46 +219,76 @@ func GetBalance(key []byte) float64 {
 		for _, transaction := range block.Transactions {
 			if bytes.Equal(transaction.Sender.Y, key) {
 				total -= transaction.Amount
-				if i > 50 { // Fees start after 50 blocks
-					fee := TransactionFee + (BodyFeePerByte * float64(len(transaction.Body)))
-					for _, contract := range transaction.Contracts {
-						fee += GasPrice * contract.GasUsed
-					}
-					total -= fee
-				}
+				total -= CalculateTransactionFee(transaction, i)
 			} else if bytes.Equal(transaction.Recipient.Y, key) {
 				total += transaction.Amount
 			}
 		}
-		if bytes.Equal(block.Miner.Y, key) {
-			lastBlock := Blockchain[i-1]
-			miningTotal += float64(len(block.TimeVerifiers)-len(lastBlock.TimeVerifiers)) * 0.1
-			if i > 50 { // Fees start after 50 blocks
-				fees := 0.0
-				for _, transaction := range block.Transactions {
-					fees += TransactionFee
-					fees += BodyFeePerByte * float64(len(transaction.Body))
-					for _, contract := range transaction.Contracts {
-						fees += GasPrice * contract.GasUsed
-					}
-				}
-				miningTotal += fees
-			}
-			// Get number of miners at the time of mining
-			minerCount := GetMinerCount(i)
-			reward := CalculateBlockReward(minerCount, i)
-			miningTotal += reward
+		// Since Kyoto, mining rewards are coinbase transactions, which are counted above
+		if i < Env.Upgrades.Kyoto && bytes.Equal(block.Miner.Y, key) {
+			miningTotal += legacyMiningRewardOn(chain, i)
 			blocksMined++
 		}
 	}
-	if blocksMined > BlocksBeforeReward && len(Blockchain) > 50 {
+	if blocksMined > BlocksBeforeReward && len(chain) > RewardsStartHeight {
 		total += miningTotal - float64(BlocksBeforeReward)
-	} else if len(Blockchain) < 50 {
+	} else if len(chain) < RewardsStartHeight {
 		total += miningTotal
 	}
 	return total
 }
 
+// BlockMiningReward returns what the miner of the block at height earns for it. Since Kyoto, that is what the block's coinbase pays the miner.
+func BlockMiningReward(height int) float64 {
+	if height < Env.Upgrades.Kyoto {
+		return legacyMiningRewardOn(Blockchain, height)
+	}
+	block := Blockchain[height]
+	total := 0.0
+	for _, transaction := range block.Transactions {
+		if IsCoinbase(transaction) && bytes.Equal(transaction.Recipient.Y, block.Miner.Y) {
+			total += transaction.Amount
+		}
+	}
+	return total
+}
+
+// legacyMiningReward returns what the miner of a block from before Kyoto earns for it, which is not recorded in the block: the time verifier bonus, the transaction fees and the block reward.
+func legacyMiningRewardOn(chain []Block, height int) float64 {
+	block := chain[height]
+	lastBlock := chain[height-1]
+	total := float64(len(block.TimeVerifiers)-len(lastBlock.TimeVerifiers)) * 0.1
+	for _, transaction := range block.Transactions {
+		total += CalculateTransactionFee(transaction, height)
+	}
+	// Get number of miners at the time of mining
+	minerCount := getMinerCountOn(chain, height)
+	return total + CalculateBlockReward(minerCount, height)
+}
+
+// CalculateTransactionFee returns the fee paid by a transaction in the block at blockHeight. A transaction with a set fee pays it in full, even before fees start.
+func CalculateTransactionFee(transaction Transaction, blockHeight int) float64 {
+	if IsCoinbase(transaction) {
+		return 0
+	}
+	if transaction.Fee > 0 {
+		return transaction.Fee
+	}
+	if blockHeight <= FeesStartHeight {
+		return 0
+	}
+	return MinimumFee(transaction)
+}
+
+// TransactionGasUsed returns the total gas used by a transaction's contracts.
+func TransactionGasUsed(transaction Transaction) float64 {
+	gasUsed := 0.0
+	for _, contract := range transaction.Contracts {
+		gasUsed += contract.GasUsed
+	}
+	return gasUsed
+}
+
 func SendRequest(req *http.Request) {
 	_, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -185,11 +298,11 @@ func SendRequest(req *http.Request) {
 	Wg.Done()
 }
 
-func Send(receiver string, amount string, transactionBody []byte) {
+// Send signs a transaction, sends it to all peers and returns its ID. A fee of 0 pays the network's minimum fee.
+func Send(receiver string, amount string, fee float64, transactionBody []byte) string {
 	key := GetKey("")
-	sender := key.PublicKey.Y
-	timestamp := time.Now().UnixNano()
-	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%s:%d", sender, receiver, amount, timestamp)))
+	timestamp := Now().UnixNano()
+	hash := TransactionSigningHashFor(BlockVersionAt(ChainLength()), key.PublicKey, PublicKey{Y: []byte(receiver)}, amount, timestamp, fee)
 	sigBytes, err := key.X.Sign(hash[:])
 	sig := Signature{
 		S: sigBytes,
@@ -210,7 +323,11 @@ func Send(receiver string, amount string, transactionBody []byte) {
 		if err != nil {
 			panic(err)
 		}
-		body := strings.NewReader(fmt.Sprintf("%s$%s$%s$%s$%d$%s$%s$[]", senderStr, receiverStr, amount, sigStr, timestamp, contractsStr, string(transactionBodyMarshaled)))
+		request := fmt.Sprintf("%s$%s$%s$%s$%d$%s$%s$[]", senderStr, receiverStr, amount, sigStr, timestamp, contractsStr, string(transactionBodyMarshaled))
+		if fee != 0 {
+			request += "$" + FormatFee(fee)
+		}
+		body := strings.NewReader(request)
 		req, err := http.NewRequest(http.MethodGet, peer+"/mine", body)
 		if err != nil {
 			panic(err)
@@ -218,6 +335,16 @@ func Send(receiver string, amount string, transactionBody []byte) {
 		Wg.Add(1)
 		go SendRequest(req)
 	}
+	amountFloat, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		panic(err)
+	}
+	return TransactionId(Transaction{
+		Sender:    key.PublicKey,
+		Recipient: PublicKey{Y: []byte(receiver)},
+		Amount:    amountFloat,
+		Timestamp: time.Unix(0, timestamp),
+	})
 }
 
 func DeploySmartContract(contractPath string) error {
@@ -252,7 +379,7 @@ func DeploySmartContract(contractPath string) error {
 		return err
 	}
 	amount := "0"
-	timestamp := time.Now().UnixNano()
+	timestamp := Now().UnixNano()
 	transactionString := fmt.Sprintf("%s:%s:%s:%d", deployer.Y, deployer.Y, amount, timestamp)
 	hash = sha256.Sum256([]byte(transactionString))
 	sigBytes, err := key.X.Sign(hash[:])
@@ -280,10 +407,18 @@ func DeploySmartContract(contractPath string) error {
 }
 
 func GetLastMinedBlock() (Block, bool) {
-	pubKey := GetKey("").PublicKey.Y
-	for i := len(Blockchain) - 1; i > 0; i-- {
-		block := Blockchain[i]
-		if bytes.Equal(block.Miner.Y, pubKey) {
+	return GetLastMinedBlockBy(GetKey("").PublicKey.Y)
+}
+
+// GetLastMinedBlockBy returns the last block mined by the given key.
+func GetLastMinedBlockBy(miner []byte) (Block, bool) {
+	return lastMinedBlockByOn(Blockchain, miner)
+}
+
+func lastMinedBlockByOn(chain []Block, miner []byte) (Block, bool) {
+	for i := len(chain) - 1; i > 0; i-- {
+		block := chain[i]
+		if bytes.Equal(block.Miner.Y, miner) {
 			return block, true
 		}
 	}
@@ -291,8 +426,12 @@ func GetLastMinedBlock() (Block, bool) {
 }
 
 func IsNewMiner(miner PublicKey, maxBlockPosition int) bool {
+	return isNewMinerOn(Blockchain, miner, maxBlockPosition)
+}
+
+func isNewMinerOn(chain []Block, miner PublicKey, maxBlockPosition int) bool {
 	isGenesis := true
-	for i, block := range Blockchain {
+	for i, block := range chain {
 		if isGenesis {
 			isGenesis = false
 			continue
@@ -308,10 +447,13 @@ func IsNewMiner(miner PublicKey, maxBlockPosition int) bool {
 }
 
 func GetMinerCount(maxBlockPosition int) int64 {
-	var result int64
-	result = 0
+	return getMinerCountOn(Blockchain, maxBlockPosition)
+}
+
+func getMinerCountOn(chain []Block, maxBlockPosition int) int64 {
+	miners := make(map[string]bool)
 	isGenesis := true
-	for i, block := range Blockchain {
+	for i, block := range chain {
 		if i > maxBlockPosition {
 			break
 		}
@@ -319,11 +461,9 @@ func GetMinerCount(maxBlockPosition int) int64 {
 			isGenesis = false
 			continue
 		}
-		if IsNewMiner(block.Miner, i-1) {
-			result++
-		}
+		miners[string(block.Miner.Y)] = true
 	}
-	return result
+	return int64(len(miners))
 }
 
 func GetMaxMiners() int64 {
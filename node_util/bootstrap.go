@@ -10,6 +10,7 @@ package node_util
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 )
@@ -21,7 +22,8 @@ func Bootstrap() {
 		// Get the peer's peers
 		req, err := http.NewRequest(http.MethodGet, peer+"/peers", nil)
 		if err != nil {
-			panic(err)
+			Log(fmt.Sprintf("Invalid peer, %s.", peer), true)
+			continue
 		}
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
@@ -29,13 +31,16 @@ func Bootstrap() {
 			continue
 		}
 		peerPeersBytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			panic(err)
+			Log("Peer is down.", true)
+			continue
 		}
 		var peerPeers []string
 		err = json.Unmarshal(peerPeersBytes, &peerPeers)
 		if err != nil {
-			panic(err)
+			Log("Peer sent an invalid peer list.", true)
+			continue
 		}
 		for _, peerPeer := range peerPeers {
 			if !PeerKnown(peerPeer) {
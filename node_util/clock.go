@@ -0,0 +1,69 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time for timestamp rules: block and transaction timestamps, time verification and the future-timestamp check.
+// Timing measurements (metrics, logs) always use the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock reads the operating system's clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SimulatedClock only moves when told to, so tests can check time rules deterministically.
+type SimulatedClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewSimulatedClock(start time.Time) *SimulatedClock {
+	return &SimulatedClock{now: start}
+}
+
+func (c *SimulatedClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d (or back, if d is negative).
+func (c *SimulatedClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *SimulatedClock) Set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = t
+}
+
+// CurrentClock is the clock the node uses. Tests replace it with a SimulatedClock.
+var CurrentClock Clock = SystemClock{}
+
+// Now returns the time on CurrentClock.
+func Now() time.Time {
+	return CurrentClock.Now()
+}
+
+// Since returns the time elapsed on CurrentClock since t.
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
@@ -0,0 +1,167 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CoinbaseKey is the sender of coinbase transactions. It is not a real key, so nobody can sign a transaction from it.
+var CoinbaseKey = PublicKey{Y: []byte("coinbase")}
+
+// VerifierReward is what each pre-mining time verifier of a block earns.
+const VerifierReward = 0.1
+
+// IsCoinbase reports whether a transaction mints new tokens as part of a block's coinbase.
+func IsCoinbase(transaction Transaction) bool {
+	return bytes.Equal(transaction.Sender.Y, CoinbaseKey.Y)
+}
+
+// CoinbaseTransactions returns the coinbase of a block at height: the block subsidy and the fees of its transactions for the miner, and VerifierReward for each pre-mining time verifier. Since Kyoto, every block starts with exactly these transactions. Outputs to the same key are merged and empty outputs are left out.
+func CoinbaseTransactions(height int, miner PublicKey, verifiers []PublicKey, transactions []Transaction) []Transaction {
+	return coinbaseTransactionsOn(Blockchain, height, miner, verifiers, transactions)
+}
+
+// coinbaseTransactionsOn is CoinbaseTransactions with the subsidy counted on chain instead of the local blockchain.
+func coinbaseTransactionsOn(chain []Block, height int, miner PublicKey, verifiers []PublicKey, transactions []Transaction) []Transaction {
+	minerAmount := blockSubsidyOn(chain, height, miner)
+	for _, transaction := range transactions {
+		minerAmount += CalculateTransactionFee(transaction, height)
+	}
+	recipients := []PublicKey{miner}
+	amounts := []float64{minerAmount}
+	for _, verifier := range verifiers {
+		found := false
+		for i, recipient := range recipients {
+			if bytes.Equal(recipient.Y, verifier.Y) {
+				amounts[i] += VerifierReward
+				found = true
+			}
+		}
+		if !found {
+			recipients = append(recipients, verifier)
+			amounts = append(amounts, VerifierReward)
+		}
+	}
+	coinbase := make([]Transaction, 0, len(recipients))
+	for i, recipient := range recipients {
+		amount := wireAmount(amounts[i])
+		if amount <= 0 {
+			continue
+		}
+		coinbase = append(coinbase, Transaction{
+			Sender:    CoinbaseKey,
+			Recipient: recipient,
+			Amount:    amount,
+			// The height makes coinbase transaction IDs unique
+			Timestamp:      time.Unix(0, int64(height)),
+			Contracts:      []Contract{},
+			BodySignatures: []Signature{},
+		})
+	}
+	return coinbase
+}
+
+// BlockSubsidy returns the newly minted reward for a block at height mined by miner. Once rewards have started, a miner's first BlocksBeforeReward blocks earn no subsidy.
+func BlockSubsidy(height int, miner PublicKey) float64 {
+	return blockSubsidyOn(Blockchain, height, miner)
+}
+
+func blockSubsidyOn(chain []Block, height int, miner PublicKey) float64 {
+	if height >= RewardsStartHeight && blocksMinedByOn(chain, miner, height) < BlocksBeforeReward {
+		return 0
+	}
+	// Count the miner even if this is its first block, as if the block were already appended
+	minerCount := getMinerCountOn(chain, height-1)
+	if isNewMinerOn(chain, miner, height-1) {
+		minerCount++
+	}
+	return CalculateBlockReward(minerCount, height)
+}
+
+// blocksMinedByOn counts the blocks of chain before height mined by miner.
+func blocksMinedByOn(chain []Block, miner PublicKey, height int) int {
+	count := 0
+	for i := 1; i < height && i < len(chain); i++ {
+		if bytes.Equal(chain[i].Miner.Y, miner.Y) {
+			count++
+		}
+	}
+	return count
+}
+
+// wireAmount rounds an amount the way the transaction encoding does, so a coinbase compares equal after it has been sent over the network.
+func wireAmount(amount float64) float64 {
+	rounded, err := strconv.ParseFloat(fmt.Sprintf("%f", amount), 64)
+	if err != nil {
+		panic(err)
+	}
+	return rounded
+}
+
+// splitCoinbase returns the coinbase transactions a block starts with and the transactions after them.
+func splitCoinbase(transactions []Transaction) ([]Transaction, []Transaction) {
+	i := 0
+	for i < len(transactions) && IsCoinbase(transactions[i]) {
+		i++
+	}
+	return transactions[:i], transactions[i:]
+}
+
+// VerifyCoinbase checks that a block at height starts with the coinbase CoinbaseTransactions gives for it, paying at most the expected amounts, and has no other coinbase transactions. Blocks before Kyoto have no coinbase.
+func VerifyCoinbase(block Block, height int) bool {
+	return verifyCoinbaseOn(Blockchain, block, height)
+}
+
+// verifyCoinbaseOn checks the coinbase of a block at height against the blocks before it in chain instead of the local blockchain, e.g. while syncing from a peer.
+func verifyCoinbaseOn(chain []Block, block Block, height int) bool {
+	coinbase, transactions := splitCoinbase(block.Transactions)
+	for _, transaction := range transactions {
+		if IsCoinbase(transaction) {
+			Log("Block has a coinbase transaction after its other transactions. Ignoring block request.", true)
+			return false
+		}
+	}
+	if height < Env.Upgrades.Kyoto {
+		if len(coinbase) > 0 {
+			Log("Block has a coinbase transaction before Kyoto. Ignoring block request.", true)
+			return false
+		}
+		return true
+	}
+	expected := coinbaseTransactionsOn(chain, height, block.Miner, block.PreMiningTimeVerifiers, transactions)
+	if len(coinbase) != len(expected) {
+		Log(fmt.Sprintf("Block has %d coinbase transactions, expected %d. Ignoring block request.", len(coinbase), len(expected)), true)
+		return false
+	}
+	for i, transaction := range coinbase {
+		// Transaction bodies grow each time they are relayed, so a peer can count higher body fees than the miner did. A coinbase may claim less than it is owed, but never more.
+		if !bytes.Equal(transaction.Recipient.Y, expected[i].Recipient.Y) || !transaction.Timestamp.Equal(expected[i].Timestamp) || transaction.Amount > expected[i].Amount || transaction.FromSmartContract || len(transaction.Contracts) > 0 {
+			Log("Block has an invalid coinbase. Ignoring block request.", true)
+			return false
+		}
+	}
+	return true
+}
+
+// CoinbaseIssuance returns the tokens a block at height since Kyoto created: its coinbase, minus the fees the coinbase passes on to the miner.
+func CoinbaseIssuance(height int) float64 {
+	total := 0.0
+	for _, transaction := range Blockchain[height].Transactions {
+		if IsCoinbase(transaction) {
+			total += transaction.Amount
+		} else {
+			total -= CalculateTransactionFee(transaction, height)
+		}
+	}
+	return total
+}
@@ -0,0 +1,360 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every node setting. Settings are resolved in this order, with later sources winning: defaults, the config file, POLYCASH_* environment variables and command-line flags.
+type Config struct {
+	DataDir             string  `json:"datadir"`
+	Network             string  `json:"network"`
+	Mine                bool    `json:"mine"`
+	Serve               bool    `json:"serve"`
+	Port                string  `json:"port"`
+	Verbose             bool    `json:"verbose"`
+	LogFormat           string  `json:"logFormat"`
+	LogLevel            string  `json:"logLevel"`
+	LogFile             string  `json:"logFile"`
+	LogMaxSize          int     `json:"logMaxSize"`
+	LogMaxBackups       int     `json:"logMaxBackups"`
+	ContractsExecutable string  `json:"contractsExecutable"`
+	NodeExecutable      string  `json:"nodeExecutable"`
+	ContractTimeout     string  `json:"contractTimeout"`
+	ContractMemoryLimit int     `json:"contractMemoryLimit"`
+	ContractOutputLimit int     `json:"contractOutputLimit"`
+	ContractWorkers     int     `json:"contractWorkers"`
+	MaxFutureBlockTime  string  `json:"maxFutureBlockTime"`
+	MiningWorkers       int     `json:"miningWorkers"`
+	Pool                bool    `json:"pool"`
+	PoolShareDifficulty int     `json:"poolShareDifficulty"`
+	PoolFee             float64 `json:"poolFee"`
+	PoolMinimumPayout   float64 `json:"poolMinimumPayout"`
+	Light               bool    `json:"light"`
+	Archive             bool    `json:"archive"`
+}
+
+// ConfigFileName is the name of the config file looked up in the data directory when -config is not given.
+const ConfigFileName = "config.json"
+
+// DataDir holds the node's files (key.json, peers.txt, env.json, blockchain.json, receipts.json, ...). It defaults to the working directory.
+var DataDir = "."
+
+// CurrentConfig is the effective configuration of the running node.
+var CurrentConfig = DefaultConfig()
+
+// CurrentConfigPath is the config file CurrentConfig was read from, or empty if there was none.
+var CurrentConfigPath string
+
+// DataPath returns the path of a node file inside DataDir. Absolute paths are returned unchanged.
+func DataPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(DataDir, name)
+}
+
+func DefaultConfig() Config {
+	return Config{
+		DataDir:             ".",
+		LogFormat:           "text",
+		LogLevel:            "info",
+		LogMaxSize:          100,
+		LogMaxBackups:       5,
+		ContractsExecutable: "./contracts/target/debug/contracts",
+		ContractTimeout:     "10s",
+		ContractMemoryLimit: 2048,
+		ContractOutputLimit: 1024,
+		MaxFutureBlockTime:  "0s",
+		PoolShareDifficulty: 1000,
+		PoolFee:             0.01,
+		PoolMinimumPayout:   1,
+	}
+}
+
+type configSetting struct {
+	name   string
+	usage  string
+	isBool bool
+	set    func(c *Config, value string) error
+}
+
+func stringSetting(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func boolSetting(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", value)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func intSetting(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected a whole number, got %q", value)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func floatSetting(field func(c *Config) *float64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+// configSettings lists the settings that can be overridden. Each one has a flag with its name and an environment variable POLYCASH_<NAME>, e.g. -log-level and POLYCASH_LOG_LEVEL.
+var configSettings = []configSetting{
+	{"datadir", "Directory holding the node's files", false, stringSetting(func(c *Config) *string { return &c.DataDir })},
+	{"network", "Network profile (mainnet, testnet or devnet; defaults to the network in env.json)", false, stringSetting(func(c *Config) *string { return &c.Network })},
+	{"mine", "Set to true to start node as miner", true, boolSetting(func(c *Config) *bool { return &c.Mine })},
+	{"serve", "Set to true to start node as server", true, boolSetting(func(c *Config) *bool { return &c.Serve })},
+	{"port", "Port to listen on (server only; defaults to the network's port)", false, stringSetting(func(c *Config) *string { return &c.Port })},
+	{"verbose", "Set to true to enable verbose logging", true, boolSetting(func(c *Config) *bool { return &c.Verbose })},
+	{"log-format", "Log format (text or json)", false, stringSetting(func(c *Config) *string { return &c.LogFormat })},
+	{"log-level", "Log levels, e.g. info,p2p=debug,mining=warn", false, stringSetting(func(c *Config) *string { return &c.LogLevel })},
+	{"log-file", "Write logs to this file instead of stdout (relative to the data directory)", false, stringSetting(func(c *Config) *string { return &c.LogFile })},
+	{"log-max-size", "Rotate the log file after this many megabytes", false, intSetting(func(c *Config) *int { return &c.LogMaxSize })},
+	{"log-max-backups", "Number of rotated log files to keep", false, intSetting(func(c *Config) *int { return &c.LogMaxBackups })},
+	{"contracts-executable", "Path of the smart contract runtime", false, stringSetting(func(c *Config) *string { return &c.ContractsExecutable })},
+	{"contract-timeout", "How long a smart contract may run for, e.g. 10s (0s for no limit)", false, stringSetting(func(c *Config) *string { return &c.ContractTimeout })},
+	{"contract-memory-limit", "Megabytes of memory a smart contract may use (0 for no limit; Linux only)", false, intSetting(func(c *Config) *int { return &c.ContractMemoryLimit })},
+	{"contract-output-limit", "Kilobytes of output a smart contract may print (0 for no limit)", false, intSetting(func(c *Config) *int { return &c.ContractOutputLimit })},
+	{"contract-workers", "Number of smart contracts run at once (0 uses one per CPU)", false, intSetting(func(c *Config) *int { return &c.ContractWorkers })},
+	{"mining-workers", "Number of mining worker goroutines (0 uses one per CPU)", false, intSetting(func(c *Config) *int { return &c.MiningWorkers })},
+	{"pool", "Set to true to run a mining pool that hands out work to external miners", true, boolSetting(func(c *Config) *bool { return &c.Pool })},
+	{"pool-share-difficulty", "Difficulty of a mining pool share", false, intSetting(func(c *Config) *int { return &c.PoolShareDifficulty })},
+	{"pool-fee", "Fraction of each block reward the mining pool keeps, e.g. 0.01", false, floatSetting(func(c *Config) *float64 { return &c.PoolFee })},
+	{"pool-minimum-payout", "Smallest balance the mining pool pays out to a worker", false, floatSetting(func(c *Config) *float64 { return &c.PoolMinimumPayout })},
+	{"light", "Set to true to keep only block headers and prove transactions with peers instead of storing the blockchain", true, boolSetting(func(c *Config) *bool { return &c.Light })},
+	{"archive", "Set to true to keep the undo records of every block, so historical state is read without replaying blocks", true, boolSetting(func(c *Config) *bool { return &c.Archive })},
+	{"max-future-block-time", "How far ahead of the local clock a block's timestamp may be, e.g. 2s", false, stringSetting(func(c *Config) *string { return &c.MaxFutureBlockTime })},
+	{"node-executable", "Path of the node executable used by smart contracts (defaults to node_executable_path.txt)", false, stringSetting(func(c *Config) *string { return &c.NodeExecutable })},
+}
+
+func configEnvName(name string) string {
+	return "POLYCASH_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// settingFlag records the value of a flag so it can be applied after the config file and environment.
+type settingFlag struct {
+	setting configSetting
+	values  map[string]string
+}
+
+func (f settingFlag) String() string {
+	return ""
+}
+
+func (f settingFlag) Set(value string) error {
+	if err := f.setting.set(&Config{}, value); err != nil {
+		return err
+	}
+	f.values[f.setting.name] = value
+	return nil
+}
+
+func (f settingFlag) IsBoolFlag() bool {
+	return f.setting.isBool
+}
+
+// LoadConfig registers the config flags on fs, parses args and resolves the effective configuration. It returns the config and the path of the config file that was read, if any.
+func LoadConfig(fs *flag.FlagSet, args []string) (Config, string, error) {
+	flagValues := make(map[string]string)
+	for _, setting := range configSettings {
+		fs.Var(settingFlag{setting, flagValues}, setting.name, setting.usage)
+	}
+	configPath := fs.String("config", "", "Config file (defaults to config.json in the data directory)")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, "", err
+	}
+	config := DefaultConfig()
+	// The data directory decides where the config file is, so it cannot come from the file itself.
+	if dataDir, ok := os.LookupEnv(configEnvName("datadir")); ok {
+		config.DataDir = dataDir
+	}
+	if dataDir, ok := flagValues["datadir"]; ok {
+		config.DataDir = dataDir
+	}
+	path, required := *configPath, true
+	if path == "" {
+		path, required = os.Getenv(configEnvName("config")), true
+	}
+	if path == "" {
+		path, required = filepath.Join(config.DataDir, ConfigFileName), false
+	}
+	configJson, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		path = ""
+	} else if err != nil {
+		return Config{}, "", fmt.Errorf("could not read config file: %w", err)
+	} else {
+		dataDir := config.DataDir
+		decoder := json.NewDecoder(bytes.NewReader(configJson))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			return Config{}, "", fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+		config.DataDir = dataDir
+	}
+	for _, setting := range configSettings {
+		envName := configEnvName(setting.name)
+		if value, ok := os.LookupEnv(envName); ok {
+			if err := setting.set(&config, value); err != nil {
+				return Config{}, "", fmt.Errorf("invalid %s: %w", envName, err)
+			}
+		}
+	}
+	for _, setting := range configSettings {
+		if value, ok := flagValues[setting.name]; ok {
+			if err := setting.set(&config, value); err != nil {
+				return Config{}, "", fmt.Errorf("invalid -%s: %w", setting.name, err)
+			}
+		}
+	}
+	if config.Mine || config.Pool {
+		config.Serve = true
+	}
+	return config, path, config.Validate()
+}
+
+// Validate reports every invalid setting in c.
+func (c Config) Validate() error {
+	var errs []error
+	if info, err := os.Stat(c.DataDir); err == nil && !info.IsDir() {
+		errs = append(errs, fmt.Errorf("datadir %s is not a directory", c.DataDir))
+	}
+	if _, ok := NetworkProfiles[c.Network]; c.Network != "" && !ok {
+		errs = append(errs, fmt.Errorf("network %q must be one of %v", c.Network, NetworkNames()))
+	}
+	if port, err := strconv.Atoi(c.Port); c.Port != "" && (err != nil || port < 1 || port > 65535) {
+		errs = append(errs, fmt.Errorf("port %q must be a number between 1 and 65535", c.Port))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("logFormat %q must be text or json", c.LogFormat))
+	}
+	if _, _, err := parseLogLevelSpec(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("logLevel %q: %w", c.LogLevel, err))
+	}
+	if d, err := time.ParseDuration(c.MaxFutureBlockTime); err != nil || d < 0 {
+		errs = append(errs, fmt.Errorf("maxFutureBlockTime %q must be a non-negative duration such as 2s", c.MaxFutureBlockTime))
+	}
+	if d, err := time.ParseDuration(c.ContractTimeout); err != nil || d < 0 {
+		errs = append(errs, fmt.Errorf("contractTimeout %q must be a non-negative duration such as 10s", c.ContractTimeout))
+	}
+	if c.ContractMemoryLimit < 0 {
+		errs = append(errs, fmt.Errorf("contractMemoryLimit must not be negative, got %d", c.ContractMemoryLimit))
+	}
+	if c.ContractOutputLimit < 0 {
+		errs = append(errs, fmt.Errorf("contractOutputLimit must not be negative, got %d", c.ContractOutputLimit))
+	}
+	if c.ContractWorkers < 0 {
+		errs = append(errs, fmt.Errorf("contractWorkers must not be negative, got %d", c.ContractWorkers))
+	}
+	if c.MiningWorkers < 0 {
+		errs = append(errs, fmt.Errorf("miningWorkers must not be negative, got %d", c.MiningWorkers))
+	}
+	if c.PoolShareDifficulty < 1 {
+		errs = append(errs, fmt.Errorf("poolShareDifficulty must be positive, got %d", c.PoolShareDifficulty))
+	}
+	if c.PoolFee < 0 || c.PoolFee >= 1 {
+		errs = append(errs, fmt.Errorf("poolFee must be at least 0 and less than 1, got %v", c.PoolFee))
+	}
+	if c.PoolMinimumPayout <= 0 {
+		errs = append(errs, fmt.Errorf("poolMinimumPayout must be positive, got %v", c.PoolMinimumPayout))
+	}
+	if c.Light && (c.Serve || c.Mine || c.Pool) {
+		errs = append(errs, errors.New("light nodes can't serve, mine or run a pool"))
+	}
+	if c.Light && c.Archive {
+		errs = append(errs, errors.New("light nodes can't be archive nodes"))
+	}
+	if c.LogMaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("logMaxSize must be positive, got %d", c.LogMaxSize))
+	}
+	if c.LogMaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("logMaxBackups must not be negative, got %d", c.LogMaxBackups))
+	}
+	return errors.Join(errs...)
+}
+
+// seedFiles are copied from the working directory into a new data directory so a fresh node starts on the same network.
+var seedFiles = []string{"env.json", "peers.txt", "blockchain.json"}
+
+// ApplyConfig makes c the running configuration. It creates the data directory, seeds it with the network files from the working directory, loads env.json, selects the network profile and configures logging.
+func ApplyConfig(c Config, path string) error {
+	if err := os.MkdirAll(c.DataDir, 0755); err != nil {
+		return fmt.Errorf("could not create datadir: %w", err)
+	}
+	DataDir = c.DataDir
+	for _, name := range seedFiles {
+		if _, err := os.Stat(DataPath(name)); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		contents, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		if err := os.WriteFile(DataPath(name), contents, 0644); err != nil {
+			return err
+		}
+	}
+	LoadEnv()
+	if c.Network == "" {
+		c.Network = Env.Network
+	}
+	if c.Network == "" {
+		c.Network = DefaultNetwork
+	}
+	if err := ApplyNetworkProfile(c.Network); err != nil {
+		return err
+	}
+	if c.Port == "" {
+		c.Port = CurrentNetwork.DefaultPort
+	}
+	MaxFutureBlockTime, _ = time.ParseDuration(c.MaxFutureBlockTime)
+	CurrentContractExecutor = NewConfigContractExecutor(c)
+	*Verbose = c.Verbose
+	logFile := c.LogFile
+	if logFile != "" {
+		logFile = DataPath(logFile)
+	}
+	if err := ConfigureLogging(c.LogFormat, c.LogLevel, logFile, c.LogMaxSize, c.LogMaxBackups); err != nil {
+		return err
+	}
+	CurrentConfig = c
+	CurrentConfigPath = path
+	return nil
+}
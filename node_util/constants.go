@@ -8,6 +8,8 @@ You should have received a copy of the GNU General Public License along with thi
 */
 package node_util
 
+import "time"
+
 // Difficulty
 var InitialBlockDifficulty = uint64(50000)
 var MinimumBlockDifficulty = uint64(50000)
@@ -16,8 +18,19 @@ var MaximumUint64 = ^uint64(0)
 // Finality
 const BlocksUntilFinality = 3
 
+// TimeVerificationWindow is how far in the past a block's time may be when a peer is asked to verify it.
+const TimeVerificationWindow = 10 * time.Second
+
+// MedianTimeSpan is the number of previous blocks whose median timestamp a new block must be later than (after the Nairobi upgrade).
+const MedianTimeSpan = 11
+
+// MaxFutureBlockTime is how far ahead of the local clock a block's timestamp may be. It is set by the maxFutureBlockTime config setting.
+var MaxFutureBlockTime = time.Duration(0)
+
 // Rewards
 var BlocksBeforeReward = 3
+var RewardsStartHeight = 50
+var FeesStartHeight = 50
 var BlockReward = 1.0
 var TransactionFee = 0.0001
 var BodyFeePerByte = 0.000001
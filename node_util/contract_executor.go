@@ -0,0 +1,169 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ErrContractUnfinished is wrapped by the errors of contracts that the node stopped or couldn't run. Unlike a contract that exits with an error, which fails the same way on every node, these depend on the node.
+var ErrContractUnfinished = errors.New("contract did not finish")
+var ErrContractTimeout = fmt.Errorf("%w: it ran out of time", ErrContractUnfinished)
+var ErrContractOutputLimit = fmt.Errorf("%w: it printed more than the output limit", ErrContractUnfinished)
+
+// ContractExecutor runs a contract and returns what it printed: its transactions, state changes and gas used, in the format Contract.Execute parses. Run is called from several goroutines at once.
+type ContractExecutor interface {
+	Run(contents string, hash [32]byte) ([]byte, error)
+}
+
+// ProcessContractExecutor runs each contract in its own process of the contract runtime, with its own copy of the contract in a temp file.
+type ProcessContractExecutor struct {
+	Executable     string
+	NodeExecutable string
+	// Timeout is the wall-clock time a contract may run for. MemoryLimit, in bytes, is the address space it may use, and OutputLimit, in bytes, how much it may print. Zero means no limit. A contract stopped by a limit returns an error wrapping ErrContractUnfinished.
+	Timeout     time.Duration
+	MemoryLimit uint64
+	OutputLimit int
+	// workers holds a slot for every contract that is running.
+	workers chan struct{}
+}
+
+// NewProcessContractExecutor creates an executor that runs up to workers contracts at once, or one per CPU if workers is not positive.
+func NewProcessContractExecutor(executable string, workers int) *ProcessContractExecutor {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	return &ProcessContractExecutor{Executable: executable, workers: make(chan struct{}, workers)}
+}
+
+// NewConfigContractExecutor creates the executor c describes. c must be valid.
+func NewConfigContractExecutor(c Config) *ProcessContractExecutor {
+	executor := NewProcessContractExecutor(c.ContractsExecutable, c.ContractWorkers)
+	executor.NodeExecutable = c.NodeExecutable
+	executor.Timeout, _ = time.ParseDuration(c.ContractTimeout)
+	executor.MemoryLimit = uint64(c.ContractMemoryLimit) << 20
+	executor.OutputLimit = c.ContractOutputLimit << 10
+	return executor
+}
+
+// CurrentContractExecutor runs the node's contracts. It is set from the config by ApplyConfig.
+var CurrentContractExecutor ContractExecutor = NewConfigContractExecutor(DefaultConfig())
+
+// limitedBuffer collects a contract's output until it passes limit, then stops the contract.
+type limitedBuffer struct {
+	// buffer isn't embedded, so io.Copy can't read into it past Write
+	buffer   bytes.Buffer
+	limit    int
+	exceeded bool
+	stop     func()
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && b.buffer.Len()+len(p) > b.limit {
+		b.exceeded = true
+		b.stop()
+		return 0, ErrContractOutputLimit
+	}
+	return b.buffer.Write(p)
+}
+
+// contractEnv passes the data directory and node executable to the contract runtime, which runs the node to query the blockchain.
+func contractEnv(nodeExecutable string) []string {
+	env := os.Environ()
+	if dataDir, err := filepath.Abs(DataDir); err == nil {
+		env = append(env, configEnvName("datadir")+"="+dataDir)
+	}
+	if nodeExecutable != "" {
+		env = append(env, configEnvName("node-executable")+"="+nodeExecutable)
+	}
+	return env
+}
+
+func (e *ProcessContractExecutor) Run(contents string, hash [32]byte) ([]byte, error) {
+	e.workers <- struct{}{}
+	defer func() { <-e.workers }()
+	out, err := e.run(contents, hash)
+	var exitErr *exec.ExitError
+	if err != nil && !errors.Is(err, ErrContractUnfinished) && !(errors.As(err, &exitErr) && exitErr.Exited()) {
+		// Only an exit code is the contract's own result: a signal means it was killed, e.g. for running out of memory
+		err = fmt.Errorf("%w: %w", ErrContractUnfinished, err)
+	}
+	return out, err
+}
+
+func (e *ProcessContractExecutor) run(contents string, hash [32]byte) ([]byte, error) {
+	contractFile, err := os.CreateTemp("", "contract-*.blockasm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(contractFile.Name())
+	_, err = contractFile.WriteString(contents)
+	if closeErr := contractFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.Background(), func() {}
+	if e.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+	}
+	defer cancel()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	cmd := contractCommand(ctx, e.MemoryLimit, e.Executable, contractFile.Name(), string(hash[:]))
+	cmd.Env = contractEnv(e.NodeExecutable)
+	// The runtime's own queries to the node can keep its output open after it is killed
+	cmd.WaitDelay = time.Second
+	out := &limitedBuffer{limit: e.OutputLimit, stop: stop}
+	cmd.Stdout = out
+	err = cmd.Run()
+	switch {
+	case out.exceeded:
+		return nil, ErrContractOutputLimit
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, ErrContractTimeout
+	case err != nil:
+		return nil, err
+	}
+	return out.buffer.Bytes(), nil
+}
+
+// ContractResult is what Contract.Execute returned for one contract.
+type ContractResult struct {
+	Transactions []Transaction
+	Transition   StateTransition
+	GasUsed      float64
+	Err          error
+}
+
+// ExecuteContracts executes contracts in parallel, as many at once as CurrentContractExecutor allows, and returns their results in the same order.
+func ExecuteContracts(contracts []Contract) []ContractResult {
+	results := make([]ContractResult, len(contracts))
+	var wg sync.WaitGroup
+	for i, contract := range contracts {
+		wg.Add(1)
+		go func(i int, contract Contract) {
+			defer wg.Done()
+			result := &results[i]
+			result.Transactions, result.Transition, result.GasUsed, result.Err = contract.Execute()
+		}(i, contract)
+	}
+	wg.Wait()
+	return results
+}
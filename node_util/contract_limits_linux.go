@@ -0,0 +1,26 @@
+//go:build linux
+
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// contractCommand runs executable with args, with its address space, and that of the processes it starts, capped at memoryLimit bytes. A shell sets the limit and then execs executable, so it applies before the runtime's first instruction.
+func contractCommand(ctx context.Context, memoryLimit uint64, executable string, args ...string) *exec.Cmd {
+	if memoryLimit == 0 {
+		return exec.CommandContext(ctx, executable, args...)
+	}
+	script := fmt.Sprintf(`ulimit -v %d && exec "$0" "$@"`, memoryLimit>>10)
+	return exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", script, executable}, args...)...)
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"context"
+	"os/exec"
+)
+
+// contractCommand runs executable with args. The memory limit is only enforced on Linux.
+func contractCommand(ctx context.Context, memoryLimit uint64, executable string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, executable, args...)
+}
@@ -14,10 +14,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"os"
-	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ContractParty struct {
@@ -32,16 +31,13 @@ type Contract struct {
 }
 
 func (c Contract) Execute() ([]Transaction, StateTransition, float64, error) {
+	defer ContractExecutionSeconds.ObserveSince(time.Now())
 	if !VerifySmartContract(c) {
 		Warn("Invalid contract detected.")
 		return make([]Transaction, 0), StateTransition{}, 0, nil
 	}
-	if err := os.WriteFile("contract.blockasm", []byte(c.Contents), 0666); err != nil {
-		return nil, StateTransition{}, 0, err
-	}
-	contractStr := c.Contents
-	hash := sha256.Sum256([]byte(contractStr))
-	out, err := exec.Command("./contracts/target/debug/contracts", "contract.blockasm", string(hash[:])).Output()
+	hash := sha256.Sum256([]byte(c.Contents))
+	out, err := CurrentContractExecutor.Run(c.Contents, hash)
 	if err != nil {
 		return nil, StateTransition{}, 0, err
 	}
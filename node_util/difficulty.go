@@ -13,7 +13,31 @@ import (
 	"time"
 )
 
+// difficultyOn returns the difficulty a block mined by miner must have to extend chain. From Quito, it follows the last block the same miner mined in chain, or the initial difficulty for a new miner. Before, it follows the previous block.
+func difficultyOn(chain []Block, miner []byte) uint64 {
+	height := len(chain)
+	if height < Env.Upgrades.Quito {
+		if height <= 1 {
+			return GetDifficulty(time.Minute, MinimumBlockDifficulty)
+		}
+		return GetDifficulty(chain[height-1].MiningTime, chain[height-1].Difficulty)
+	}
+	lastMinedBlock, found := lastMinedBlockByOn(chain, miner)
+	if !found {
+		return GetDifficulty(time.Minute, InitialBlockDifficulty)
+	}
+	return GetDifficulty(lastMinedBlock.MiningTime, lastMinedBlock.Difficulty)
+}
+
+// verifyDifficultyOn checks the difficulty of a block added to chain.
+func verifyDifficultyOn(chain []Block, block Block) bool {
+	return block.Difficulty == difficultyOn(chain, block.Miner.Y) && block.Difficulty >= MinimumBlockDifficulty
+}
+
 func GetDifficulty(lastTime time.Duration, lastDifficulty uint64) uint64 {
+	if CurrentNetwork.FixedDifficulty != 0 {
+		return CurrentNetwork.FixedDifficulty
+	}
 	// The target time for a block is 1 minute.
 	// The difficulty is adjusted on a per-miner, per-block basis.
 	// To give faster miners a (small) advantage, the difficulty is divided by the result of a modified sigmoid function.
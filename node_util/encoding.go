@@ -0,0 +1,183 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The canonical binary encoding is specified in docs/encoding.md, with test vectors in docs/encoding_vectors.json. Any change here must keep the vectors passing, in Go and in the contracts crate.
+
+// Block versions. Version 0 blocks are hashed from Go's formatting of the block; BinaryBlockVersion blocks, from the Oslo upgrade, use the binary encoding for all hashing and signing. StateRootBlockVersion blocks, from the Paris upgrade, also commit to the state in their header.
+const (
+	LegacyBlockVersion    = 0
+	BinaryBlockVersion    = 1
+	StateRootBlockVersion = 2
+)
+
+// BlockVersionAt returns the version of a block at height. A block only gets a state root once it is also binary, so Paris takes effect from Oslo if it is set earlier.
+func BlockVersionAt(height int) int {
+	if height < Env.Upgrades.Oslo {
+		return LegacyBlockVersion
+	}
+	if height < Env.Upgrades.Paris {
+		return BinaryBlockVersion
+	}
+	return StateRootBlockVersion
+}
+
+// VerifyBlockVersion checks that a block at height has the version BlockVersionAt gives for it.
+func VerifyBlockVersion(block Block, height int) bool {
+	if block.Version != BlockVersionAt(height) {
+		Log(fmt.Sprintf("Block has version %d instead of %d. Ignoring block request.", block.Version, BlockVersionAt(height)), true)
+		return false
+	}
+	return true
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return binary.BigEndian.AppendUint32(b, v)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(b, v)
+}
+
+func appendInt64(b []byte, v int64) []byte {
+	return appendUint64(b, uint64(v))
+}
+
+func appendFloat64(b []byte, v float64) []byte {
+	return appendUint64(b, math.Float64bits(v))
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// appendBytes appends v with its length in front, so fields can't run into each other.
+func appendBytes(b []byte, v []byte) []byte {
+	b = appendUint32(b, uint32(len(v)))
+	return append(b, v...)
+}
+
+// EncodeTransaction returns the binary encoding of a transaction. Its body is encoded at its original size, its body signatures are left out and its amount and fee are rounded the way the wire encoding rounds them, because all of these change when a transaction is relayed.
+func EncodeTransaction(transaction Transaction) []byte {
+	var b []byte
+	b = appendBytes(b, transaction.Sender.Y)
+	b = appendBytes(b, transaction.Recipient.Y)
+	b = appendFloat64(b, wireAmount(transaction.Amount))
+	b = appendBytes(b, transaction.SenderSignature.S)
+	b = appendInt64(b, transaction.Timestamp.UnixNano())
+	b = appendFloat64(b, wireAmount(transaction.Fee))
+	b = appendBool(b, transaction.FromSmartContract)
+	b = appendUint32(b, uint32(len(transaction.Contracts)))
+	for _, contract := range transaction.Contracts {
+		b = appendBytes(b, []byte(contract.Contents))
+		b = appendUint32(b, uint32(len(contract.Parties)))
+		for _, party := range contract.Parties {
+			b = appendBytes(b, party.Signature.S)
+			b = appendBytes(b, party.PublicKey.Y)
+		}
+		b = appendFloat64(b, contract.GasUsed)
+	}
+	b = appendBytes(b, OriginalBody(transaction.Body))
+	return b
+}
+
+// EncodeSigningPayload returns what a sender signs for a transaction in a BinaryBlockVersion block. The amount and fee are rounded like in EncodeTransaction, so the signature still holds after the transaction is relayed.
+func EncodeSigningPayload(sender PublicKey, recipient PublicKey, amount float64, timestamp int64, fee float64) []byte {
+	var b []byte
+	b = appendBytes(b, []byte("transaction"))
+	b = appendBytes(b, sender.Y)
+	b = appendBytes(b, recipient.Y)
+	b = appendFloat64(b, wireAmount(amount))
+	b = appendInt64(b, timestamp)
+	b = appendFloat64(b, wireAmount(fee))
+	return b
+}
+
+// EncodeStateTransition returns the binary encoding of a state transition, with its keys in byte order.
+func EncodeStateTransition(transition StateTransition) []byte {
+	keys := make([]string, 0, len(transition.UpdatedData))
+	for key := range transition.UpdatedData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b []byte
+	b = appendUint32(b, uint32(len(keys)))
+	for _, key := range keys {
+		b = appendBytes(b, []byte(key))
+		b = appendBytes(b, transition.UpdatedData[key])
+	}
+	return b
+}
+
+// EncodeBlockHeader returns the binary encoding of a block header, which a BinaryBlockVersion block's hash covers. The state root is only encoded from StateRootBlockVersion.
+func EncodeBlockHeader(header BlockHeader) []byte {
+	var b []byte
+	b = appendUint32(b, uint32(header.Version))
+	b = append(b, header.PreviousBlockHash[:]...)
+	b = append(b, header.MerkleRoot[:]...)
+	b = append(b, header.TransitionHash[:]...)
+	if header.Version >= StateRootBlockVersion {
+		b = append(b, header.StateRoot[:]...)
+	}
+	b = appendInt64(b, header.Timestamp.UnixNano())
+	b = appendUint64(b, header.Difficulty)
+	b = appendInt64(b, header.Nonce)
+	b = appendBytes(b, header.Miner.Y)
+	return b
+}
+
+// EncodeTimeVerification returns what a time verifier signs for a time in a BinaryBlockVersion block.
+func EncodeTimeVerification(t time.Time) []byte {
+	var b []byte
+	b = appendBytes(b, []byte("time"))
+	b = appendInt64(b, t.UnixNano())
+	return b
+}
+
+// TimeVerificationMessage returns what a time verifier signs for a time in a block of the given version.
+func TimeVerificationMessage(version int, t time.Time) []byte {
+	if version >= BinaryBlockVersion {
+		return EncodeTimeVerification(t)
+	}
+	return []byte(fmt.Sprintf("%d", t.UnixNano()))
+}
+
+// TransactionSigningHashFor returns the hash a sender signs for a transaction that will be verified in a block of the given version.
+func TransactionSigningHashFor(version int, sender PublicKey, recipient PublicKey, amount string, timestamp int64, fee float64) [32]byte {
+	if version < BinaryBlockVersion {
+		return TransactionSigningHash(sender, recipient, amount, timestamp, fee)
+	}
+	amountFloat, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		panic(err)
+	}
+	return sha256.Sum256(EncodeSigningPayload(sender, recipient, amountFloat, timestamp, fee))
+}
+
+// legacySignatureAllowed reports whether a transaction verified at height may still be signed the legacy way: before Oslo, or if it was signed before the last block before Oslo, so transactions that were pending at the switch can still be mined.
+func legacySignatureAllowed(timestamp time.Time, height int) bool {
+	if height < Env.Upgrades.Oslo {
+		return true
+	}
+	last := Env.Upgrades.Oslo - 1
+	return last >= 0 && last < len(Blockchain) && !timestamp.After(Blockchain[last].Timestamp)
+}
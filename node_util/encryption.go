@@ -13,15 +13,17 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
 )
 
 func IsKeyEncrypted() bool {
 	// Check if key.json is encrypted.
-	contents, err := os.ReadFile("key.json")
+	contents, err := os.ReadFile(DataPath("key.json"))
 	if err != nil {
-		Error("No key found.", true)
+		Error("No key found.", false)
+		return false
 	}
 	var key PrivateKey
 	err = json.Unmarshal(contents, &key)
@@ -31,52 +33,50 @@ func IsKeyEncrypted() bool {
 	return false
 }
 
-func EncryptKey(password string) {
-	plaintext, err := os.ReadFile("key.json")
+// EncryptKey encrypts key.json with password, which must be 16, 24 or 32 bytes long.
+func EncryptKey(password string) error {
+	plaintext, err := os.ReadFile(DataPath("key.json"))
 	if err != nil {
-		Error("No key found.", true)
+		return errors.New("no key found")
 	}
 	block, err := aes.NewCipher([]byte(password))
 	if err != nil {
-		Error("Error creating cipher. Ensure that the password is a multiple of 16 characters long.", false)
-		return
+		return errors.New("error creating cipher, ensure that the password is a multiple of 16 characters long")
 	}
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		panic(err)
+		return err
 	}
 	cipherText := gcm.Seal(nonce, nonce, plaintext, nil)
-	err = os.WriteFile("key.json", cipherText, 0644)
-	if err != nil {
-		panic(err)
-	}
+	return os.WriteFile(DataPath("key.json"), cipherText, 0644)
 }
 
-func DecryptKey(password string) {
-	ciphertext, err := os.ReadFile("key.json")
+// DecryptKey decrypts key.json, which was encrypted by EncryptKey with password.
+func DecryptKey(password string) error {
+	ciphertext, err := os.ReadFile(DataPath("key.json"))
 	if err != nil {
-		panic(err)
+		return errors.New("no key found")
 	}
 	block, err := aes.NewCipher([]byte(password))
 	if err != nil {
-		panic(err)
+		return err
 	}
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	nonceSize := gcm.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return errors.New("key is not encrypted")
+	}
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		panic(err)
-	}
-	err = os.WriteFile("key.json", plaintext, 0644)
-	if err != nil {
-		panic(err)
+		return errors.New("wrong password or key is not encrypted")
 	}
+	return os.WriteFile(DataPath("key.json"), plaintext, 0644)
 }
@@ -10,6 +10,7 @@ package node_util
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
 )
@@ -18,6 +19,13 @@ type NetworkUpgrades struct {
 	Guadalajara int `json:"guadalajara"`
 	Jinan       int `json:"jinan"`
 	Alexandria  int `json:"alexandria"`
+	Nairobi     int `json:"nairobi"`
+	Kyoto       int `json:"kyoto"`
+	Lima        int `json:"lima"`
+	Manila      int `json:"manila"`
+	Oslo        int `json:"oslo"`
+	Paris       int `json:"paris"`
+	Quito       int `json:"quito"`
 }
 
 type Environment struct {
@@ -27,8 +35,47 @@ type Environment struct {
 
 var Env Environment
 
+// upgradeOverrides holds the upgrade heights set in env.json. Heights it doesn't set are nil and keep the network profile's.
+type upgradeOverrides struct {
+	Guadalajara *int `json:"guadalajara"`
+	Jinan       *int `json:"jinan"`
+	Alexandria  *int `json:"alexandria"`
+	Nairobi     *int `json:"nairobi"`
+	Kyoto       *int `json:"kyoto"`
+	Lima        *int `json:"lima"`
+	Manila      *int `json:"manila"`
+	Oslo        *int `json:"oslo"`
+	Paris       *int `json:"paris"`
+	Quito       *int `json:"quito"`
+}
+
+func (o upgradeOverrides) applyTo(upgrades *NetworkUpgrades) {
+	override := func(height *int, value *int) {
+		if value != nil {
+			*height = *value
+		}
+	}
+	override(&upgrades.Guadalajara, o.Guadalajara)
+	override(&upgrades.Jinan, o.Jinan)
+	override(&upgrades.Alexandria, o.Alexandria)
+	override(&upgrades.Nairobi, o.Nairobi)
+	override(&upgrades.Kyoto, o.Kyoto)
+	override(&upgrades.Lima, o.Lima)
+	override(&upgrades.Manila, o.Manila)
+	override(&upgrades.Oslo, o.Oslo)
+	override(&upgrades.Paris, o.Paris)
+	override(&upgrades.Quito, o.Quito)
+}
+
+// envUpgrades are the upgrade heights env.json sets for envUpgradesNetwork, the network it names.
+var envUpgrades upgradeOverrides
+var envUpgradesNetwork string
+
 func LoadEnv() {
-	envFile, err := os.Open("env.json")
+	envFile, err := os.Open(DataPath("env.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -38,4 +85,14 @@ func LoadEnv() {
 	if err != nil {
 		panic(err)
 	}
+	var overrides struct {
+		Network  string           `json:"network"`
+		Upgrades upgradeOverrides `json:"upgrades"`
+	}
+	err = json.Unmarshal(jsonBytes, &overrides)
+	if err != nil {
+		panic(err)
+	}
+	envUpgrades = overrides.Upgrades
+	envUpgradesNetwork = overrides.Network
 }
@@ -0,0 +1,80 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import "sync"
+
+const (
+	BlockEvent       = "block"
+	TransactionEvent = "transaction"
+)
+
+// Event is published when a block is appended to the local blockchain or a transaction enters the mining pool.
+type Event struct {
+	Type        string
+	Height      int
+	Block       Block
+	Transaction Transaction
+}
+
+// Subscription receives published events on Events. If the subscriber falls behind and its buffer fills up, it is dropped: Events is closed and Lagged is set.
+type Subscription struct {
+	Events chan Event
+	Lagged bool
+	closed bool
+}
+
+var subscriptions = make(map[*Subscription]bool)
+var subscriptionsMutex sync.Mutex
+
+func Subscribe(bufferSize int) *Subscription {
+	subscription := &Subscription{
+		Events: make(chan Event, bufferSize),
+	}
+	subscriptionsMutex.Lock()
+	subscriptions[subscription] = true
+	subscriptionsMutex.Unlock()
+	return subscription
+}
+
+func (s *Subscription) Unsubscribe() {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	s.close()
+}
+
+// close must be called with subscriptionsMutex held.
+func (s *Subscription) close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(subscriptions, s)
+	close(s.Events)
+}
+
+// PublishEvent sends an event to every subscriber without blocking.
+func PublishEvent(event Event) {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	for subscription := range subscriptions {
+		// A subscription closed while another node's state was swapped in (see NodeState) is still in this map.
+		if subscription.closed {
+			delete(subscriptions, subscription)
+			continue
+		}
+		select {
+		case subscription.Events <- event:
+		default:
+			Log("Dropping subscriber that fell behind.", true)
+			subscription.Lagged = true
+			subscription.close()
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Default and maximum page sizes for /explorer/address
+const DefaultHistoryPageSize = 50
+const MaxHistoryPageSize = 500
+
+type BlockInfo struct {
+	Height        int    `json:"height"`
+	Hash          string `json:"hash"`
+	Confirmations int    `json:"confirmations"`
+	Block         Block  `json:"block"`
+}
+
+type TransactionInfo struct {
+	Id            string      `json:"id"`
+	BlockHeight   int         `json:"blockHeight"`
+	BlockHash     string      `json:"blockHash"`
+	Index         int         `json:"index"`
+	Confirmations int         `json:"confirmations"`
+	Transaction   Transaction `json:"transaction"`
+}
+
+type AddressHistory struct {
+	PublicKey    []byte            `json:"publicKey"`
+	Total        int               `json:"total"`
+	Page         int               `json:"page"`
+	Limit        int               `json:"limit"`
+	Transactions []TransactionInfo `json:"transactions"`
+}
+
+func GetBlockInfo(height int) (BlockInfo, bool) {
+	if height < 0 || height >= len(Blockchain) {
+		return BlockInfo{}, false
+	}
+	hash := HashBlock(Blockchain[height])
+	return BlockInfo{
+		Height:        height,
+		Hash:          hex.EncodeToString(hash[:]),
+		Confirmations: GetConfirmations(height),
+		Block:         Blockchain[height],
+	}, true
+}
+
+func GetBlockInfoByHash(hashStr string) (BlockInfo, bool) {
+	hashBytes, err := hex.DecodeString(hashStr)
+	if err != nil || len(hashBytes) != 64 {
+		return BlockInfo{}, false
+	}
+	var hash [64]byte
+	copy(hash[:], hashBytes)
+	height, ok := GetBlockHeightByHash(hash)
+	if !ok {
+		return BlockInfo{}, false
+	}
+	return GetBlockInfo(height)
+}
+
+func getTransactionInfoAt(location TransactionLocation) (TransactionInfo, bool) {
+	if location.Height >= len(Blockchain) || location.Index >= len(Blockchain[location.Height].Transactions) {
+		return TransactionInfo{}, false
+	}
+	block := Blockchain[location.Height]
+	transaction := block.Transactions[location.Index]
+	blockHash := HashBlock(block)
+	return TransactionInfo{
+		Id:            TransactionId(transaction),
+		BlockHeight:   location.Height,
+		BlockHash:     hex.EncodeToString(blockHash[:]),
+		Index:         location.Index,
+		Confirmations: GetConfirmations(location.Height),
+		Transaction:   transaction,
+	}, true
+}
+
+func GetTransactionInfo(id string) (TransactionInfo, bool) {
+	hashBytes, err := hex.DecodeString(id)
+	if err != nil || len(hashBytes) != 32 {
+		return TransactionInfo{}, false
+	}
+	var hash [32]byte
+	copy(hash[:], hashBytes)
+	location, ok := GetTransactionLocation(hash)
+	if !ok {
+		return TransactionInfo{}, false
+	}
+	return getTransactionInfoAt(location)
+}
+
+func GetAddressHistoryInfo(key []byte, page int, limit int) AddressHistory {
+	locations, total := GetAddressHistory(key, page*limit, limit)
+	history := AddressHistory{
+		PublicKey:    key,
+		Total:        total,
+		Page:         page,
+		Limit:        limit,
+		Transactions: []TransactionInfo{},
+	}
+	for _, location := range locations {
+		info, ok := getTransactionInfoAt(location)
+		if ok {
+			history.Transactions = append(history.Transactions, info)
+		}
+	}
+	return history
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(bytes)
+	if err != nil {
+		Log("Failed to write response.", true)
+	}
+}
+
+func HandleExplorerBlockRequest(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	var info BlockInfo
+	var ok bool
+	if hash := query.Get("hash"); hash != "" {
+		info, ok = GetBlockInfoByHash(hash)
+	} else {
+		height, err := strconv.Atoi(query.Get("height"))
+		if err != nil {
+			http.Error(w, "invalid or missing height", http.StatusBadRequest)
+			return
+		}
+		info, ok = GetBlockInfo(height)
+	}
+	if !ok {
+		http.Error(w, "block not found", http.StatusNotFound)
+		return
+	}
+	writeJson(w, info)
+}
+
+func HandleExplorerTransactionRequest(w http.ResponseWriter, req *http.Request) {
+	info, ok := GetTransactionInfo(req.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, "transaction not found", http.StatusNotFound)
+		return
+	}
+	writeJson(w, info)
+}
+
+func HandleExplorerAddressRequest(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	// "+" becomes a space when the key isn't URL-escaped
+	key, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(query.Get("key"), " ", "+"))
+	if err != nil || len(key) == 0 {
+		http.Error(w, "invalid or missing key", http.StatusBadRequest)
+		return
+	}
+	page := 0
+	if pageStr := query.Get("page"); pageStr != "" {
+		page, err = strconv.Atoi(pageStr)
+		if err != nil || page < 0 {
+			http.Error(w, "invalid page", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := DefaultHistoryPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > MaxHistoryPageSize {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	writeJson(w, GetAddressHistoryInfo(key, page, limit))
+}
@@ -0,0 +1,106 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// MaxIssuedTemplates is how many templates handed to external miners the node remembers. Submissions for older templates are rejected.
+const MaxIssuedTemplates = 64
+
+var ErrPoolDry = errors.New("mining pool is empty")
+var ErrUnknownTemplate = errors.New("unknown or expired template")
+var ErrStaleTemplate = errors.New("stale template: a new block has been added since it was issued")
+var ErrInvalidNonce = errors.New("nonce does not solve the template")
+
+// templateStore holds the templates issued to external miners, oldest first.
+type templateStore struct {
+	mutex     sync.Mutex
+	templates map[string]BlockTemplate
+	order     []string
+}
+
+func newTemplateStore() *templateStore {
+	return &templateStore{templates: make(map[string]BlockTemplate)}
+}
+
+var issuedTemplates = newTemplateStore()
+
+func (s *templateStore) add(template BlockTemplate) string {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		panic(err)
+	}
+	id := hex.EncodeToString(idBytes)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.templates[id] = template
+	s.order = append(s.order, id)
+	if len(s.order) > MaxIssuedTemplates {
+		delete(s.templates, s.order[0])
+		s.order = s.order[1:]
+	}
+	return id
+}
+
+func (s *templateStore) get(id string) (BlockTemplate, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	template, ok := s.templates[id]
+	return template, ok
+}
+
+func (s *templateStore) remove(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.templates, id)
+}
+
+// IssueBlockTemplate builds a template paid to miner for external mining software and returns it with the ID to submit a solution under.
+func IssueBlockTemplate(miner PublicKey) (string, BlockTemplate, error) {
+	template := NewBlockTemplate(miner)
+	if len(template.Transactions) == 0 {
+		return "", BlockTemplate{}, ErrPoolDry
+	}
+	MiningAttemptsCounter.Inc()
+	return issuedTemplates.add(template), template, nil
+}
+
+// SubmitBlock finalizes an issued template with a nonce found by an external miner: it collects the post-mining time verifier signatures, appends the block and broadcasts it.
+func SubmitBlock(id string, nonce int64) (Block, error) {
+	template, ok := issuedTemplates.get(id)
+	if !ok {
+		return Block{}, ErrUnknownTemplate
+	}
+	ChainMutex.Lock()
+	stale := len(Blockchain) != template.Height || (len(Blockchain) > 0 && HashBlock(Blockchain[len(Blockchain)-1]) != template.PreviousBlockHash)
+	ChainMutex.Unlock()
+	if stale {
+		issuedTemplates.remove(id)
+		return Block{}, ErrStaleTemplate
+	}
+	if !template.Solves(nonce) {
+		return Block{}, ErrInvalidNonce
+	}
+	block, err := FinalizeBlock(template, nonce)
+	if err != nil {
+		return Block{}, err
+	}
+	issuedTemplates.remove(id)
+	if err := addMinedBlock(block); err != nil {
+		return Block{}, err
+	}
+	blockHash := HashBlock(block)
+	miningLog.Info("Block submitted by external miner.", Fields{"height": template.Height, "block": hex.EncodeToString(blockHash[:8])})
+	return block, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+// FeeEstimateBlocks is how many recent blocks EstimateFee looks at.
+const FeeEstimateBlocks = 20
+
+// FeeEstimate is a recommended fee for a transaction to be mined within Blocks blocks.
+type FeeEstimate struct {
+	Blocks int `json:"blocks"`
+	// FeeRate is a multiple of a transaction's minimum fee.
+	FeeRate float64 `json:"feeRate"`
+	// Fee is FeeRate applied to a plain transfer without a body or contracts.
+	Fee float64 `json:"fee"`
+}
+
+// FormatFee formats a fee the way it is signed and sent over the network.
+func FormatFee(fee float64) string {
+	return fmt.Sprintf("%f", fee)
+}
+
+// TransactionSigningHash returns the hash a sender signs. Transactions without a set fee are signed the way they were before senders could set fees, so their signatures stay valid.
+func TransactionSigningHash(sender PublicKey, recipient PublicKey, amount string, timestamp int64, fee float64) [32]byte {
+	transactionString := fmt.Sprintf("%s:%s:%s:%d", sender.Y, recipient.Y, amount, timestamp)
+	if fee != 0 {
+		transactionString += ":" + FormatFee(fee)
+	}
+	return sha256.Sum256([]byte(transactionString))
+}
+
+// MinimumFee returns the least a transaction must offer: the network's fixed fee, its body and the gas its contracts used.
+func MinimumFee(transaction Transaction) float64 {
+	fee := TransactionFee + (BodyFeePerByte * float64(len(transaction.Body)))
+	fee += GasPrice * TransactionGasUsed(transaction)
+	return fee
+}
+
+// offeredFee returns the fee a transaction pays once fees have started: its set fee, or the minimum fee if it has none.
+func offeredFee(transaction Transaction) float64 {
+	if transaction.Fee > 0 {
+		return transaction.Fee
+	}
+	return MinimumFee(transaction)
+}
+
+// FeeRate returns the fee a transaction offers as a multiple of its minimum fee. Miners pick the transactions with the highest rates first.
+func FeeRate(transaction Transaction) float64 {
+	minimum := MinimumFee(transaction)
+	if minimum <= 0 {
+		return offeredFee(transaction)
+	}
+	return offeredFee(transaction) / minimum
+}
+
+// groupByFeeRate groups transactions with the transactions their contracts created, and orders the groups by fee rate, highest first.
+func groupByFeeRate(transactions []Transaction) [][]Transaction {
+	var groups [][]Transaction
+	for _, transaction := range transactions {
+		if transaction.FromSmartContract && len(groups) > 0 {
+			groups[len(groups)-1] = append(groups[len(groups)-1], transaction)
+			continue
+		}
+		groups = append(groups, []Transaction{transaction})
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return FeeRate(groups[i][0]) > FeeRate(groups[j][0])
+	})
+	return groups
+}
+
+// EstimateFee recommends a fee rate for a transaction to be mined within blocks blocks. It takes the lowest rate accepted by each of the last FeeEstimateBlocks blocks and picks the smallest one that at least 1 in blocks of them accepted. If the mining pool holds more transactions paying at least that rate than blocks blocks have recently carried, the rate is raised to that of the last one that would fit. The rate is never below 1, the minimum fee.
+func EstimateFee(blocks int) FeeEstimate {
+	if blocks < 1 {
+		blocks = 1
+	}
+	// Waiting longer than the blocks it looks at doesn't change the estimate
+	if blocks > FeeEstimateBlocks {
+		blocks = FeeEstimateBlocks
+	}
+	start := len(Blockchain) - FeeEstimateBlocks
+	if start < 1 {
+		start = 1
+	}
+	var lowestRates []float64
+	capacity := 1
+	for _, block := range Blockchain[start:] {
+		lowest := -1.0
+		count := 0
+		for _, transaction := range block.Transactions {
+			if IsCoinbase(transaction) || transaction.FromSmartContract {
+				continue
+			}
+			count++
+			if rate := FeeRate(transaction); lowest < 0 || rate < lowest {
+				lowest = rate
+			}
+		}
+		if count > capacity {
+			capacity = count
+		}
+		if lowest >= 0 {
+			lowestRates = append(lowestRates, lowest)
+		}
+	}
+	rate := 1.0
+	if len(lowestRates) > 0 {
+		sort.Float64s(lowestRates)
+		// The rate at this index was enough for at least len/blocks of the blocks
+		accepted := (len(lowestRates) + blocks - 1) / blocks
+		rate = lowestRates[accepted-1]
+	}
+	var pending []float64
+	for _, transaction := range MiningTransactions {
+		if !transaction.FromSmartContract {
+			pending = append(pending, FeeRate(transaction))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(pending)))
+	if ahead := blocks * capacity; len(pending) >= ahead && pending[ahead-1] > rate {
+		rate = pending[ahead-1]
+	}
+	if rate < 1 {
+		rate = 1
+	}
+	return FeeEstimate{
+		Blocks:  blocks,
+		FeeRate: rate,
+		Fee:     wireAmount(rate * MinimumFee(Transaction{})),
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+)
+
+// MaxGenerateBlocks is the most blocks a single GenerateBlocks call mines.
+const MaxGenerateBlocks = 1000
+
+// GenerateBlocks immediately mines count blocks paid to miner, appends them to the local blockchain and broadcasts them. Pending transactions go into the first block. It only works on networks that allow it, such as regtest.
+func GenerateBlocks(count int, miner PublicKey) ([]Block, error) {
+	if !CurrentNetwork.AllowGenerate {
+		return nil, fmt.Errorf("generate is not available on %s", CurrentNetwork.Name)
+	}
+	if count < 1 || count > MaxGenerateBlocks {
+		return nil, fmt.Errorf("block count must be between 1 and %d, got %d", MaxGenerateBlocks, count)
+	}
+	blocks := make([]Block, 0, count)
+	for i := 0; i < count; i++ {
+		block := generateBlock(miner)
+		if err := addMinedBlock(block); err != nil {
+			return blocks, err
+		}
+		blockHash := HashBlock(block)
+		miningLog.Info("Block generated.", Fields{"height": len(Blockchain) - 1, "block": hex.EncodeToString(blockHash[:8])})
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+func generateBlock(miner PublicKey) Block {
+	template := NewBlockTemplate(miner)
+	nonce, _ := NewMiningEngine(1).Solve(context.Background(), template)
+	block := template.Block(nonce)
+	block.MiningTime = Since(template.Created)
+	return block
+}
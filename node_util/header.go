@@ -0,0 +1,101 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// BlockHeader is the part of a block its hash commits to from the Oslo upgrade. The transactions, state transition and state are committed to by MerkleRoot, TransitionHash and StateRoot, so a header can be stored, synced and hashed without the rest of the block.
+type BlockHeader struct {
+	// Version selects how the block is hashed and signed, see BlockVersionAt.
+	Version           int      `json:"version"`
+	PreviousBlockHash [64]byte `json:"previousBlockHash"`
+	// MerkleRoot commits to the transactions, see TransactionsMerkleRoot. It is zero before Manila.
+	MerkleRoot [32]byte `json:"merkleRoot"`
+	// TransitionHash commits to the state transition, see HashStateTransition. It is zero before Oslo.
+	TransitionHash [32]byte `json:"transitionHash"`
+	// StateRoot commits to the state after the block's transition, see StateRoot. It is zero before Paris.
+	StateRoot  [32]byte  `json:"stateRoot"`
+	Timestamp  time.Time `json:"timestamp"`
+	Difficulty uint64    `json:"difficulty"`
+	Nonce      int64     `json:"nonce"`
+	Miner      PublicKey `json:"miner"`
+}
+
+// HashBlockHeader returns the hash of a BinaryBlockVersion block from its header alone. Older blocks hash their transactions too, so they can only be hashed with HashBlock.
+func HashBlockHeader(header BlockHeader) [64]byte {
+	return sha3.Sum512(EncodeBlockHeader(header))
+}
+
+// HashStateTransition returns the hash a BinaryBlockVersion header commits to for a state transition.
+func HashStateTransition(transition StateTransition) [32]byte {
+	return sha256.Sum256(EncodeStateTransition(transition))
+}
+
+// VerifyTransitionHash checks a block at height's transition hash. Blocks from the Oslo upgrade commit to their state transition with it, and blocks before it must not have one.
+func VerifyTransitionHash(block Block, height int) bool {
+	if height < Env.Upgrades.Oslo {
+		if block.TransitionHash != [32]byte{} {
+			Log("Block has a transition hash before Oslo. Ignoring block request.", true)
+			return false
+		}
+		return true
+	}
+	if block.TransitionHash != HashStateTransition(block.Transition) {
+		Log("Block has an invalid transition hash. Ignoring block request.", true)
+		return false
+	}
+	return true
+}
+
+// MaxHeadersPerRequest is the most headers /headers returns at once.
+const MaxHeadersPerRequest = 2000
+
+// WithoutBody returns the block without its transactions and state transition if it can be hashed from its header alone, and the whole block otherwise.
+func WithoutBody(block Block) Block {
+	if block.Version < BinaryBlockVersion {
+		return block
+	}
+	block.Transactions = nil
+	block.Transition = StateTransition{}
+	return block
+}
+
+// GetHeaders returns up to count blocks from height from, without their bodies (see WithoutBody).
+func GetHeaders(from int, count int) []Block {
+	headers := []Block{}
+	for height := from; height >= 0 && height < len(Blockchain) && len(headers) < count; height++ {
+		headers = append(headers, WithoutBody(Blockchain[height]))
+	}
+	return headers
+}
+
+func HandleHeadersRequest(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	from, err := strconv.Atoi(query.Get("from"))
+	if err != nil || from < 0 {
+		http.Error(w, "invalid or missing from", http.StatusBadRequest)
+		return
+	}
+	count := MaxHeadersPerRequest
+	if countStr := query.Get("count"); countStr != "" {
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count <= 0 || count > MaxHeadersPerRequest {
+			http.Error(w, "invalid count", http.StatusBadRequest)
+			return
+		}
+	}
+	writeJson(w, GetHeaders(from, count))
+}
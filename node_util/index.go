@@ -0,0 +1,137 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import "sync"
+
+// TransactionLocation is the position of a transaction in the blockchain.
+type TransactionLocation struct {
+	Height int
+	Index  int
+}
+
+type indexedBlock struct {
+	hash         [64]byte
+	transactions [][32]byte
+	addresses    []string
+}
+
+var indexedBlocks []indexedBlock
+var blockHeightsByHash = make(map[[64]byte]int)
+var transactionLocations = make(map[[32]byte]TransactionLocation)
+var addressTransactions = make(map[string][]TransactionLocation)
+var indexMutex sync.Mutex
+
+// SyncIndexes brings the block, transaction and address indexes in line with Blockchain.
+// Blocks that are no longer in Blockchain (because of a reorg) are removed from the indexes first.
+// It returns the height of the first block that was indexed.
+func SyncIndexes() int {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	// Find the highest indexed block that is still in the blockchain
+	common := len(indexedBlocks)
+	if common > len(Blockchain) {
+		common = len(Blockchain)
+	}
+	for common > 0 && HashBlock(Blockchain[common-1]) != indexedBlocks[common-1].hash {
+		common--
+	}
+	for len(indexedBlocks) > common {
+		unindexBlock()
+	}
+	for height := len(indexedBlocks); height < len(Blockchain); height++ {
+		indexBlock(height, Blockchain[height])
+	}
+	return common
+}
+
+func indexBlock(height int, block Block) {
+	indexed := indexedBlock{
+		hash: HashBlock(block),
+	}
+	blockHeightsByHash[indexed.hash] = height
+	for i, transaction := range block.Transactions {
+		hash := TransactionHash(transaction)
+		location := TransactionLocation{Height: height, Index: i}
+		transactionLocations[hash] = location
+		indexed.transactions = append(indexed.transactions, hash)
+		addresses := []string{string(transaction.Sender.Y)}
+		if string(transaction.Recipient.Y) != string(transaction.Sender.Y) {
+			addresses = append(addresses, string(transaction.Recipient.Y))
+		}
+		for _, address := range addresses {
+			addressTransactions[address] = append(addressTransactions[address], location)
+			indexed.addresses = append(indexed.addresses, address)
+		}
+	}
+	indexedBlocks = append(indexedBlocks, indexed)
+}
+
+func unindexBlock() {
+	height := len(indexedBlocks) - 1
+	indexed := indexedBlocks[height]
+	if blockHeightsByHash[indexed.hash] == height {
+		delete(blockHeightsByHash, indexed.hash)
+	}
+	for _, hash := range indexed.transactions {
+		if transactionLocations[hash].Height == height {
+			delete(transactionLocations, hash)
+		}
+	}
+	for _, address := range indexed.addresses {
+		locations := addressTransactions[address]
+		for len(locations) > 0 && locations[len(locations)-1].Height >= height {
+			locations = locations[:len(locations)-1]
+		}
+		if len(locations) == 0 {
+			delete(addressTransactions, address)
+		} else {
+			addressTransactions[address] = locations
+		}
+	}
+	indexedBlocks = indexedBlocks[:height]
+}
+
+func GetBlockHeightByHash(hash [64]byte) (int, bool) {
+	SyncIndexes()
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	height, ok := blockHeightsByHash[hash]
+	return height, ok
+}
+
+func GetTransactionLocation(hash [32]byte) (TransactionLocation, bool) {
+	SyncIndexes()
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	location, ok := transactionLocations[hash]
+	return location, ok
+}
+
+// GetAddressHistory returns up to limit transactions sending to or from key, newest first, skipping the first offset. It also returns the total number of transactions for the key.
+func GetAddressHistory(key []byte, offset int, limit int) ([]TransactionLocation, int) {
+	SyncIndexes()
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	locations := addressTransactions[string(key)]
+	total := len(locations)
+	var result []TransactionLocation
+	for i := total - 1 - offset; i >= 0 && len(result) < limit; i-- {
+		result = append(result, locations[i])
+	}
+	return result, total
+}
+
+// GetConfirmations returns the number of blocks at or after height, so a transaction in the last block has one confirmation.
+func GetConfirmations(height int) int {
+	if height < 0 || height >= len(Blockchain) {
+		return 0
+	}
+	return len(Blockchain) - height
+}
@@ -14,18 +14,28 @@ import (
 	"strings"
 )
 
+// DecodePublicKey decodes a key encoded by EncodePublicKey. It panics if keyString is malformed; use ParsePublicKey for keys from peers.
 func DecodePublicKey(keyString string) PublicKey {
+	key, err := ParsePublicKey(keyString)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// ParsePublicKey decodes a key encoded by EncodePublicKey.
+func ParsePublicKey(keyString string) (PublicKey, error) {
 	key := PublicKey{
 		Y: []byte(""),
 	}
 	for _, ps := range strings.Split(strings.Trim(keyString, "[]"), " ") {
 		pi, err := strconv.ParseUint(ps, 10, 8)
 		if err != nil {
-			panic(err)
+			return PublicKey{}, err
 		}
 		key.Y = append(key.Y, byte(pi))
 	}
-	return key
+	return key, nil
 }
 
 func EncodePublicKey(key PublicKey) string {
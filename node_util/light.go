@@ -0,0 +1,429 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+)
+
+// A light node (the light setting) keeps only block headers, in Headers, instead of the blockchain. It checks their proof of work, difficulty and time verifiers like SyncBlockchain does, and asks peers for proofs of the transactions it needs.
+
+// LightHeader is what a light node keeps of a block: the block without its body (see WithoutBody), and its hash, since blocks before Oslo can't be hashed without their transactions.
+type LightHeader struct {
+	Block
+	Hash [64]byte `json:"hash"`
+}
+
+// HeadersPath is where a light node stores its headers, relative to DataDir.
+var HeadersPath = "headers.json"
+
+// Headers are a light node's verified headers, starting with the genesis block.
+var Headers []LightHeader
+
+var ErrNoPeers = errors.New("no peer responded")
+var ErrHeaderNotSynced = errors.New("the block is past the synced headers")
+var ErrInvalidBlock = errors.New("peer returned a block that doesn't match its header")
+
+// ChainLength returns the number of blocks the node knows of: its headers on a light node, and its blockchain otherwise.
+func ChainLength() int {
+	if CurrentConfig.Light {
+		return len(Headers)
+	}
+	return len(Blockchain)
+}
+
+// NewLightHeader returns the header a light node keeps of block.
+func NewLightHeader(block Block) LightHeader {
+	return LightHeader{Block: WithoutBody(block), Hash: HashBlock(block)}
+}
+
+func lightBlocks(headers []LightHeader) []Block {
+	blocks := make([]Block, len(headers))
+	for i, header := range headers {
+		blocks[i] = header.Block
+	}
+	return blocks
+}
+
+// LoadHeaders reads the light node's headers, starting over from the genesis block if there are none or they belong to another network.
+func LoadHeaders() {
+	Headers = nil
+	headersJson, err := os.ReadFile(DataPath(HeadersPath))
+	if err == nil {
+		err = json.Unmarshal(headersJson, &Headers)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		Warn("Could not read the saved headers: " + err.Error())
+		Headers = nil
+	}
+	genesis := NewLightHeader(GenesisBlock())
+	if len(Headers) > 0 && Headers[0].Hash != genesis.Hash {
+		Warn("The saved headers belong to a different network. Starting from the genesis block.")
+		Headers = nil
+	}
+	if len(Headers) == 0 {
+		Headers = []LightHeader{genesis}
+	}
+}
+
+func SaveHeaders() {
+	headersJson, err := json.Marshal(Headers)
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(DataPath(HeadersPath), headersJson, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// verifyHeaderOn checks a block added to chain, whose block hashes are hashes, and returns its hash. Blocks from Oslo have no body, so only what their header commits to is checked; older blocks come with their body, which their hash covers.
+func verifyHeaderOn(chain []Block, hashes [][64]byte, block Block) ([64]byte, bool) {
+	height := len(chain)
+	hash := HashBlock(block)
+	if height == 0 {
+		return hash, hash == HashBlock(GenesisBlock())
+	}
+	if block.PreviousBlockHash != hashes[height-1] {
+		return hash, false
+	}
+	if block.Difficulty == 0 || binary.BigEndian.Uint64(hash[:]) > MaximumUint64/block.Difficulty {
+		return hash, false
+	}
+	if !VerifyBlockVersion(block, height) {
+		return hash, false
+	}
+	if block.Version < StateRootBlockVersion && block.StateRoot != [32]byte{} {
+		return hash, false
+	}
+	if block.Version < BinaryBlockVersion {
+		if !VerifyMerkleRoot(block, height) || !VerifyTransitionHash(block, height) {
+			return hash, false
+		}
+	} else if height < Env.Upgrades.Manila && block.MerkleRoot != [32]byte{} {
+		return hash, false
+	}
+	// Mining times aren't part of the hash, so this only checks that the peer's headers agree with each other
+	if !verifyDifficultyOn(chain, block) {
+		return hash, false
+	}
+	if !verifyBlockTimeOn(chain, block) {
+		return hash, false
+	}
+	if !verifyTimeVerifiersOn(chain, block, block.TimeVerifiers, block.TimeVerifierSignatures, false) || !verifyTimeVerifiersOn(chain, block, block.PreMiningTimeVerifiers, block.PreMiningTimeVerifierSignatures, true) {
+		return hash, false
+	}
+	return hash, true
+}
+
+// RequestHeaders asks peer for up to MaxHeadersPerRequest blocks from height from, without their bodies.
+func RequestHeaders(peer string, from int) ([]Block, error) {
+	var headers []Block
+	err := getJson(fmt.Sprintf("%s/headers?from=%d", peer, from), &headers)
+	return headers, err
+}
+
+// syncHeadersFrom returns local extended with peer's headers. If peer's chain doesn't extend local, it is downloaded from the genesis block instead.
+func syncHeadersFrom(peer string, local []LightHeader) ([]LightHeader, error) {
+	headers := append([]LightHeader(nil), local...)
+	chain := lightBlocks(headers)
+	hashes := make([][64]byte, len(headers))
+	for i, header := range headers {
+		hashes[i] = header.Hash
+	}
+	for {
+		page, err := RequestHeaders(peer, len(headers))
+		if err != nil {
+			return nil, err
+		}
+		if len(page) > 0 && len(headers) > 0 && page[0].PreviousBlockHash != headers[len(headers)-1].Hash {
+			if len(local) == 0 {
+				return nil, fmt.Errorf("invalid header at height %d", len(headers))
+			}
+			p2pLog.Debug("Peer is on another fork. Syncing its headers from the genesis block.", Fields{"peer": peer})
+			return syncHeadersFrom(peer, nil)
+		}
+		for _, block := range page {
+			hash, ok := verifyHeaderOn(chain, hashes, block)
+			if !ok {
+				return nil, fmt.Errorf("invalid header at height %d", len(headers))
+			}
+			headers = append(headers, LightHeader{Block: WithoutBody(block), Hash: hash})
+			chain = append(chain, WithoutBody(block))
+			hashes = append(hashes, hash)
+		}
+		if len(page) < MaxHeadersPerRequest {
+			return headers, nil
+		}
+	}
+}
+
+// SyncHeaders replaces Headers with the longest valid header chain of any peer, if it is longer.
+func SyncHeaders() {
+	best := Headers
+	errCount := 0
+	for _, peer := range GetPeers() {
+		headers, err := syncHeadersFrom(peer, Headers)
+		if err != nil {
+			p2pLog.Debug("Invalid headers received from peer.", Fields{"peer": peer, "error": err})
+			errCount++
+			continue
+		}
+		if len(headers) > len(best) {
+			best = headers
+		}
+		if len(headers)-1 > BestPeerHeight {
+			BestPeerHeight = len(headers) - 1
+		}
+	}
+	if errCount >= len(GetPeers()) {
+		Log("Failed to sync headers with any peers.", true)
+		return
+	}
+	Headers = best
+	Log(fmt.Sprintf("Headers successfully synced! Length: %d", len(Headers)), false)
+}
+
+func getJson(url string, v interface{}) error {
+	res, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer returned %s: %s", res.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// requestProvenBlock asks peer for the whole block at height and checks it against its header.
+func requestProvenBlock(peer string, height int) (Block, error) {
+	if height >= len(Headers) {
+		return Block{}, ErrHeaderNotSynced
+	}
+	var info BlockInfo
+	if err := getJson(fmt.Sprintf("%s/explorer/block?height=%d", peer, height), &info); err != nil {
+		return Block{}, err
+	}
+	block := info.Block
+	if HashBlock(block) != Headers[height].Hash || !VerifyMerkleRoot(block, height) || !VerifyTransitionHash(block, height) {
+		return Block{}, ErrInvalidBlock
+	}
+	return block, nil
+}
+
+// proveTransaction asks peer to prove that the transaction with the given ID is in the block at height, with a Merkle proof or, for blocks without a Merkle root, the whole block. It returns the transaction and its index in the block.
+func proveTransaction(peer string, id string, height int) (Transaction, int, error) {
+	if height < 0 || height >= len(Headers) {
+		return Transaction{}, 0, ErrHeaderNotSynced
+	}
+	header := Headers[height]
+	if header.MerkleRoot == [32]byte{} {
+		block, err := requestProvenBlock(peer, height)
+		if err != nil {
+			return Transaction{}, 0, err
+		}
+		for i, transaction := range block.Transactions {
+			if TransactionId(transaction) == id {
+				return transaction, i, nil
+			}
+		}
+		return Transaction{}, 0, ErrTransactionNotFound
+	}
+	proof, err := RequestTransactionProof(peer, id)
+	if err != nil {
+		return Transaction{}, 0, err
+	}
+	if proof.BlockHeight != height || proof.BlockVersion != header.Version || proof.BlockHash != hex.EncodeToString(header.Hash[:]) || proof.MerkleRoot != hex.EncodeToString(header.MerkleRoot[:]) {
+		return Transaction{}, 0, errors.New("peer returned a proof for another block")
+	}
+	return proof.Transaction, proof.Proof.Index, nil
+}
+
+func lightTransactionInfo(id string, height int, index int, transaction Transaction) TransactionInfo {
+	return TransactionInfo{
+		Id:            id,
+		BlockHeight:   height,
+		BlockHash:     hex.EncodeToString(Headers[height].Hash[:]),
+		Index:         index,
+		Confirmations: len(Headers) - height,
+		Transaction:   transaction,
+	}
+}
+
+// requestAddressHistory asks peer for every transaction it knows of involving key.
+func requestAddressHistory(peer string, key []byte) ([]TransactionInfo, error) {
+	var transactions []TransactionInfo
+	for page := 0; ; page++ {
+		var history AddressHistory
+		err := getJson(fmt.Sprintf("%s/explorer/address?key=%s&page=%d&limit=%d", peer, url.QueryEscape(base64.StdEncoding.EncodeToString(key)), page, MaxHistoryPageSize), &history)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, history.Transactions...)
+		if len(history.Transactions) == 0 || len(transactions) >= history.Total {
+			return transactions, nil
+		}
+	}
+}
+
+// LightHistory returns the transactions involving key in the synced headers' blocks, oldest first. It asks every peer, so one peer can't hide a transaction as long as another reports it, and keeps only the transactions that are proven against Headers.
+func LightHistory(key []byte) ([]TransactionInfo, error) {
+	proven := make(map[string]TransactionInfo)
+	responded := 0
+	for _, peer := range GetPeers() {
+		history, err := requestAddressHistory(peer, key)
+		if err != nil {
+			p2pLog.Debug("Peer down.", Fields{"peer": peer, "error": err})
+			continue
+		}
+		responded++
+		for _, info := range history {
+			if _, ok := proven[info.Id]; ok || info.BlockHeight >= len(Headers) {
+				continue
+			}
+			transaction, index, err := proveTransaction(peer, info.Id, info.BlockHeight)
+			if err != nil || (!bytes.Equal(transaction.Sender.Y, key) && !bytes.Equal(transaction.Recipient.Y, key)) {
+				p2pLog.Debug("Peer could not prove a transaction.", Fields{"peer": peer, "tx": info.Id, "error": err})
+				continue
+			}
+			proven[info.Id] = lightTransactionInfo(info.Id, info.BlockHeight, index, transaction)
+		}
+	}
+	if responded == 0 {
+		return nil, ErrNoPeers
+	}
+	history := make([]TransactionInfo, 0, len(proven))
+	for _, info := range proven {
+		history = append(history, info)
+	}
+	sort.Slice(history, func(i, j int) bool {
+		if history[i].BlockHeight != history[j].BlockHeight {
+			return history[i].BlockHeight < history[j].BlockHeight
+		}
+		return history[i].Index < history[j].Index
+	})
+	return history, nil
+}
+
+// LightBalance returns key's balance from its proven history. Before Kyoto, mining rewards include the fees of the whole block, so the blocks key mined then are downloaded and checked against their headers.
+func LightBalance(key []byte) (float64, error) {
+	history, err := LightHistory(key)
+	if err != nil {
+		return 0, err
+	}
+	chain := lightBlocks(Headers)
+	whole := make(map[int]bool)
+	for height := 1; height < len(chain) && height < Env.Upgrades.Kyoto; height++ {
+		if !bytes.Equal(chain[height].Miner.Y, key) {
+			continue
+		}
+		var block Block
+		err := ErrNoPeers
+		for _, peer := range GetPeers() {
+			if block, err = requestProvenBlock(peer, height); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		chain[height] = block
+		whole[height] = true
+	}
+	for _, info := range history {
+		if !whole[info.BlockHeight] {
+			chain[info.BlockHeight].Transactions = append(chain[info.BlockHeight].Transactions, info.Transaction)
+		}
+	}
+	return getBalanceOn(chain, key), nil
+}
+
+// LightStateValue returns key's value in the state after the last synced header, proven against its state root, and whether the state has the key at all.
+func LightStateValue(key string) ([]byte, bool, error) {
+	height := len(Headers) - 1
+	header := Headers[height]
+	if header.Version < StateRootBlockVersion {
+		return nil, false, ErrNoStateRoot
+	}
+	for _, peer := range GetPeers() {
+		proof, err := RequestStateProof(peer, key, height)
+		if err != nil || proof.BlockHash != hex.EncodeToString(header.Hash[:]) || proof.StateRoot != hex.EncodeToString(header.StateRoot[:]) {
+			p2pLog.Debug("Peer could not prove a state value.", Fields{"peer": peer, "key": key, "error": err})
+			continue
+		}
+		return proof.Value, proof.Included, nil
+	}
+	return nil, false, ErrNoPeers
+}
+
+// ProveTransactionStatus returns the receipt of the transaction with the given ID once a peer proves it is in the synced headers' blocks. Until then, its status is unknown.
+func ProveTransactionStatus(id string) Receipt {
+	for _, peer := range GetPeers() {
+		var info TransactionInfo
+		if err := getJson(fmt.Sprintf("%s/explorer/tx?id=%s", peer, id), &info); err != nil {
+			continue
+		}
+		transaction, _, err := proveTransaction(peer, id, info.BlockHeight)
+		if err != nil {
+			continue
+		}
+		receipt := Receipt{
+			Id:            id,
+			Status:        MinedStatus,
+			BlockHash:     hex.EncodeToString(Headers[info.BlockHeight].Hash[:]),
+			BlockHeight:   info.BlockHeight,
+			Confirmations: len(Headers) - info.BlockHeight,
+			Fee:           CalculateTransactionFee(transaction, info.BlockHeight),
+			GasUsed:       TransactionGasUsed(transaction),
+		}
+		if receipt.Confirmations >= BlocksUntilFinality {
+			receipt.Status = FinalizedStatus
+		}
+		return receipt
+	}
+	return Receipt{Id: id, Status: UnknownStatus, BlockHeight: -1}
+}
+
+// WaitForLightConfirmations syncs headers until a peer proves the transaction has at least confirmations confirmations or timeout passes.
+func WaitForLightConfirmations(id string, confirmations int, timeout time.Duration) (Receipt, error) {
+	deadline := time.Now().Add(timeout)
+	lastStatus := ""
+	for {
+		SyncHeaders()
+		receipt := ProveTransactionStatus(id)
+		if receipt.Status != lastStatus {
+			Log(fmt.Sprintf("Transaction %s is %s.", id, receipt.Status), false)
+			lastStatus = receipt.Status
+		}
+		if receipt.Status != UnknownStatus && receipt.Confirmations >= confirmations {
+			return receipt, nil
+		}
+		if time.Now().After(deadline) {
+			return receipt, errors.New("timed out waiting for confirmations")
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
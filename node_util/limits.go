@@ -0,0 +1,139 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// BlockLimits bound what a block may carry. The coinbase is not counted. A zero limit means no limit.
+type BlockLimits struct {
+	// MaxSize is the most bytes the block's transactions may take up, see TransactionSize.
+	MaxSize         int
+	MaxTransactions int
+	// MaxGas is the most gas the block's contracts may use together.
+	MaxGas float64
+}
+
+// DefaultBlockLimits are the limits every built-in network profile starts with at the Lima upgrade.
+var DefaultBlockLimits = BlockLimits{
+	MaxSize:         4 << 20,
+	MaxTransactions: 1000,
+	MaxGas:          10000000,
+}
+
+// BlockLimitsAt returns the limits for a block at height. Blocks before Lima have no limits. To change the limits later, add an upgrade and return the new limits from its height.
+func BlockLimitsAt(height int) BlockLimits {
+	if height < Env.Upgrades.Lima {
+		return BlockLimits{}
+	}
+	return CurrentNetwork.BlockLimits
+}
+
+// blockUsage is how much of its limits a block, or a block being filled, uses.
+type blockUsage struct {
+	size         int
+	transactions int
+	gas          float64
+}
+
+func (u *blockUsage) add(transaction Transaction) {
+	u.size += TransactionSize(transaction)
+	u.transactions++
+	u.gas += TransactionGasUsed(transaction)
+}
+
+// exceeds reports which limit the usage is over, or "" if it is within all of them.
+func (u blockUsage) exceeds(limits BlockLimits) string {
+	switch {
+	case limits.MaxSize > 0 && u.size > limits.MaxSize:
+		return fmt.Sprintf("size %d is over the limit of %d bytes", u.size, limits.MaxSize)
+	case limits.MaxTransactions > 0 && u.transactions > limits.MaxTransactions:
+		return fmt.Sprintf("%d transactions are over the limit of %d", u.transactions, limits.MaxTransactions)
+	case limits.MaxGas > 0 && u.gas > limits.MaxGas:
+		return fmt.Sprintf("gas %f is over the limit of %f", u.gas, limits.MaxGas)
+	}
+	return ""
+}
+
+// TransactionSize returns how many bytes a transaction counts for towards MaxSize: the length of its stable encoding.
+func TransactionSize(transaction Transaction) int {
+	return len(stableEncoding(transaction))
+}
+
+// stableEncoding returns a transaction's wire encoding without its body signatures, followed by its body at its original size. Bodies are wrapped in another layer of base64 each time they are relayed, and body signatures are dropped, so unlike the wire encoding it is the same on every node.
+func stableEncoding(transaction Transaction) []byte {
+	body := OriginalBody(transaction.Body)
+	transaction.Body = nil
+	transaction.BodySignatures = nil
+	encoded, err := json.Marshal(transaction)
+	if err != nil {
+		panic(err)
+	}
+	return append(encoded, body...)
+}
+
+// OriginalBody unwraps the layers of quoted base64 a body picks up when it is relayed.
+func OriginalBody(body []byte) []byte {
+	for {
+		if string(body) == "null" || string(body) == `"null"` {
+			return nil
+		}
+		if len(body) < 2 || body[0] != '"' || body[len(body)-1] != '"' {
+			return body
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(body[1 : len(body)-1]))
+		if err != nil {
+			return body
+		}
+		body = decoded
+	}
+}
+
+// fillBlock takes groups of transactions, best first, as long as they fit within the limits. A group that doesn't fit is skipped, so smaller ones after it can still fill the space.
+func fillBlock(groups [][]Transaction, limits BlockLimits) []Transaction {
+	var usage blockUsage
+	var transactions []Transaction
+	for _, group := range groups {
+		next := usage
+		for _, transaction := range group {
+			next.add(transaction)
+		}
+		if next.exceeds(limits) != "" {
+			continue
+		}
+		usage = next
+		transactions = append(transactions, group...)
+	}
+	return transactions
+}
+
+// FitsInBlock reports whether a transaction on its own fits within the limits of a block at height. Transactions that don't could never be mined.
+func FitsInBlock(transaction Transaction, height int) bool {
+	var usage blockUsage
+	usage.add(transaction)
+	return usage.exceeds(BlockLimitsAt(height)) == ""
+}
+
+// VerifyBlockLimits checks that the transactions of a block at height fit within BlockLimitsAt(height).
+func VerifyBlockLimits(block Block, height int) bool {
+	var usage blockUsage
+	for _, transaction := range block.Transactions {
+		if !IsCoinbase(transaction) {
+			usage.add(transaction)
+		}
+	}
+	if reason := usage.exceeds(BlockLimitsAt(height)); reason != "" {
+		Log(fmt.Sprintf("Block is too big: %s. Ignoring block request.", reason), true)
+		return false
+	}
+	return true
+}
@@ -8,7 +8,17 @@ You should have received a copy of the GNU General Public License along with thi
 */
 package node_util
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
 
 var Reset = "\033[0m"
 var Red = "\033[31m"
@@ -16,31 +26,346 @@ var Green = "\033[32m"
 var Yellow = "\033[33m"
 var Blue = "\033[34m"
 var Magenta = "\033[35m"
-var Cyan = "\033[36mG"
+var Cyan = "\033[36m"
 var Gray = "\033[37m"
 var White = "\033[97m"
 
-func Log(m string, isVerbose bool) {
-	m = Blue + "INFO " + Reset + m
-	if !isVerbose {
-		fmt.Println(m)
+type LogLevel int
+
+const (
+	DebugLevel LogLevel = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+	FatalLevel
+)
+
+var levelNames = map[LogLevel]string{
+	DebugLevel: "DEBUG",
+	InfoLevel:  "INFO",
+	WarnLevel:  "WARNING",
+	ErrorLevel: "ERROR",
+	FatalLevel: "FATAL",
+}
+
+var levelColors = map[LogLevel]string{
+	DebugLevel: Gray,
+	InfoLevel:  Blue,
+	WarnLevel:  Yellow,
+	ErrorLevel: Red,
+	FatalLevel: Red,
+}
+
+func (l LogLevel) String() string {
+	return levelNames[l]
+}
+
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	case "fatal":
+		return FatalLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+// Fields are key/value pairs attached to a log entry, e.g. Fields{"peer": peer, "height": height}.
+type Fields map[string]interface{}
+
+// LogFormat is "text" (colored, for terminals) or "json" (one object per line).
+var LogFormat = "text"
+
+// LogOutput is where log entries are written.
+var LogOutput io.Writer = os.Stdout
+
+// LogColors enables ANSI colors in the text format.
+var LogColors = true
+
+// DefaultLogLevel applies to subsystems without their own level in SubsystemLogLevels. Verbose lowers it to DebugLevel.
+var DefaultLogLevel = InfoLevel
+var SubsystemLogLevels = make(map[string]LogLevel)
+
+// ExitFunc is called after a fatal error has been logged and the shutdown hooks have run.
+var ExitFunc = os.Exit
+
+var logMutex sync.Mutex
+var shutdownHooks []func()
+
+// OnShutdown registers a function to run before the node exits because of a fatal error.
+func OnShutdown(hook func()) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// ParseLogLevels parses a spec like "info,p2p=debug,mining=warn", setting DefaultLogLevel and SubsystemLogLevels.
+func ParseLogLevels(spec string) error {
+	defaultLevel, subsystemLevels, err := parseLogLevelSpec(spec)
+	if err != nil {
+		return err
+	}
+	DefaultLogLevel = defaultLevel
+	for subsystem, level := range subsystemLevels {
+		SubsystemLogLevels[subsystem] = level
+	}
+	return nil
+}
+
+func parseLogLevelSpec(spec string) (LogLevel, map[string]LogLevel, error) {
+	defaultLevel := DefaultLogLevel
+	subsystemLevels := make(map[string]LogLevel)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		subsystem, levelStr, found := strings.Cut(part, "=")
+		if !found {
+			level, err := ParseLogLevel(part)
+			if err != nil {
+				return 0, nil, err
+			}
+			defaultLevel = level
+			continue
+		}
+		level, err := ParseLogLevel(levelStr)
+		if err != nil {
+			return 0, nil, err
+		}
+		subsystemLevels[subsystem] = level
+	}
+	return defaultLevel, subsystemLevels, nil
+}
+
+// ConfigureLogging applies the logging settings given on the command line. If path is not empty, logs go to a file there that is rotated every maxSizeMB megabytes.
+func ConfigureLogging(format string, levels string, path string, maxSizeMB int, maxBackups int) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown log format %q (expected text or json)", format)
+	}
+	LogFormat = format
+	if err := ParseLogLevels(levels); err != nil {
+		return err
+	}
+	if path != "" {
+		file, err := OpenRotatingFile(path, int64(maxSizeMB)*1024*1024, maxBackups)
+		if err != nil {
+			return err
+		}
+		LogOutput = file
+		LogColors = false
+	}
+	return nil
+}
+
+// Logger writes leveled, structured log entries for one subsystem.
+type Logger struct {
+	Subsystem string
+}
+
+func NewLogger(subsystem string) *Logger {
+	return &Logger{Subsystem: subsystem}
+}
+
+// Subsystem loggers
+var nodeLog = NewLogger("node")
+var chainLog = NewLogger("chain")
+var p2pLog = NewLogger("p2p")
+var miningLog = NewLogger("mining")
+
+func (l *Logger) Enabled(level LogLevel) bool {
+	minimum, ok := SubsystemLogLevels[l.Subsystem]
+	if !ok {
+		minimum = DefaultLogLevel
+		if *Verbose {
+			minimum = DebugLevel
+		}
+	}
+	return level >= minimum
+}
+
+func formatTextEntry(level LogLevel, subsystem string, m string, fields Fields) string {
+	var builder strings.Builder
+	color, keyColor, reset := levelColors[level], Cyan, Reset
+	if !LogColors {
+		color, keyColor, reset = "", "", ""
+	}
+	builder.WriteString(color + level.String() + " " + reset)
+	if subsystem != "node" {
+		builder.WriteString("[" + subsystem + "] ")
+	}
+	builder.WriteString(m)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		builder.WriteString(fmt.Sprintf(" %s%s=%s%v", keyColor, key, reset, fields[key]))
+	}
+	return builder.String()
+}
+
+func formatJsonEntry(level LogLevel, subsystem string, m string, fields Fields) string {
+	entry := make(map[string]interface{}, len(fields)+4)
+	for key, value := range fields {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		entry[key] = value
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = strings.ToLower(level.String())
+	entry["subsystem"] = subsystem
+	entry["msg"] = m
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Sprintf(`{"level":"error","msg":"failed to encode log entry: %s"}`, err)
+	}
+	return string(entryBytes)
+}
+
+func (l *Logger) log(level LogLevel, m string, fields Fields) {
+	if !l.Enabled(level) {
 		return
 	}
-	if *Verbose {
-		fmt.Println(m)
+	var line string
+	if LogFormat == "json" {
+		line = formatJsonEntry(level, l.Subsystem, m, fields)
+	} else {
+		line = formatTextEntry(level, l.Subsystem, m, fields)
 	}
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	fmt.Fprintln(LogOutput, line)
+}
+
+func (l *Logger) Debug(m string, fields Fields) {
+	l.log(DebugLevel, m, fields)
+}
+
+func (l *Logger) Info(m string, fields Fields) {
+	l.log(InfoLevel, m, fields)
+}
+
+func (l *Logger) Warn(m string, fields Fields) {
+	l.log(WarnLevel, m, fields)
+}
+
+func (l *Logger) Error(m string, fields Fields) {
+	l.log(ErrorLevel, m, fields)
+}
+
+// Fatal logs the error, runs the shutdown hooks and exits the process.
+func (l *Logger) Fatal(m string, fields Fields) {
+	l.log(FatalLevel, m, fields)
+	logMutex.Lock()
+	hooks := shutdownHooks
+	logMutex.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+	if closer, ok := LogOutput.(io.Closer); ok && LogOutput != io.Writer(os.Stdout) {
+		closer.Close()
+	}
+	ExitFunc(1)
+}
+
+func Log(m string, isVerbose bool) {
+	if isVerbose {
+		nodeLog.Debug(m, nil)
+		return
+	}
+	nodeLog.Info(m, nil)
 }
 
 func Warn(m string) {
-	m = Yellow + "WARNING " + Reset + m
-	fmt.Println(m)
+	nodeLog.Warn(m, nil)
 }
 
+// Error logs m as an error. If fatal is true it calls Fatal, which exits the process, so only startup code should pass true; request handlers and library code return or log errors instead.
 func Error(m string, fatal bool) {
-	m = Red + "ERROR " + Reset + m
 	if fatal {
-		panic(m)
-	} else {
-		fmt.Println(m)
+		nodeLog.Fatal(m, nil)
+		return
+	}
+	nodeLog.Error(m, nil)
+}
+
+// RotatingFile is a log file that is rotated when it grows past MaxSize bytes. Rotated files are named <path>.1 (newest) to <path>.<MaxBackups> (oldest).
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+	mutex      sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.Path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
 	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	for i := r.MaxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", r.Path, i), fmt.Sprintf("%s.%d", r.Path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if r.MaxBackups > 0 {
+		if err := os.Rename(r.Path, r.Path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.Path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.MaxSize > 0 && r.size+int64(len(p)) > r.MaxSize && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
 }
@@ -0,0 +1,231 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Domain separation for Merkle tree hashes, so a leaf can never be passed off as an inner node
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+var ErrTransactionNotFound = errors.New("transaction not found")
+var ErrNoMerkleRoot = errors.New("block has no Merkle root: it was mined before the Manila upgrade")
+
+// MerkleProof is the path from a transaction's leaf to the Merkle root of its block.
+type MerkleProof struct {
+	// Index is the position of the transaction in the block, and Count the number of transactions in it.
+	Index int `json:"index"`
+	Count int `json:"count"`
+	// Siblings are the hex-encoded hashes the path is combined with, from the leaf up. Levels where the path has no sibling are skipped.
+	Siblings []string `json:"siblings"`
+}
+
+// TransactionProof is what /proof returns: a transaction, the block it was mined in and the proof that the block's Merkle root commits to it.
+type TransactionProof struct {
+	Id          string `json:"id"`
+	BlockHeight int    `json:"blockHeight"`
+	BlockHash   string `json:"blockHash"`
+	MerkleRoot  string `json:"merkleRoot"`
+	// BlockVersion selects how the transaction's leaf is computed, see MerkleLeaf.
+	BlockVersion int         `json:"blockVersion"`
+	Transaction  Transaction `json:"transaction"`
+	Proof        MerkleProof `json:"proof"`
+}
+
+// MerkleLeaf returns the leaf hash of a transaction in a block of the given version: the hash of its binary encoding, or of its stable encoding before BinaryBlockVersion.
+func MerkleLeaf(transaction Transaction, version int) [32]byte {
+	encoding := stableEncoding(transaction)
+	if version >= BinaryBlockVersion {
+		encoding = EncodeTransaction(transaction)
+	}
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, encoding...))
+}
+
+func merkleNode(left [32]byte, right [32]byte) [32]byte {
+	data := make([]byte, 0, 1+2*sha256.Size)
+	data = append(data, merkleNodePrefix)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return sha256.Sum256(data)
+}
+
+// merkleLevels builds the tree over the transactions' leaves, from the leaves up to the root. A node without a sibling is promoted to the next level as it is.
+func merkleLevels(transactions []Transaction, version int) [][][32]byte {
+	level := make([][32]byte, len(transactions))
+	for i, transaction := range transactions {
+		level[i] = MerkleLeaf(transaction, version)
+	}
+	levels := [][][32]byte{level}
+	for len(level) > 1 {
+		next := make([][32]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, merkleNode(level[i], level[i+1]))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// TransactionsMerkleRoot returns the root of the Merkle tree over the transactions of a block of the given version, in block order. The root of no transactions is the hash of nothing.
+func TransactionsMerkleRoot(transactions []Transaction, version int) [32]byte {
+	if len(transactions) == 0 {
+		return sha256.Sum256(nil)
+	}
+	levels := merkleLevels(transactions, version)
+	return levels[len(levels)-1][0]
+}
+
+// NewMerkleProof returns the proof for the transaction at index in the transactions of a block of the given version.
+func NewMerkleProof(transactions []Transaction, index int, version int) MerkleProof {
+	proof := MerkleProof{
+		Index:    index,
+		Count:    len(transactions),
+		Siblings: []string{},
+	}
+	levels := merkleLevels(transactions, version)
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Siblings = append(proof.Siblings, hex.EncodeToString(level[sibling][:]))
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof checks that proof leads from leaf to root. It only needs the proof, so light clients and the rollup package can use it without the block.
+func VerifyMerkleProof(leaf [32]byte, proof MerkleProof, root [32]byte) bool {
+	if proof.Index < 0 || proof.Index >= proof.Count {
+		return false
+	}
+	hash := leaf
+	index, count := proof.Index, proof.Count
+	siblings := proof.Siblings
+	for count > 1 {
+		if index^1 < count {
+			if len(siblings) == 0 {
+				return false
+			}
+			siblingBytes, err := hex.DecodeString(siblings[0])
+			if err != nil || len(siblingBytes) != sha256.Size {
+				return false
+			}
+			siblings = siblings[1:]
+			var sibling [32]byte
+			copy(sibling[:], siblingBytes)
+			if index%2 == 0 {
+				hash = merkleNode(hash, sibling)
+			} else {
+				hash = merkleNode(sibling, hash)
+			}
+		}
+		index /= 2
+		count = (count + 1) / 2
+	}
+	return len(siblings) == 0 && hash == root
+}
+
+// VerifyTransactionProof checks that a proof from /proof is consistent: its transaction has its ID and leads to its Merkle root. The caller still has to check that the root is the one in a block it trusts.
+func VerifyTransactionProof(proof TransactionProof) bool {
+	if TransactionId(proof.Transaction) != proof.Id {
+		return false
+	}
+	rootBytes, err := hex.DecodeString(proof.MerkleRoot)
+	if err != nil || len(rootBytes) != sha256.Size {
+		return false
+	}
+	var root [32]byte
+	copy(root[:], rootBytes)
+	return VerifyMerkleProof(MerkleLeaf(proof.Transaction, proof.BlockVersion), proof.Proof, root)
+}
+
+// VerifyMerkleRoot checks a block at height's Merkle root. Blocks from the Manila upgrade commit to their transactions with it, and blocks before it must not have one.
+func VerifyMerkleRoot(block Block, height int) bool {
+	if height < Env.Upgrades.Manila {
+		if block.MerkleRoot != [32]byte{} {
+			Log("Block has a Merkle root before Manila. Ignoring block request.", true)
+			return false
+		}
+		return true
+	}
+	if block.MerkleRoot != TransactionsMerkleRoot(block.Transactions, block.Version) {
+		Log("Block has an invalid Merkle root. Ignoring block request.", true)
+		return false
+	}
+	return true
+}
+
+// GetTransactionProof returns the proof that the transaction with the given ID is in the blockchain.
+func GetTransactionProof(id string) (TransactionProof, error) {
+	info, ok := GetTransactionInfo(id)
+	if !ok {
+		return TransactionProof{}, ErrTransactionNotFound
+	}
+	block := Blockchain[info.BlockHeight]
+	if block.MerkleRoot == [32]byte{} {
+		return TransactionProof{}, ErrNoMerkleRoot
+	}
+	return TransactionProof{
+		Id:           info.Id,
+		BlockHeight:  info.BlockHeight,
+		BlockHash:    info.BlockHash,
+		MerkleRoot:   hex.EncodeToString(block.MerkleRoot[:]),
+		BlockVersion: block.Version,
+		Transaction:  info.Transaction,
+		Proof:        NewMerkleProof(block.Transactions, info.Index, block.Version),
+	}, nil
+}
+
+// RequestTransactionProof asks peer for the proof that the transaction with the given ID was mined, and checks it with VerifyTransactionProof.
+func RequestTransactionProof(peer string, id string) (TransactionProof, error) {
+	res, err := http.Get(fmt.Sprintf("%s/proof?tx=%s", peer, id))
+	if err != nil {
+		return TransactionProof{}, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return TransactionProof{}, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return TransactionProof{}, fmt.Errorf("peer returned %s: %s", res.Status, body)
+	}
+	var proof TransactionProof
+	if err := json.Unmarshal(body, &proof); err != nil {
+		return TransactionProof{}, err
+	}
+	if proof.Id != id || !VerifyTransactionProof(proof) {
+		return TransactionProof{}, errors.New("peer returned an invalid proof")
+	}
+	return proof, nil
+}
+
+func HandleProofRequest(w http.ResponseWriter, req *http.Request) {
+	proof, err := GetTransactionProof(req.URL.Query().Get("tx"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJson(w, proof)
+}
@@ -0,0 +1,274 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are exposed on /metrics in the Prometheus text exposition format. See docs/metrics.md for the list.
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var metrics = make(map[string]metric)
+var metricsMutex sync.Mutex
+
+func registerMetric(name string, m metric) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if _, ok := metrics[name]; ok {
+		panic("metric registered twice: " + name)
+	}
+	metrics[name] = m
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+type Counter struct {
+	name  string
+	help  string
+	mutex sync.Mutex
+	value float64
+}
+
+func NewCounter(name string, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	registerMetric(name, c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	c.mutex.Lock()
+	c.value += v
+	c.mutex.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.value
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(c.Value()))
+}
+
+// CounterVec is a set of counters distinguished by the value of one label.
+type CounterVec struct {
+	name     string
+	help     string
+	label    string
+	mutex    sync.Mutex
+	counters map[string]*Counter
+}
+
+func NewCounterVec(name string, help string, label string) *CounterVec {
+	c := &CounterVec{name: name, help: help, label: label, counters: make(map[string]*Counter)}
+	registerMetric(name, c)
+	return c
+}
+
+func (c *CounterVec) WithLabel(value string) *Counter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counter, ok := c.counters[value]
+	if !ok {
+		counter = &Counter{name: c.name, help: c.help}
+		c.counters[value] = counter
+	}
+	return counter
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mutex.Lock()
+	var values []string
+	for value := range c.counters {
+		values = append(values, value)
+	}
+	c.mutex.Unlock()
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(w, "%s{%s=%q} %s\n", c.name, c.label, value, formatFloat(c.WithLabel(value).Value()))
+	}
+}
+
+type Gauge struct {
+	name  string
+	help  string
+	mutex sync.Mutex
+	value float64
+	fn    func() float64
+}
+
+func NewGauge(name string, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	registerMetric(name, g)
+	return g
+}
+
+// NewGaugeFunc creates a gauge whose value is computed by fn on every scrape.
+func NewGaugeFunc(name string, help string, fn func() float64) *Gauge {
+	g := &Gauge{name: name, help: help, fn: fn}
+	registerMetric(name, g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mutex.Lock()
+	g.value = v
+	g.mutex.Unlock()
+}
+
+func (g *Gauge) Value() float64 {
+	if g.fn != nil {
+		return g.fn()
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.value
+}
+
+func (g *Gauge) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.Value()))
+}
+
+type Histogram struct {
+	name    string
+	help    string
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// DurationBuckets are histogram buckets (in seconds) for timing operations.
+var DurationBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+func NewHistogram(name string, help string, buckets []float64) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	registerMetric(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, bucket := range h.buckets {
+		if v <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// ObserveSince records the number of seconds since start.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, bucket := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, formatFloat(bucket), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+func WriteMetrics(w io.Writer) {
+	metricsMutex.Lock()
+	var names []string
+	for name := range metrics {
+		names = append(names, name)
+	}
+	metricsMutex.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		metricsMutex.Lock()
+		m := metrics[name]
+		metricsMutex.Unlock()
+		m.write(w)
+	}
+}
+
+func HandleMetricsRequest(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	var builder strings.Builder
+	WriteMetrics(&builder)
+	_, err := io.WriteString(w, builder.String())
+	if err != nil {
+		Log("Failed to write metrics.", true)
+	}
+}
+
+// Node metrics
+var BestPeerHeight = -1
+
+var BlockHeightGauge = NewGaugeFunc("polycash_block_height", "Height of the last block in the local blockchain.", func() float64 {
+	return float64(len(Blockchain) - 1)
+})
+var MempoolSizeGauge = NewGaugeFunc("polycash_mempool_transactions", "Number of transactions waiting to be mined.", func() float64 {
+	return float64(len(MiningTransactions))
+})
+var PeerCountGauge = NewGaugeFunc("polycash_peers", "Number of known peers.", func() float64 {
+	return float64(len(GetPeers()))
+})
+var SyncLagGauge = NewGaugeFunc("polycash_sync_lag_blocks", "Blocks between the tallest chain seen from peers and the local chain.", func() float64 {
+	lag := BestPeerHeight - (len(Blockchain) - 1)
+	if lag < 0 {
+		return 0
+	}
+	return float64(lag)
+})
+var BlockValidationSeconds = NewHistogram("polycash_block_validation_seconds", "Time spent validating a block.", DurationBuckets)
+var SignatureVerificationSeconds = NewHistogram("polycash_signature_verification_seconds", "Time spent verifying a single Dilithium3 signature.", DurationBuckets)
+var ContractExecutionSeconds = NewHistogram("polycash_contract_execution_seconds", "Time spent executing a smart contract.", DurationBuckets)
+var BlocksReceivedCounter = NewCounterVec("polycash_blocks_received_total", "Blocks received from peers, by result (accepted or rejected).", "result")
+var MiningAttemptsCounter = NewCounter("polycash_mining_attempts_total", "Blocks this node started mining.")
+var BlocksMinedCounter = NewCounter("polycash_blocks_mined_total", "Blocks this node mined and broadcast.")
+var MiningHashrateGauge = NewGauge("polycash_mining_hashrate", "Hashes per second computed by the local miner's workers.")
+var BlocksLostCounter = NewCounter("polycash_blocks_lost_total", "Blocks this node solved but could not get enough time verifiers for.")
+var TimeVerificationCounter = NewCounterVec("polycash_time_verification_responses_total", "Responses to time verification requests, by result.", "result")
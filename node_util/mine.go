@@ -9,36 +9,111 @@ You should have received a copy of the GNU General Public License along with thi
 package node_util
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"strings"
+	"time"
 )
 
 var NextTransitions = make(map[[32]byte]StateTransition)
 
+// MiningEventBufferSize is how many block and transaction events the miner buffers while it builds a template.
+const MiningEventBufferSize = 256
+
+// HashrateReportInterval is how often the miner logs its hashrate.
+const HashrateReportInterval = time.Minute
+
+// Mine mines blocks forever with CurrentConfig.MiningWorkers workers. Whenever a block is appended or a transaction enters the mining pool, the workers stop and mining restarts on a new template.
 func Mine() {
+	engine := NewMiningEngine(CurrentConfig.MiningWorkers)
+	subscription := Subscribe(MiningEventBufferSize)
+	lastReport := time.Now()
+	miningLog.Info("Mining started.", Fields{"workers": engine.Workers})
 	for {
-		block, err := CreateBlock()
+		// Events from before the template is built are already in it.
+		if !drainEvents(subscription) {
+			subscription = Subscribe(MiningEventBufferSize)
+		}
+		template := NewBlockTemplate(GetKey("").PublicKey)
+		if len(template.Transactions) == 0 {
+			// Wait for a transaction
+			<-subscription.Events
+			continue
+		}
+		MiningAttemptsCounter.Inc()
+		miningLog.Debug("Mining block.", Fields{"height": template.Height, "difficulty": template.Difficulty, "transactions": len(template.Transactions)})
+		ctx, cancel := context.WithCancel(context.Background())
+		go cancelOnEvent(ctx, cancel, subscription)
+		nonce, err := engine.Solve(ctx, template)
+		cancel()
+		if time.Since(lastReport) >= HashrateReportInterval {
+			miningLog.Info("Hashrate.", Fields{"hashrate": engine.Hashrate(), "workers": engine.Workers})
+			lastReport = time.Now()
+		}
 		if err != nil {
+			miningLog.Debug("Chain or mining pool changed. Rebuilding block template.", Fields{"height": template.Height})
 			continue
 		}
-		Log("Block mined successfully!", false)
-		Log("Broadcasting block to peers...", true)
-		bodyChars, err := json.Marshal(&block)
+		block, err := FinalizeBlock(template, nonce)
 		if err != nil {
-			panic(err)
+			continue
 		}
-		for _, peer := range GetPeers() {
-			body := strings.NewReader(string(bodyChars))
-			req, err := http.NewRequest(http.MethodGet, peer+"/block", body)
-			if err != nil {
-				panic(err)
-			}
-			_, err = http.DefaultClient.Do(req)
-			if err != nil {
-				Log("Peer down.", true)
-			}
+		blockHash := HashBlock(block)
+		miningLog.Info("Block mined successfully!", Fields{
+			"height":     template.Height,
+			"block":      hex.EncodeToString(blockHash[:8]),
+			"difficulty": block.Difficulty,
+			"hashrate":   engine.Hashrate(),
+		})
+		if err := addMinedBlock(block); err != nil {
+			miningLog.Debug("Chain changed while the block was finalized. Rebuilding block template.", Fields{"height": template.Height})
+			continue
 		}
 		Log("All done!", false)
 	}
 }
+
+// drainEvents discards buffered events. It returns false if the subscription was dropped for falling behind.
+func drainEvents(subscription *Subscription) bool {
+	for {
+		select {
+		case _, ok := <-subscription.Events:
+			if !ok {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// cancelOnEvent calls cancel when the next event arrives, unless ctx is done first.
+func cancelOnEvent(ctx context.Context, cancel context.CancelFunc, subscription *Subscription) {
+	select {
+	case <-subscription.Events:
+		cancel()
+	case <-ctx.Done():
+	}
+}
+
+// BroadcastBlock sends a block to every peer's /block endpoint.
+func BroadcastBlock(block Block) {
+	bodyChars, err := json.Marshal(&block)
+	if err != nil {
+		panic(err)
+	}
+	for _, peer := range GetPeers() {
+		body := strings.NewReader(string(bodyChars))
+		req, err := http.NewRequest(http.MethodGet, peer+"/block", body)
+		if err != nil {
+			p2pLog.Debug("Invalid peer.", Fields{"peer": peer, "error": err})
+			continue
+		}
+		_, err = http.DefaultClient.Do(req)
+		if err != nil {
+			p2pLog.Debug("Peer down.", Fields{"peer": peer, "error": err})
+		}
+	}
+}
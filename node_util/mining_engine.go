@@ -0,0 +1,132 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"context"
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MiningEngine searches for nonces with several worker goroutines. Worker i tries nonces i, i+Workers, i+2*Workers and so on, so workers never repeat each other's work.
+type MiningEngine struct {
+	Workers int
+
+	hashes       atomic.Uint64
+	mutex        sync.Mutex
+	hashrate     float64
+	windowStart  time.Time
+	windowHashes uint64
+}
+
+// NewMiningEngine creates an engine with the given number of workers, or one per CPU if workers is not positive.
+func NewMiningEngine(workers int) *MiningEngine {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	return &MiningEngine{Workers: workers}
+}
+
+// Solve searches for a nonce that solves template. It returns ctx's error if ctx is cancelled first, e.g. because a new block arrived.
+func (e *MiningEngine) Solve(ctx context.Context, template BlockTemplate) (int64, error) {
+	return e.Search(ctx, template, template.Target(), 0)
+}
+
+// Search searches for a nonce of at least first whose block hashes to at most target. Pool miners use it with the pool's share target and the start of the nonce range the pool gave them.
+func (e *MiningEngine) Search(ctx context.Context, template BlockTemplate, target uint64, first int64) (int64, error) {
+	search, stop := context.WithCancel(ctx)
+	defer stop()
+	solution := make(chan int64, 1)
+	e.startWindow()
+	var workers sync.WaitGroup
+	for i := 0; i < e.Workers; i++ {
+		workers.Add(1)
+		go func(start int64) {
+			defer workers.Done()
+			block := template.Block(start)
+			for nonce := start; ; nonce += int64(e.Workers) {
+				select {
+				case <-search.Done():
+					return
+				default:
+				}
+				block.Nonce = nonce
+				hash := HashBlock(block)
+				e.hashes.Add(1)
+				if binary.BigEndian.Uint64(hash[:]) <= target {
+					select {
+					case solution <- nonce:
+					default:
+					}
+					stop()
+					return
+				}
+			}
+		}(first + int64(i))
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.updateHashrate(time.Second)
+			case <-done:
+				return
+			}
+		}
+	}()
+	workers.Wait()
+	close(done)
+	e.updateHashrate(100 * time.Millisecond)
+	select {
+	case nonce := <-solution:
+		return nonce, nil
+	default:
+		return 0, ctx.Err()
+	}
+}
+
+// Hashrate returns the hashes per second the workers computed recently.
+func (e *MiningEngine) Hashrate() float64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.hashrate
+}
+
+// Hashes returns the number of hashes computed since the engine was created.
+func (e *MiningEngine) Hashes() uint64 {
+	return e.hashes.Load()
+}
+
+func (e *MiningEngine) startWindow() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.windowStart = time.Now()
+	e.windowHashes = e.hashes.Load()
+}
+
+// updateHashrate measures the hashrate since the window started, if at least minimum has passed, and starts a new window. Only time spent in Solve is measured.
+func (e *MiningEngine) updateHashrate(minimum time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	elapsed := time.Since(e.windowStart)
+	if elapsed < minimum {
+		return
+	}
+	hashes := e.hashes.Load()
+	e.hashrate = float64(hashes-e.windowHashes) / elapsed.Seconds()
+	e.windowStart = time.Now()
+	e.windowHashes = hashes
+	MiningHashrateGauge.Set(e.hashrate)
+}
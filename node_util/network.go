@@ -0,0 +1,177 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"fmt"
+	"sort"
+)
+
+// NetworkProfile holds the consensus parameters of one network. Nodes on different profiles reject each other's blocks, because their genesis blocks differ.
+type NetworkProfile struct {
+	Name                   string
+	Genesis                Block
+	Upgrades               NetworkUpgrades
+	InitialBlockDifficulty uint64
+	MinimumBlockDifficulty uint64
+	BlocksBeforeReward     int
+	RewardsStartHeight     int
+	FeesStartHeight        int
+	TransactionFee         float64
+	BodyFeePerByte         float64
+	GasPrice               float64
+	BlockLimits            BlockLimits
+	// SecurityLevels are difficulty presets that ApplySecurityLevel can switch to. Networks without them keep the profile's difficulty.
+	SecurityLevels []SecurityLevel
+	DefaultPort    string
+	// FixedDifficulty, if not zero, replaces the per-miner difficulty adjustment.
+	FixedDifficulty uint64
+	// SkipTimeVerification mines blocks without asking peers to verify their timestamps.
+	SkipTimeVerification bool
+	// AllowGenerate enables GenerateBlocks, which mines blocks on demand.
+	AllowGenerate bool
+}
+
+// DefaultNetwork is used when neither the config nor env.json names a network.
+const DefaultNetwork = "testnet"
+
+var NetworkProfiles = map[string]NetworkProfile{
+	"mainnet": {
+		Name: "mainnet",
+		Genesis: Block{
+			BlockHeader:            BlockHeader{Nonce: 1},
+			TimeVerifierSignatures: []Signature{},
+			TimeVerifiers:          []PublicKey{},
+		},
+		Upgrades:               NetworkUpgrades{},
+		InitialBlockDifficulty: 120000,
+		MinimumBlockDifficulty: 100000,
+		BlocksBeforeReward:     5,
+		RewardsStartHeight:     50,
+		FeesStartHeight:        50,
+		TransactionFee:         0.0001,
+		BodyFeePerByte:         0.000001,
+		GasPrice:               0.000001,
+		BlockLimits:            DefaultBlockLimits,
+		SecurityLevels:         DefaultSecurityLevels,
+		DefaultPort:            "8080",
+	},
+	"testnet": {
+		Name: "testnet",
+		Genesis: Block{
+			TimeVerifierSignatures: []Signature{},
+			TimeVerifiers:          []PublicKey{},
+		},
+		Upgrades: NetworkUpgrades{
+			Guadalajara: 8,
+			Jinan:       9,
+			Alexandria:  9,
+			Nairobi:     20,
+			Kyoto:       30,
+			Lima:        40,
+			Manila:      50,
+			Oslo:        60,
+			Paris:       70,
+			Quito:       80,
+		},
+		InitialBlockDifficulty: 50000,
+		MinimumBlockDifficulty: 50000,
+		BlocksBeforeReward:     3,
+		RewardsStartHeight:     50,
+		FeesStartHeight:        50,
+		TransactionFee:         0.0001,
+		BodyFeePerByte:         0.000001,
+		GasPrice:               0.000001,
+		BlockLimits:            DefaultBlockLimits,
+		SecurityLevels:         DefaultSecurityLevels,
+		DefaultPort:            "8080",
+	},
+	"devnet": {
+		Name: "devnet",
+		Genesis: Block{
+			BlockHeader:            BlockHeader{Nonce: 2},
+			TimeVerifierSignatures: []Signature{},
+			TimeVerifiers:          []PublicKey{},
+		},
+		Upgrades:               NetworkUpgrades{},
+		InitialBlockDifficulty: 1000,
+		MinimumBlockDifficulty: 1000,
+		BlocksBeforeReward:     0,
+		RewardsStartHeight:     0,
+		FeesStartHeight:        0,
+		TransactionFee:         0.0001,
+		BodyFeePerByte:         0.000001,
+		GasPrice:               0.000001,
+		BlockLimits:            DefaultBlockLimits,
+		DefaultPort:            "28080",
+	},
+	"regtest": {
+		Name: "regtest",
+		Genesis: Block{
+			BlockHeader:            BlockHeader{Nonce: 3},
+			TimeVerifierSignatures: []Signature{},
+			TimeVerifiers:          []PublicKey{},
+		},
+		Upgrades:               NetworkUpgrades{},
+		InitialBlockDifficulty: 1,
+		MinimumBlockDifficulty: 1,
+		BlocksBeforeReward:     0,
+		RewardsStartHeight:     0,
+		FeesStartHeight:        0,
+		TransactionFee:         0.0001,
+		BodyFeePerByte:         0.000001,
+		GasPrice:               0.000001,
+		BlockLimits:            DefaultBlockLimits,
+		DefaultPort:            "38080",
+		FixedDifficulty:        1,
+		SkipTimeVerification:   true,
+		AllowGenerate:          true,
+	},
+}
+
+// CurrentNetwork is the profile the node runs on. ApplyNetworkProfile changes it.
+var CurrentNetwork = NetworkProfiles[DefaultNetwork]
+
+// NetworkNames returns the names of the built-in profiles in alphabetical order.
+func NetworkNames() []string {
+	names := make([]string, 0, len(NetworkProfiles))
+	for name := range NetworkProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyNetworkProfile makes the named profile current, setting the consensus parameters and upgrade heights used by every consensus function. Each upgrade height set in env.json overrides the profile's when env.json names the same network.
+func ApplyNetworkProfile(name string) error {
+	profile, ok := NetworkProfiles[name]
+	if !ok {
+		return fmt.Errorf("unknown network %q (expected one of %v)", name, NetworkNames())
+	}
+	upgrades := profile.Upgrades
+	if envUpgradesNetwork == name {
+		envUpgrades.applyTo(&upgrades)
+	}
+	// A binary header commits to the transactions through the Merkle root, so binary blocks need one
+	if upgrades.Oslo < upgrades.Manila {
+		return fmt.Errorf("network %q activates Oslo at height %d, before Manila at height %d", name, upgrades.Oslo, upgrades.Manila)
+	}
+	CurrentNetwork = profile
+	InitialBlockDifficulty = profile.InitialBlockDifficulty
+	MinimumBlockDifficulty = profile.MinimumBlockDifficulty
+	BlocksBeforeReward = profile.BlocksBeforeReward
+	RewardsStartHeight = profile.RewardsStartHeight
+	FeesStartHeight = profile.FeesStartHeight
+	TransactionFee = profile.TransactionFee
+	BodyFeePerByte = profile.BodyFeePerByte
+	GasPrice = profile.GasPrice
+	Env.Upgrades = upgrades
+	Env.Network = name
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+// NodeState is the mutable state of one node: its chain (or headers, on a light node), mempool, indexes, state database, receipts, subscribers, issued block templates and data directory.
+// A process normally runs a single node, kept in the package globals. To run several nodes in one process (see the harness package), each node's state is captured when it stops running and restored when it runs again.
+// The network profile, logging and metrics are shared by all nodes.
+type NodeState struct {
+	Blockchain         []Block
+	Headers            []LightHeader
+	MiningTransactions []Transaction
+	TransactionHashes  map[[32]byte]int
+	NextTransitions    map[[32]byte]StateTransition
+	IsMining           bool
+	DataDir            string
+	Config             Config
+	BestPeerHeight     int
+
+	indexedBlocks        []indexedBlock
+	blockHeightsByHash   map[[64]byte]int
+	transactionLocations map[[32]byte]TransactionLocation
+	addressTransactions  map[string][]TransactionLocation
+	receipts             map[string]Receipt
+	receiptsLogged       int
+	subscriptions        map[*Subscription]bool
+	issuedTemplates      *templateStore
+	stateDB              *stateStore
+}
+
+// NewNodeState returns the state of a node with an empty blockchain whose files are in dataDir.
+func NewNodeState(dataDir string) NodeState {
+	config := CurrentConfig
+	config.DataDir = dataDir
+	return NodeState{
+		TransactionHashes:    make(map[[32]byte]int),
+		NextTransitions:      make(map[[32]byte]StateTransition),
+		DataDir:              dataDir,
+		Config:               config,
+		BestPeerHeight:       -1,
+		blockHeightsByHash:   make(map[[64]byte]int),
+		transactionLocations: make(map[[32]byte]TransactionLocation),
+		addressTransactions:  make(map[string][]TransactionLocation),
+		receipts:             make(map[string]Receipt),
+		subscriptions:        make(map[*Subscription]bool),
+		issuedTemplates:      newTemplateStore(),
+		stateDB:              newStateStore(),
+	}
+}
+
+// CaptureNodeState returns the state of the node currently in the package globals.
+func CaptureNodeState() NodeState {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	receiptsMutex.Lock()
+	defer receiptsMutex.Unlock()
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return NodeState{
+		Blockchain:           Blockchain,
+		Headers:              Headers,
+		MiningTransactions:   MiningTransactions,
+		TransactionHashes:    TransactionHashes,
+		NextTransitions:      NextTransitions,
+		IsMining:             IsMining,
+		DataDir:              DataDir,
+		Config:               CurrentConfig,
+		BestPeerHeight:       BestPeerHeight,
+		indexedBlocks:        indexedBlocks,
+		blockHeightsByHash:   blockHeightsByHash,
+		transactionLocations: transactionLocations,
+		addressTransactions:  addressTransactions,
+		receipts:             receipts,
+		receiptsLogged:       receiptsLogged,
+		subscriptions:        subscriptions,
+		issuedTemplates:      issuedTemplates,
+		stateDB:              stateDB,
+	}
+}
+
+// RestoreNodeState makes state the node in the package globals.
+func RestoreNodeState(state NodeState) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	receiptsMutex.Lock()
+	defer receiptsMutex.Unlock()
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	Blockchain = state.Blockchain
+	Headers = state.Headers
+	MiningTransactions = state.MiningTransactions
+	TransactionHashes = state.TransactionHashes
+	NextTransitions = state.NextTransitions
+	IsMining = state.IsMining
+	DataDir = state.DataDir
+	CurrentConfig = state.Config
+	BestPeerHeight = state.BestPeerHeight
+	indexedBlocks = state.indexedBlocks
+	blockHeightsByHash = state.blockHeightsByHash
+	transactionLocations = state.transactionLocations
+	addressTransactions = state.addressTransactions
+	receipts = state.receipts
+	receiptsLogged = state.receiptsLogged
+	subscriptions = state.subscriptions
+	issuedTemplates = state.issuedTemplates
+	stateDB = state.stateDB
+}
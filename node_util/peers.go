@@ -18,7 +18,7 @@ import (
 )
 
 func AddPeer(ip string) {
-	f, err := os.OpenFile("peers.txt", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	f, err := os.OpenFile(DataPath("peers.txt"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		panic(err)
 	}
@@ -36,7 +36,7 @@ func AddPeer(ip string) {
 }
 
 func GetPeers() []string {
-	file, err := os.Open("peers.txt")
+	file, err := os.Open(DataPath("peers.txt"))
 	if err != nil {
 		panic(err)
 	}
@@ -87,7 +87,7 @@ func ConnectToPeer(ip string) {
 	if err != nil {
 		panic(err)
 	}
-	ipStr := "http://" + myIp.Query + ":8080"
+	ipStr := "http://" + myIp.Query + ":" + CurrentConfig.Port
 	requestBody := strings.NewReader(ipStr)
 	req, err := http.NewRequest(http.MethodGet, ip+"/addPeer", requestBody)
 	_, err = http.DefaultClient.Do(req)
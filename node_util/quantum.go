@@ -24,6 +24,22 @@ type PrivateKey struct {
 	X         oqs.Signature
 }
 
+// GenerateKey creates a new Dilithium3 key pair.
+func GenerateKey() (PrivateKey, error) {
+	var privateKey PrivateKey
+	if err := privateKey.X.Init("Dilithium3", nil); err != nil {
+		return PrivateKey{}, err
+	}
+	pubKey, err := privateKey.X.GenerateKeyPair()
+	if err != nil {
+		return PrivateKey{}, err
+	}
+	privateKey.PublicKey = PublicKey{
+		Y: pubKey,
+	}
+	return privateKey, nil
+}
+
 func (i PrivateKey) MarshalJSON() ([]byte, error) {
 	pubKey, err := json.Marshal(i.PublicKey)
 	if err != nil {
@@ -64,7 +80,7 @@ func (i *PrivateKey) UnmarshalJSON(data []byte) error {
 	privKey := oqs.Signature{}
 	sigName := "Dilithium3"
 	if err := privKey.Init(sigName, privKeyBytes); err != nil {
-		panic(err)
+		return err
 	}
 
 	i.PublicKey = pubKey
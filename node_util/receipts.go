@@ -0,0 +1,327 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Transaction statuses, in order of progress
+const (
+	UnknownStatus   = "unknown"
+	PendingStatus   = "pending"
+	MinedStatus     = "mined"
+	FinalizedStatus = "finalized"
+)
+
+type ContractOutputs struct {
+	Transactions []string          `json:"transactions"`
+	StateChanges map[string]string `json:"stateChanges"`
+}
+
+// Receipt describes what happened to a transaction. Confirmations count the block containing the transaction; a transaction is finalized after BlocksUntilFinality confirmations.
+type Receipt struct {
+	Id              string          `json:"id"`
+	Status          string          `json:"status"`
+	BlockHash       string          `json:"blockHash"`
+	BlockHeight     int             `json:"blockHeight"`
+	Confirmations   int             `json:"confirmations"`
+	Fee             float64         `json:"fee"`
+	GasUsed         float64         `json:"gasUsed"`
+	ContractOutputs ContractOutputs `json:"contractOutputs"`
+}
+
+// ReceiptsPath is where receipts are stored, relative to DataDir. The file holds one receipt per line; a later line for the same transaction replaces an earlier one.
+var ReceiptsPath = "receipts.json"
+
+var receipts = make(map[string]Receipt)
+var receiptsMutex sync.Mutex
+
+// receiptsLogged is how many lines the receipts file has. Once they are more than twice the receipts, and more than receiptsCompactMinimum, the file is rewritten with one line per receipt.
+var receiptsLogged int
+
+const receiptsCompactMinimum = 1000
+
+func LoadReceipts() {
+	receiptsMutex.Lock()
+	defer receiptsMutex.Unlock()
+	receiptsJson, err := os.ReadFile(DataPath(ReceiptsPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(receiptsJson))
+	for decoder.More() {
+		var line json.RawMessage
+		if err := decoder.Decode(&line); err != nil {
+			panic(err)
+		}
+		receiptsLogged++
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			panic(err)
+		}
+		// Older nodes saved every receipt in a single object keyed by ID
+		if _, ok := fields["id"]; !ok {
+			var saved map[string]Receipt
+			if err := json.Unmarshal(line, &saved); err != nil {
+				panic(err)
+			}
+			for id, receipt := range saved {
+				receipts[id] = receipt
+			}
+			continue
+		}
+		var receipt Receipt
+		if err := json.Unmarshal(line, &receipt); err != nil {
+			panic(err)
+		}
+		receipts[receipt.Id] = receipt
+	}
+}
+
+// saveReceipts appends changed to the receipts file, or rewrites it if it has grown too far past the receipts it holds. It must be called with receiptsMutex held.
+func saveReceipts(changed []Receipt) {
+	var err error
+	if receiptsLogged+len(changed) > max(2*len(receipts), receiptsCompactMinimum) {
+		all := make([]Receipt, 0, len(receipts))
+		for _, receipt := range receipts {
+			all = append(all, receipt)
+		}
+		err = writeReceiptLines(os.O_TRUNC, all)
+		receiptsLogged = len(receipts)
+	} else {
+		err = writeReceiptLines(os.O_APPEND, changed)
+		receiptsLogged += len(changed)
+	}
+	if err != nil {
+		Warn("Failed to save receipts: " + err.Error())
+	}
+}
+
+func writeReceiptLines(mode int, lines []Receipt) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, receipt := range lines {
+		if err := encoder.Encode(receipt); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(DataPath(ReceiptsPath), os.O_CREATE|os.O_WRONLY|mode, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buffer.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RecordPendingReceipt stores a receipt for a transaction that entered the mining pool, along with the outputs of its contracts.
+func RecordPendingReceipt(transaction Transaction, outputs []Transaction, transition StateTransition) {
+	receipt := Receipt{
+		Id:          TransactionId(transaction),
+		Status:      PendingStatus,
+		BlockHeight: -1,
+		GasUsed:     TransactionGasUsed(transaction),
+		ContractOutputs: ContractOutputs{
+			Transactions: []string{},
+			StateChanges: make(map[string]string),
+		},
+	}
+	for _, output := range outputs {
+		receipt.ContractOutputs.Transactions = append(receipt.ContractOutputs.Transactions, TransactionId(output))
+	}
+	for key, value := range transition.UpdatedData {
+		receipt.ContractOutputs.StateChanges[key] = hex.EncodeToString(value)
+	}
+	receiptsMutex.Lock()
+	defer receiptsMutex.Unlock()
+	receipts[receipt.Id] = receipt
+	saveReceipts([]Receipt{receipt})
+}
+
+// RecordBlockReceipts stores receipts for the transactions in the block at height.
+func RecordBlockReceipts(height int) {
+	block := Blockchain[height]
+	blockHash := HashBlock(block)
+	receiptsMutex.Lock()
+	defer receiptsMutex.Unlock()
+	var changed []Receipt
+	for i, transaction := range block.Transactions {
+		if transaction.FromSmartContract {
+			continue
+		}
+		id := TransactionId(transaction)
+		receipt, found := receipts[id]
+		if !found {
+			receipt.Id = id
+			receipt.ContractOutputs = ContractOutputs{
+				Transactions: []string{},
+				StateChanges: make(map[string]string),
+			}
+			// Contract-created transactions follow the transaction that created them
+			if len(transaction.Contracts) > 0 {
+				for _, output := range block.Transactions[i+1:] {
+					if !output.FromSmartContract {
+						break
+					}
+					receipt.ContractOutputs.Transactions = append(receipt.ContractOutputs.Transactions, TransactionId(output))
+				}
+			}
+		}
+		receipt.Status = MinedStatus
+		receipt.BlockHash = hex.EncodeToString(blockHash[:])
+		receipt.BlockHeight = height
+		receipt.Fee = CalculateTransactionFee(transaction, height)
+		receipt.GasUsed = TransactionGasUsed(transaction)
+		receipts[id] = receipt
+		changed = append(changed, receipt)
+	}
+	saveReceipts(changed)
+}
+
+// GetReceipt returns the current receipt for a transaction, reconciled with the local blockchain.
+func GetReceipt(id string) Receipt {
+	receiptsMutex.Lock()
+	receipt, found := receipts[id]
+	receiptsMutex.Unlock()
+	if info, ok := GetTransactionInfo(id); ok {
+		if !found || receipt.BlockHash != info.BlockHash {
+			RecordBlockReceipts(info.BlockHeight)
+			receiptsMutex.Lock()
+			receipt = receipts[id]
+			receiptsMutex.Unlock()
+		}
+		receipt.Confirmations = info.Confirmations
+		receipt.Status = MinedStatus
+		if receipt.Confirmations >= BlocksUntilFinality {
+			receipt.Status = FinalizedStatus
+		}
+		return receipt
+	}
+	if found {
+		// Not in the blockchain (anymore), e.g. because of a reorg
+		receipt.Status = PendingStatus
+		receipt.BlockHash = ""
+		receipt.BlockHeight = -1
+		receipt.Confirmations = 0
+		return receipt
+	}
+	hashBytes, err := hex.DecodeString(id)
+	if err == nil && len(hashBytes) == 32 {
+		var hash [32]byte
+		copy(hash[:], hashBytes)
+		if TransactionHashes[hash] == 1 {
+			return Receipt{Id: id, Status: PendingStatus, BlockHeight: -1}
+		}
+	}
+	return Receipt{Id: id, Status: UnknownStatus, BlockHeight: -1}
+}
+
+func statusRank(status string) int {
+	switch status {
+	case PendingStatus:
+		return 1
+	case MinedStatus:
+		return 2
+	case FinalizedStatus:
+		return 3
+	}
+	return 0
+}
+
+// RequestReceipt returns a transaction's receipt from the local blockchain. Peers are asked for the receipt, but their answers are only hints: if a peer reports the transaction further along than the local blockchain, the blockchain is synced, which validates the peer chains, and the receipt is read again. A lying peer can't make a transaction look mined or finalized.
+func RequestReceipt(id string) Receipt {
+	receipt, _ := requestReceipt(id, true)
+	return receipt
+}
+
+// requestReceipt is RequestReceipt, syncing the blockchain only if allowSync is set. It reports whether it synced.
+func requestReceipt(id string, allowSync bool) (Receipt, bool) {
+	local := GetReceipt(id)
+	peerPending := false
+	synced := false
+	for _, peer := range GetPeers() {
+		res, err := http.Get(fmt.Sprintf("%s/tx/status?id=%s", peer, id))
+		if err != nil {
+			Log("Peer down.", true)
+			continue
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			continue
+		}
+		var receipt Receipt
+		if err := json.Unmarshal(body, &receipt); err != nil {
+			continue
+		}
+		if receipt.Status == PendingStatus {
+			peerPending = true
+		}
+		ahead := statusRank(receipt.Status) > statusRank(local.Status) || (receipt.Status == local.Status && receipt.Confirmations > local.Confirmations)
+		if ahead && statusRank(receipt.Status) >= statusRank(MinedStatus) && allowSync && !synced {
+			SyncBlockchain(-1)
+			synced = true
+			local = GetReceipt(id)
+		}
+	}
+	// A pending transaction can't be proven, but it doesn't claim anything either
+	if local.Status == UnknownStatus && peerPending {
+		return Receipt{Id: id, Status: PendingStatus, BlockHeight: -1}, synced
+	}
+	return local, synced
+}
+
+// ReceiptPollInterval is how often WaitForConfirmations checks a transaction.
+var ReceiptPollInterval = 5 * time.Second
+
+// WaitForConfirmations polls peers until the transaction has at least confirmations confirmations or timeout passes. It syncs the blockchain at most once, the first time a peer reports the transaction further along than the local blockchain; after that, new blocks arrive as usual.
+func WaitForConfirmations(id string, confirmations int, timeout time.Duration) (Receipt, error) {
+	deadline := Now().Add(timeout)
+	lastStatus := ""
+	synced := false
+	for {
+		receipt, justSynced := requestReceipt(id, !synced)
+		synced = synced || justSynced
+		if receipt.Status != lastStatus {
+			Log(fmt.Sprintf("Transaction %s is %s.", id, receipt.Status), false)
+			lastStatus = receipt.Status
+		}
+		if receipt.Confirmations >= confirmations {
+			return receipt, nil
+		}
+		if Now().After(deadline) {
+			return receipt, errors.New("timed out waiting for confirmations")
+		}
+		time.Sleep(ReceiptPollInterval)
+	}
+}
+
+func HandleTransactionStatusRequest(w http.ResponseWriter, req *http.Request) {
+	id := req.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	writeJson(w, GetReceipt(id))
+}
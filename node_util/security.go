@@ -15,14 +15,16 @@ type SecurityLevel struct {
 	BlocksBeforeReward     int
 }
 
-var SecurityLevels = []SecurityLevel{
+// DefaultSecurityLevels are the security levels of the mainnet and testnet profiles.
+var DefaultSecurityLevels = []SecurityLevel{
 	{0, 40000, 50000, 3},
 	{1, 100000, 120000, 5},
 	{2, 500000, 500000, 7},
 }
 
+// ApplySecurityLevel sets the difficulty bounds and reward delay of one of the current network's security levels. It does nothing if the network has no such level.
 func ApplySecurityLevel(level int) {
-	for _, securityLevel := range SecurityLevels {
+	for _, securityLevel := range CurrentNetwork.SecurityLevels {
 		if securityLevel.Level == level {
 			InitialBlockDifficulty = securityLevel.InitialBlockDifficulty
 			MinimumBlockDifficulty = securityLevel.MinimumDifficulty
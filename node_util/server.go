@@ -10,7 +10,9 @@ package node_util
 
 import (
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -20,65 +22,146 @@ import (
 	"time"
 )
 
-func HandleMineRequest(_ http.ResponseWriter, req *http.Request) {
+// IsMining is true when this node accepts jobs on /mine.
+var IsMining = false
+
+func HandleMineRequest(w http.ResponseWriter, req *http.Request) {
 	bodyBytes, err := io.ReadAll(req.Body)
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	if err := ProcessMineRequest(bodyBytes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// SubmitTransactionRequest adds a transaction request (in the /mine wire format) to this node's pool if it is mining, and otherwise forwards it to all peers.
+func SubmitTransactionRequest(bodyBytes []byte) error {
+	if IsMining {
+		return ProcessMineRequest(bodyBytes)
+	}
+	broadcastJob(bodyBytes)
+	return nil
+}
+
+// broadcastJob sends a transaction request to every peer's /mine.
+func broadcastJob(bodyBytes []byte) {
+	for _, peer := range GetPeers() {
+		body := strings.NewReader(string(bodyBytes))
+		req, err := http.NewRequest(http.MethodGet, peer+"/mine", body)
+		if err != nil {
+			Log(fmt.Sprintf("Invalid peer, %s.", peer), true)
+			continue
+		}
+		_, err = http.DefaultClient.Do(req)
+		if err != nil {
+			Log(fmt.Sprintf("Peer, %s is down.", peer), true)
+		}
+	}
+}
+
+// ProcessMineRequest adds a transaction request (in the /mine wire format) to the mining pool and broadcasts it to peers. It returns an error if the request is malformed; valid requests for transactions that are invalid or already known are logged and ignored.
+func ProcessMineRequest(bodyBytes []byte) error {
+	added, err := addTransactionRequest(bodyBytes)
+	if err != nil || !added {
+		return err
+	}
+	Log("Broadcasting job to peers...", true)
+	broadcastJob(bodyBytes)
+	return nil
+}
+
+// addTransactionRequest parses a transaction request and adds it to the mining pool with the transactions its contracts create. It reports whether the transaction was new and valid.
+func addTransactionRequest(bodyBytes []byte) (bool, error) {
+	ChainMutex.Lock()
+	defer ChainMutex.Unlock()
 	body := string(bodyBytes)
 	fields := strings.Split(body, "$")
+	if len(fields) < 8 {
+		return false, errors.New("malformed transaction request")
+	}
 	senderStr := fields[0]
-	senderKey := DecodePublicKey(senderStr)
+	senderKey, err := ParsePublicKey(senderStr)
+	if err != nil {
+		return false, err
+	}
 	recipientStr := fields[1]
-	recipientKey := DecodePublicKey(recipientStr)
+	recipientKey, err := ParsePublicKey(recipientStr)
+	if err != nil {
+		return false, err
+	}
 	amount, err := strconv.ParseFloat(fields[2], 64)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	timestampInt, err := strconv.ParseInt(fields[4], 10, 64)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	timestamp := time.Unix(0, timestampInt)
 	sStr := fields[3]
 	var s Signature
 	err = json.Unmarshal([]byte(sStr), &s)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	contractsStr := fields[5]
 	var contracts []Contract
 	err = json.Unmarshal([]byte(contractsStr), &contracts)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	transactionBody := []byte(fields[6])
 	transactionBodySignaturesStr := fields[7]
 	var transactionBodySignatures []Signature
 	err = json.Unmarshal([]byte(transactionBodySignaturesStr), &transactionBodySignatures)
 	if err != nil {
-		panic(err)
+		return false, err
+	}
+	var fee float64
+	if len(fields) > 8 {
+		fee, err = strconv.ParseFloat(fields[8], 64)
+		if err != nil {
+			return false, err
+		}
 	}
 	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%f:%d", senderStr, recipientStr, amount, timestamp.UnixNano())))
 	if TransactionHashes[hash] > 0 {
 		Log("No new job. Ignoring mine request.", true)
-		return
+		return false, nil
 	}
-	if !VerifyTransaction(senderKey, recipientKey, strconv.FormatFloat(amount, 'f', -1, 64), timestamp, s.S) {
+	if !VerifyTransactionWithFee(senderKey, recipientKey, strconv.FormatFloat(amount, 'f', -1, 64), timestamp, fee, s.S) {
 		Log("Transaction is invalid. Ignoring transaction request.", true)
-		return
+		return false, nil
+	}
+	if fee > 0 && fee < MinimumFee(Transaction{Contracts: contracts, Body: transactionBody}) {
+		Log("Transaction fee is below the minimum fee. Ignoring transaction request.", true)
+		return false, nil
+	}
+	if !FitsInBlock(Transaction{Sender: senderKey, Recipient: recipientKey, Amount: amount, SenderSignature: s, Timestamp: timestamp, Contracts: contracts, Body: transactionBody, Fee: fee}, len(Blockchain)) {
+		Log("Transaction is too big for a block. Ignoring transaction request.", true)
+		return false, nil
+	}
+	// Contracts the node's limits stop would get any block carrying them rejected, so they are kept out of the pool
+	results := ExecuteContracts(contracts)
+	for _, result := range results {
+		if errors.Is(result.Err, ErrContractUnfinished) {
+			Log("Contract did not finish: "+result.Err.Error()+". Ignoring transaction request.", true)
+			return false, nil
+		}
 	}
-	Log("New job.", false)
+	miningLog.Info("New job.", Fields{"tx": hex.EncodeToString(hash[:])})
 	TransactionHashes[hash] = 1
 	// Create a copy of the timestamp
 	marshaledTimestamp, err := json.Marshal(timestamp)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	unmarshaledTimestamp := time.Time{}
 	err = json.Unmarshal(marshaledTimestamp, &unmarshaledTimestamp)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	transaction := Transaction{
 		Sender:          senderKey,
@@ -89,82 +172,69 @@ func HandleMineRequest(_ http.ResponseWriter, req *http.Request) {
 		Contracts:       contracts,
 		Body:            transactionBody,
 		BodySignatures:  transactionBodySignatures,
+		Fee:             fee,
 	}
 	MiningTransactions = append(MiningTransactions, transaction)
+	PublishEvent(Event{Type: TransactionEvent, Transaction: transaction})
 	var smartContractTransactions []Transaction
-	if len(transaction.Contracts) > 0 {
-		for _, contract := range transaction.Contracts {
-			executeResult, transition,
-				gasUsed, err := contract.Execute()
-			NextTransitions[hash] = transition
-			if err != nil {
-				Warn("Error executing contract: " + err.Error())
-				continue
-			}
-			if executeResult != nil {
-				smartContractTransactions = append(smartContractTransactions, executeResult...)
-			}
-			contract.GasUsed = gasUsed
+	contractTransition := StateTransition{
+		UpdatedData: make(map[string][]byte),
+	}
+	for _, result := range results {
+		NextTransitions[hash] = result.Transition
+		if result.Err != nil {
+			Warn("Error executing contract: " + result.Err.Error())
+			continue
+		}
+		for location, value := range result.Transition.UpdatedData {
+			contractTransition.UpdatedData[location] = value
+		}
+		if result.Transactions != nil {
+			smartContractTransactions = append(smartContractTransactions, result.Transactions...)
 		}
 	}
 	for _, smartContractTransaction := range smartContractTransactions {
 		MiningTransactions = append(MiningTransactions, smartContractTransaction)
-		transactionString := fmt.Sprintf("%s:%s:%f:%d", EncodePublicKey(smartContractTransaction.Sender), EncodePublicKey(smartContractTransaction.Recipient), smartContractTransaction.Amount, smartContractTransaction.Timestamp.UnixNano())
-		transactionBytes := []byte(transactionString)
-		hash := sha256.Sum256(transactionBytes)
-		TransactionHashes[hash] = 1
-	}
-	Log("Broadcasting job to peers...", true)
-	for _, peer := range GetPeers() {
-		// Create a new body
-		body := strings.NewReader(string(bodyBytes))
-		req, err := http.NewRequest(http.MethodGet, peer+"/mine", body)
-		if err != nil {
-			panic(err)
-		}
-		_, err = http.DefaultClient.Do(req)
-		if err != nil {
-			Log(fmt.Sprintf("Peer, %s is down.", peer), true)
-		}
+		TransactionHashes[TransactionHash(smartContractTransaction)] = 1
+		PublishEvent(Event{Type: TransactionEvent, Transaction: smartContractTransaction})
 	}
+	RecordPendingReceipt(transaction, smartContractTransactions, contractTransition)
+	return true, nil
 }
 
-func HandleBlockRequest(_ http.ResponseWriter, req *http.Request) {
+func HandleBlockRequest(w http.ResponseWriter, req *http.Request) {
 	bodyBytes, err := io.ReadAll(req.Body)
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	block := Block{}
 	err = json.Unmarshal(bodyBytes, &block)
 	if err != nil {
-		panic(err)
-	}
-	if !VerifyBlock(block) {
-		Log("Block is invalid. Ignoring block request.", true)
+		http.Error(w, "invalid block: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	for _, transaction := range block.Transactions {
-		// Get transaction as string
-		transactionString := fmt.Sprintf("%s:%s:%f:%d", EncodePublicKey(transaction.Sender), EncodePublicKey(transaction.Recipient), transaction.Amount, transaction.Timestamp.UnixNano())
-		transactionBytes := []byte(transactionString)
-		// Get hash of transaction
-		hash := sha256.Sum256(transactionBytes)
-		// Mark transaction as completed
-		TransactionHashes[hash] = 2
+	appended, resyncHeight := connectBlock(block, req.RemoteAddr)
+	if resyncHeight > 0 {
+		Log("The blockchain will be re-synced to stay on the longest chain.", true)
+		SyncBlockchain(resyncHeight)
+	}
+	if !appended {
+		return
 	}
-	Append(block)
-	Log("Block appended to local blockchain!", true)
 	// Broadcast block to peers
 	Log("Broadcasting block to peers...", true)
 	bodyChars, err := json.Marshal(&block)
 	if err != nil {
-		panic(err)
+		Error("Failed to encode block: "+err.Error(), false)
+		return
 	}
 	for _, peer := range GetPeers() {
 		body := strings.NewReader(string(bodyChars))
 		req, err := http.NewRequest(http.MethodGet, peer+"/block", body)
 		if err != nil {
-			panic(err)
+			Log(fmt.Sprintf("Invalid peer, %s.", peer), true)
+			continue
 		}
 		_, err = http.DefaultClient.Do(req)
 		if err != nil {
@@ -173,14 +243,51 @@ func HandleBlockRequest(_ http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// connectBlock verifies a block received from peer and appends it to the blockchain. It reports whether the block was appended. If the block doesn't extend the blockchain, it may be on a fork, and connectBlock returns the length a peer chain must have to replace the blockchain; the caller syncs once ChainMutex is released.
+func connectBlock(block Block, peer string) (bool, int) {
+	ChainMutex.Lock()
+	defer ChainMutex.Unlock()
+	blockHash := HashBlock(block)
+	blockFields := Fields{
+		"peer":   peer,
+		"height": len(Blockchain),
+		"block":  hex.EncodeToString(blockHash[:8]),
+	}
+	if !VerifyBlock(block) {
+		chainLog.Debug("Block is invalid. Ignoring block request.", blockFields)
+		BlocksReceivedCounter.WithLabel("rejected").Inc()
+		if len(Blockchain) > 0 && block.PreviousBlockHash != HashBlock(Blockchain[len(Blockchain)-1]) {
+			// Wait for finality when switching chains
+			return false, len(Blockchain) + BlocksUntilFinality
+		}
+		return false, 0
+	}
+	BlocksReceivedCounter.WithLabel("accepted").Inc()
+	for _, transaction := range block.Transactions {
+		// Mark transaction as completed
+		TransactionHashes[TransactionHash(transaction)] = 2
+	}
+	Append(block)
+	RecordBlockReceipts(len(Blockchain) - 1)
+	chainLog.Debug("Block appended to local blockchain!", blockFields)
+	return true, 0
+}
+
 func HandleBlockchainRequest(w http.ResponseWriter, _ *http.Request) {
+	ChainMutex.Lock()
 	blockchainChars, err := json.Marshal(Blockchain)
+	ChainMutex.Unlock()
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	_, err = io.WriteString(w, string(blockchainChars))
-	if err != nil {
-		panic(err)
+	writeString(w, string(blockchainChars))
+}
+
+// writeString writes a plain response, logging instead of failing if the peer has gone away.
+func writeString(w http.ResponseWriter, response string) {
+	if _, err := io.WriteString(w, response); err != nil {
+		Log("Failed to write response.", true)
 	}
 }
 
@@ -188,42 +295,49 @@ func HandleIdentifyRequest(w http.ResponseWriter, req *http.Request) {
 	// Get body of request
 	bodyBytes, err := io.ReadAll(req.Body)
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, err := LoadKey("")
+	if err != nil {
+		http.Error(w, "no key available", http.StatusInternalServerError)
+		return
 	}
 	// Hash data
 	hash := sha256.Sum256(bodyBytes)
 	// Initialize AuthenticationProof
 	proof := AuthenticationProof{
-		PublicKey: GetKey("").PublicKey,
+		PublicKey: key.PublicKey,
 		Data:      hash[:],
 	}
 	// Sign the proof
 	err = SignAuthenticationProof(&proof)
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	// Send the proof
 	proofBytes, err := json.Marshal(proof)
 	if err != nil {
-		panic(err)
-	}
-	_, err = io.WriteString(w, string(proofBytes))
-	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	writeString(w, string(proofBytes))
 }
 
 func HandlePeerIpRequest(w http.ResponseWriter, req *http.Request) {
 	// Find the IP address of a peer by their public key
 	peerKeyBytes, err := io.ReadAll(req.Body)
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	peerKey := string(peerKeyBytes)
 	for _, peer := range GetPeers() {
 		req, err := http.NewRequest(http.MethodGet, peer+"/identify", nil)
 		if err != nil {
-			panic(err)
+			Log(fmt.Sprintf("Invalid peer, %s.", peer), true)
+			continue
 		}
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
@@ -231,15 +345,14 @@ func HandlePeerIpRequest(w http.ResponseWriter, req *http.Request) {
 			continue
 		}
 		currentPeerKeyBytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			panic(err)
+			Log("Peer is down.", true)
+			continue
 		}
 		currentPeerKey := string(currentPeerKeyBytes)
 		if currentPeerKey == peerKey {
-			_, err := io.WriteString(w, peer)
-			if err != nil {
-				panic(err)
-			}
+			writeString(w, peer)
 			return
 		}
 	}
@@ -251,50 +364,52 @@ func HandleVerifyTimeRequest(w http.ResponseWriter, req *http.Request) {
 	// This is to prevent miners from mining blocks in the future or the past
 	requestBytes, err := io.ReadAll(req.Body)
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	request := string(requestBytes)
 	// Parse the request (JSON)
 	block := Block{}
 	err = json.Unmarshal([]byte(request), &block)
 	if err != nil {
-		panic(err)
+		http.Error(w, "invalid block: "+err.Error(), http.StatusBadRequest)
+		return
 	}
 	// Get the current time
-	currentTime := time.Now()
+	currentTime := Now()
 	var miningFinishedTime time.Time
 	if block.MiningTime > 0 {
 		// Get the time mining finished
 		miningFinishedTime = block.Timestamp.Add(block.MiningTime)
 		// Check if the time the block was mined is within a reasonable range of the current time
-		// It cannot be in the future, and it cannot be more than 10 seconds in the past
-		if miningFinishedTime.After(currentTime) || miningFinishedTime.Before(currentTime.Add(-10*time.Second)) {
-			_, err := io.WriteString(w, "invalid")
-			if err != nil {
-				panic(err)
-			}
+		// It cannot be in the future, and it cannot be more than TimeVerificationWindow in the past
+		if miningFinishedTime.After(currentTime) || miningFinishedTime.Before(currentTime.Add(-TimeVerificationWindow)) {
+			writeString(w, "invalid")
 			return
 		}
 	} else {
 		// Check if the time the block started to be mined is within a reasonable range of the current time
-		// It cannot be in the future, and it cannot be more than 10 seconds in the past
-		if block.Timestamp.After(currentTime) || block.Timestamp.Before(currentTime.Add(-10*time.Second)) {
-			_, err := io.WriteString(w, "invalid")
-			if err != nil {
-				panic(err)
-			}
+		// It cannot be in the future, and it cannot be more than TimeVerificationWindow in the past
+		if block.Timestamp.After(currentTime) || block.Timestamp.Before(currentTime.Add(-TimeVerificationWindow)) {
+			writeString(w, "invalid")
+			return
 		}
 	}
 	// Sign the time with the time verifier's (this node's) private key
-	key := GetKey("")
+	key, err := LoadKey("")
+	if err != nil {
+		http.Error(w, "no key available", http.StatusInternalServerError)
+		return
+	}
 	var s []byte
 	if block.MiningTime > 0 {
-		s, err = key.X.Sign([]byte(fmt.Sprintf("%d", block.Timestamp.Add(block.MiningTime).UnixNano())))
+		s, err = key.X.Sign(TimeVerificationMessage(block.Version, block.Timestamp.Add(block.MiningTime)))
 	} else {
-		s, err = key.X.Sign([]byte(fmt.Sprintf("%d", block.Timestamp.UnixNano())))
+		s, err = key.X.Sign(TimeVerificationMessage(block.Version, block.Timestamp))
 	}
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	signature := Signature{
 		S: s,
@@ -302,49 +417,61 @@ func HandleVerifyTimeRequest(w http.ResponseWriter, req *http.Request) {
 	// Send the signature and public key back to the requester
 	signatureBytes, err := json.Marshal(signature)
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	// Marshal the public key
 	publicKeyBytes, err := json.Marshal(key.PublicKey)
 	if err != nil {
-		panic(err)
-	}
-	_, err = io.WriteString(w, string(signatureBytes)+"%"+string(publicKeyBytes))
-	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	writeString(w, string(signatureBytes)+"%"+string(publicKeyBytes))
 }
 
 func HandlePeersRequest(w http.ResponseWriter, _ *http.Request) {
 	peersBytes, err := json.Marshal(GetPeers())
 	if err != nil {
-		panic(err)
-	}
-	_, err = io.WriteString(w, string(peersBytes))
-	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	writeString(w, string(peersBytes))
 }
 
-func HandleAddPeerRequest(_ http.ResponseWriter, req *http.Request) {
+func HandleAddPeerRequest(w http.ResponseWriter, req *http.Request) {
 	peerBytes, err := io.ReadAll(req.Body)
 	if err != nil {
-		panic(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	peer := string(peerBytes)
 	AddPeer(peer)
 }
 
-func Serve(mine bool, port string) {
+// RegisterHandlers adds the node's HTTP endpoints to mux. /mine is only served by miners.
+func RegisterHandlers(mux *http.ServeMux, mine bool) {
 	if mine {
-		http.HandleFunc("/mine", HandleMineRequest)
-	}
-	http.HandleFunc("/block", HandleBlockRequest)
-	http.HandleFunc("/blockchain", HandleBlockchainRequest)
-	http.HandleFunc("/identify", HandleIdentifyRequest)
-	http.HandleFunc("/peerIp", HandlePeerIpRequest)
-	http.HandleFunc("/verifyTime", HandleVerifyTimeRequest)
-	http.HandleFunc("/peers", HandlePeersRequest)
-	http.HandleFunc("/addPeer", HandleAddPeerRequest)
+		mux.HandleFunc("/mine", HandleMineRequest)
+	}
+	mux.HandleFunc("/block", HandleBlockRequest)
+	mux.HandleFunc("/blockchain", HandleBlockchainRequest)
+	mux.HandleFunc("/identify", HandleIdentifyRequest)
+	mux.HandleFunc("/peerIp", HandlePeerIpRequest)
+	mux.HandleFunc("/verifyTime", HandleVerifyTimeRequest)
+	mux.HandleFunc("/peers", HandlePeersRequest)
+	mux.HandleFunc("/addPeer", HandleAddPeerRequest)
+	mux.HandleFunc("/explorer/block", HandleExplorerBlockRequest)
+	mux.HandleFunc("/explorer/tx", HandleExplorerTransactionRequest)
+	mux.HandleFunc("/explorer/address", HandleExplorerAddressRequest)
+	mux.HandleFunc("/tx/status", HandleTransactionStatusRequest)
+	mux.HandleFunc("/proof", HandleProofRequest)
+	mux.HandleFunc("/stateProof", HandleStateProofRequest)
+	mux.HandleFunc("/headers", HandleHeadersRequest)
+	mux.HandleFunc("/metrics", HandleMetricsRequest)
+}
+
+func Serve(mine bool, port string) {
+	IsMining = mine
+	RegisterHandlers(http.DefaultServeMux, mine)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
 }
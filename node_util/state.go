@@ -23,12 +23,10 @@ func TransitionState(state State, transition StateTransition) State {
 	return state
 }
 
+// CalculateCurrentState returns a copy of the state after the last block, from the state database.
 func CalculateCurrentState() State {
-	state := State{
-		Data: make(map[string][]byte),
-	}
-	for _, block := range Blockchain {
-		state = TransitionState(state, block.Transition)
-	}
-	return state
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	syncStateStore()
+	return State{Data: copyStateData(stateDB.Data)}
 }
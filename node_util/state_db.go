@@ -0,0 +1,331 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The state database keeps the state after the last block, so it doesn't have to be replayed from the genesis block on every read. Like the indexes, it follows Blockchain: blocks are connected by applying their transition and recording what it overwrote, and disconnected on a reorg by undoing that.
+
+// StateDir is where the state database is stored, relative to DataDir.
+var StateDir = "state"
+
+// StateUndoDepth is how many blocks' undo records a node that isn't an archive node keeps. A reorg deeper than that rebuilds the state from a snapshot.
+var StateUndoDepth = 100
+
+// StateSnapshotInterval is how often, in blocks, the whole state is written to a snapshot. A node that isn't an archive node keeps the last StateSnapshotsKept.
+var StateSnapshotInterval = 1000
+var StateSnapshotsKept = 2
+
+// stateUndo is a key's value before a block set it. Existed is false if the block added the key.
+type stateUndo struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value"`
+	Existed bool   `json:"existed"`
+}
+
+// stateBlock is a block connected to the state database. Pruned blocks have no undo record, so the state can't be rewound past them.
+type stateBlock struct {
+	Hash   [64]byte    `json:"hash"`
+	Undo   []stateUndo `json:"undo"`
+	Pruned bool        `json:"pruned,omitempty"`
+}
+
+type stateStore struct {
+	// Height is the height of the last connected block, or -1 if there is none.
+	Height int               `json:"height"`
+	Data   map[string][]byte `json:"data"`
+	// Blocks are the connected blocks the store still knows of, the last one at Height.
+	Blocks []stateBlock `json:"blocks"`
+	// tree is the state tree over Data, kept up to date so blocks' state roots don't have to be computed from scratch.
+	tree StateTree
+}
+
+// stateSnapshot is the whole state after the block at Height.
+type stateSnapshot struct {
+	Height int               `json:"height"`
+	Hash   [64]byte          `json:"hash"`
+	Data   map[string][]byte `json:"data"`
+}
+
+func newStateStore() *stateStore {
+	return &stateStore{Height: -1, Data: make(map[string][]byte)}
+}
+
+var stateDB = newStateStore()
+var stateMutex sync.Mutex
+
+// base returns the height of the first block in s.Blocks.
+func (s *stateStore) base() int {
+	return s.Height - len(s.Blocks) + 1
+}
+
+func (s *stateStore) connect(height int, block Block) {
+	keys := make([]string, 0, len(block.Transition.UpdatedData))
+	for key := range block.Transition.UpdatedData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	undo := make([]stateUndo, 0, len(keys))
+	for _, key := range keys {
+		value, existed := s.Data[key]
+		undo = append(undo, stateUndo{Key: key, Value: value, Existed: existed})
+		s.Data[key] = block.Transition.UpdatedData[key]
+	}
+	s.tree = s.tree.Update(block.Transition)
+	s.Height = height
+	s.Blocks = append(s.Blocks, stateBlock{Hash: HashBlock(block), Undo: undo})
+	if height > 0 && height%StateSnapshotInterval == 0 {
+		s.writeSnapshot()
+	}
+	if !CurrentConfig.Archive {
+		s.prune()
+	}
+}
+
+// canDisconnect reports whether the last block can be undone.
+func (s *stateStore) canDisconnect() bool {
+	return len(s.Blocks) > 0 && !s.Blocks[len(s.Blocks)-1].Pruned
+}
+
+func (s *stateStore) disconnect() {
+	last := s.Blocks[len(s.Blocks)-1]
+	undoData(s.Data, last.Undo)
+	s.tree = s.tree.undo(last.Undo)
+	s.Blocks = s.Blocks[:len(s.Blocks)-1]
+	s.Height--
+}
+
+func undoData(data map[string][]byte, undo []stateUndo) {
+	for _, u := range undo {
+		if u.Existed {
+			data[u.Key] = u.Value
+		} else {
+			delete(data, u.Key)
+		}
+	}
+}
+
+// prune drops the undo records of all but the last StateUndoDepth blocks. The block before them stays, without its undo record, so its hash can still be checked.
+func (s *stateStore) prune() {
+	if len(s.Blocks) <= StateUndoDepth+1 {
+		return
+	}
+	s.Blocks = append([]stateBlock(nil), s.Blocks[len(s.Blocks)-StateUndoDepth-1:]...)
+	s.Blocks[0] = stateBlock{Hash: s.Blocks[0].Hash, Pruned: true}
+}
+
+// matches reports whether the store's last block is in Blockchain.
+func (s *stateStore) matches() bool {
+	return s.Height < len(Blockchain) && len(s.Blocks) > 0 && s.Blocks[len(s.Blocks)-1].Hash == HashBlock(Blockchain[s.Height])
+}
+
+func snapshotPath(height int) string {
+	return DataPath(filepath.Join(StateDir, fmt.Sprintf("snapshot-%d.json", height)))
+}
+
+// snapshotHeights returns the heights of the snapshots on disk, newest first.
+func snapshotHeights() []int {
+	entries, err := os.ReadDir(DataPath(StateDir))
+	if err != nil {
+		return nil
+	}
+	var heights []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "snapshot-") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		height, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "snapshot-"), ".json"))
+		if err == nil {
+			heights = append(heights, height)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(heights)))
+	return heights
+}
+
+func (s *stateStore) writeSnapshot() {
+	snapshotJson, err := json.Marshal(stateSnapshot{Height: s.Height, Hash: s.Blocks[len(s.Blocks)-1].Hash, Data: s.Data})
+	if err != nil {
+		panic(err)
+	}
+	err = os.MkdirAll(DataPath(StateDir), 0755)
+	if err == nil {
+		err = os.WriteFile(snapshotPath(s.Height), snapshotJson, 0644)
+	}
+	if err != nil {
+		Warn("Failed to save a state snapshot: " + err.Error())
+		return
+	}
+	if CurrentConfig.Archive {
+		return
+	}
+	heights := snapshotHeights()
+	if len(heights) > StateSnapshotsKept {
+		for _, height := range heights[StateSnapshotsKept:] {
+			_ = os.Remove(snapshotPath(height))
+		}
+	}
+}
+
+func readSnapshot(height int) (stateSnapshot, error) {
+	var snapshot stateSnapshot
+	snapshotJson, err := os.ReadFile(snapshotPath(height))
+	if err != nil {
+		return snapshot, err
+	}
+	err = json.Unmarshal(snapshotJson, &snapshot)
+	return snapshot, err
+}
+
+// latestSnapshot returns the newest snapshot of a block that is in Blockchain at or below height, or the empty state before the genesis block if there is none.
+func latestSnapshot(height int) stateSnapshot {
+	for _, snapshotHeight := range snapshotHeights() {
+		if snapshotHeight > height {
+			continue
+		}
+		snapshot, err := readSnapshot(snapshotHeight)
+		if err == nil && snapshot.Height == snapshotHeight && snapshot.Hash == HashBlock(Blockchain[snapshotHeight]) {
+			return snapshot
+		}
+	}
+	return stateSnapshot{Height: -1, Data: make(map[string][]byte)}
+}
+
+// rebuild resets the store to the latest usable snapshot.
+func (s *stateStore) rebuild() {
+	snapshot := latestSnapshot(len(Blockchain) - 1)
+	s.Height = snapshot.Height
+	s.Data = snapshot.Data
+	s.tree = NewStateTree(State{Data: s.Data})
+	s.Blocks = nil
+	if snapshot.Height >= 0 {
+		s.Blocks = []stateBlock{{Hash: snapshot.Hash, Pruned: true}}
+	}
+}
+
+// syncStateStore must be called with stateMutex held.
+func syncStateStore() {
+	s := stateDB
+	for s.Height >= 0 && !s.matches() {
+		if !s.canDisconnect() {
+			s.rebuild()
+			break
+		}
+		s.disconnect()
+	}
+	for height := s.Height + 1; height < len(Blockchain); height++ {
+		s.connect(height, Blockchain[height])
+	}
+}
+
+// SyncState brings the state database in line with Blockchain, undoing blocks that are no longer in it.
+func SyncState() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	syncStateStore()
+}
+
+// GetStateValue returns key's value after the last block, and whether the state has the key.
+func GetStateValue(key string) ([]byte, bool) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	syncStateStore()
+	value, ok := stateDB.Data[key]
+	return value, ok
+}
+
+// stateTreeAfter returns the state tree after the last block and then transition.
+func stateTreeAfter(transition StateTransition) StateTree {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	syncStateStore()
+	return stateDB.tree.Update(transition)
+}
+
+// StateAt returns the state after the block at height. The state is rewound with the undo records if it can be, and otherwise replayed from the newest snapshot at or below height, or from the genesis block. Archive nodes keep every undo record, so they never replay.
+func StateAt(height int) (State, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	syncStateStore()
+	s := stateDB
+	if height < 0 || height > s.Height {
+		return State{}, fmt.Errorf("no block at height %d", height)
+	}
+	// Only the first block can be pruned, so every block after it can be undone
+	if height >= s.base() {
+		data := copyStateData(s.Data)
+		for i := len(s.Blocks) - 1; s.base()+i > height; i-- {
+			undoData(data, s.Blocks[i].Undo)
+		}
+		return State{Data: data}, nil
+	}
+	snapshot := latestSnapshot(height)
+	state := State{Data: snapshot.Data}
+	for i := snapshot.Height + 1; i <= height; i++ {
+		state = TransitionState(state, Blockchain[i].Transition)
+	}
+	return state, nil
+}
+
+func copyStateData(data map[string][]byte) map[string][]byte {
+	copied := make(map[string][]byte, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
+}
+
+// LoadStateDB reads the state database saved by SaveStateDB. It is brought in line with Blockchain on the next read.
+func LoadStateDB() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	stateDB = newStateStore()
+	stateJson, err := os.ReadFile(DataPath(filepath.Join(StateDir, "state.json")))
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(stateJson, stateDB)
+	}
+	if err != nil {
+		Warn("Could not read the saved state: " + err.Error())
+		stateDB = newStateStore()
+		return
+	}
+	stateDB.tree = NewStateTree(State{Data: stateDB.Data})
+}
+
+// SaveStateDB writes the state database to the data directory.
+func SaveStateDB() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	syncStateStore()
+	stateJson, err := json.Marshal(stateDB)
+	if err != nil {
+		panic(err)
+	}
+	err = os.MkdirAll(DataPath(StateDir), 0755)
+	if err == nil {
+		err = os.WriteFile(DataPath(filepath.Join(StateDir, "state.json")), stateJson, 0644)
+	}
+	if err != nil {
+		Warn("Failed to save the state: " + err.Error())
+	}
+}
@@ -0,0 +1,406 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// The state tree is a sparse Merkle tree with a leaf for every possible key: the path to a key's leaf is the bits of sha256(key), first bit at the root. Empty leaves and subtrees hash to zero, so only the paths to keys in the state are ever hashed.
+const stateTreeDepth = 256
+
+var ErrNoStateRoot = errors.New("block has no state root: it was mined before the Paris upgrade")
+
+// StateMerkleProof is the path from a key's leaf to a state root.
+type StateMerkleProof struct {
+	// Bitmap is a hex-encoded bit per level, from the leaf up, set if the path's sibling at that level is not empty. The first level is the highest bit of the first byte.
+	Bitmap string `json:"bitmap"`
+	// Siblings are the hex-encoded siblings that are not empty, from the leaf up.
+	Siblings []string `json:"siblings"`
+}
+
+// StateProof is what /stateProof returns: the value of a key after a block, or that the key wasn't set, and the proof that the block's state root commits to it.
+type StateProof struct {
+	Key string `json:"key"`
+	// Value is the key's value, and Included whether the key is in the state at all.
+	Value       []byte           `json:"value"`
+	Included    bool             `json:"included"`
+	BlockHeight int              `json:"blockHeight"`
+	BlockHash   string           `json:"blockHash"`
+	StateRoot   string           `json:"stateRoot"`
+	Proof       StateMerkleProof `json:"proof"`
+}
+
+type stateEntry struct {
+	path [32]byte
+	leaf [32]byte
+}
+
+func stateKeyPath(key string) [32]byte {
+	return sha256.Sum256([]byte(key))
+}
+
+// pathBit returns the bit of path at depth, counting from the root.
+func pathBit(path [32]byte, depth int) int {
+	return int(path[depth/8]>>(7-depth%8)) & 1
+}
+
+// StateLeaf returns the leaf hash of a key with value in the state tree.
+func StateLeaf(key string, value []byte) [32]byte {
+	path := stateKeyPath(key)
+	valueHash := sha256.Sum256(value)
+	data := make([]byte, 0, 1+2*sha256.Size)
+	data = append(data, merkleLeafPrefix)
+	data = append(data, path[:]...)
+	data = append(data, valueHash[:]...)
+	return sha256.Sum256(data)
+}
+
+// stateNode is merkleNode, except that two empty children make an empty node.
+func stateNode(left [32]byte, right [32]byte) [32]byte {
+	if left == [32]byte{} && right == [32]byte{} {
+		return [32]byte{}
+	}
+	return merkleNode(left, right)
+}
+
+// stateEntries returns the leaves of the state's keys, sorted by path.
+func stateEntries(state State) []stateEntry {
+	entries := make([]stateEntry, 0, len(state.Data))
+	for key, value := range state.Data {
+		entries = append(entries, stateEntry{path: stateKeyPath(key), leaf: StateLeaf(key, value)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].path[:], entries[j].path[:]) < 0
+	})
+	return entries
+}
+
+// splitEntries splits entries, which all share the path up to depth, into those that go left and right at depth.
+func splitEntries(entries []stateEntry, depth int) ([]stateEntry, []stateEntry) {
+	split := sort.Search(len(entries), func(i int) bool {
+		return pathBit(entries[i].path, depth) == 1
+	})
+	return entries[:split], entries[split:]
+}
+
+// stateSubtree returns the hash of the subtree at depth holding entries.
+func stateSubtree(entries []stateEntry, depth int) [32]byte {
+	if len(entries) == 0 {
+		return [32]byte{}
+	}
+	if depth == stateTreeDepth {
+		return entries[0].leaf
+	}
+	left, right := splitEntries(entries, depth)
+	return stateNode(stateSubtree(left, depth+1), stateSubtree(right, depth+1))
+}
+
+// StateRoot returns the root of the state tree over state's keys. The root of the empty state is zero.
+func StateRoot(state State) [32]byte {
+	return stateSubtree(stateEntries(state), 0)
+}
+
+// stateTreeNode is a cached node of the state tree. A node with an entry is the subtree holding only that key, however far above its leaf it is. Other nodes have a child for each side that isn't empty.
+type stateTreeNode struct {
+	hash  [32]byte
+	entry *stateEntry
+	left  *stateTreeNode
+	right *stateTreeNode
+}
+
+// StateTree is the state tree with its inner nodes cached, so updating it only rehashes the paths to the keys that changed. Updates return a new tree that shares the nodes they didn't change, so the old tree stays usable.
+type StateTree struct {
+	root *stateTreeNode
+}
+
+// NewStateTree builds the state tree over state's keys.
+func NewStateTree(state State) StateTree {
+	return StateTree{root: buildStateTreeNode(stateEntries(state), 0)}
+}
+
+// Root returns the tree's state root, the same as StateRoot of its state.
+func (t StateTree) Root() [32]byte {
+	return t.root.hashOrEmpty()
+}
+
+// Update returns the tree after transition.
+func (t StateTree) Update(transition StateTransition) StateTree {
+	root := t.root
+	for key, value := range transition.UpdatedData {
+		entry := stateEntry{path: stateKeyPath(key), leaf: StateLeaf(key, value)}
+		root = root.set(0, entry)
+	}
+	return StateTree{root: root}
+}
+
+// undo returns the tree with undo's keys set back to their values, or removed if they didn't exist.
+func (t StateTree) undo(undo []stateUndo) StateTree {
+	root := t.root
+	for _, u := range undo {
+		if u.Existed {
+			root = root.set(0, stateEntry{path: stateKeyPath(u.Key), leaf: StateLeaf(u.Key, u.Value)})
+		} else {
+			root = root.remove(0, stateKeyPath(u.Key))
+		}
+	}
+	return StateTree{root: root}
+}
+
+func (n *stateTreeNode) hashOrEmpty() [32]byte {
+	if n == nil {
+		return [32]byte{}
+	}
+	return n.hash
+}
+
+func buildStateTreeNode(entries []stateEntry, depth int) *stateTreeNode {
+	if len(entries) == 0 {
+		return nil
+	}
+	if len(entries) == 1 {
+		return newStateTreeLeaf(entries[0], depth)
+	}
+	left, right := splitEntries(entries, depth)
+	return newStateTreeBranch(buildStateTreeNode(left, depth+1), buildStateTreeNode(right, depth+1))
+}
+
+// newStateTreeLeaf returns the subtree at depth holding only entry.
+func newStateTreeLeaf(entry stateEntry, depth int) *stateTreeNode {
+	hash := entry.leaf
+	for level := stateTreeDepth - 1; level >= depth; level-- {
+		if pathBit(entry.path, level) == 0 {
+			hash = stateNode(hash, [32]byte{})
+		} else {
+			hash = stateNode([32]byte{}, hash)
+		}
+	}
+	return &stateTreeNode{hash: hash, entry: &entry}
+}
+
+func newStateTreeBranch(left *stateTreeNode, right *stateTreeNode) *stateTreeNode {
+	if left == nil && right == nil {
+		return nil
+	}
+	return &stateTreeNode{hash: stateNode(left.hashOrEmpty(), right.hashOrEmpty()), left: left, right: right}
+}
+
+// set returns the subtree at depth with entry's key set to entry.
+func (n *stateTreeNode) set(depth int, entry stateEntry) *stateTreeNode {
+	if n == nil || (n.entry != nil && n.entry.path == entry.path) {
+		return newStateTreeLeaf(entry, depth)
+	}
+	if n.entry != nil {
+		// The two keys share their path down to split, so the nodes above it have one child
+		split := depth
+		for pathBit(n.entry.path, split) == pathBit(entry.path, split) {
+			split++
+		}
+		node := newStateTreeLeaf(*n.entry, split+1)
+		if pathBit(entry.path, split) == 0 {
+			node = newStateTreeBranch(newStateTreeLeaf(entry, split+1), node)
+		} else {
+			node = newStateTreeBranch(node, newStateTreeLeaf(entry, split+1))
+		}
+		for level := split - 1; level >= depth; level-- {
+			if pathBit(entry.path, level) == 0 {
+				node = newStateTreeBranch(node, nil)
+			} else {
+				node = newStateTreeBranch(nil, node)
+			}
+		}
+		return node
+	}
+	if pathBit(entry.path, depth) == 0 {
+		return newStateTreeBranch(n.left.set(depth+1, entry), n.right)
+	}
+	return newStateTreeBranch(n.left, n.right.set(depth+1, entry))
+}
+
+// remove returns the subtree at depth without the key at path. Nodes left with one child aren't merged, since they hash the same.
+func (n *stateTreeNode) remove(depth int, path [32]byte) *stateTreeNode {
+	if n == nil {
+		return nil
+	}
+	if n.entry != nil {
+		if n.entry.path == path {
+			return nil
+		}
+		return n
+	}
+	if pathBit(path, depth) == 0 {
+		return newStateTreeBranch(n.left.remove(depth+1, path), n.right)
+	}
+	return newStateTreeBranch(n.left, n.right.remove(depth+1, path))
+}
+
+// NewStateMerkleProof returns the proof for key in state, whether or not state has it.
+func NewStateMerkleProof(state State, key string) StateMerkleProof {
+	path := stateKeyPath(key)
+	entries := stateEntries(state)
+	siblings := make([][32]byte, stateTreeDepth)
+	for depth := 0; depth < stateTreeDepth; depth++ {
+		left, right := splitEntries(entries, depth)
+		if pathBit(path, depth) == 0 {
+			siblings[depth], entries = stateSubtree(right, depth+1), left
+		} else {
+			siblings[depth], entries = stateSubtree(left, depth+1), right
+		}
+	}
+	var bitmap [stateTreeDepth / 8]byte
+	proof := StateMerkleProof{Siblings: []string{}}
+	for level := 0; level < stateTreeDepth; level++ {
+		sibling := siblings[stateTreeDepth-1-level]
+		if sibling == [32]byte{} {
+			continue
+		}
+		bitmap[level/8] |= 0x80 >> (level % 8)
+		proof.Siblings = append(proof.Siblings, hex.EncodeToString(sibling[:]))
+	}
+	proof.Bitmap = hex.EncodeToString(bitmap[:])
+	return proof
+}
+
+// VerifyStateMerkleProof checks that proof leads from key's leaf to root: the leaf of key with value if included, or an empty leaf if not.
+func VerifyStateMerkleProof(key string, value []byte, included bool, proof StateMerkleProof, root [32]byte) bool {
+	bitmap, err := hex.DecodeString(proof.Bitmap)
+	if err != nil || len(bitmap) != stateTreeDepth/8 {
+		return false
+	}
+	path := stateKeyPath(key)
+	var hash [32]byte
+	if included {
+		hash = StateLeaf(key, value)
+	}
+	siblings := proof.Siblings
+	for level := 0; level < stateTreeDepth; level++ {
+		var sibling [32]byte
+		if bitmap[level/8]&(0x80>>(level%8)) != 0 {
+			if len(siblings) == 0 {
+				return false
+			}
+			siblingBytes, err := hex.DecodeString(siblings[0])
+			if err != nil || len(siblingBytes) != sha256.Size {
+				return false
+			}
+			siblings = siblings[1:]
+			copy(sibling[:], siblingBytes)
+		}
+		if pathBit(path, stateTreeDepth-1-level) == 0 {
+			hash = stateNode(hash, sibling)
+		} else {
+			hash = stateNode(sibling, hash)
+		}
+	}
+	return len(siblings) == 0 && hash == root
+}
+
+// VerifyStateProof checks that a proof from /stateProof is consistent: its key and value lead to its state root. The caller still has to check that the root is the one in a block it trusts.
+func VerifyStateProof(proof StateProof) bool {
+	if !proof.Included && len(proof.Value) > 0 {
+		return false
+	}
+	rootBytes, err := hex.DecodeString(proof.StateRoot)
+	if err != nil || len(rootBytes) != sha256.Size {
+		return false
+	}
+	var root [32]byte
+	copy(root[:], rootBytes)
+	return VerifyStateMerkleProof(proof.Key, proof.Value, proof.Included, proof.Proof, root)
+}
+
+// VerifyStateRoot checks a block at height's state root against state, the state after the block's transition. Blocks from the Paris upgrade commit to the state with it, and older blocks must not have one.
+func VerifyStateRoot(block Block, height int, state State) bool {
+	return verifyStateRootOn(block, height, NewStateTree(state))
+}
+
+// verifyStateRootOn is VerifyStateRoot with the state tree after the block's transition.
+func verifyStateRootOn(block Block, height int, tree StateTree) bool {
+	if BlockVersionAt(height) < StateRootBlockVersion {
+		if block.StateRoot != [32]byte{} {
+			Log("Block has a state root before Paris. Ignoring block request.", true)
+			return false
+		}
+		return true
+	}
+	if block.StateRoot != tree.Root() {
+		Log("Block has an invalid state root. Ignoring block request.", true)
+		return false
+	}
+	return true
+}
+
+// GetStateProof returns the proof of key's value after the block at height.
+func GetStateProof(key string, height int) (StateProof, error) {
+	if height < 0 || height >= len(Blockchain) {
+		return StateProof{}, fmt.Errorf("no block at height %d", height)
+	}
+	block := Blockchain[height]
+	if block.Version < StateRootBlockVersion {
+		return StateProof{}, ErrNoStateRoot
+	}
+	state, err := StateAt(height)
+	if err != nil {
+		return StateProof{}, err
+	}
+	value, included := state.Data[key]
+	hash := HashBlock(block)
+	return StateProof{
+		Key:         key,
+		Value:       value,
+		Included:    included,
+		BlockHeight: height,
+		BlockHash:   hex.EncodeToString(hash[:]),
+		StateRoot:   hex.EncodeToString(block.StateRoot[:]),
+		Proof:       NewStateMerkleProof(state, key),
+	}, nil
+}
+
+// RequestStateProof asks peer for the proof of key's value after the block at height, and checks it with VerifyStateProof.
+func RequestStateProof(peer string, key string, height int) (StateProof, error) {
+	var proof StateProof
+	if err := getJson(fmt.Sprintf("%s/stateProof?key=%s&height=%d", peer, url.QueryEscape(key), height), &proof); err != nil {
+		return StateProof{}, err
+	}
+	if proof.Key != key || proof.BlockHeight != height || !VerifyStateProof(proof) {
+		return StateProof{}, errors.New("peer returned an invalid state proof")
+	}
+	return proof, nil
+}
+
+func HandleStateProofRequest(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if !query.Has("key") {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	height := len(Blockchain) - 1
+	if heightStr := query.Get("height"); heightStr != "" {
+		var err error
+		height, err = strconv.Atoi(heightStr)
+		if err != nil {
+			http.Error(w, "invalid height", http.StatusBadRequest)
+			return
+		}
+	}
+	proof, err := GetStateProof(query.Get("key"), height)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJson(w, proof)
+}
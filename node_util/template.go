@@ -0,0 +1,185 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package node_util
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// BlockTemplate is a snapshot of everything needed to mine the next block. Miners work on a template instead of the live Blockchain and MiningTransactions, so nothing changes under them while they search for a nonce.
+type BlockTemplate struct {
+	Height            int
+	PreviousBlockHash [64]byte
+	Miner             PublicKey
+	// Coinbase goes before Transactions in the block. It is empty before Kyoto.
+	Coinbase                        []Transaction
+	Transactions                    []Transaction
+	Transition                      StateTransition
+	Difficulty                      uint64
+	Timestamp                       time.Time
+	PreMiningTimeVerifiers          []PublicKey
+	PreMiningTimeVerifierSignatures []Signature
+	// MerkleRoot is the root of the coinbase and transactions, or zero before Manila.
+	MerkleRoot [32]byte
+	// TransitionHash is the hash of Transition, or zero before Oslo.
+	TransitionHash [32]byte
+	// StateRoot is the root of the state after Transition, or zero before Paris.
+	StateRoot [32]byte
+	Version   int
+	// Created is when the template was built. The block's MiningTime is measured from it.
+	Created time.Time
+}
+
+// NewBlockTemplate builds a template for the next block paid to miner from the current blockchain and mining pool: the best-paying transactions first, as many as fit within the block limits. Transactions that have already been mined are dropped from the pool first. Unless the network skips time verification, peers are asked to sign the timestamp before mining starts.
+func NewBlockTemplate(miner PublicKey) BlockTemplate {
+	ChainMutex.Lock()
+	template := newBlockTemplate(miner)
+	ChainMutex.Unlock()
+	if !CurrentNetwork.SkipTimeVerification {
+		template.PreMiningTimeVerifierSignatures, template.PreMiningTimeVerifiers = RequestTimeVerification(template.Block(0))
+	}
+	ChainMutex.Lock()
+	defer ChainMutex.Unlock()
+	// The coinbase pays the pre-mining time verifiers, so it is added once they have signed
+	if Env.Upgrades.Kyoto <= template.Height {
+		template.Coinbase = CoinbaseTransactions(template.Height, miner, template.PreMiningTimeVerifiers, template.Transactions)
+	}
+	if Env.Upgrades.Manila <= template.Height {
+		template.MerkleRoot = TransactionsMerkleRoot(template.Block(0).Transactions, template.Version)
+	}
+	return template
+}
+
+// newBlockTemplate builds a template without its time verifiers, coinbase and Merkle root. It must be called with ChainMutex held.
+func newBlockTemplate(miner PublicKey) BlockTemplate {
+	removeMiningTransactions(nil)
+	template := BlockTemplate{
+		Height:       len(Blockchain),
+		Version:      BlockVersionAt(len(Blockchain)),
+		Miner:        miner,
+		Transactions: fillBlock(groupByFeeRate(MiningTransactions), BlockLimitsAt(len(Blockchain))),
+		Transition: StateTransition{
+			UpdatedData: make(map[string][]byte),
+		},
+		Difficulty:                      difficultyOn(Blockchain, miner.Y),
+		Timestamp:                       NextBlockTimestamp(),
+		PreMiningTimeVerifiers:          []PublicKey{},
+		PreMiningTimeVerifierSignatures: []Signature{},
+		Created:                         Now(),
+	}
+	if len(Blockchain) > 0 {
+		template.PreviousBlockHash = HashBlock(Blockchain[len(Blockchain)-1])
+	}
+	// Only the contracts of the transactions that fit in the block change the state, in block order
+	for _, transaction := range template.Transactions {
+		for address, data := range NextTransitions[TransactionHash(transaction)].UpdatedData {
+			template.Transition.UpdatedData[address] = data
+		}
+	}
+	if Env.Upgrades.Oslo <= template.Height {
+		template.TransitionHash = HashStateTransition(template.Transition)
+	}
+	if template.Version >= StateRootBlockVersion {
+		template.StateRoot = stateTreeAfter(template.Transition).Root()
+	}
+	return template
+}
+
+// Block returns the template's block with the given nonce.
+func (t BlockTemplate) Block(nonce int64) Block {
+	transactions := t.Transactions
+	if len(t.Coinbase) > 0 {
+		transactions = append(append([]Transaction(nil), t.Coinbase...), t.Transactions...)
+	}
+	return Block{
+		BlockHeader: BlockHeader{
+			Miner:             t.Miner,
+			PreviousBlockHash: t.PreviousBlockHash,
+			Nonce:             nonce,
+			Difficulty:        t.Difficulty,
+			Timestamp:         t.Timestamp,
+			MerkleRoot:        t.MerkleRoot,
+			TransitionHash:    t.TransitionHash,
+			StateRoot:         t.StateRoot,
+			Version:           t.Version,
+		},
+		Transactions:                    transactions,
+		TimeVerifierSignatures:          []Signature{},
+		TimeVerifiers:                   []PublicKey{},
+		PreMiningTimeVerifierSignatures: t.PreMiningTimeVerifierSignatures,
+		PreMiningTimeVerifiers:          t.PreMiningTimeVerifiers,
+		Transition:                      t.Transition,
+	}
+}
+
+// Target is the largest hash prefix, read as a big-endian uint64, that solves the template.
+func (t BlockTemplate) Target() uint64 {
+	return MaximumUint64 / t.Difficulty
+}
+
+// Solves reports whether nonce solves the template.
+func (t BlockTemplate) Solves(nonce int64) bool {
+	hash := HashBlock(t.Block(nonce))
+	return binary.BigEndian.Uint64(hash[:]) <= t.Target()
+}
+
+// FinalizeBlock turns a solved template into a block: it records the mining time and, unless the network skips time verification, collects the post-mining time verifier signatures. It fails if too few peers verified the time.
+func FinalizeBlock(t BlockTemplate, nonce int64) (Block, error) {
+	block := t.Block(nonce)
+	block.MiningTime = Since(t.Created)
+	if CurrentNetwork.SkipTimeVerification {
+		return block, nil
+	}
+	block.TimeVerifierSignatures, block.TimeVerifiers = RequestTimeVerification(block)
+	if int64(len(block.TimeVerifiers)) < GetMinerCount(len(Blockchain))/5 {
+		Warn("Not enough time verifiers.")
+		BlocksLostCounter.Inc()
+		return Block{}, errors.New("lost block")
+	}
+	return block, nil
+}
+
+// removeMiningTransactions drops the given transactions, and any that have already been mined, from the mining pool. It must be called with ChainMutex held.
+func removeMiningTransactions(included []Transaction) {
+	remove := make(map[[32]byte]bool)
+	for _, transaction := range included {
+		remove[TransactionHash(transaction)] = true
+	}
+	pending := make([]Transaction, 0, len(MiningTransactions))
+	for _, transaction := range MiningTransactions {
+		hash := TransactionHash(transaction)
+		if remove[hash] || TransactionHashes[hash] > 1 {
+			delete(NextTransitions, hash)
+			continue
+		}
+		pending = append(pending, transaction)
+	}
+	MiningTransactions = pending
+}
+
+// addMinedBlock appends a block this node mined, marks its transactions as mined and broadcasts it. It fails with ErrStaleTemplate if the block no longer extends the blockchain, e.g. because a peer's block arrived while it was being finalized.
+func addMinedBlock(block Block) error {
+	ChainMutex.Lock()
+	if len(Blockchain) > 0 && HashBlock(Blockchain[len(Blockchain)-1]) != block.PreviousBlockHash {
+		ChainMutex.Unlock()
+		return ErrStaleTemplate
+	}
+	for _, transaction := range block.Transactions {
+		TransactionHashes[TransactionHash(transaction)] = 2
+	}
+	removeMiningTransactions(block.Transactions)
+	Append(block)
+	RecordBlockReceipts(len(Blockchain) - 1)
+	ChainMutex.Unlock()
+	BlocksMinedCounter.Inc()
+	BroadcastBlock(block)
+	return nil
+}
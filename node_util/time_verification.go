@@ -28,57 +28,63 @@ func RequestTimeVerification(block Block) ([]Signature, []PublicKey) {
 		// Get the peer's public key
 		peerKey, validSig, err := RequestAuthentication(peer)
 		if err != nil {
-			Log("Peer down.", true)
+			p2pLog.Debug("Peer down.", Fields{"peer": peer, "error": err})
+			TimeVerificationCounter.WithLabel("down").Inc()
 			continue
 		}
 		if !validSig {
-			Log("Peer has invalid signature.", true)
+			p2pLog.Debug("Peer has invalid signature.", Fields{"peer": peer})
+			TimeVerificationCounter.WithLabel("unauthenticated").Inc()
 			continue
 		}
 		// Verify that the peer has mined a block
 		if IsNewMiner(peerKey, len(Blockchain)+1) {
-			Log("Peer has not mined a block.", true)
+			p2pLog.Debug("Peer has not mined a block.", Fields{"peer": peer})
+			TimeVerificationCounter.WithLabel("not_miner").Inc()
 			continue
 		}
 		// Ask to verify the time
 		body := strings.NewReader(string(bodyChars))
 		req, err := http.NewRequest(http.MethodGet, peer+"/verifyTime", body)
 		if err != nil {
-			panic(err)
+			p2pLog.Debug("Invalid peer.", Fields{"peer": peer, "error": err})
+			continue
 		}
 		res, err := http.DefaultClient.Do(req)
 		if err != nil {
-			Log("Peer down.", true)
+			p2pLog.Debug("Peer down.", Fields{"peer": peer, "error": err})
+			TimeVerificationCounter.WithLabel("down").Inc()
 			continue
 		}
 		// Get the response body
 		bodyBytes, err := io.ReadAll(res.Body)
+		res.Body.Close()
 		if err != nil {
-			panic(err)
+			p2pLog.Debug("Peer down.", Fields{"peer": peer, "error": err})
+			TimeVerificationCounter.WithLabel("down").Inc()
+			continue
 		}
 		if string(bodyBytes) == "invalid" {
-			Warn("verifier believes block is invalid.")
+			p2pLog.Warn("Verifier believes block is invalid.", Fields{"peer": peer})
+			TimeVerificationCounter.WithLabel("invalid").Inc()
 			continue
 		}
 		// Split the response body into the signature and the public key
 		split := strings.Split(string(bodyBytes), "%")
-		// Unmarshal the signature
+		// Unmarshal the signature and the public key
 		var signature Signature
-		err = json.Unmarshal([]byte(split[0]), &signature)
-		if err != nil {
-			panic(err)
-		}
-		// Unmarshal the public key
 		var publicKey PublicKey
-		err = json.Unmarshal([]byte(split[1]), &publicKey)
-		if err != nil {
-			panic(err)
+		if len(split) != 2 || json.Unmarshal([]byte(split[0]), &signature) != nil || json.Unmarshal([]byte(split[1]), &publicKey) != nil {
+			p2pLog.Debug("Peer sent a malformed verification.", Fields{"peer": peer})
+			TimeVerificationCounter.WithLabel("invalid").Inc()
+			continue
 		}
 		// Add the time verifier to the block
 		publicKeys = append(publicKeys, publicKey)
 		// Add the time verifier signature to the block
 		signatures = append(signatures, signature)
-		Log("Got verification.", true)
+		TimeVerificationCounter.WithLabel("signed").Inc()
+		p2pLog.Debug("Got verification.", Fields{"peer": peer})
 	}
 	return signatures, publicKeys
 }
@@ -12,8 +12,10 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
 
@@ -21,33 +23,60 @@ import (
 )
 
 func VerifyTransaction(senderKey PublicKey, recipientKey PublicKey, amount string, timestamp time.Time, sig []byte) bool {
+	return VerifyTransactionWithFee(senderKey, recipientKey, amount, timestamp, 0, sig)
+}
+
+// VerifyTransactionWithFee checks a transaction's signature, including the set fee it was signed with, and that its sender can pay the amount and fee on top of its other pending transactions.
+func VerifyTransactionWithFee(senderKey PublicKey, recipientKey PublicKey, amount string, timestamp time.Time, fee float64, sig []byte) bool {
+	if bytes.Equal(senderKey.Y, CoinbaseKey.Y) {
+		Warn("Transaction from the coinbase key detected")
+		return false
+	}
 	amountFloat, err := strconv.ParseFloat(amount, 64)
 	if err != nil {
-		panic(err)
+		Log("Transaction amount is not a number.", true)
+		return false
 	}
-	transactionString := fmt.Sprintf("%s:%s:%s:%d", senderKey.Y, recipientKey.Y, amount, timestamp.UnixNano())
-	hash := sha256.Sum256([]byte(transactionString))
+	if fee < 0 {
+		Warn("Transaction with a negative fee detected")
+		return false
+	}
+	version := BlockVersionAt(len(Blockchain))
+	hash := TransactionSigningHashFor(version, senderKey, recipientKey, amount, timestamp.UnixNano(), fee)
 	verifier := oqs.Signature{}
 	sigName := "Dilithium3"
 	if err := verifier.Init(sigName, nil); err != nil {
-		Error("Failed to initialize Dilithium2 verifier", true)
+		Error("Failed to initialize Dilithium2 verifier", false)
+		return false
 	}
+	start := time.Now()
 	isValid, err := verifier.Verify(hash[:], sig, senderKey.Y)
+	SignatureVerificationSeconds.ObserveSince(start)
 	if err != nil {
-		panic(err)
+		Log("Malformed signature: "+err.Error(), true)
+		return false
+	}
+	if !isValid && version >= BinaryBlockVersion && legacySignatureAllowed(timestamp, len(Blockchain)) {
+		legacyHash := TransactionSigningHash(senderKey, recipientKey, amount, timestamp.UnixNano(), fee)
+		isValid, err = verifier.Verify(legacyHash[:], sig, senderKey.Y)
+		if err != nil {
+			Log("Malformed signature: "+err.Error(), true)
+			return false
+		}
 	}
 	if !isValid {
 		Warn("Invalid transaction signature detected")
 		return false
 	}
-	// Calculate amount spent so far in this block
+	// Calculate amount spent so far in this block, including fees
+	id := TransactionHash(Transaction{Sender: senderKey, Recipient: recipientKey, Amount: amountFloat, Timestamp: timestamp})
 	var amountSpentInCurrentBlock float64
 	for _, transaction := range MiningTransactions {
-		if bytes.Equal(transaction.Sender.Y, senderKey.Y) {
-			amountSpentInCurrentBlock += transaction.Amount
+		if bytes.Equal(transaction.Sender.Y, senderKey.Y) && TransactionHash(transaction) != id {
+			amountSpentInCurrentBlock += transaction.Amount + transaction.Fee
 		}
 	}
-	amountSpentInCurrentBlock -= amountFloat
+	amountSpentInCurrentBlock += amountFloat + fee
 	if GetBalance(senderKey.Y) < amountSpentInCurrentBlock {
 		Warn("Double spending detected.")
 		return false
@@ -69,13 +98,22 @@ func VerifyMiner(miner PublicKey) bool {
 
 func VerifyTransactions(transactions []Transaction) bool {
 	for _, transaction := range transactions {
+		// The coinbase is checked by VerifyCoinbase
+		if IsCoinbase(transaction) {
+			continue
+		}
 		if transaction.FromSmartContract {
 			return true
 		}
-		if !VerifyTransaction(transaction.Sender, transaction.Recipient, strconv.FormatFloat(transaction.Amount, 'f', -1, 64), transaction.Timestamp, transaction.SenderSignature.S) {
+		if !VerifyTransactionWithFee(transaction.Sender, transaction.Recipient, strconv.FormatFloat(transaction.Amount, 'f', -1, 64), transaction.Timestamp, transaction.Fee, transaction.SenderSignature.S) {
 			Log("Block has invalid transaction/transaction signature. Ignoring block request.", true)
 			return false
 		}
+		// Bodies grow as they are relayed, so the minimum is taken at the body's original size, which every node agrees on
+		if transaction.Fee > 0 && transaction.Fee < MinimumFee(Transaction{Contracts: transaction.Contracts, Body: OriginalBody(transaction.Body)}) {
+			Log("Block has a transaction paying less than the minimum fee. Ignoring block request.", true)
+			return false
+		}
 	}
 	return true
 }
@@ -89,8 +127,6 @@ func DetectFork(block Block) bool {
 			Warn("Block creates a fork.")
 			Log("The node software is designed to handle this edge case, so operations can continue as normal.", false)
 			Log("This is most likely a result of latency between miners. If the issue persists, the network may be under attack or a bug may be present; please open an issue on the GitHub repository.", true)
-			Log("The blockchain will be re-synced to stay on the longest chain.", true)
-			SyncBlockchain(len(Blockchain) + BlocksUntilFinality) // Wait for finality when switching chains
 			return true
 		}
 	}
@@ -128,18 +164,22 @@ func VerifySmartContractTransactions(block Block) bool {
 	var fullTransition = StateTransition{
 		UpdatedData: make(map[string][]byte),
 	}
-	for _, contract := range smartContracts {
-		transactions, transition, gasUsed, err := contract.Execute()
-		if err != nil {
+	for i, result := range ExecuteContracts(smartContracts) {
+		if errors.Is(result.Err, ErrContractUnfinished) {
+			Warn("Block has a smart contract that did not finish: " + result.Err.Error() + ". Ignoring block request.")
+			return false
+		}
+		// A contract that fails fails on every node, so its transactions and state changes are left out
+		if result.Err != nil {
 			continue
 		}
-		smartContractCreatedTransactions = append(smartContractCreatedTransactions, transactions...)
-		if gasUsed != contract.GasUsed {
+		smartContractCreatedTransactions = append(smartContractCreatedTransactions, result.Transactions...)
+		if result.GasUsed != smartContracts[i].GasUsed {
 			Warn("Block has invalid smart contract gas usage. Ignoring block request.")
 			return false
 		}
 		// Add transition to fullTransition
-		for location, value := range transition.UpdatedData {
+		for location, value := range result.Transition.UpdatedData {
 			fullTransition.UpdatedData[location] = value
 		}
 	}
@@ -162,7 +202,21 @@ func VerifySmartContractTransactions(block Block) bool {
 	return true
 }
 
+// VerifyDifficulty checks a block's difficulty against the local blockchain, the same way the miner's template sets it.
+func VerifyDifficulty(block Block) bool {
+	if !verifyDifficultyOn(Blockchain, block) {
+		correctDifficulty := difficultyOn(Blockchain, block.Miner.Y)
+		Warn("Invalid difficulty detected.")
+		Log("The node software is designed to prevent difficulty manipulation, so this invalid difficulty will not cause issues for the network.", false)
+		Log(fmt.Sprintf("Expected difficulty: %d", correctDifficulty), true)
+		Log(fmt.Sprintf("Actual difficulty: %d", block.Difficulty), true)
+		return false
+	}
+	return true
+}
+
 func VerifyBlock(block Block) bool {
+	defer BlockValidationSeconds.ObserveSince(time.Now())
 	isValid := true
 	isValid = VerifyTransactions(block.Transactions) && isValid
 	hashBytes := HashBlock(block)
@@ -173,30 +227,17 @@ func VerifyBlock(block Block) bool {
 	if len(Blockchain) > 0 && block.PreviousBlockHash != HashBlock(Blockchain[len(Blockchain)-1]) {
 		Log("Block has invalid previous block hash. Ignoring block request.", true)
 		Log("The block could be on a different fork.", true)
-		Log("The blockchain will be re-synced to stay on the longest chain.", true)
-		SyncBlockchain(len(Blockchain) + BlocksUntilFinality) // Wait for finality when switching chains
 		isValid = false
 	}
 	isValid = VerifyMiner(block.Miner) && isValid
-	// Get the correct difficulty for the block
-	lastMinedBlock, found := GetLastMinedBlock()
-	if !found {
-		lastMinedBlock.Difficulty = InitialBlockDifficulty
-		lastMinedBlock.MiningTime = time.Minute
-	}
-	correctDifficulty := GetDifficulty(lastMinedBlock.MiningTime, lastMinedBlock.Difficulty)
-	if block.Difficulty != correctDifficulty || block.Difficulty < MinimumBlockDifficulty {
-		Warn("Invalid difficulty detected.")
-		Log("The node software is designed to prevent difficulty manipulation, so this invalid difficulty will not cause issues for the network.", false)
-		Log(fmt.Sprintf("Expected difficulty: %d", correctDifficulty), true)
-		Log(fmt.Sprintf("Actual difficulty: %d", block.Difficulty), true)
-		isValid = false
-	}
-	if block.Timestamp.After(time.Now()) {
-		Log("Block has invalid timestamp. Ignoring block request.", true)
-		Log("Timestamp is in the future.", true)
-		isValid = false
-	}
+	isValid = VerifyCoinbase(block, len(Blockchain)) && isValid
+	isValid = VerifyBlockLimits(block, len(Blockchain)) && isValid
+	isValid = VerifyMerkleRoot(block, len(Blockchain)) && isValid
+	isValid = VerifyBlockVersion(block, len(Blockchain)) && isValid
+	isValid = VerifyTransitionHash(block, len(Blockchain)) && isValid
+	isValid = verifyStateRootOn(block, len(Blockchain), stateTreeAfter(block.Transition)) && isValid
+	isValid = VerifyDifficulty(block) && isValid
+	isValid = verifyBlockTimeOn(Blockchain, block) && isValid
 	if !VerifyTimeVerifiers(block, block.TimeVerifiers, block.TimeVerifierSignatures, false) || !VerifyTimeVerifiers(block, block.PreMiningTimeVerifiers, block.PreMiningTimeVerifierSignatures, true) {
 		Log("Block has invalid time verifiers. Ignoring block request.", true)
 		isValid = false
@@ -208,7 +249,61 @@ func VerifyBlock(block Block) bool {
 	return isValid
 }
 
+// verifyBlockTimeOn checks the timestamp of a block added to chain: it may not be more than MaxFutureBlockTime ahead of the local clock, and from Nairobi it must be after the median time past of chain.
+func verifyBlockTimeOn(chain []Block, block Block) bool {
+	height := len(chain)
+	if block.Timestamp.After(Now().Add(MaxFutureBlockTime)) {
+		Log("Block has invalid timestamp. Ignoring block request.", true)
+		Log("Timestamp is in the future.", true)
+		return false
+	}
+	if Env.Upgrades.Nairobi <= height && height > 0 && !block.Timestamp.After(medianTimePastOn(chain, height)) {
+		Log("Block has invalid timestamp. Ignoring block request.", true)
+		Log("Timestamp is not after the median time of the previous blocks.", true)
+		return false
+	}
+	return true
+}
+
+// MedianTimePast returns the median timestamp of the MedianTimeSpan blocks before height.
+func MedianTimePast(height int) time.Time {
+	return medianTimePastOn(Blockchain, height)
+}
+
+func medianTimePastOn(chain []Block, height int) time.Time {
+	start := height - MedianTimeSpan
+	if start < 0 {
+		start = 0
+	}
+	var timestamps []time.Time
+	for _, block := range chain[start:height] {
+		timestamps = append(timestamps, block.Timestamp)
+	}
+	if len(timestamps) == 0 {
+		return time.Time{}
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+	return timestamps[len(timestamps)/2]
+}
+
+// NextBlockTimestamp returns the timestamp for a new block: the current time, moved past the median time past if the clock is behind it.
+func NextBlockTimestamp() time.Time {
+	now := Now()
+	medianTimePast := MedianTimePast(len(Blockchain))
+	if !now.After(medianTimePast) {
+		return medianTimePast.Add(time.Nanosecond)
+	}
+	return now
+}
+
 func VerifyTimeVerifiers(block Block, verifiers []PublicKey, signatures []Signature, premining bool) bool {
+	return verifyTimeVerifiersOn(Blockchain, block, verifiers, signatures, premining)
+}
+
+// verifyTimeVerifiersOn checks the time verifiers of a block added to chain.
+func verifyTimeVerifiersOn(chain []Block, block Block, verifiers []PublicKey, signatures []Signature, premining bool) bool {
 	if len(verifiers) != len(signatures) {
 		Log("Signature count does not match verifier count.", true)
 		return false
@@ -216,13 +311,17 @@ func VerifyTimeVerifiers(block Block, verifiers []PublicKey, signatures []Signat
 	oqsVerifier := oqs.Signature{}
 	sigName := "Dilithium3"
 	if err := oqsVerifier.Init(sigName, nil); err != nil {
-		Error("Failed to initialize Dilithium2 verifier", true)
+		Error("Failed to initialize Dilithium2 verifier", false)
+		return false
 	}
 	if premining {
 		for i, verifier := range verifiers {
-			valid, err := oqsVerifier.Verify([]byte(fmt.Sprintf("%d", block.Timestamp.UnixNano())), signatures[i].S, verifier.Y)
+			start := time.Now()
+			valid, err := oqsVerifier.Verify(TimeVerificationMessage(block.Version, block.Timestamp), signatures[i].S, verifier.Y)
+			SignatureVerificationSeconds.ObserveSince(start)
 			if err != nil {
-				panic(err)
+				Log("Malformed signature: "+err.Error(), true)
+				return false
 			}
 			if !valid {
 				Warn("Invalid time verifier signature detected")
@@ -231,9 +330,12 @@ func VerifyTimeVerifiers(block Block, verifiers []PublicKey, signatures []Signat
 		}
 	} else {
 		for i, verifier := range verifiers {
-			valid, err := oqsVerifier.Verify([]byte(fmt.Sprintf("%d", block.Timestamp.Add(block.MiningTime).UnixNano())), signatures[i].S, verifier.Y)
+			start := time.Now()
+			valid, err := oqsVerifier.Verify(TimeVerificationMessage(block.Version, block.Timestamp.Add(block.MiningTime)), signatures[i].S, verifier.Y)
+			SignatureVerificationSeconds.ObserveSince(start)
 			if err != nil {
-				panic(err)
+				Log("Malformed signature: "+err.Error(), true)
+				return false
 			}
 			if !valid {
 				Warn("Invalid time verifier signature detected")
@@ -252,13 +354,13 @@ func VerifyTimeVerifiers(block Block, verifiers []PublicKey, signatures []Signat
 	}
 	// Ensure all verifiers are miners
 	for _, verifier := range verifiers {
-		if IsNewMiner(verifier, len(Blockchain)+1) {
+		if isNewMinerOn(chain, verifier, len(chain)+1) {
 			Log("Time verifier is not a miner.", true)
 			return false
 		}
 	}
 	// Ensure there are enough verifiers
-	if len(verifiers) < GetMinVerifiers() {
+	if len(verifiers) < getMinVerifiersOn(chain) {
 		Log("Not enough time verifiers.", true)
 		return false
 	}
@@ -266,8 +368,12 @@ func VerifyTimeVerifiers(block Block, verifiers []PublicKey, signatures []Signat
 }
 
 func GetMinVerifiers() int {
+	return getMinVerifiersOn(Blockchain)
+}
+
+func getMinVerifiersOn(chain []Block) int {
 	// Get the last block
-	lastBlock := Blockchain[len(Blockchain)-1]
+	lastBlock := chain[len(chain)-1]
 	// Get the number of verifiers in the last block
 	lastVerifierCount := len(lastBlock.TimeVerifiers)
 	// Get the minimum number of verifiers
@@ -282,11 +388,15 @@ func VerifySmartContract(contract Contract) bool {
 		verifier := oqs.Signature{}
 		sigName := "Dilithium3"
 		if err := verifier.Init(sigName, nil); err != nil {
-			Error("Failed to initialize Dilithium2 verifier", true)
+			Error("Failed to initialize Dilithium2 verifier", false)
+			return false
 		}
+		start := time.Now()
 		isValid, err := verifier.Verify(hash[:], party.Signature.S, party.PublicKey.Y)
+		SignatureVerificationSeconds.ObserveSince(start)
 		if err != nil {
-			panic(err)
+			Log("Malformed signature: "+err.Error(), true)
+			return false
 		}
 		if !isValid {
 			Warn("Invalid smart contract signature detected.")
@@ -308,11 +418,15 @@ func VerifyAuthenticationProof(proof *AuthenticationProof, data []byte) bool {
 	verifier := oqs.Signature{}
 	sigName := "Dilithium3"
 	if err := verifier.Init(sigName, nil); err != nil {
-		Error("Failed to initialize Dilithium2 verifier", true)
+		Error("Failed to initialize Dilithium2 verifier", false)
+		return false
 	}
+	start := time.Now()
 	isValid, err := verifier.Verify(hash[:], proof.Signature.S, proof.PublicKey.Y)
+	SignatureVerificationSeconds.ObserveSince(start)
 	if err != nil {
-		panic(err)
+		Log("Malformed signature: "+err.Error(), true)
+		return false
 	}
 	if !isValid {
 		Warn("Invalid authentication proof signature detected.")
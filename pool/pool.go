@@ -0,0 +1,522 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package pool runs a mining pool on a node. Workers mine blocks paid to the pool's key and prove their work with shares, solutions to the same template at an easier difficulty. When a block matures, its reward is split between the workers in proportion to the shares they found for it, and balances are paid out in batches.
+package pool
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	. "cryptocurrency/node_util"
+	"cryptocurrency/rpc"
+)
+
+// TemplateRefresh is how long the pool hands out the same template before building one with the latest transactions.
+const TemplateRefresh = 10 * time.Second
+
+// HashrateWindow is the period over which worker hashrates are estimated from their shares.
+const HashrateWindow = 10 * time.Minute
+
+// EventBufferSize is how many block events the pool buffers while it processes rewards.
+const EventBufferSize = 256
+
+// PayoutExpiry is how many blocks a payout may go unmined before the pool gives up on it and returns the amount to the worker's balance.
+var PayoutExpiry = 100
+
+// StatePath is where the pool's ledger is stored, relative to DataDir.
+var StatePath = "pool.json"
+
+var ErrStaleShare = errors.New("stale share: a new block has been added since the work was issued")
+var ErrDuplicateShare = errors.New("duplicate share")
+var ErrLowDifficultyShare = errors.New("share does not meet the share target")
+
+var poolLog = NewLogger("pool")
+
+// Options configures a pool.
+type Options struct {
+	// ShareDifficulty is the difficulty of a share. It is capped at the block difficulty, so a share is never harder to find than a block.
+	ShareDifficulty uint64
+	// Fee is the fraction of each block reward the pool keeps.
+	Fee float64
+	// MinimumPayout is the smallest balance paid out to a worker.
+	MinimumPayout float64
+}
+
+// OptionsFromConfig returns the pool settings of c.
+func OptionsFromConfig(c Config) Options {
+	return Options{
+		ShareDifficulty: uint64(c.PoolShareDifficulty),
+		Fee:             c.PoolFee,
+		MinimumPayout:   c.PoolMinimumPayout,
+	}
+}
+
+// Work is a template handed out to a worker. Shares are nonces whose block hashes to at most ShareTarget; the block itself is found when the hash is at most Target. Each worker gets a different NonceStart so workers don't repeat each other's nonces.
+type Work struct {
+	rpc.BlockTemplateResult
+	ShareDifficulty uint64 `json:"shareDifficulty"`
+	ShareTarget     uint64 `json:"shareTarget"`
+	NonceStart      int64  `json:"nonceStart"`
+}
+
+// ShareResult tells a worker whether its share was accepted and whether it also solved the block.
+type ShareResult struct {
+	Accepted  bool   `json:"accepted"`
+	Block     bool   `json:"block"`
+	BlockHash string `json:"blockHash,omitempty"`
+}
+
+// WorkerAccount is a worker's share and payout accounting.
+type WorkerAccount struct {
+	Shares        uint64    `json:"shares"`
+	StaleShares   uint64    `json:"staleShares"`
+	InvalidShares uint64    `json:"invalidShares"`
+	Balance       float64   `json:"balance"`
+	Paid          float64   `json:"paid"`
+	LastShare     time.Time `json:"lastShare"`
+}
+
+// FoundBlock is a block found by the pool whose reward has not been credited yet. Shares holds the difficulty-weighted shares of the round that found it.
+type FoundBlock struct {
+	Height int                `json:"height"`
+	Hash   string             `json:"hash"`
+	Finder string             `json:"finder"`
+	Shares map[string]float64 `json:"shares"`
+}
+
+// Payout is a payout transaction that has not been mined yet. Height is the length of the blockchain when it was sent.
+type Payout struct {
+	Id     string  `json:"id"`
+	Worker string  `json:"worker"`
+	Amount float64 `json:"amount"`
+	Height int     `json:"height"`
+}
+
+// ledger is the state of the pool that is saved to StatePath. Workers are identified by their base64-encoded public key.
+type ledger struct {
+	Round          map[string]float64        `json:"round"`
+	Blocks         []FoundBlock              `json:"blocks"`
+	Payouts        []Payout                  `json:"payouts"`
+	Expired        []Payout                  `json:"expired"`
+	Workers        map[string]*WorkerAccount `json:"workers"`
+	BlocksFound    int                       `json:"blocksFound"`
+	BlocksOrphaned int                       `json:"blocksOrphaned"`
+	FeesKept       float64                   `json:"feesKept"`
+}
+
+type share struct {
+	worker     string
+	difficulty uint64
+	time       time.Time
+}
+
+// Pool hands out work, validates shares and pays workers. Its methods must be called with the node's state current, e.g. from the node's HTTP handlers.
+type Pool struct {
+	Key     PrivateKey
+	Options Options
+
+	mutex  sync.Mutex
+	path   string
+	ledger ledger
+	// current is the ID of the job handed out to new workers.
+	current string
+	jobs    map[string]BlockTemplate
+	order   []string
+	// seen holds the block hashes of the shares accepted on the current tip.
+	seen   map[[64]byte]bool
+	recent []share
+}
+
+// New creates a pool that mines with key and loads its ledger from StatePath in the data directory.
+func New(key PrivateKey, options Options) (*Pool, error) {
+	p := &Pool{
+		Key:     key,
+		Options: options,
+		path:    DataPath(StatePath),
+		ledger: ledger{
+			Round:   make(map[string]float64),
+			Workers: make(map[string]*WorkerAccount),
+		},
+		jobs: make(map[string]BlockTemplate),
+		seen: make(map[[64]byte]bool),
+	}
+	ledgerJson, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(ledgerJson, &p.ledger); err != nil {
+		return nil, err
+	}
+	if p.ledger.Round == nil {
+		p.ledger.Round = make(map[string]float64)
+	}
+	if p.ledger.Workers == nil {
+		p.ledger.Workers = make(map[string]*WorkerAccount)
+	}
+	return p, nil
+}
+
+// save must be called with p.mutex held.
+func (p *Pool) save() {
+	ledgerJson, err := json.Marshal(p.ledger)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(p.path, ledgerJson, 0644); err != nil {
+		poolLog.Warn("Failed to save the pool ledger.", Fields{"error": err.Error()})
+	}
+}
+
+// account must be called with p.mutex held.
+func (p *Pool) account(worker string) *WorkerAccount {
+	account, ok := p.ledger.Workers[worker]
+	if !ok {
+		account = &WorkerAccount{}
+		p.ledger.Workers[worker] = account
+	}
+	return account
+}
+
+// shareDifficulty returns the share difficulty for template.
+func (p *Pool) shareDifficulty(template BlockTemplate) uint64 {
+	if p.Options.ShareDifficulty < template.Difficulty {
+		return p.Options.ShareDifficulty
+	}
+	return template.Difficulty
+}
+
+// isStale reports whether template no longer builds on the tip of the blockchain.
+func isStale(template BlockTemplate) bool {
+	ChainMutex.Lock()
+	defer ChainMutex.Unlock()
+	return len(Blockchain) != template.Height || (len(Blockchain) > 0 && HashBlock(Blockchain[len(Blockchain)-1]) != template.PreviousBlockHash)
+}
+
+// GetWork returns work for worker. The template is shared by all workers until the chain changes or TemplateRefresh passes. It returns ErrPoolDry if there are no transactions to mine.
+func (p *Pool) GetWork(worker PublicKey) (Work, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	template, ok := p.jobs[p.current]
+	if !ok || isStale(template) || Since(template.Created) >= TemplateRefresh {
+		var id string
+		var err error
+		id, template, err = IssueBlockTemplate(p.Key.PublicKey)
+		if err != nil {
+			return Work{}, err
+		}
+		if ok && template.PreviousBlockHash != p.jobs[p.current].PreviousBlockHash {
+			p.seen = make(map[[64]byte]bool)
+		}
+		p.current = id
+		p.jobs[id] = template
+		p.order = append(p.order, id)
+		if len(p.order) > MaxIssuedTemplates {
+			delete(p.jobs, p.order[0])
+			p.order = p.order[1:]
+		}
+	}
+	difficulty := p.shareDifficulty(template)
+	return Work{
+		BlockTemplateResult: rpc.NewBlockTemplateResult(p.current, template),
+		ShareDifficulty:     difficulty,
+		ShareTarget:         MaximumUint64 / difficulty,
+		// Leave room for the worker to count up without overflowing
+		NonceStart: rand.Int63() >> 1,
+	}, nil
+}
+
+// SubmitShare validates a share found by worker for the job with the given ID and credits it to the current round. If the share also solves the block, the block is submitted and the round ends. The block is submitted without holding the pool's mutex, since it is broadcast to peers.
+func (p *Pool) SubmitShare(worker PublicKey, id string, nonce int64) (ShareResult, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	name := base64.StdEncoding.EncodeToString(worker.Y)
+	template, ok := p.jobs[id]
+	if !ok {
+		return ShareResult{}, ErrUnknownTemplate
+	}
+	account := p.account(name)
+	if isStale(template) {
+		account.StaleShares++
+		return ShareResult{}, ErrStaleShare
+	}
+	hash := HashBlock(template.Block(nonce))
+	if p.seen[hash] {
+		account.InvalidShares++
+		return ShareResult{}, ErrDuplicateShare
+	}
+	difficulty := p.shareDifficulty(template)
+	if binary.BigEndian.Uint64(hash[:]) > MaximumUint64/difficulty {
+		account.InvalidShares++
+		return ShareResult{}, ErrLowDifficultyShare
+	}
+	p.seen[hash] = true
+	now := Now()
+	account.Shares++
+	account.LastShare = now
+	p.ledger.Round[name] += float64(difficulty)
+	p.recent = append(p.recent, share{worker: name, difficulty: difficulty, time: now})
+	p.trimRecent(now)
+	result := ShareResult{Accepted: true}
+	if binary.BigEndian.Uint64(hash[:]) <= template.Target() {
+		// Shares found while the block is submitted count toward the next round
+		round := p.ledger.Round
+		p.ledger.Round = make(map[string]float64)
+		p.mutex.Unlock()
+		_, err := SubmitBlock(id, nonce)
+		p.mutex.Lock()
+		if err != nil {
+			poolLog.Warn("Share solved the block, but the block could not be added.", Fields{"error": err.Error()})
+			for worker, shares := range round {
+				p.ledger.Round[worker] += shares
+			}
+		} else {
+			result.Block = true
+			result.BlockHash = hex.EncodeToString(hash[:])
+			p.ledger.Blocks = append(p.ledger.Blocks, FoundBlock{
+				Height: template.Height,
+				Hash:   result.BlockHash,
+				Finder: name,
+				Shares: round,
+			})
+			p.ledger.BlocksFound++
+			poolLog.Info("Pool found a block.", Fields{"height": template.Height, "block": result.BlockHash[:16], "worker": name[:16]})
+		}
+	}
+	p.save()
+	return result, nil
+}
+
+// trimRecent drops the shares found before the hashrate window ending at now. It must be called with p.mutex held.
+func (p *Pool) trimRecent(now time.Time) {
+	since := now.Add(-HashrateWindow)
+	for len(p.recent) > 0 && p.recent[0].time.Before(since) {
+		p.recent = p.recent[1:]
+	}
+}
+
+// Process credits the rewards of blocks that have matured, returns the shares of orphaned blocks to the current round, confirms mined payouts, expires payouts that went unmined for PayoutExpiry blocks and sends a batch of payouts to workers whose balance has reached the minimum.
+func (p *Pool) Process() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ChainMutex.Lock()
+	height := len(Blockchain)
+	var pending []FoundBlock
+	for _, block := range p.ledger.Blocks {
+		if block.Height >= height {
+			pending = append(pending, block)
+			continue
+		}
+		hash := HashBlock(Blockchain[block.Height])
+		if hex.EncodeToString(hash[:]) != block.Hash {
+			poolLog.Info("Pool block was orphaned. Its shares count toward the next block.", Fields{"height": block.Height})
+			for worker, shares := range block.Shares {
+				p.ledger.Round[worker] += shares
+			}
+			p.ledger.BlocksOrphaned++
+			continue
+		}
+		if height-block.Height <= BlocksBeforeReward {
+			pending = append(pending, block)
+			continue
+		}
+		p.credit(block)
+	}
+	p.ledger.Blocks = pending
+	var unconfirmed []Payout
+	for _, payout := range p.ledger.Payouts {
+		if _, ok := GetTransactionInfo(payout.Id); ok {
+			p.account(payout.Worker).Paid += payout.Amount
+			continue
+		}
+		if payout.Height == 0 {
+			// Payouts saved before they had a height get a full PayoutExpiry from now
+			payout.Height = height
+		}
+		if height-payout.Height > PayoutExpiry {
+			poolLog.Warn("Pool payout was not mined. Its amount is returned to the worker's balance.", Fields{"payout": payout.Id, "worker": payout.Worker[:16]})
+			p.account(payout.Worker).Balance += payout.Amount
+			p.ledger.Expired = append(p.ledger.Expired, payout)
+			continue
+		}
+		unconfirmed = append(unconfirmed, payout)
+	}
+	p.ledger.Payouts = unconfirmed
+	// An expired payout can still be mined later, e.g. from another node's mempool. It is then taken back out of the worker's balance.
+	var expired []Payout
+	for _, payout := range p.ledger.Expired {
+		if _, ok := GetTransactionInfo(payout.Id); ok {
+			account := p.account(payout.Worker)
+			account.Balance -= payout.Amount
+			account.Paid += payout.Amount
+			continue
+		}
+		if height-payout.Height <= 2*PayoutExpiry {
+			expired = append(expired, payout)
+		}
+	}
+	p.ledger.Expired = expired
+	available := GetBalance(p.Key.PublicKey.Y)
+	version := BlockVersionAt(height)
+	ChainMutex.Unlock()
+	p.pay(available, height, version)
+	p.save()
+}
+
+// credit splits a matured block's reward between the workers of its round. It must be called with p.mutex held.
+func (p *Pool) credit(block FoundBlock) {
+	reward := BlockMiningReward(block.Height)
+	fee := reward * p.Options.Fee
+	total := 0.0
+	for _, shares := range block.Shares {
+		total += shares
+	}
+	if total == 0 {
+		p.ledger.FeesKept += reward
+		return
+	}
+	p.ledger.FeesKept += fee
+	for worker, shares := range block.Shares {
+		p.account(worker).Balance += (reward - fee) * shares / total
+	}
+	poolLog.Info("Pool block reward credited.", Fields{"height": block.Height, "reward": reward, "workers": len(block.Shares)})
+}
+
+// pay sends one payout transaction to every worker whose balance has reached the minimum, as long as available, the pool's balance at height, covers them. It must be called with p.mutex held, but not ChainMutex, since submitting a transaction takes it.
+func (p *Pool) pay(available float64, height int, version int) {
+	for _, payout := range p.ledger.Payouts {
+		available -= payout.Amount + TransactionFee
+	}
+	workers := make([]string, 0, len(p.ledger.Workers))
+	for worker, account := range p.ledger.Workers {
+		if account.Balance >= p.Options.MinimumPayout {
+			workers = append(workers, worker)
+		}
+	}
+	sort.Strings(workers)
+	var batch []Payout
+	for _, worker := range workers {
+		account := p.ledger.Workers[worker]
+		if account.Balance+TransactionFee > available {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(worker)
+		if err != nil {
+			continue
+		}
+		params, err := rpc.SignTransaction(p.Key, PublicKey{Y: key}, account.Balance, 0, nil, version)
+		if err != nil {
+			poolLog.Warn("Failed to sign payout.", Fields{"error": err.Error()})
+			continue
+		}
+		id, err := rpc.SubmitTransaction(params)
+		if err != nil {
+			poolLog.Warn("Failed to submit payout.", Fields{"error": err.Error()})
+			continue
+		}
+		batch = append(batch, Payout{Id: id, Worker: worker, Amount: account.Balance, Height: height})
+		available -= account.Balance + TransactionFee
+		account.Balance = 0
+	}
+	if len(batch) > 0 {
+		p.ledger.Payouts = append(p.ledger.Payouts, batch...)
+		poolLog.Info("Pool payouts sent.", Fields{"payouts": len(batch)})
+	}
+}
+
+// Run processes rewards and payouts whenever a block is appended, until ctx is cancelled.
+func (p *Pool) Run(ctx context.Context) {
+	subscription := Subscribe(EventBufferSize)
+	defer subscription.Unsubscribe()
+	p.Process()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-subscription.Events:
+			if !ok {
+				subscription = Subscribe(EventBufferSize)
+				p.Process()
+				continue
+			}
+			if event.Type == BlockEvent {
+				p.Process()
+			}
+		}
+	}
+}
+
+// WorkerStats is a worker's accounting and estimated hashrate.
+type WorkerStats struct {
+	WorkerAccount
+	RoundShares float64 `json:"roundShares"`
+	Hashrate    float64 `json:"hashrate"`
+}
+
+// Stats describes the pool.
+type Stats struct {
+	PublicKey       []byte                 `json:"publicKey"`
+	ShareDifficulty uint64                 `json:"shareDifficulty"`
+	Fee             float64                `json:"fee"`
+	MinimumPayout   float64                `json:"minimumPayout"`
+	Hashrate        float64                `json:"hashrate"`
+	RoundShares     float64                `json:"roundShares"`
+	BlocksFound     int                    `json:"blocksFound"`
+	BlocksOrphaned  int                    `json:"blocksOrphaned"`
+	BlocksImmature  int                    `json:"blocksImmature"`
+	PendingPayouts  int                    `json:"pendingPayouts"`
+	FeesKept        float64                `json:"feesKept"`
+	Workers         map[string]WorkerStats `json:"workers"`
+}
+
+// Stats returns the pool's current statistics. Hashrates are estimated from the shares found in the last HashrateWindow.
+func (p *Pool) Stats() Stats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := Stats{
+		PublicKey:       p.Key.PublicKey.Y,
+		ShareDifficulty: p.Options.ShareDifficulty,
+		Fee:             p.Options.Fee,
+		MinimumPayout:   p.Options.MinimumPayout,
+		BlocksFound:     p.ledger.BlocksFound,
+		BlocksOrphaned:  p.ledger.BlocksOrphaned,
+		BlocksImmature:  len(p.ledger.Blocks),
+		PendingPayouts:  len(p.ledger.Payouts),
+		FeesKept:        p.ledger.FeesKept,
+		Workers:         make(map[string]WorkerStats),
+	}
+	for worker, account := range p.ledger.Workers {
+		stats.Workers[worker] = WorkerStats{WorkerAccount: *account, RoundShares: p.ledger.Round[worker]}
+	}
+	for _, shares := range p.ledger.Round {
+		stats.RoundShares += shares
+	}
+	p.trimRecent(Now())
+	for _, share := range p.recent {
+		// A share of difficulty d takes d hashes on average
+		hashrate := float64(share.difficulty) / HashrateWindow.Seconds()
+		worker := stats.Workers[share.worker]
+		worker.Hashrate += hashrate
+		stats.Workers[share.worker] = worker
+		stats.Hashrate += hashrate
+	}
+	return stats
+}
@@ -0,0 +1,162 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package pool
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	. "cryptocurrency/node_util"
+)
+
+// ShareParams is the body of a /pool/share request.
+type ShareParams struct {
+	Worker []byte `json:"worker"`
+	Id     string `json:"id"`
+	Nonce  int64  `json:"nonce"`
+}
+
+// Handler serves the pool's endpoints: /pool/work, /pool/share and /pool/stats.
+func (p *Pool) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pool/work", p.HandleWorkRequest)
+	mux.HandleFunc("/pool/share", p.HandleShareRequest)
+	mux.HandleFunc("/pool/stats", p.HandleStatsRequest)
+	return mux
+}
+
+func (p *Pool) HandleWorkRequest(w http.ResponseWriter, req *http.Request) {
+	// "+" becomes a space when the key isn't URL-escaped
+	worker, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(req.URL.Query().Get("worker"), " ", "+"))
+	if err != nil || len(worker) == 0 {
+		http.Error(w, "invalid or missing worker", http.StatusBadRequest)
+		return
+	}
+	work, err := p.GetWork(PublicKey{Y: worker})
+	if errors.Is(err, ErrPoolDry) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, work)
+}
+
+func (p *Pool) HandleShareRequest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "shares must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	var params ShareParams
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		http.Error(w, "invalid share: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(params.Worker) == 0 {
+		http.Error(w, "missing worker", http.StatusBadRequest)
+		return
+	}
+	result, err := p.SubmitShare(PublicKey{Y: params.Worker}, params.Id, params.Nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, result)
+}
+
+func (p *Pool) HandleStatsRequest(w http.ResponseWriter, req *http.Request) {
+	writeJson(w, p.Stats())
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(bytes); err != nil {
+		Log("Failed to write response.", true)
+	}
+}
+
+// Client talks to a pool's endpoints.
+type Client struct {
+	Url        string
+	HttpClient *http.Client
+}
+
+// NewClient creates a client for the pool served by the node at url, e.g. "http://localhost:8080".
+func NewClient(url string) *Client {
+	return &Client{Url: url, HttpClient: http.DefaultClient}
+}
+
+// do sends req and decodes the JSON response into result. Rejections are returned as errors with the pool's message.
+func (c *Client) do(req *http.Request, result interface{}) error {
+	res, err := c.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.New(strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, result)
+}
+
+// GetWork fetches work for worker.
+func (c *Client) GetWork(worker []byte) (Work, error) {
+	var work Work
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/pool/work?worker=%s", c.Url, url.QueryEscape(base64.StdEncoding.EncodeToString(worker))), nil)
+	if err != nil {
+		return work, err
+	}
+	err = c.do(req, &work)
+	return work, err
+}
+
+// SubmitShare submits a nonce found by worker for the work with the given ID.
+func (c *Client) SubmitShare(worker []byte, id string, nonce int64) (ShareResult, error) {
+	var result ShareResult
+	body, err := json.Marshal(ShareParams{Worker: worker, Id: id, Nonce: nonce})
+	if err != nil {
+		return result, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.Url+"/pool/share", bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = c.do(req, &result)
+	return result, err
+}
+
+// GetStats fetches the pool's statistics.
+func (c *Client) GetStats() (Stats, error) {
+	var stats Stats
+	req, err := http.NewRequest(http.MethodGet, c.Url+"/pool/stats", nil)
+	if err != nil {
+		return stats, err
+	}
+	err = c.do(req, &stats)
+	return stats, err
+}
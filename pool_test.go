@@ -0,0 +1,265 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	"cryptocurrency/pool"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPool starts a pool on node with a pending transaction to mine. Blocks have difficulty 16 so that most shares don't solve them.
+func newTestPool(t *testing.T, h *harness.Harness, node *harness.Node, options pool.Options) (*pool.Pool, *pool.Client) {
+	t.Helper()
+	CurrentNetwork.FixedDifficulty = 16
+	h.Mine(node, 2)
+	_, err := h.Send(node, h.Nodes[len(h.Nodes)-1], 0.5)
+	assert.Nil(t, err)
+	var p *pool.Pool
+	node.Run(func() {
+		p, err = pool.New(node.Key, options)
+	})
+	assert.Nil(t, err)
+	node.Handle("/pool/", p.Handler())
+	return p, pool.NewClient(node.Url)
+}
+
+// findShare returns a nonce from the work's range whose block meets target. If block is false, nonces that also solve the block are skipped.
+func findShare(t *testing.T, work pool.Work, target uint64, start int64, block bool) int64 {
+	t.Helper()
+	template, err := work.Template()
+	assert.Nil(t, err)
+	engine := NewMiningEngine(1)
+	for {
+		nonce, err := engine.Search(context.Background(), template, target, start)
+		assert.Nil(t, err)
+		if block || !template.Solves(nonce) {
+			return nonce
+		}
+		start = nonce + 1
+	}
+}
+
+func TestPool(t *testing.T) {
+	t.Run("It splits block rewards in proportion to shares and pays them out", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		node := h.Nodes[0]
+		p, client := newTestPool(t, h, node, pool.Options{ShareDifficulty: 2, Fee: 0.1, MinimumPayout: 0.001})
+		alice, err := GenerateKey()
+		assert.Nil(t, err)
+		bob, err := GenerateKey()
+		assert.Nil(t, err)
+		aliceWork, err := client.GetWork(alice.PublicKey.Y)
+		assert.Nil(t, err)
+		bobWork, err := client.GetWork(bob.PublicKey.Y)
+		assert.Nil(t, err)
+		assert.Equal(t, aliceWork.Id, bobWork.Id)
+		nonce := aliceWork.NonceStart
+		for i := 0; i < 3; i++ {
+			nonce = findShare(t, aliceWork, aliceWork.ShareTarget, nonce, false)
+			result, err := client.SubmitShare(alice.PublicKey.Y, aliceWork.Id, nonce)
+			assert.Nil(t, err)
+			assert.True(t, result.Accepted)
+			assert.False(t, result.Block)
+			nonce++
+		}
+		share := findShare(t, bobWork, bobWork.ShareTarget, bobWork.NonceStart, false)
+		_, err = client.SubmitShare(bob.PublicKey.Y, bobWork.Id, share)
+		assert.Nil(t, err)
+		solution := findShare(t, bobWork, bobWork.Target, share+1, true)
+		// Act
+		result, err := client.SubmitShare(bob.PublicKey.Y, bobWork.Id, solution)
+		node.Run(p.Process)
+		// Assert
+		assert.Nil(t, err)
+		assert.True(t, result.Block)
+		h.AssertConverged()
+		var reward float64
+		node.Run(func() {
+			reward = BlockMiningReward(3)
+		})
+		aliceName := base64.StdEncoding.EncodeToString(alice.PublicKey.Y)
+		bobName := base64.StdEncoding.EncodeToString(bob.PublicKey.Y)
+		stats, err := client.GetStats()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, stats.BlocksFound)
+		assert.Equal(t, 2, stats.PendingPayouts)
+		assert.Equal(t, uint64(3), stats.Workers[aliceName].Shares)
+		assert.Equal(t, uint64(2), stats.Workers[bobName].Shares)
+		assert.InDelta(t, reward*0.1, stats.FeesKept, 1e-6)
+		assert.Greater(t, stats.Hashrate, 0.0)
+		// The payouts are mined in the pool's next block
+		h.Mine(node, 1)
+		node.Run(p.Process)
+		stats, err = client.GetStats()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, stats.PendingPayouts)
+		assert.InDelta(t, reward*0.9*6/10, stats.Workers[aliceName].Paid, 1e-6)
+		assert.InDelta(t, reward*0.9*4/10, stats.Workers[bobName].Paid, 1e-6)
+		node.Run(func() {
+			assert.InDelta(t, reward*0.9*6/10, GetBalance(alice.PublicKey.Y), 1e-6)
+			assert.InDelta(t, reward*0.9*4/10, GetBalance(bob.PublicKey.Y), 1e-6)
+		})
+	})
+	t.Run("It rejects stale, duplicate and low difficulty shares", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		node := h.Nodes[0]
+		_, client := newTestPool(t, h, node, pool.Options{ShareDifficulty: 2, Fee: 0.01, MinimumPayout: 1})
+		worker := node.Key.PublicKey.Y
+		work, err := client.GetWork(worker)
+		assert.Nil(t, err)
+		share := findShare(t, work, work.ShareTarget, work.NonceStart, false)
+		_, err = client.SubmitShare(worker, work.Id, share)
+		assert.Nil(t, err)
+		template, err := work.Template()
+		assert.Nil(t, err)
+		low := work.NonceStart
+		for {
+			hash := HashBlock(template.Block(low))
+			if binary.BigEndian.Uint64(hash[:]) > work.ShareTarget {
+				break
+			}
+			low++
+		}
+		// Act
+		_, duplicateErr := client.SubmitShare(worker, work.Id, share)
+		_, lowErr := client.SubmitShare(worker, work.Id, low)
+		h.Mine(node, 1)
+		_, staleErr := client.SubmitShare(worker, work.Id, share+1)
+		// Assert
+		assert.EqualError(t, duplicateErr, pool.ErrDuplicateShare.Error())
+		assert.EqualError(t, lowErr, pool.ErrLowDifficultyShare.Error())
+		assert.EqualError(t, staleErr, pool.ErrStaleShare.Error())
+		stats, err := client.GetStats()
+		assert.Nil(t, err)
+		name := base64.StdEncoding.EncodeToString(worker)
+		assert.Equal(t, uint64(1), stats.Workers[name].Shares)
+		assert.Equal(t, uint64(2), stats.Workers[name].InvalidShares)
+		assert.Equal(t, uint64(1), stats.Workers[name].StaleShares)
+	})
+	t.Run("It returns the shares of orphaned blocks to the next round", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		node := h.Nodes[0]
+		p, client := newTestPool(t, h, node, pool.Options{ShareDifficulty: 16, Fee: 0.01, MinimumPayout: 1})
+		worker := h.Nodes[1].Key.PublicKey.Y
+		work, err := client.GetWork(worker)
+		assert.Nil(t, err)
+		h.Partition(h.Nodes[:1], h.Nodes[1:])
+		result, err := client.SubmitShare(worker, work.Id, findShare(t, work, work.Target, work.NonceStart, true))
+		assert.Nil(t, err)
+		assert.True(t, result.Block)
+		h.Mine(h.Nodes[1], 2)
+		h.Heal()
+		h.Sync()
+		// Act
+		node.Run(p.Process)
+		// Assert
+		h.AssertConverged()
+		stats, err := client.GetStats()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, stats.BlocksOrphaned)
+		assert.Equal(t, 0, stats.BlocksImmature)
+		assert.Equal(t, 16.0, stats.RoundShares)
+		assert.Equal(t, 0.0, stats.FeesKept)
+	})
+	t.Run("It keeps its ledger across restarts", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		node := h.Nodes[0]
+		options := pool.Options{ShareDifficulty: 2, Fee: 0.01, MinimumPayout: 1}
+		_, client := newTestPool(t, h, node, options)
+		work, err := client.GetWork(node.Key.PublicKey.Y)
+		assert.Nil(t, err)
+		_, err = client.SubmitShare(node.Key.PublicKey.Y, work.Id, findShare(t, work, work.ShareTarget, work.NonceStart, false))
+		assert.Nil(t, err)
+		// Act
+		var restarted *pool.Pool
+		node.Run(func() {
+			restarted, err = pool.New(node.Key, options)
+		})
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, 2.0, restarted.Stats().RoundShares)
+	})
+	t.Run("It never makes shares harder than blocks", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		_, client := newTestPool(t, h, h.Nodes[0], pool.Options{ShareDifficulty: 1000, Fee: 0.01, MinimumPayout: 1})
+		// Act
+		work, err := client.GetWork(h.Nodes[0].Key.PublicKey.Y)
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(16), work.ShareDifficulty)
+		assert.Equal(t, work.Target, work.ShareTarget)
+	})
+	t.Run("It hands out no work when there is nothing to mine", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		var p *pool.Pool
+		var err error
+		h.Nodes[0].Run(func() {
+			p, err = pool.New(h.Nodes[0].Key, pool.Options{ShareDifficulty: 2, Fee: 0.01, MinimumPayout: 1})
+		})
+		assert.Nil(t, err)
+		h.Nodes[0].Handle("/pool/", p.Handler())
+		// Act
+		_, err = pool.NewClient(h.Nodes[0].Url).GetWork(h.Nodes[0].Key.PublicKey.Y)
+		// Assert
+		assert.EqualError(t, err, ErrPoolDry.Error())
+	})
+	t.Run("It returns payouts that are never mined to the worker's balance", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 1)
+		node := h.Nodes[0]
+		p, client := newTestPool(t, h, node, pool.Options{ShareDifficulty: 16, Fee: 0, MinimumPayout: 0.001})
+		expiry := pool.PayoutExpiry
+		pool.PayoutExpiry = 2
+		defer func() {
+			pool.PayoutExpiry = expiry
+		}()
+		worker := h.Nodes[0].Key.PublicKey.Y
+		name := base64.StdEncoding.EncodeToString(worker)
+		work, err := client.GetWork(worker)
+		assert.Nil(t, err)
+		result, err := client.SubmitShare(worker, work.Id, findShare(t, work, work.Target, work.NonceStart, true))
+		assert.Nil(t, err)
+		assert.True(t, result.Block)
+		node.Run(p.Process)
+		stats, err := client.GetStats()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, stats.PendingPayouts)
+		var reward float64
+		node.Run(func() {
+			reward = BlockMiningReward(3)
+			// The payout is lost, e.g. because the node restarted
+			MiningTransactions = nil
+		})
+		p.Options.MinimumPayout = 1e12
+		// Act
+		h.Mine(node, 3)
+		node.Run(p.Process)
+		// Assert
+		stats, err = client.GetStats()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, stats.PendingPayouts)
+		assert.InDelta(t, reward, stats.Workers[name].Balance, 1e-6)
+		assert.Equal(t, 0.0, stats.Workers[name].Paid)
+	})
+}
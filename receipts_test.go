@@ -0,0 +1,150 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetReceipt(t *testing.T) {
+	ReceiptsPath = filepath.Join(t.TempDir(), "receipts.json")
+	t.Run("It returns unknown for transactions that were never seen", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		// Act
+		receipt := GetReceipt("00")
+		// Assert
+		assert.Equal(t, UnknownStatus, receipt.Status)
+	})
+	t.Run("It tracks confirmations until the transaction is final", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		transaction := Transaction{
+			Sender:    PublicKey{Y: []byte("321")},
+			Recipient: PublicKey{Y: []byte("123")},
+			Amount:    1,
+		}
+		RecordPendingReceipt(transaction, nil, StateTransition{})
+		id := TransactionId(transaction)
+		assert.Equal(t, PendingStatus, GetReceipt(id).Status)
+		// Act
+		Append(Block{Transactions: []Transaction{transaction}})
+		mined := GetReceipt(id)
+		for i := 1; i < BlocksUntilFinality; i++ {
+			Append(Block{BlockHeader: BlockHeader{Difficulty: uint64(i)}})
+		}
+		finalized := GetReceipt(id)
+		// Assert
+		assert.Equal(t, MinedStatus, mined.Status)
+		assert.Equal(t, 1, mined.BlockHeight)
+		assert.Equal(t, 1, mined.Confirmations)
+		assert.Equal(t, FinalizedStatus, finalized.Status)
+		assert.Equal(t, BlocksUntilFinality, finalized.Confirmations)
+	})
+	t.Run("It persists receipts", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		transaction := Transaction{
+			Sender:    PublicKey{Y: []byte("321")},
+			Recipient: PublicKey{Y: []byte("123")},
+			Amount:    2,
+		}
+		RecordPendingReceipt(transaction, nil, StateTransition{})
+		// Act
+		LoadReceipts()
+		receipt := GetReceipt(TransactionId(transaction))
+		// Assert
+		assert.Equal(t, PendingStatus, receipt.Status)
+	})
+	t.Run("It appends receipts to the file instead of rewriting it", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		path := filepath.Join(t.TempDir(), "receipts.json")
+		ReceiptsPath = path
+		first := Transaction{Sender: PublicKey{Y: []byte("321")}, Recipient: PublicKey{Y: []byte("123")}, Amount: 3}
+		second := Transaction{Sender: PublicKey{Y: []byte("321")}, Recipient: PublicKey{Y: []byte("123")}, Amount: 4}
+		// Act
+		RecordPendingReceipt(first, nil, StateTransition{})
+		RecordPendingReceipt(second, nil, StateTransition{})
+		contents, err := os.ReadFile(path)
+		// Assert
+		assert.Nil(t, err)
+		lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+		assert.Len(t, lines, 2)
+		assert.Contains(t, lines[0], TransactionId(first))
+		assert.Contains(t, lines[1], TransactionId(second))
+	})
+}
+
+func TestRequestReceipt(t *testing.T) {
+	t.Run("It doesn't believe a peer that claims a transaction is finalized", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		h.Intercept = func(from *harness.Node, to *harness.Node, req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/tx/status" {
+				return nil, nil
+			}
+			body := `{"id":"` + req.URL.Query().Get("id") + `","status":"finalized","blockHeight":1,"confirmations":10}`
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body)), Request: req}, nil
+		}
+		var receipt Receipt
+		// Act
+		h.Nodes[0].Run(func() {
+			receipt = RequestReceipt(strings.Repeat("ab", 32))
+		})
+		// Assert
+		assert.Equal(t, UnknownStatus, receipt.Status)
+		assert.Equal(t, 0, receipt.Confirmations)
+	})
+	t.Run("It syncs at most once while waiting on a peer that claims a transaction was mined", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		h.ConnectAll()
+		syncs := 0
+		h.Intercept = func(from *harness.Node, to *harness.Node, req *http.Request) (*http.Response, error) {
+			switch req.URL.Path {
+			case "/blockchain":
+				syncs++
+			case "/tx/status":
+				body := `{"id":"` + req.URL.Query().Get("id") + `","status":"mined","blockHeight":1,"confirmations":1}`
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body)), Request: req}, nil
+			}
+			return nil, nil
+		}
+		interval := ReceiptPollInterval
+		ReceiptPollInterval = time.Millisecond
+		defer func() {
+			ReceiptPollInterval = interval
+		}()
+		var err error
+		// Act
+		h.Nodes[0].Run(func() {
+			_, err = WaitForConfirmations(strings.Repeat("ab", 32), 1, 20*time.Millisecond)
+		})
+		// Assert
+		assert.NotNil(t, err)
+		assert.Equal(t, 1, syncs)
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "cryptocurrency/node_util"
+	. "cryptocurrency/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// useRegtest switches to the regtest network with an empty data directory, so generated blocks are not broadcast to real peers.
+func useRegtest(t *testing.T) PublicKey {
+	key := GetKey("").PublicKey
+	env, dataDir := Env, DataDir
+	DataDir = t.TempDir()
+	err := os.WriteFile(filepath.Join(DataDir, "peers.txt"), nil, 0600)
+	assert.Nil(t, err)
+	err = ApplyNetworkProfile("regtest")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = ApplyNetworkProfile(DefaultNetwork)
+		Env, DataDir = env, dataDir
+	})
+	Blockchain = nil
+	Append(GenesisBlock())
+	return key
+}
+
+func TestGenerateBlocks(t *testing.T) {
+	t.Run("It mines valid blocks to the given key", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		// Act
+		blocks, err := GenerateBlocks(3, key)
+		// Assert
+		assert.Nil(t, err)
+		assert.Len(t, blocks, 3)
+		assert.Len(t, Blockchain, 4)
+		for i := 1; i < len(Blockchain); i++ {
+			assert.Equal(t, key.Y, Blockchain[i].Miner.Y)
+			assert.Equal(t, HashBlock(Blockchain[i-1]), Blockchain[i].PreviousBlockHash)
+		}
+	})
+	t.Run("It refuses to generate blocks outside regtest", func(t *testing.T) {
+		// Act
+		_, err := GenerateBlocks(1, PublicKey{})
+		// Assert
+		assert.ErrorContains(t, err, "testnet")
+	})
+	t.Run("It refuses to generate more blocks than the cap", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		// Act
+		blocks, err := GenerateBlocks(MaxGenerateBlocks+1, key)
+		// Assert
+		assert.NotNil(t, err)
+		assert.Empty(t, blocks)
+		assert.Len(t, Blockchain, 1)
+	})
+}
+
+func TestGenerateRPC(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(HandleRPCRequest))
+	defer server.Close()
+	client := &Client{Url: server.URL, HttpClient: server.Client()}
+	t.Run("It returns the hashes of the generated blocks", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		// Act
+		hashes, err := client.Generate(2, key.Y)
+		// Assert
+		assert.Nil(t, err)
+		assert.Len(t, hashes, 2)
+		height, err := client.GetHeight()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, height)
+	})
+	t.Run("It rejects block counts above the cap", func(t *testing.T) {
+		// Arrange
+		key := useRegtest(t)
+		// Act
+		_, err := client.Generate(MaxGenerateBlocks+1, key.Y)
+		// Assert
+		rpcErr, ok := err.(*RPCError)
+		assert.True(t, ok)
+		assert.Equal(t, InvalidParamsCode, rpcErr.Code)
+		assert.Len(t, Blockchain, 1)
+	})
+}
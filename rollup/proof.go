@@ -0,0 +1,24 @@
+package rollup
+
+import (
+	. "cryptocurrency/node_util"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// VerifyL2Batch checks a proof, for example from RequestTransactionProof, that a rollup transaction was mined in the block with the given Merkle root. It returns the L2 transactions the rollup carries, so they can be trusted without downloading the block.
+func VerifyL2Batch(proof TransactionProof, merkleRoot [32]byte) ([]string, bool) {
+	if proof.MerkleRoot != hex.EncodeToString(merkleRoot[:]) || !VerifyTransactionProof(proof) {
+		return nil, false
+	}
+	body := string(OriginalBody(proof.Transaction.Body))
+	// Rollups are sent with their body as a JSON string
+	var decoded string
+	if err := json.Unmarshal([]byte(body), &decoded); err == nil {
+		body = decoded
+	}
+	if !BodyContainsL2Transactions(body) {
+		return nil, false
+	}
+	return SeperateL2Transactions(body), true
+}
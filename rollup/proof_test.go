@@ -0,0 +1,46 @@
+package rollup
+
+import (
+	. "cryptocurrency/node_util"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyL2Batch(t *testing.T) {
+	// Arrange
+	l2Transactions := []string{"== BEGIN L2 TRANSACTION ==\na\nb\n1\n", "== BEGIN L2 TRANSACTION ==\nb\na\n2\n"}
+	body, err := json.Marshal(CombineL2Transactions(l2Transactions))
+	if err != nil {
+		panic(err)
+	}
+	key := PublicKey{Y: []byte("rollup")}
+	rollup := Transaction{Sender: key, Recipient: key, Timestamp: time.Unix(0, 1), Body: body}
+	transactions := []Transaction{{Sender: key, Recipient: key, Amount: 1, Timestamp: time.Unix(0, 2)}, rollup, {Sender: key, Recipient: key, Amount: 2, Timestamp: time.Unix(0, 3)}}
+	root := TransactionsMerkleRoot(transactions, BinaryBlockVersion)
+	// The proof is sent over the network, so the body picks up another layer of encoding
+	proofBytes, err := json.Marshal(TransactionProof{
+		Id:           TransactionId(rollup),
+		MerkleRoot:   hex.EncodeToString(root[:]),
+		BlockVersion: BinaryBlockVersion,
+		Transaction:  rollup,
+		Proof:        NewMerkleProof(transactions, 1, BinaryBlockVersion),
+	})
+	if err != nil {
+		panic(err)
+	}
+	var proof TransactionProof
+	if err := json.Unmarshal(proofBytes, &proof); err != nil {
+		panic(err)
+	}
+	// Act
+	result, ok := VerifyL2Batch(proof, root)
+	_, wrongRootOk := VerifyL2Batch(proof, [32]byte{1})
+	// Assert
+	assert.True(t, ok)
+	assert.Equal(t, SeperateL2Transactions(CombineL2Transactions(l2Transactions)), result)
+	assert.False(t, wrongRootOk)
+}
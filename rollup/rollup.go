@@ -2,7 +2,6 @@ package rollup
 
 import (
 	"bytes"
-	"crypto/sha256"
 	. "cryptocurrency/node_util"
 	"encoding/json"
 	"fmt"
@@ -13,6 +12,9 @@ import (
 	"time"
 )
 
+var L2TransactionsCounter = NewCounter("polycash_l2_transactions_received_total", "L2 transactions received for rollups.")
+var L2RollupsCounter = NewCounter("polycash_l2_rollups_submitted_total", "L2 rollup transactions sent to peers for mining.")
+
 var nextTransactions []string
 var nextTransactionPeerIps []string
 var nextTransactionSignatures [][]byte
@@ -24,6 +26,7 @@ func HandleTransactionRequest(_ http.ResponseWriter, req *http.Request) {
 		panic(err)
 	}
 	transaction := string(bodyBytes)
+	L2TransactionsCounter.Inc()
 	// Add transaction to nextTransactions
 	nextTransactions = append(nextTransactions, transaction)
 	// Get IP address of requester
@@ -72,9 +75,7 @@ func HandleTransactionRequest(_ http.ResponseWriter, req *http.Request) {
 		rollup += "0.0"
 		rollup += "$"
 		timestamp := time.Now().UnixNano()
-		transactionStr := fmt.Sprintf("%s:%s:%s:%d", key.PublicKey.Y, key.PublicKey.Y, "0", timestamp)
-		hash := sha256.Sum256([]byte(transactionStr))
-		fmt.Println(transactionStr)
+		hash := TransactionSigningHashFor(BlockVersionAt(len(Blockchain)), key.PublicKey, key.PublicKey, "0", timestamp, 0)
 		sigBytes, err := key.X.Sign(hash[:])
 		if err != nil {
 			panic(err)
@@ -108,6 +109,7 @@ func HandleTransactionRequest(_ http.ResponseWriter, req *http.Request) {
 		rollup += string(signaturesStr)
 		// Send rollup to all peers
 		fmt.Println("Sending rollup to peers...")
+		L2RollupsCounter.Inc()
 		for _, peer := range GetPeers() {
 			req, err := http.NewRequest(http.MethodGet, peer+"/mine", strings.NewReader(rollup))
 			if err != nil {
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/open-quantum-safe/liboqs-go/oqs"
 )
@@ -85,7 +86,9 @@ func GetL2TokenBalances() map[string]uint64 {
 				foundValidSignature := false
 				for _, signature := range transaction.BodySignatures {
 					// Check if the signature is valid using the sender's public key
+					start := time.Now()
 					isValid, err := verifier.Verify(transaction.Body, signature.S, sender.Y)
+					SignatureVerificationSeconds.ObserveSince(start)
 					if err != nil {
 						panic(err)
 					}
@@ -0,0 +1,288 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	. "cryptocurrency/node_util"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// RemoteTemplateRefresh is how often MineBlock fetches a new block template.
+	RemoteTemplateRefresh = 10 * time.Second
+	// RemotePoolDryWait is how long MineBlock waits before asking again when the node has no transactions to mine.
+	RemotePoolDryWait = time.Second
+)
+
+// Client is a JSON-RPC client for a node's /rpc endpoint.
+type Client struct {
+	Url        string
+	HttpClient *http.Client
+	nextId     int64
+}
+
+// NewClient creates a client for the node at url, e.g. "http://localhost:8080".
+func NewClient(url string) *Client {
+	return &Client{
+		Url:        url + "/rpc",
+		HttpClient: http.DefaultClient,
+	}
+}
+
+// Call invokes method with params and unmarshals the result into result. Errors returned by the node are of type *RPCError.
+func (c *Client) Call(method string, params interface{}, result interface{}) error {
+	request := RPCRequest{
+		JsonRpc: "2.0",
+		Method:  method,
+		Id:      json.RawMessage(strconv.FormatInt(atomic.AddInt64(&c.nextId, 1), 10)),
+	}
+	if params != nil {
+		paramsBytes, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		request.Params = paramsBytes
+	}
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	res, err := c.HttpClient.Post(c.Url, "application/json", bytes.NewReader(requestBytes))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	responseBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	var response RPCResponse
+	if err := json.Unmarshal(responseBytes, &response); err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if len(response.Result) == 0 {
+		return errors.New("empty result")
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+func (c *Client) GetHeight() (int, error) {
+	var height int
+	err := c.Call("getHeight", nil, &height)
+	return height, err
+}
+
+func (c *Client) GetBlockByHeight(height int) (BlockResult, error) {
+	var result BlockResult
+	err := c.Call("getBlockByHeight", HeightParams{Height: height}, &result)
+	return result, err
+}
+
+func (c *Client) GetBlockByHash(hash string) (BlockResult, error) {
+	var result BlockResult
+	err := c.Call("getBlockByHash", HashParams{Hash: hash}, &result)
+	return result, err
+}
+
+func (c *Client) GetTransaction(id string) (TransactionResult, error) {
+	var result TransactionResult
+	err := c.Call("getTransaction", IdParams{Id: id}, &result)
+	return result, err
+}
+
+func (c *Client) GetReceipt(id string) (Receipt, error) {
+	var result Receipt
+	err := c.Call("getReceipt", IdParams{Id: id}, &result)
+	return result, err
+}
+
+func (c *Client) GetBalance(publicKey PublicKey) (float64, error) {
+	var result BalanceResult
+	err := c.Call("getBalance", PublicKeyParams{PublicKey: publicKey.Y}, &result)
+	return result.Balance, err
+}
+
+func (c *Client) GetFromState(address string) (string, error) {
+	var result StateResult
+	err := c.Call("getFromState", AddressParams{Address: address}, &result)
+	return result.Data, err
+}
+
+// GetFromStateAt reads address from the state after the block at height.
+func (c *Client) GetFromStateAt(address string, height int) (string, error) {
+	var result StateResult
+	err := c.Call("getFromState", AddressParams{Address: address, Height: &height}, &result)
+	return result.Data, err
+}
+
+// Generate mines count blocks paying miner on a regtest node and returns their hashes. A nil miner pays the node's own key.
+func (c *Client) Generate(count int, miner []byte) ([]string, error) {
+	var result GenerateResult
+	err := c.Call("generate", GenerateParams{Count: count, PublicKey: miner}, &result)
+	return result.Hashes, err
+}
+
+// GetBlockTemplate fetches a template for the next block paid to miner. A nil miner pays the node's own key.
+func (c *Client) GetBlockTemplate(miner []byte) (BlockTemplateResult, error) {
+	var result BlockTemplateResult
+	var params interface{}
+	if miner != nil {
+		params = PublicKeyParams{PublicKey: miner}
+	}
+	err := c.Call("getBlockTemplate", params, &result)
+	return result, err
+}
+
+// SubmitBlock submits a nonce that solves the template with the given id.
+func (c *Client) SubmitBlock(id string, nonce int64) (BlockResult, error) {
+	var result BlockResult
+	err := c.Call("submitBlock", SubmitBlockParams{Id: id, Nonce: nonce}, &result)
+	return result, err
+}
+
+// MineBlock mines one block paid to miner on the node using engine. It fetches a fresh template every RemoteTemplateRefresh, so new transactions and blocks are picked up, and waits while the node's mining pool is empty.
+func (c *Client) MineBlock(ctx context.Context, engine *MiningEngine, miner []byte) (BlockResult, error) {
+	for {
+		result, err := c.GetBlockTemplate(miner)
+		if err != nil {
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) || rpcErr.Message != ErrPoolDry.Error() {
+				return BlockResult{}, err
+			}
+			select {
+			case <-ctx.Done():
+				return BlockResult{}, ctx.Err()
+			case <-time.After(RemotePoolDryWait):
+			}
+			continue
+		}
+		template, err := result.Template()
+		if err != nil {
+			return BlockResult{}, err
+		}
+		search, cancel := context.WithTimeout(ctx, RemoteTemplateRefresh)
+		nonce, err := engine.Solve(search, template)
+		cancel()
+		if ctx.Err() != nil {
+			return BlockResult{}, ctx.Err()
+		}
+		if err != nil {
+			continue
+		}
+		block, err := c.SubmitBlock(result.Id, nonce)
+		if err != nil {
+			var rpcErr *RPCError
+			if errors.As(err, &rpcErr) && rpcErr.Message == ErrStaleTemplate.Error() {
+				continue
+			}
+			return BlockResult{}, err
+		}
+		return block, nil
+	}
+}
+
+func (c *Client) GetPeers() ([]string, error) {
+	var peers []string
+	err := c.Call("getPeers", nil, &peers)
+	return peers, err
+}
+
+// SignTransaction builds the parameters for sendTransaction, signed with key for a block of the given version (see BlockVersion). A fee of 0 pays the network's minimum fee.
+func SignTransaction(key PrivateKey, recipient PublicKey, amount float64, fee float64, body []byte, version int) (TransactionParams, error) {
+	timestamp := time.Now().UnixNano()
+	amountStr := strconv.FormatFloat(amount, 'f', -1, 64)
+	hash := TransactionSigningHashFor(version, key.PublicKey, recipient, amountStr, timestamp, fee)
+	sig, err := key.X.Sign(hash[:])
+	if err != nil {
+		return TransactionParams{}, err
+	}
+	return TransactionParams{
+		Sender:    key.PublicKey.Y,
+		Recipient: recipient.Y,
+		Amount:    amount,
+		Signature: sig,
+		Timestamp: timestamp,
+		Body:      body,
+		Fee:       fee,
+	}, nil
+}
+
+// SendTransaction signs a transaction with key and submits it, returning the transaction ID. A fee of 0 pays the network's minimum fee.
+func (c *Client) SendTransaction(key PrivateKey, recipient PublicKey, amount float64, fee float64, body []byte) (string, error) {
+	version, err := c.BlockVersion()
+	if err != nil {
+		return "", err
+	}
+	params, err := SignTransaction(key, recipient, amount, fee, body, version)
+	if err != nil {
+		return "", err
+	}
+	var result SubmitResult
+	err = c.Call("sendTransaction", params, &result)
+	return result.Id, err
+}
+
+// BlockVersion asks the node for the version of its next block, which decides how transactions are signed.
+func (c *Client) BlockVersion() (int, error) {
+	var version int
+	err := c.Call("getBlockVersion", nil, &version)
+	return version, err
+}
+
+// EstimateFee asks the node for a fee that gets a transaction mined within blocks blocks.
+func (c *Client) EstimateFee(blocks int) (FeeEstimate, error) {
+	var estimate FeeEstimate
+	err := c.Call("estimateFee", EstimateFeeParams{Blocks: blocks}, &estimate)
+	return estimate, err
+}
+
+// DeployContract signs the contract source with key and submits it in a zero-value transaction to the deployer, returning the transaction ID.
+func (c *Client) DeployContract(key PrivateKey, contents string) (string, error) {
+	hash := sha256.Sum256([]byte(contents))
+	partySig, err := key.X.Sign(hash[:])
+	if err != nil {
+		return "", err
+	}
+	contract := Contract{
+		Contents: contents,
+		Parties: []ContractParty{
+			{
+				PublicKey: key.PublicKey,
+				Signature: Signature{S: partySig},
+			},
+		},
+	}
+	version, err := c.BlockVersion()
+	if err != nil {
+		return "", err
+	}
+	params, err := SignTransaction(key, key.PublicKey, 0, 0, nil, version)
+	if err != nil {
+		return "", err
+	}
+	params.Contracts = []Contract{contract}
+	var result SubmitResult
+	err = c.Call("deployContract", params, &result)
+	return result.Id, err
+}
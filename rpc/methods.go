@@ -0,0 +1,483 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package rpc
+
+import (
+	. "cryptocurrency/node_util"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var rpcMethods = map[string]func(json.RawMessage) (interface{}, error){
+	"getHeight":        GetHeightMethod,
+	"getBlockByHeight": GetBlockByHeightMethod,
+	"getBlockByHash":   GetBlockByHashMethod,
+	"getTransaction":   GetTransactionMethod,
+	"getReceipt":       GetReceiptMethod,
+	"getBalance":       GetBalanceMethod,
+	"getFromState":     GetFromStateMethod,
+	"getPeers":         GetPeersMethod,
+	"sendTransaction":  SendTransactionMethod,
+	"deployContract":   DeployContractMethod,
+	"generate":         GenerateMethod,
+	"getBlockTemplate": GetBlockTemplateMethod,
+	"submitBlock":      SubmitBlockMethod,
+	"estimateFee":      EstimateFeeMethod,
+	"getBlockVersion":  GetBlockVersionMethod,
+}
+
+type HeightParams struct {
+	Height int `json:"height"`
+}
+
+type HashParams struct {
+	Hash string `json:"hash"`
+}
+
+type IdParams struct {
+	Id string `json:"id"`
+}
+
+type PublicKeyParams struct {
+	PublicKey []byte `json:"publicKey"`
+}
+
+type AddressParams struct {
+	Address string `json:"address"`
+	// Height, if given, reads the state after that block instead of the last one.
+	Height *int `json:"height,omitempty"`
+}
+
+type TransactionParams struct {
+	Sender         []byte      `json:"sender"`
+	Recipient      []byte      `json:"recipient"`
+	Amount         float64     `json:"amount"`
+	Signature      []byte      `json:"signature"`
+	Timestamp      int64       `json:"timestamp"`
+	Contracts      []Contract  `json:"contracts"`
+	Body           []byte      `json:"body"`
+	BodySignatures []Signature `json:"bodySignatures"`
+	// Fee is signed with the transaction and paid in full. Leave it out to pay the network's minimum fee.
+	Fee float64 `json:"fee,omitempty"`
+}
+
+type GenerateParams struct {
+	Count     int    `json:"count"`
+	PublicKey []byte `json:"publicKey"`
+}
+
+type EstimateFeeParams struct {
+	Blocks int `json:"blocks"`
+}
+
+type SubmitBlockParams struct {
+	Id    string `json:"id"`
+	Nonce int64  `json:"nonce"`
+}
+
+// BlockTemplateResult is a template for external mining software. A block solves it when its hash, read as a big-endian uint64, is at most Target.
+type BlockTemplateResult struct {
+	Id                              string          `json:"id"`
+	Height                          int             `json:"height"`
+	PreviousBlockHash               string          `json:"previousBlockHash"`
+	Miner                           []byte          `json:"miner"`
+	Coinbase                        []Transaction   `json:"coinbase"`
+	Difficulty                      uint64          `json:"difficulty"`
+	Target                          uint64          `json:"target"`
+	Timestamp                       time.Time       `json:"timestamp"`
+	Transactions                    []Transaction   `json:"transactions"`
+	Transition                      StateTransition `json:"transition"`
+	PreMiningTimeVerifiers          []PublicKey     `json:"preMiningTimeVerifiers"`
+	PreMiningTimeVerifierSignatures []Signature     `json:"preMiningTimeVerifierSignatures"`
+	// MerkleRoot is hex-encoded, and empty before the Manila upgrade.
+	MerkleRoot string `json:"merkleRoot,omitempty"`
+	// TransitionHash is hex-encoded, and empty before the Oslo upgrade.
+	TransitionHash string `json:"transitionHash,omitempty"`
+	// StateRoot is hex-encoded, and empty before the Paris upgrade.
+	StateRoot string `json:"stateRoot,omitempty"`
+	Version   int    `json:"version"`
+}
+
+type BlockResult struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+	Block  Block  `json:"block"`
+}
+
+type TransactionResult struct {
+	Id            string      `json:"id"`
+	Pending       bool        `json:"pending"`
+	BlockHeight   int         `json:"blockHeight"`
+	BlockHash     string      `json:"blockHash"`
+	Index         int         `json:"index"`
+	Confirmations int         `json:"confirmations"`
+	Transaction   Transaction `json:"transaction"`
+}
+
+type BalanceResult struct {
+	Balance float64 `json:"balance"`
+}
+
+type StateResult struct {
+	Data string `json:"data"`
+}
+
+type SubmitResult struct {
+	Id string `json:"id"`
+}
+
+type GenerateResult struct {
+	Height int      `json:"height"`
+	Hashes []string `json:"hashes"`
+}
+
+func blockResult(height int) BlockResult {
+	block := Blockchain[height]
+	hash := HashBlock(block)
+	return BlockResult{
+		Height: height,
+		Hash:   hex.EncodeToString(hash[:]),
+		Block:  block,
+	}
+}
+
+func GetHeightMethod(_ json.RawMessage) (interface{}, error) {
+	return len(Blockchain) - 1, nil
+}
+
+func GetBlockByHeightMethod(params json.RawMessage) (interface{}, error) {
+	var p HeightParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Height < 0 || p.Height >= len(Blockchain) {
+		return nil, notFound(fmt.Sprintf("Block %d out of range", p.Height))
+	}
+	return blockResult(p.Height), nil
+}
+
+func GetBlockByHashMethod(params json.RawMessage) (interface{}, error) {
+	var p HashParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	hashBytes, err := hex.DecodeString(p.Hash)
+	if err != nil || len(hashBytes) != 64 {
+		return nil, invalidParams(fmt.Errorf("invalid block hash %q", p.Hash))
+	}
+	info, ok := GetBlockInfoByHash(p.Hash)
+	if !ok {
+		return nil, notFound("Block not found")
+	}
+	return blockResult(info.Height), nil
+}
+
+func GetTransactionMethod(params json.RawMessage) (interface{}, error) {
+	var p IdParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if info, ok := GetTransactionInfo(p.Id); ok {
+		return TransactionResult{
+			Id:            p.Id,
+			BlockHeight:   info.BlockHeight,
+			BlockHash:     info.BlockHash,
+			Index:         info.Index,
+			Confirmations: info.Confirmations,
+			Transaction:   info.Transaction,
+		}, nil
+	}
+	for j, transaction := range MiningTransactions {
+		if TransactionId(transaction) == p.Id {
+			return TransactionResult{
+				Id:          p.Id,
+				Pending:     true,
+				BlockHeight: -1,
+				Index:       j,
+				Transaction: transaction,
+			}, nil
+		}
+	}
+	return nil, notFound("Transaction not found")
+}
+
+func GetReceiptMethod(params json.RawMessage) (interface{}, error) {
+	var p IdParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	return GetReceipt(p.Id), nil
+}
+
+func GetBalanceMethod(params json.RawMessage) (interface{}, error) {
+	var p PublicKeyParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	return BalanceResult{Balance: GetBalance(p.PublicKey)}, nil
+}
+
+func GetFromStateMethod(params json.RawMessage) (interface{}, error) {
+	var p AddressParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Height == nil {
+		data, _ := GetStateValue(p.Address)
+		return StateResult{Data: hex.EncodeToString(data)}, nil
+	}
+	state, err := StateAt(*p.Height)
+	if err != nil {
+		return nil, notFound(err.Error())
+	}
+	return StateResult{Data: hex.EncodeToString(state.Data[p.Address])}, nil
+}
+
+func GetPeersMethod(_ json.RawMessage) (interface{}, error) {
+	peers := GetPeers()
+	if peers == nil {
+		peers = []string{}
+	}
+	return peers, nil
+}
+
+func submitTransaction(p TransactionParams) (interface{}, error) {
+	id, err := SubmitTransaction(p)
+	if err != nil {
+		return "", err
+	}
+	return SubmitResult{Id: id}, nil
+}
+
+// SubmitTransaction verifies a signed transaction and adds it to this node's mining pool, or forwards it to peers if the node is not mining. It returns the transaction's ID.
+func SubmitTransaction(p TransactionParams) (string, error) {
+	sender := PublicKey{Y: p.Sender}
+	recipient := PublicKey{Y: p.Recipient}
+	amountStr := strconv.FormatFloat(p.Amount, 'f', -1, 64)
+	timestamp := time.Unix(0, p.Timestamp)
+	ChainMutex.Lock()
+	valid := VerifyTransactionWithFee(sender, recipient, amountStr, timestamp, p.Fee, p.Signature)
+	ChainMutex.Unlock()
+	if !valid {
+		return "", rejected("Transaction is invalid")
+	}
+	if p.Fee > 0 && p.Fee < MinimumFee(Transaction{Contracts: p.Contracts, Body: p.Body}) {
+		return "", rejected("Transaction fee is below the minimum fee")
+	}
+	if p.Contracts == nil {
+		p.Contracts = make([]Contract, 0)
+	}
+	if p.BodySignatures == nil {
+		p.BodySignatures = make([]Signature, 0)
+	}
+	sigStr, err := json.Marshal(Signature{S: p.Signature})
+	if err != nil {
+		return "", err
+	}
+	contractsStr, err := json.Marshal(p.Contracts)
+	if err != nil {
+		return "", err
+	}
+	bodyStr, err := json.Marshal(p.Body)
+	if err != nil {
+		return "", err
+	}
+	bodySignaturesStr, err := json.Marshal(p.BodySignatures)
+	if err != nil {
+		return "", err
+	}
+	body := fmt.Sprintf("%s$%s$%s$%s$%d$%s$%s$%s", EncodePublicKey(sender), EncodePublicKey(recipient), amountStr, sigStr, p.Timestamp, contractsStr, bodyStr, bodySignaturesStr)
+	if p.Fee != 0 {
+		body += "$" + FormatFee(p.Fee)
+	}
+	if err := SubmitTransactionRequest([]byte(body)); err != nil {
+		return "", err
+	}
+	transaction := Transaction{
+		Sender:    sender,
+		Recipient: recipient,
+		Amount:    p.Amount,
+		Timestamp: timestamp,
+	}
+	return TransactionId(transaction), nil
+}
+
+func SendTransactionMethod(params json.RawMessage) (interface{}, error) {
+	var p TransactionParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	return submitTransaction(p)
+}
+
+func DeployContractMethod(params json.RawMessage) (interface{}, error) {
+	var p TransactionParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.Contracts) == 0 {
+		return nil, invalidParams(fmt.Errorf("no contract given"))
+	}
+	for _, contract := range p.Contracts {
+		if !VerifySmartContract(contract) {
+			return nil, rejected("Contract has an invalid party signature")
+		}
+	}
+	return submitTransaction(p)
+}
+
+func GenerateMethod(params json.RawMessage) (interface{}, error) {
+	var p GenerateParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Count < 1 || p.Count > MaxGenerateBlocks {
+		return nil, invalidParams(fmt.Errorf("count must be between 1 and %d", MaxGenerateBlocks))
+	}
+	miner := PublicKey{Y: p.PublicKey}
+	if len(p.PublicKey) == 0 {
+		miner = GetKey("").PublicKey
+	}
+	blocks, err := GenerateBlocks(p.Count, miner)
+	if err != nil {
+		return nil, rejected(err.Error())
+	}
+	result := GenerateResult{Height: len(Blockchain) - 1, Hashes: make([]string, 0, len(blocks))}
+	for _, block := range blocks {
+		hash := HashBlock(block)
+		result.Hashes = append(result.Hashes, hex.EncodeToString(hash[:]))
+	}
+	return result, nil
+}
+
+func GetBlockTemplateMethod(params json.RawMessage) (interface{}, error) {
+	var p PublicKeyParams
+	if len(params) > 0 {
+		if err := parseParams(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	miner := PublicKey{Y: p.PublicKey}
+	if len(p.PublicKey) == 0 {
+		miner = GetKey("").PublicKey
+	}
+	id, template, err := IssueBlockTemplate(miner)
+	if err != nil {
+		return nil, rejected(err.Error())
+	}
+	return NewBlockTemplateResult(id, template), nil
+}
+
+// GetBlockVersionMethod returns the version of the next block, which decides how transactions are signed.
+func GetBlockVersionMethod(_ json.RawMessage) (interface{}, error) {
+	return BlockVersionAt(len(Blockchain)), nil
+}
+
+func EstimateFeeMethod(params json.RawMessage) (interface{}, error) {
+	p := EstimateFeeParams{Blocks: 1}
+	if len(params) > 0 {
+		if err := parseParams(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	if p.Blocks < 1 {
+		return nil, invalidParams(fmt.Errorf("blocks must be at least 1"))
+	}
+	return EstimateFee(p.Blocks), nil
+}
+
+func SubmitBlockMethod(params json.RawMessage) (interface{}, error) {
+	var p SubmitBlockParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	block, err := SubmitBlock(p.Id, p.Nonce)
+	if err != nil {
+		return nil, rejected(err.Error())
+	}
+	hash := HashBlock(block)
+	return BlockResult{Height: len(Blockchain) - 1, Hash: hex.EncodeToString(hash[:]), Block: block}, nil
+}
+
+func NewBlockTemplateResult(id string, template BlockTemplate) BlockTemplateResult {
+	result := BlockTemplateResult{
+		Id:                              id,
+		Height:                          template.Height,
+		PreviousBlockHash:               hex.EncodeToString(template.PreviousBlockHash[:]),
+		Miner:                           template.Miner.Y,
+		Coinbase:                        template.Coinbase,
+		Difficulty:                      template.Difficulty,
+		Target:                          template.Target(),
+		Timestamp:                       template.Timestamp,
+		Transactions:                    template.Transactions,
+		Transition:                      template.Transition,
+		PreMiningTimeVerifiers:          template.PreMiningTimeVerifiers,
+		PreMiningTimeVerifierSignatures: template.PreMiningTimeVerifierSignatures,
+		Version:                         template.Version,
+	}
+	if template.MerkleRoot != [32]byte{} {
+		result.MerkleRoot = hex.EncodeToString(template.MerkleRoot[:])
+	}
+	if template.TransitionHash != [32]byte{} {
+		result.TransitionHash = hex.EncodeToString(template.TransitionHash[:])
+	}
+	if template.StateRoot != [32]byte{} {
+		result.StateRoot = hex.EncodeToString(template.StateRoot[:])
+	}
+	return result
+}
+
+// Template converts the result back into a BlockTemplate that a MiningEngine can solve.
+func (r BlockTemplateResult) Template() (BlockTemplate, error) {
+	template := BlockTemplate{
+		Height:                          r.Height,
+		Miner:                           PublicKey{Y: r.Miner},
+		Coinbase:                        r.Coinbase,
+		Transactions:                    r.Transactions,
+		Transition:                      r.Transition,
+		Difficulty:                      r.Difficulty,
+		Timestamp:                       r.Timestamp,
+		PreMiningTimeVerifiers:          r.PreMiningTimeVerifiers,
+		PreMiningTimeVerifierSignatures: r.PreMiningTimeVerifierSignatures,
+		Version:                         r.Version,
+	}
+	previousBlockHash, err := hex.DecodeString(r.PreviousBlockHash)
+	if err != nil || len(previousBlockHash) != len(template.PreviousBlockHash) {
+		return BlockTemplate{}, fmt.Errorf("invalid previous block hash %q", r.PreviousBlockHash)
+	}
+	copy(template.PreviousBlockHash[:], previousBlockHash)
+	if template.Difficulty == 0 {
+		return BlockTemplate{}, fmt.Errorf("invalid difficulty 0")
+	}
+	if r.MerkleRoot != "" {
+		merkleRoot, err := hex.DecodeString(r.MerkleRoot)
+		if err != nil || len(merkleRoot) != len(template.MerkleRoot) {
+			return BlockTemplate{}, fmt.Errorf("invalid Merkle root %q", r.MerkleRoot)
+		}
+		copy(template.MerkleRoot[:], merkleRoot)
+	}
+	if r.TransitionHash != "" {
+		transitionHash, err := hex.DecodeString(r.TransitionHash)
+		if err != nil || len(transitionHash) != len(template.TransitionHash) {
+			return BlockTemplate{}, fmt.Errorf("invalid transition hash %q", r.TransitionHash)
+		}
+		copy(template.TransitionHash[:], transitionHash)
+	}
+	if r.StateRoot != "" {
+		stateRoot, err := hex.DecodeString(r.StateRoot)
+		if err != nil || len(stateRoot) != len(template.StateRoot) {
+			return BlockTemplate{}, fmt.Errorf("invalid state root %q", r.StateRoot)
+		}
+		copy(template.StateRoot[:], stateRoot)
+	}
+	return template, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package rpc
+
+import (
+	"bytes"
+	. "cryptocurrency/node_util"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// JSON-RPC 2.0 error codes. See docs/rpc.md for the full schema.
+const (
+	ParseErrorCode     = -32700
+	InvalidRequestCode = -32600
+	MethodNotFoundCode = -32601
+	InvalidParamsCode  = -32602
+	InternalErrorCode  = -32603
+	NotFoundCode       = -32000
+	RejectedCode       = -32001
+)
+
+type RPCRequest struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Id      json.RawMessage `json:"id,omitempty"`
+}
+
+type RPCResponse struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+func invalidParams(err error) *RPCError {
+	return &RPCError{Code: InvalidParamsCode, Message: "Invalid params: " + err.Error()}
+}
+
+func notFound(message string) *RPCError {
+	return &RPCError{Code: NotFoundCode, Message: message}
+}
+
+func rejected(message string) *RPCError {
+	return &RPCError{Code: RejectedCode, Message: message}
+}
+
+func parseParams(params json.RawMessage, v interface{}) *RPCError {
+	if len(params) == 0 {
+		return invalidParams(errors.New("missing params"))
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return invalidParams(err)
+	}
+	return nil
+}
+
+func handleRPCCall(request RPCRequest) RPCResponse {
+	response := RPCResponse{
+		JsonRpc: "2.0",
+		Id:      request.Id,
+	}
+	if len(response.Id) == 0 {
+		response.Id = json.RawMessage("null")
+	}
+	if request.JsonRpc != "2.0" || request.Method == "" {
+		response.Error = &RPCError{Code: InvalidRequestCode, Message: "Invalid request"}
+		return response
+	}
+	method, ok := rpcMethods[request.Method]
+	if !ok {
+		response.Error = &RPCError{Code: MethodNotFoundCode, Message: "Method not found: " + request.Method}
+		return response
+	}
+	result, err := method(request.Params)
+	if err != nil {
+		var rpcErr *RPCError
+		if errors.As(err, &rpcErr) {
+			response.Error = rpcErr
+		} else {
+			response.Error = &RPCError{Code: InternalErrorCode, Message: err.Error()}
+		}
+		return response
+	}
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		response.Error = &RPCError{Code: InternalErrorCode, Message: err.Error()}
+		return response
+	}
+	response.Result = resultBytes
+	return response
+}
+
+func writeRPCResponse(w http.ResponseWriter, response interface{}) {
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(responseBytes)
+	if err != nil {
+		Log("Failed to write RPC response.", true)
+	}
+}
+
+func HandleRPCRequest(w http.ResponseWriter, req *http.Request) {
+	bodyBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeRPCResponse(w, RPCResponse{JsonRpc: "2.0", Id: json.RawMessage("null"), Error: &RPCError{Code: ParseErrorCode, Message: "Parse error"}})
+		return
+	}
+	bodyBytes = bytes.TrimSpace(bodyBytes)
+	// Batch request
+	if len(bodyBytes) > 0 && bodyBytes[0] == '[' {
+		var requests []RPCRequest
+		if err := json.Unmarshal(bodyBytes, &requests); err != nil || len(requests) == 0 {
+			writeRPCResponse(w, RPCResponse{JsonRpc: "2.0", Id: json.RawMessage("null"), Error: &RPCError{Code: ParseErrorCode, Message: "Parse error"}})
+			return
+		}
+		var responses []RPCResponse
+		for _, request := range requests {
+			response := handleRPCCall(request)
+			if len(request.Id) == 0 {
+				// Notifications don't get a response
+				continue
+			}
+			responses = append(responses, response)
+		}
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeRPCResponse(w, responses)
+		return
+	}
+	var request RPCRequest
+	if err := json.Unmarshal(bodyBytes, &request); err != nil {
+		writeRPCResponse(w, RPCResponse{JsonRpc: "2.0", Id: json.RawMessage("null"), Error: &RPCError{Code: ParseErrorCode, Message: "Parse error"}})
+		return
+	}
+	response := handleRPCCall(request)
+	if len(request.Id) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeRPCResponse(w, response)
+}
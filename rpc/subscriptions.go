@@ -0,0 +1,308 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package rpc
+
+import (
+	"bytes"
+	. "cryptocurrency/node_util"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// SubscriptionBufferSize is the number of events a WebSocket client may fall behind by before it is disconnected.
+var SubscriptionBufferSize = 256
+
+const (
+	NewHeadsTopic            = "newHeads"
+	PendingTransactionsTopic = "pendingTransactions"
+	AddressActivityTopic     = "addressActivity"
+	StateChangesTopic        = "stateChanges"
+)
+
+type SubscribeParams struct {
+	Topic     string   `json:"topic"`
+	PublicKey []byte   `json:"publicKey,omitempty"`
+	Keys      []string `json:"keys,omitempty"`
+}
+
+type UnsubscribeParams struct {
+	Subscription string `json:"subscription"`
+}
+
+type HeaderResult struct {
+	Height            int       `json:"height"`
+	Hash              string    `json:"hash"`
+	PreviousBlockHash string    `json:"previousBlockHash"`
+	Miner             []byte    `json:"miner"`
+	Timestamp         time.Time `json:"timestamp"`
+	Difficulty        uint64    `json:"difficulty"`
+	Nonce             int64     `json:"nonce"`
+	TransactionCount  int       `json:"transactionCount"`
+}
+
+type PendingTransactionResult struct {
+	Id          string      `json:"id"`
+	Transaction Transaction `json:"transaction"`
+}
+
+type ActivityResult struct {
+	Id          string      `json:"id"`
+	Pending     bool        `json:"pending"`
+	BlockHeight int         `json:"blockHeight"`
+	Transaction Transaction `json:"transaction"`
+}
+
+type StateChangesResult struct {
+	Height  int               `json:"height"`
+	Changes map[string]string `json:"changes"`
+}
+
+type SubscriptionNotification struct {
+	JsonRpc string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  NotificationParams `json:"params"`
+}
+
+type NotificationParams struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+type wsConnection struct {
+	conn          *websocket.Conn
+	writeMutex    sync.Mutex
+	topicsMutex   sync.Mutex
+	subscriptions map[string]SubscribeParams
+	nextId        int
+}
+
+func (c *wsConnection) send(v interface{}) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return websocket.JSON.Send(c.conn, v)
+}
+
+func (c *wsConnection) notify(subscription string, result interface{}) error {
+	return c.send(SubscriptionNotification{
+		JsonRpc: "2.0",
+		Method:  "subscription",
+		Params: NotificationParams{
+			Subscription: subscription,
+			Result:       result,
+		},
+	})
+}
+
+func (c *wsConnection) handleCall(request RPCRequest) RPCResponse {
+	response := RPCResponse{
+		JsonRpc: "2.0",
+		Id:      request.Id,
+	}
+	var result interface{}
+	var rpcErr *RPCError
+	switch request.Method {
+	case "subscribe":
+		var p SubscribeParams
+		if rpcErr = parseParams(request.Params, &p); rpcErr != nil {
+			break
+		}
+		switch p.Topic {
+		case NewHeadsTopic, PendingTransactionsTopic, StateChangesTopic:
+		case AddressActivityTopic:
+			if len(p.PublicKey) == 0 {
+				rpcErr = invalidParams(fmt.Errorf("addressActivity requires a publicKey"))
+			}
+		default:
+			rpcErr = invalidParams(fmt.Errorf("unknown topic %q", p.Topic))
+		}
+		if rpcErr != nil {
+			break
+		}
+		c.topicsMutex.Lock()
+		c.nextId++
+		id := strconv.Itoa(c.nextId)
+		c.subscriptions[id] = p
+		c.topicsMutex.Unlock()
+		result = id
+	case "unsubscribe":
+		var p UnsubscribeParams
+		if rpcErr = parseParams(request.Params, &p); rpcErr != nil {
+			break
+		}
+		c.topicsMutex.Lock()
+		_, ok := c.subscriptions[p.Subscription]
+		delete(c.subscriptions, p.Subscription)
+		c.topicsMutex.Unlock()
+		result = ok
+	default:
+		// Everything else is handled like a regular RPC call
+		return handleRPCCall(request)
+	}
+	if rpcErr != nil {
+		response.Error = rpcErr
+		return response
+	}
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		response.Error = &RPCError{Code: InternalErrorCode, Message: err.Error()}
+		return response
+	}
+	response.Result = resultBytes
+	return response
+}
+
+func touches(transaction Transaction, key []byte) bool {
+	return bytes.Equal(transaction.Sender.Y, key) || bytes.Equal(transaction.Recipient.Y, key)
+}
+
+// notifications returns the results to send to a subscription for an event.
+func notifications(subscription SubscribeParams, event Event) []interface{} {
+	var results []interface{}
+	switch subscription.Topic {
+	case NewHeadsTopic:
+		if event.Type != BlockEvent {
+			break
+		}
+		hash := HashBlock(event.Block)
+		results = append(results, HeaderResult{
+			Height:            event.Height,
+			Hash:              hex.EncodeToString(hash[:]),
+			PreviousBlockHash: hex.EncodeToString(event.Block.PreviousBlockHash[:]),
+			Miner:             event.Block.Miner.Y,
+			Timestamp:         event.Block.Timestamp,
+			Difficulty:        event.Block.Difficulty,
+			Nonce:             event.Block.Nonce,
+			TransactionCount:  len(event.Block.Transactions),
+		})
+	case PendingTransactionsTopic:
+		if event.Type != TransactionEvent {
+			break
+		}
+		results = append(results, PendingTransactionResult{
+			Id:          TransactionId(event.Transaction),
+			Transaction: event.Transaction,
+		})
+	case AddressActivityTopic:
+		if event.Type == TransactionEvent && touches(event.Transaction, subscription.PublicKey) {
+			results = append(results, ActivityResult{
+				Id:          TransactionId(event.Transaction),
+				Pending:     true,
+				BlockHeight: -1,
+				Transaction: event.Transaction,
+			})
+		}
+		if event.Type == BlockEvent {
+			for _, transaction := range event.Block.Transactions {
+				if touches(transaction, subscription.PublicKey) {
+					results = append(results, ActivityResult{
+						Id:          TransactionId(transaction),
+						BlockHeight: event.Height,
+						Transaction: transaction,
+					})
+				}
+			}
+		}
+	case StateChangesTopic:
+		if event.Type != BlockEvent || len(event.Block.Transition.UpdatedData) == 0 {
+			break
+		}
+		changes := make(map[string]string)
+		for key, value := range event.Block.Transition.UpdatedData {
+			if len(subscription.Keys) > 0 {
+				found := false
+				for _, wanted := range subscription.Keys {
+					if wanted == key {
+						found = true
+						break
+					}
+				}
+				if !found {
+					continue
+				}
+			}
+			changes[key] = hex.EncodeToString(value)
+		}
+		if len(changes) > 0 {
+			results = append(results, StateChangesResult{
+				Height:  event.Height,
+				Changes: changes,
+			})
+		}
+	}
+	return results
+}
+
+func (c *wsConnection) forwardEvents(subscription *Subscription) {
+	for event := range subscription.Events {
+		c.topicsMutex.Lock()
+		pending := make(map[string][]interface{})
+		for id, params := range c.subscriptions {
+			if results := notifications(params, event); len(results) > 0 {
+				pending[id] = results
+			}
+		}
+		c.topicsMutex.Unlock()
+		for id, results := range pending {
+			for _, result := range results {
+				if err := c.notify(id, result); err != nil {
+					subscription.Unsubscribe()
+					c.conn.Close()
+					return
+				}
+			}
+		}
+	}
+	if subscription.Lagged {
+		_ = c.send(RPCResponse{
+			JsonRpc: "2.0",
+			Id:      json.RawMessage("null"),
+			Error:   &RPCError{Code: RejectedCode, Message: "Subscriber fell too far behind"},
+		})
+		c.conn.Close()
+	}
+}
+
+func serveSubscriptions(conn *websocket.Conn) {
+	c := &wsConnection{
+		conn:          conn,
+		subscriptions: make(map[string]SubscribeParams),
+	}
+	subscription := Subscribe(SubscriptionBufferSize)
+	defer subscription.Unsubscribe()
+	go c.forwardEvents(subscription)
+	for {
+		var request RPCRequest
+		if err := websocket.JSON.Receive(conn, &request); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				_ = c.send(RPCResponse{JsonRpc: "2.0", Id: json.RawMessage("null"), Error: &RPCError{Code: ParseErrorCode, Message: "Parse error"}})
+				continue
+			}
+			return
+		}
+		response := c.handleCall(request)
+		if len(request.Id) == 0 {
+			continue
+		}
+		if err := c.send(response); err != nil {
+			return
+		}
+	}
+}
+
+func HandleWebSocketRequest(w http.ResponseWriter, req *http.Request) {
+	server := websocket.Server{Handler: serveSubscriptions}
+	server.ServeHTTP(w, req)
+}
@@ -0,0 +1,158 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	. "cryptocurrency/node_util"
+	. "cryptocurrency/rpc"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func TestRPC(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(HandleRPCRequest))
+	defer server.Close()
+	client := &Client{Url: server.URL, HttpClient: server.Client()}
+	t.Run("It returns the chain height", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		Append(Block{BlockHeader: BlockHeader{Difficulty: 1}})
+		// Act
+		height, err := client.GetHeight()
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, 1, height)
+	})
+	t.Run("It returns blocks by height and hash", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		Append(Block{BlockHeader: BlockHeader{Difficulty: 1}})
+		hash := HashBlock(Blockchain[1])
+		// Act
+		byHeight, err := client.GetBlockByHeight(1)
+		assert.Nil(t, err)
+		byHash, err := client.GetBlockByHash(hex.EncodeToString(hash[:]))
+		assert.Nil(t, err)
+		// Assert
+		assert.Equal(t, hex.EncodeToString(hash[:]), byHeight.Hash)
+		assert.Equal(t, 1, byHash.Height)
+	})
+	t.Run("It returns a not found error for blocks out of range", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		// Act
+		_, err := client.GetBlockByHeight(5)
+		// Assert
+		rpcErr, ok := err.(*RPCError)
+		assert.True(t, ok)
+		assert.Equal(t, NotFoundCode, rpcErr.Code)
+	})
+	t.Run("It returns an error for unknown methods", func(t *testing.T) {
+		// Act
+		err := client.Call("doesNotExist", nil, nil)
+		// Assert
+		rpcErr, ok := err.(*RPCError)
+		assert.True(t, ok)
+		assert.Equal(t, MethodNotFoundCode, rpcErr.Code)
+	})
+	t.Run("It finds transactions by ID", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		transaction := Transaction{
+			Sender:    PublicKey{Y: []byte("321")},
+			Recipient: PublicKey{Y: []byte("123")},
+			Amount:    5,
+		}
+		Append(Block{Transactions: []Transaction{transaction}})
+		// Act
+		result, err := client.GetTransaction(TransactionId(transaction))
+		// Assert
+		assert.Nil(t, err)
+		assert.Equal(t, 1, result.BlockHeight)
+		assert.Equal(t, 0, result.Index)
+		assert.Equal(t, float64(5), result.Transaction.Amount)
+	})
+	t.Run("It returns a parse error when the body can't be read", func(t *testing.T) {
+		// Arrange
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", iotest.ErrReader(errors.New("connection reset")))
+		// Act
+		HandleRPCRequest(recorder, req)
+		// Assert
+		var response RPCResponse
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		assert.Equal(t, ParseErrorCode, response.Error.Code)
+	})
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(HandleWebSocketRequest))
+	defer server.Close()
+	t.Run("It notifies subscribers of new blocks", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+		assert.Nil(t, err)
+		defer conn.Close()
+		err = websocket.JSON.Send(conn, RPCRequest{
+			JsonRpc: "2.0",
+			Method:  "subscribe",
+			Params:  json.RawMessage(`{"topic": "newHeads"}`),
+			Id:      json.RawMessage("1"),
+		})
+		assert.Nil(t, err)
+		var response RPCResponse
+		err = websocket.JSON.Receive(conn, &response)
+		assert.Nil(t, err)
+		assert.Nil(t, response.Error)
+		// Act
+		Append(Block{BlockHeader: BlockHeader{Difficulty: 1}})
+		// Assert
+		var notification struct {
+			Method string
+			Params struct {
+				Result HeaderResult
+			}
+		}
+		err = websocket.JSON.Receive(conn, &notification)
+		assert.Nil(t, err)
+		assert.Equal(t, "subscription", notification.Method)
+		assert.Equal(t, 1, notification.Params.Result.Height)
+	})
+}
+
+func TestPublishEvent(t *testing.T) {
+	t.Run("It drops subscribers that fall behind", func(t *testing.T) {
+		// Arrange
+		subscription := Subscribe(1)
+		// Act
+		PublishEvent(Event{Type: BlockEvent})
+		PublishEvent(Event{Type: BlockEvent})
+		// Assert
+		assert.True(t, subscription.Lagged)
+		_, ok := <-subscription.Events
+		assert.True(t, ok)
+		_, ok = <-subscription.Events
+		assert.False(t, ok)
+	})
+}
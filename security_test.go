@@ -16,22 +16,36 @@ import (
 )
 
 func TestApplySecurityLevel(t *testing.T) {
+	network := CurrentNetwork.Name
+	defer func() {
+		_ = ApplyNetworkProfile(network)
+	}()
+	_ = ApplyNetworkProfile("testnet")
 	t.Run("It sets the correct parameters for security level 0", func(t *testing.T) {
 		ApplySecurityLevel(0)
-		assert.Equal(t, InitialBlockDifficulty, SecurityLevels[0].InitialBlockDifficulty)
-		assert.Equal(t, MinimumBlockDifficulty, SecurityLevels[0].MinimumDifficulty)
-		assert.Equal(t, BlocksBeforeReward, SecurityLevels[0].BlocksBeforeReward)
+		assert.Equal(t, InitialBlockDifficulty, CurrentNetwork.SecurityLevels[0].InitialBlockDifficulty)
+		assert.Equal(t, MinimumBlockDifficulty, CurrentNetwork.SecurityLevels[0].MinimumDifficulty)
+		assert.Equal(t, BlocksBeforeReward, CurrentNetwork.SecurityLevels[0].BlocksBeforeReward)
 	})
 	t.Run("It sets the correct parameters for security level 1", func(t *testing.T) {
 		ApplySecurityLevel(1)
-		assert.Equal(t, InitialBlockDifficulty, SecurityLevels[1].InitialBlockDifficulty)
-		assert.Equal(t, MinimumBlockDifficulty, SecurityLevels[1].MinimumDifficulty)
-		assert.Equal(t, BlocksBeforeReward, SecurityLevels[1].BlocksBeforeReward)
+		assert.Equal(t, InitialBlockDifficulty, CurrentNetwork.SecurityLevels[1].InitialBlockDifficulty)
+		assert.Equal(t, MinimumBlockDifficulty, CurrentNetwork.SecurityLevels[1].MinimumDifficulty)
+		assert.Equal(t, BlocksBeforeReward, CurrentNetwork.SecurityLevels[1].BlocksBeforeReward)
 	})
 	t.Run("It sets the correct parameters for security level 2", func(t *testing.T) {
 		ApplySecurityLevel(2)
-		assert.Equal(t, InitialBlockDifficulty, SecurityLevels[2].InitialBlockDifficulty)
-		assert.Equal(t, MinimumBlockDifficulty, SecurityLevels[2].MinimumDifficulty)
-		assert.Equal(t, BlocksBeforeReward, SecurityLevels[2].BlocksBeforeReward)
+		assert.Equal(t, InitialBlockDifficulty, CurrentNetwork.SecurityLevels[2].InitialBlockDifficulty)
+		assert.Equal(t, MinimumBlockDifficulty, CurrentNetwork.SecurityLevels[2].MinimumDifficulty)
+		assert.Equal(t, BlocksBeforeReward, CurrentNetwork.SecurityLevels[2].BlocksBeforeReward)
+	})
+	t.Run("It keeps the difficulty of networks without security levels", func(t *testing.T) {
+		// Arrange
+		_ = ApplyNetworkProfile("devnet")
+		// Act
+		ApplySecurityLevel(1)
+		// Assert
+		assert.Equal(t, NetworkProfiles["devnet"].InitialBlockDifficulty, InitialBlockDifficulty)
+		assert.Equal(t, NetworkProfiles["devnet"].MinimumBlockDifficulty, MinimumBlockDifficulty)
 	})
 }
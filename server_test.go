@@ -0,0 +1,112 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+	"cryptocurrency/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleMineRequest(t *testing.T) {
+	t.Run("It rejects a malformed transaction request", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		MiningTransactions = nil
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/mine", strings.NewReader("[1 2]$[3 x]$1"))
+		// Act
+		HandleMineRequest(recorder, req)
+		// Assert
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Empty(t, MiningTransactions)
+	})
+}
+
+func TestHandleBlockRequest(t *testing.T) {
+	t.Run("It rejects a block that isn't JSON", func(t *testing.T) {
+		// Arrange
+		Blockchain = nil
+		Append(GenesisBlock())
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/block", strings.NewReader("not a block"))
+		// Act
+		HandleBlockRequest(recorder, req)
+		// Assert
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Len(t, Blockchain, 1)
+	})
+}
+
+func TestProcessMineRequest(t *testing.T) {
+	t.Run("It keeps every transaction submitted while block templates are built", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		node, recipient := h.Nodes[0], h.Nodes[1]
+		h.Mine(node, 3)
+		var transactions []rpc.TransactionParams
+		for i := 1; i <= 20; i++ {
+			params, err := rpc.SignTransaction(node.Key, recipient.Key.PublicKey, float64(i)/1000, 0, nil, BlockVersionAt(4))
+			assert.Nil(t, err)
+			transactions = append(transactions, params)
+		}
+		var pending int
+		// Act
+		node.Run(func() {
+			IsMining = true
+			var wg sync.WaitGroup
+			for _, params := range transactions {
+				wg.Add(2)
+				go func(params rpc.TransactionParams) {
+					defer wg.Done()
+					_, err := rpc.SubmitTransaction(params)
+					assert.Nil(t, err)
+				}(params)
+				go func() {
+					defer wg.Done()
+					NewBlockTemplate(node.Key.PublicKey)
+				}()
+			}
+			wg.Wait()
+			pending = len(MiningTransactions)
+		})
+		// Assert
+		assert.Equal(t, len(transactions), pending)
+	})
+	t.Run("It refuses transactions with a contract the node stops", func(t *testing.T) {
+		// Arrange
+		h := harness.New(t, 2)
+		node, recipient := h.Nodes[0], h.Nodes[1]
+		h.Mine(node, 3)
+		slow := NewProcessContractExecutor(fakeContractRuntime(t, `sleep 5`), 1)
+		slow.Timeout = 100 * time.Millisecond
+		useContractExecutor(t, slow)
+		params, err := rpc.SignTransaction(node.Key, recipient.Key.PublicKey, 0.001, 0, nil, BlockVersionAt(4))
+		assert.Nil(t, err)
+		params.Contracts = []Contract{{Contents: "a"}}
+		var pending int
+		// Act
+		node.Run(func() {
+			_, err = rpc.SubmitTransaction(params)
+			pending = len(MiningTransactions)
+		})
+		// Assert
+		assert.Nil(t, err)
+		assert.Zero(t, pending)
+	})
+}
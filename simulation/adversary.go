@@ -0,0 +1,98 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package simulation
+
+import (
+	"crypto/rand"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+)
+
+// Adversary scripts a malicious node. The node runs the normal node software, so it validates and stores blocks like any other, but it never relays blocks: it only sends what its Adversary returns.
+type Adversary interface {
+	// Mined is called when the node mines a block, which is already in its own blockchain. It returns the blocks to broadcast now.
+	Mined(s *Simulation, node *harness.Node, block Block) []Block
+	// Tick is called after every event and returns blocks to broadcast now, such as blocks withheld earlier.
+	Tick(s *Simulation, node *harness.Node) []Block
+}
+
+// Withholder mines on a private chain and publishes it later, as in selfish mining. It releases its withheld blocks when it is Lead blocks ahead of the honest nodes, when the honest nodes have caught up with the first withheld block, or when the simulation is ending. A Lead of zero never releases early.
+type Withholder struct {
+	Lead int
+
+	withheld []Block
+	first    int
+}
+
+func (w *Withholder) Mined(s *Simulation, node *harness.Node, block Block) []Block {
+	if len(w.withheld) == 0 {
+		w.first = node.Height()
+	}
+	w.withheld = append(w.withheld, block)
+	return w.Tick(s, node)
+}
+
+func (w *Withholder) Tick(s *Simulation, node *harness.Node) []Block {
+	if len(w.withheld) == 0 {
+		return nil
+	}
+	// The node gives up its private chain when it syncs to a longer honest one.
+	last := w.withheld[len(w.withheld)-1]
+	if node.Height() < w.first+len(w.withheld)-1 || HashBlock(node.Blockchain()[w.first+len(w.withheld)-1]) != HashBlock(last) {
+		w.withheld = nil
+		return nil
+	}
+	lead := node.Height() - s.HonestHeight()
+	if (w.Lead > 0 && lead >= w.Lead) || s.HonestHeight() >= w.first || s.Ending() {
+		released := w.withheld
+		w.withheld = nil
+		return released
+	}
+	return nil
+}
+
+// InvalidBlockSender gives every block it mines the wrong difficulty, so honest nodes should reject them whether they are broadcast or fetched by syncing.
+type InvalidBlockSender struct{}
+
+func (InvalidBlockSender) Mined(_ *Simulation, node *harness.Node, block Block) []Block {
+	block.Difficulty++
+	replaceTip(node, block)
+	return []Block{block}
+}
+
+func (InvalidBlockSender) Tick(*Simulation, *harness.Node) []Block {
+	return nil
+}
+
+// BogusTimeSigner adds a time verifier signature that does not verify to every block it mines. Time verifiers are not part of the block hash, so only the signature check keeps these blocks out.
+type BogusTimeSigner struct{}
+
+func (BogusTimeSigner) Mined(_ *Simulation, node *harness.Node, block Block) []Block {
+	verifier := make([]byte, 32)
+	signature := make([]byte, 32)
+	_, _ = rand.Read(verifier)
+	_, _ = rand.Read(signature)
+	block.TimeVerifiers = []PublicKey{{Y: verifier}}
+	block.TimeVerifierSignatures = []Signature{{S: signature}}
+	replaceTip(node, block)
+	return []Block{block}
+}
+
+func (BogusTimeSigner) Tick(*Simulation, *harness.Node) []Block {
+	return nil
+}
+
+// replaceTip swaps the block the node just mined for the one the adversary sends, so peers that sync from the node get it too.
+func replaceTip(node *harness.Node, block Block) {
+	node.Run(func() {
+		Blockchain[len(Blockchain)-1] = block
+		SyncIndexes()
+	})
+}
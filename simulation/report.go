@@ -0,0 +1,83 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package simulation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "cryptocurrency/node_util"
+)
+
+// Report summarises a simulation. The canonical chain is the longest blockchain held by an honest node when the simulation ends.
+type Report struct {
+	BlocksMined          int
+	AdversaryBlocksMined int
+	// Height is the height of the canonical chain.
+	Height int
+	// OrphanedBlocks is the number of mined blocks that are not in the canonical chain.
+	OrphanedBlocks int
+	// ForkRate is OrphanedBlocks divided by BlocksMined.
+	ForkRate float64
+	// AdversaryBlocksInChain is the number of canonical blocks mined by adversaries.
+	AdversaryBlocksInChain int
+	// Reorgs counts the times a node's last block was replaced by a block that does not build on it.
+	Reorgs int
+	// BlocksRejected counts blocks that nodes received and found invalid, including duplicates.
+	BlocksRejected  int
+	MessagesSent    int
+	MessagesDropped int
+	// Converged reports whether the honest nodes agreed on the last block before Scenario.ConvergenceTimeout.
+	Converged bool
+	// TimeToConvergence is how long after Scenario.Duration the honest nodes took to agree. Miners keep mining in that time.
+	TimeToConvergence time.Duration
+}
+
+func (s *Simulation) finishReport() {
+	var canonical []Block
+	for i, node := range s.Harness.Nodes {
+		if !s.IsAdversary(i) && node.Height() >= len(canonical) {
+			canonical = node.Blockchain()
+		}
+	}
+	s.report.Height = len(canonical) - 1
+	inChain := make(map[[64]byte]bool)
+	for _, block := range canonical[1:] {
+		hash := HashBlock(block)
+		inChain[hash] = true
+		if miner, ok := s.mined[hash]; ok && s.IsAdversary(miner) {
+			s.report.AdversaryBlocksInChain++
+		}
+	}
+	for hash := range s.mined {
+		if !inChain[hash] {
+			s.report.OrphanedBlocks++
+		}
+	}
+	if s.report.BlocksMined > 0 {
+		s.report.ForkRate = float64(s.report.OrphanedBlocks) / float64(s.report.BlocksMined)
+	}
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "blocks mined:        %d (%d by adversaries)\n", r.BlocksMined, r.AdversaryBlocksMined)
+	fmt.Fprintf(&b, "canonical height:    %d (%d by adversaries)\n", r.Height, r.AdversaryBlocksInChain)
+	fmt.Fprintf(&b, "orphaned blocks:     %d (fork rate %.1f%%)\n", r.OrphanedBlocks, r.ForkRate*100)
+	fmt.Fprintf(&b, "reorgs:              %d\n", r.Reorgs)
+	fmt.Fprintf(&b, "blocks rejected:     %d\n", r.BlocksRejected)
+	fmt.Fprintf(&b, "messages:            %d sent, %d dropped\n", r.MessagesSent, r.MessagesDropped)
+	if r.Converged {
+		fmt.Fprintf(&b, "time to convergence: %s\n", r.TimeToConvergence)
+	} else {
+		b.WriteString("time to convergence: did not converge\n")
+	}
+	return b.String()
+}
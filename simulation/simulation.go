@@ -0,0 +1,464 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package simulation runs many virtual nodes against a virtual clock to see how the network behaves under latency, loss, partitions and malicious peers.
+//
+// A simulation is built on a harness. Instead of serving broadcasts immediately, it queues them as events that are delivered after the link latency, or dropped. Miners find blocks at random times with the configured average interval, and every node syncs with its peers periodically. Events run one at a time in virtual time order, with the node clock set to the event's time, so an hour of network time takes a few seconds.
+package simulation
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"cryptocurrency/harness"
+	. "cryptocurrency/node_util"
+)
+
+// Scenario describes a simulation. Zero values get the defaults noted on each field.
+type Scenario struct {
+	// Nodes is the number of nodes. Defaults to 4.
+	Nodes int
+	// Miners is the number of nodes, starting from the first, that mine. Each miner has the same share of the hash rate. Defaults to every node.
+	Miners int
+	// BlockInterval is the average time between blocks across the whole network. Defaults to one minute.
+	BlockInterval time.Duration
+	// Duration is how long to simulate before waiting for the honest nodes to agree. Defaults to one hour.
+	Duration time.Duration
+	// Link is the latency and loss of every link between two nodes.
+	Link Link
+	// SyncInterval is how often each node syncs its blockchain with its peers. Defaults to ten minutes; a negative interval turns periodic syncing off.
+	SyncInterval time.Duration
+	// ConvergenceTimeout is how long the simulation waits after Duration for the honest nodes to agree. Defaults to one hour.
+	ConvergenceTimeout time.Duration
+	// Partitions split the network for part of the simulation.
+	Partitions []Partition
+	// Adversaries maps node indexes to their behaviour. Every other node is honest.
+	Adversaries map[int]Adversary
+	// Seed seeds mining times, latency jitter and loss. Runs with the same scenario and seed schedule the same events.
+	Seed int64
+	// Logs receives the nodes' log output. It is discarded if nil.
+	Logs io.Writer
+}
+
+// Link describes the network between two nodes.
+type Link struct {
+	// Latency is the delay before a message arrives.
+	Latency time.Duration
+	// Jitter is a random extra delay of up to Jitter.
+	Jitter time.Duration
+	// Loss is the probability, from 0 to 1, that a message is dropped.
+	Loss float64
+}
+
+// Partition splits the nodes into groups from Start until End. Groups hold node indexes; nodes in no group are cut off from everyone.
+type Partition struct {
+	Start  time.Duration
+	End    time.Duration
+	Groups [][]int
+}
+
+// Simulation is a running simulation. Adversaries use it to inspect the network and send blocks.
+type Simulation struct {
+	Scenario Scenario
+	Harness  *harness.Harness
+
+	tb       testing.TB
+	clock    *SimulatedClock
+	start    time.Time
+	now      time.Duration
+	queue    eventQueue
+	sequence int
+	random   *rand.Rand
+	index    map[*harness.Node]int
+	tips     []tip
+	mined    map[[64]byte]int
+	inFlight int
+	ending   bool
+	done     bool
+	report   Report
+}
+
+type tip struct {
+	height int
+	hash   [64]byte
+}
+
+// startTime is the virtual time at which every simulation begins.
+var startTime = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// New sets up a simulation: it starts the nodes, connects them all to each other and installs the virtual clock. Everything is restored when the test finishes.
+func New(tb testing.TB, scenario Scenario) *Simulation {
+	tb.Helper()
+	scenario = withDefaults(scenario)
+	for i := range scenario.Adversaries {
+		if i < 0 || i >= scenario.Nodes {
+			tb.Fatalf("simulation: adversary %d is not one of the %d nodes", i, scenario.Nodes)
+		}
+	}
+	s := &Simulation{
+		Scenario: scenario,
+		tb:       tb,
+		clock:    NewSimulatedClock(startTime),
+		start:    startTime,
+		random:   rand.New(rand.NewSource(scenario.Seed)),
+		index:    make(map[*harness.Node]int),
+		tips:     make([]tip, scenario.Nodes),
+		mined:    make(map[[64]byte]int),
+	}
+	clock, logOutput := CurrentClock, LogOutput
+	CurrentClock = s.clock
+	LogOutput = io.Discard
+	if scenario.Logs != nil {
+		LogOutput = scenario.Logs
+	}
+	tb.Cleanup(func() {
+		CurrentClock, LogOutput = clock, logOutput
+	})
+	s.Harness = harness.New(tb, scenario.Nodes)
+	s.Harness.ConnectAll()
+	s.Harness.Intercept = s.intercept
+	genesisHash := HashBlock(GenesisBlock())
+	for i, node := range s.Harness.Nodes {
+		s.index[node] = i
+		s.tips[i] = tip{hash: genesisHash}
+	}
+	return s
+}
+
+func withDefaults(scenario Scenario) Scenario {
+	if scenario.Nodes == 0 {
+		scenario.Nodes = 4
+	}
+	if scenario.Miners == 0 {
+		scenario.Miners = scenario.Nodes
+	}
+	if scenario.BlockInterval == 0 {
+		scenario.BlockInterval = time.Minute
+	}
+	if scenario.Duration == 0 {
+		scenario.Duration = time.Hour
+	}
+	if scenario.SyncInterval == 0 {
+		scenario.SyncInterval = 10 * time.Minute
+	}
+	if scenario.ConvergenceTimeout == 0 {
+		scenario.ConvergenceTimeout = time.Hour
+	}
+	return scenario
+}
+
+// Run is shorthand for New followed by Simulation.Run.
+func Run(tb testing.TB, scenario Scenario) Report {
+	tb.Helper()
+	return New(tb, scenario).Run()
+}
+
+// Run mines for Scenario.Duration, waits for the honest nodes to converge and reports what happened. A simulation can only be run once.
+func (s *Simulation) Run() Report {
+	s.tb.Helper()
+	if s.done {
+		s.tb.Fatal("simulation: already run")
+	}
+	s.done = true
+	rejected := BlocksReceivedCounter.WithLabel("rejected").Value()
+	for i := 0; i < s.Scenario.Miners; i++ {
+		s.scheduleMining(i)
+	}
+	if s.Scenario.SyncInterval > 0 {
+		for i := range s.Harness.Nodes {
+			i := i
+			s.at(s.Scenario.SyncInterval*time.Duration(i+1)/time.Duration(len(s.Harness.Nodes)), func() {
+				s.sync(i)
+			})
+		}
+	}
+	for _, partition := range s.Scenario.Partitions {
+		partition := partition
+		s.at(partition.Start, func() {
+			var groups [][]*harness.Node
+			for _, group := range partition.Groups {
+				var nodes []*harness.Node
+				for _, i := range group {
+					nodes = append(nodes, s.Node(i))
+				}
+				groups = append(groups, nodes)
+			}
+			s.Harness.Partition(groups...)
+		})
+		s.at(partition.End, s.Harness.Heal)
+	}
+	// Miners keep mining after Duration, because nodes on equally long chains only switch when one of the chains grows.
+	s.at(s.Scenario.Duration, func() {
+		s.ending = true
+	})
+	deadline := s.Scenario.Duration + s.Scenario.ConvergenceTimeout
+	for s.queue.Len() > 0 {
+		e := heap.Pop(&s.queue).(event)
+		if e.at > deadline {
+			break
+		}
+		s.now = e.at
+		s.clock.Set(s.start.Add(s.now))
+		e.run()
+		s.tickAdversaries()
+		if s.ending && s.inFlight == 0 && s.honestConverged() {
+			s.report.Converged = true
+			s.report.TimeToConvergence = s.now - s.Scenario.Duration
+			break
+		}
+	}
+	s.report.BlocksRejected = int(BlocksReceivedCounter.WithLabel("rejected").Value() - rejected)
+	s.finishReport()
+	return s.report
+}
+
+// Now returns the virtual time since the simulation started.
+func (s *Simulation) Now() time.Duration {
+	return s.now
+}
+
+// Node returns the node with index i.
+func (s *Simulation) Node(i int) *harness.Node {
+	return s.Harness.Nodes[i]
+}
+
+// IsAdversary reports whether the node with index i is an adversary.
+func (s *Simulation) IsAdversary(i int) bool {
+	_, ok := s.Scenario.Adversaries[i]
+	return ok
+}
+
+// HonestHeight returns the height of the longest blockchain held by an honest node.
+func (s *Simulation) HonestHeight() int {
+	height := 0
+	for i, node := range s.Harness.Nodes {
+		if !s.IsAdversary(i) && node.Height() > height {
+			height = node.Height()
+		}
+	}
+	return height
+}
+
+// Ending reports whether Scenario.Duration has passed and the simulation is waiting for the honest nodes to agree. Adversaries should stop withholding blocks.
+func (s *Simulation) Ending() bool {
+	return s.ending
+}
+
+// Broadcast sends blocks from a node to each of its peers over the simulated links.
+func (s *Simulation) Broadcast(from *harness.Node, blocks ...Block) {
+	var peers []string
+	from.Run(func() {
+		peers = GetPeers()
+	})
+	for _, block := range blocks {
+		body, err := json.Marshal(block)
+		if err != nil {
+			s.tb.Fatal(err)
+		}
+		for _, peer := range peers {
+			if to, ok := s.Harness.NodeByUrl(peer); ok {
+				s.transmit(from, to, http.MethodGet, peer+"/block", body)
+			}
+		}
+	}
+}
+
+func (s *Simulation) scheduleMining(i int) {
+	// Each miner finds blocks as a Poisson process, so the network as a whole averages one block per BlockInterval.
+	mean := float64(s.Scenario.BlockInterval) * float64(s.Scenario.Miners)
+	s.at(s.now+time.Duration(s.random.ExpFloat64()*mean), func() {
+		s.mine(i)
+	})
+}
+
+func (s *Simulation) mine(i int) {
+	node := s.Node(i)
+	block := s.Harness.Mine(node, 1)[0]
+	s.report.BlocksMined++
+	if adversary, ok := s.Scenario.Adversaries[i]; ok {
+		s.report.AdversaryBlocksMined++
+		s.Broadcast(node, adversary.Mined(s, node, block)...)
+	}
+	// Adversaries may have replaced the block they mined, so record the one the node kept.
+	node.Run(func() {
+		s.mined[HashBlock(Blockchain[len(Blockchain)-1])] = i
+	})
+	s.observe(node)
+	s.scheduleMining(i)
+}
+
+func (s *Simulation) sync(i int) {
+	node := s.Node(i)
+	node.Run(func() {
+		SyncBlockchain(-1)
+	})
+	s.observe(node)
+	s.at(s.now+s.Scenario.SyncInterval, func() {
+		s.sync(i)
+	})
+}
+
+func (s *Simulation) tickAdversaries() {
+	indexes := make([]int, 0, len(s.Scenario.Adversaries))
+	for i := range s.Scenario.Adversaries {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		node := s.Node(i)
+		s.Broadcast(node, s.Scenario.Adversaries[i].Tick(s, node)...)
+	}
+}
+
+// intercept replaces the harness's immediate delivery. Broadcasts are queued and answered at once, as a real peer answers before it has processed the block; other requests are served immediately unless the link drops them.
+func (s *Simulation) intercept(from *harness.Node, to *harness.Node, req *http.Request) (*http.Response, error) {
+	if req.URL.Path != "/block" && req.URL.Path != "/mine" {
+		s.report.MessagesSent++
+		if s.lost(from, to) {
+			s.report.MessagesDropped++
+			return nil, fmt.Errorf("simulation: request from %s to %s was lost", from.Name, to.Name)
+		}
+		return nil, nil
+	}
+	// Adversaries choose which blocks to send themselves, through Mined and Tick.
+	if req.URL.Path == "/block" && s.IsAdversary(s.index[from]) {
+		return accepted(req), nil
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.transmit(from, to, req.Method, req.URL.String(), body)
+	return accepted(req), nil
+}
+
+// transmit queues a message for delivery after the link latency, unless the link drops it.
+func (s *Simulation) transmit(from *harness.Node, to *harness.Node, method string, url string, body []byte) {
+	s.report.MessagesSent++
+	if s.lost(from, to) {
+		s.report.MessagesDropped++
+		return
+	}
+	latency := s.Scenario.Link.Latency
+	if s.Scenario.Link.Jitter > 0 {
+		latency += time.Duration(s.random.Int63n(int64(s.Scenario.Link.Jitter)))
+	}
+	s.inFlight++
+	s.at(s.now+latency, func() {
+		s.inFlight--
+		// The partition may have started while the message was on its way.
+		if !s.Harness.Reachable(from, to) {
+			s.report.MessagesDropped++
+			return
+		}
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			s.tb.Fatal(err)
+		}
+		s.Harness.Deliver(from, to, req)
+		s.observe(to)
+	})
+}
+
+func (s *Simulation) lost(from *harness.Node, to *harness.Node) bool {
+	return !s.Harness.Reachable(from, to) || s.random.Float64() < s.Scenario.Link.Loss
+}
+
+func accepted(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// observe counts a reorganisation if node's previous last block is no longer in its blockchain.
+func (s *Simulation) observe(node *harness.Node) {
+	i := s.index[node]
+	previous := s.tips[i]
+	var current tip
+	reorg := false
+	node.Run(func() {
+		current.height = len(Blockchain) - 1
+		current.hash = HashBlock(Blockchain[current.height])
+		if current.hash != previous.hash {
+			reorg = previous.height > current.height || HashBlock(Blockchain[previous.height]) != previous.hash
+		}
+	})
+	if reorg {
+		s.report.Reorgs++
+	}
+	s.tips[i] = current
+}
+
+func (s *Simulation) honestConverged() bool {
+	tip := ""
+	for i, node := range s.Harness.Nodes {
+		if s.IsAdversary(i) {
+			continue
+		}
+		if tip == "" {
+			tip = node.Tip()
+		} else if node.Tip() != tip {
+			return false
+		}
+	}
+	return true
+}
+
+type event struct {
+	at       time.Duration
+	sequence int
+	run      func()
+}
+
+// eventQueue orders events by time, then by the order they were scheduled in.
+type eventQueue []event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].sequence < q[j].sequence
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x any) { *q = append(*q, x.(event)) }
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+func (s *Simulation) at(t time.Duration, run func()) {
+	s.sequence++
+	heap.Push(&s.queue, event{at: t, sequence: s.sequence, run: run})
+}
@@ -0,0 +1,146 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"testing"
+	"time"
+
+	"cryptocurrency/simulation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulation(t *testing.T) {
+	t.Run("It converges without forks on a fast network", func(t *testing.T) {
+		// Arrange
+		scenario := simulation.Scenario{Nodes: 4, Duration: 30 * time.Minute, Seed: 1}
+		// Act
+		report := simulation.Run(t, scenario)
+		// Assert
+		t.Log("\n" + report.String())
+		assert.True(t, report.Converged)
+		assert.Greater(t, report.BlocksMined, 10)
+		assert.Equal(t, report.BlocksMined, report.Height)
+		assert.Equal(t, 0, report.OrphanedBlocks)
+		assert.Equal(t, 0, report.MessagesDropped)
+	})
+	t.Run("It orphans blocks when latency is close to the block interval", func(t *testing.T) {
+		// Arrange
+		scenario := simulation.Scenario{
+			Nodes:    4,
+			Duration: 30 * time.Minute,
+			Link:     simulation.Link{Latency: 20 * time.Second, Jitter: 20 * time.Second},
+			Seed:     1,
+		}
+		// Act
+		report := simulation.Run(t, scenario)
+		// Assert
+		t.Log("\n" + report.String())
+		assert.True(t, report.Converged)
+		assert.Greater(t, report.OrphanedBlocks, 0)
+		assert.Equal(t, report.BlocksMined-report.OrphanedBlocks, report.Height)
+	})
+	t.Run("It converges after a partition heals", func(t *testing.T) {
+		// Arrange
+		scenario := simulation.Scenario{
+			Nodes:    4,
+			Duration: 30 * time.Minute,
+			Partitions: []simulation.Partition{
+				{Start: 5 * time.Minute, End: 20 * time.Minute, Groups: [][]int{{0, 1}, {2, 3}}},
+			},
+			Seed: 1,
+		}
+		// Act
+		report := simulation.Run(t, scenario)
+		// Assert
+		t.Log("\n" + report.String())
+		assert.True(t, report.Converged)
+		assert.Greater(t, report.OrphanedBlocks, 0)
+		assert.Greater(t, report.Reorgs, 0)
+		assert.Greater(t, report.MessagesDropped, 0)
+	})
+	t.Run("It converges despite message loss", func(t *testing.T) {
+		// Arrange
+		scenario := simulation.Scenario{
+			Nodes:    4,
+			Duration: 30 * time.Minute,
+			Link:     simulation.Link{Latency: time.Second, Loss: 0.3},
+			Seed:     1,
+		}
+		// Act
+		report := simulation.Run(t, scenario)
+		// Assert
+		t.Log("\n" + report.String())
+		assert.True(t, report.Converged)
+		assert.Greater(t, report.MessagesDropped, 0)
+	})
+	t.Run("It keeps invalid blocks out of the chain", func(t *testing.T) {
+		// Arrange
+		scenario := simulation.Scenario{
+			Nodes:       4,
+			Duration:    30 * time.Minute,
+			Adversaries: map[int]simulation.Adversary{3: simulation.InvalidBlockSender{}},
+			Seed:        1,
+		}
+		// Act
+		report := simulation.Run(t, scenario)
+		// Assert
+		t.Log("\n" + report.String())
+		assert.True(t, report.Converged)
+		assert.Greater(t, report.AdversaryBlocksMined, 0)
+		assert.Equal(t, 0, report.AdversaryBlocksInChain)
+		assert.GreaterOrEqual(t, report.BlocksRejected, report.AdversaryBlocksMined)
+	})
+	t.Run("It rejects blocks with bogus time signatures", func(t *testing.T) {
+		// Arrange
+		scenario := simulation.Scenario{
+			Nodes:       4,
+			Duration:    30 * time.Minute,
+			Adversaries: map[int]simulation.Adversary{3: simulation.BogusTimeSigner{}},
+			Seed:        1,
+		}
+		// Act
+		report := simulation.Run(t, scenario)
+		// Assert
+		t.Log("\n" + report.String())
+		assert.True(t, report.Converged)
+		assert.Greater(t, report.AdversaryBlocksMined, 0)
+		assert.Equal(t, 0, report.AdversaryBlocksInChain)
+	})
+	t.Run("It orphans honest blocks when a miner withholds blocks", func(t *testing.T) {
+		// Arrange
+		scenario := simulation.Scenario{
+			Nodes:       4,
+			Duration:    time.Hour,
+			Adversaries: map[int]simulation.Adversary{3: &simulation.Withholder{Lead: 2}},
+			Seed:        1,
+		}
+		// Act
+		report := simulation.Run(t, scenario)
+		// Assert
+		t.Log("\n" + report.String())
+		assert.True(t, report.Converged)
+		assert.Greater(t, report.AdversaryBlocksInChain, 0)
+		assert.Greater(t, report.OrphanedBlocks, 0)
+	})
+	t.Run("It schedules the same events for the same seed", func(t *testing.T) {
+		// Arrange
+		scenario := simulation.Scenario{
+			Nodes:    3,
+			Duration: 20 * time.Minute,
+			Link:     simulation.Link{Latency: 10 * time.Second, Jitter: 10 * time.Second, Loss: 0.1},
+			Seed:     7,
+		}
+		// Act
+		first := simulation.Run(t, scenario)
+		second := simulation.Run(t, scenario)
+		// Assert
+		assert.Equal(t, first, second)
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+// useStateLimits sets how many undo records and snapshots the state database keeps for the test.
+func useStateLimits(t *testing.T, undoDepth int, snapshotInterval int) {
+	depth, interval, config := StateUndoDepth, StateSnapshotInterval, CurrentConfig
+	t.Cleanup(func() {
+		StateUndoDepth, StateSnapshotInterval, CurrentConfig = depth, interval, config
+	})
+	StateUndoDepth, StateSnapshotInterval = undoDepth, snapshotInterval
+}
+
+// appendCounterBlocks appends count blocks that each set "counter" to their height and "label" to label.
+func appendCounterBlocks(count int, label string) {
+	for i := 0; i < count; i++ {
+		appendStateBlock(StateTransition{UpdatedData: map[string][]byte{
+			"counter": []byte(fmt.Sprint(len(Blockchain))),
+			"label":   []byte(label),
+		}})
+	}
+}
+
+func TestStateDB(t *testing.T) {
+	t.Run("It follows the blockchain through reorgs", func(t *testing.T) {
+		// Arrange
+		useRegtest(t)
+		useStateLimits(t, 10, 1000)
+		appendStateBlock(StateTransition{UpdatedData: map[string][]byte{"a": []byte("1")}})
+		appendStateBlock(StateTransition{UpdatedData: map[string][]byte{"a": []byte("2"), "b": []byte("x")}})
+		// Act
+		Blockchain = Blockchain[:2]
+		appendStateBlock(StateTransition{UpdatedData: map[string][]byte{"c": []byte("y")}})
+		a, _ := GetStateValue("a")
+		_, hasB := GetStateValue("b")
+		// Assert
+		assert.Equal(t, []byte("1"), a)
+		assert.False(t, hasB)
+		assert.Equal(t, map[string][]byte{"a": []byte("1"), "c": []byte("y")}, CalculateCurrentState().Data)
+	})
+	t.Run("It rewinds historical state with the undo records and replays it from the genesis block further back on pruned nodes", func(t *testing.T) {
+		// Arrange
+		useRegtest(t)
+		useStateLimits(t, 2, 1000)
+		appendCounterBlocks(6, "pruned")
+		// Act
+		recent, recentErr := StateAt(4)
+		replayed, replayedErr := StateAt(1)
+		CurrentConfig.Archive = true
+		archived, archivedErr := StateAt(1)
+		// Assert
+		assert.Nil(t, recentErr)
+		assert.Equal(t, []byte("4"), recent.Data["counter"])
+		assert.Nil(t, replayedErr)
+		assert.Equal(t, []byte("1"), replayed.Data["counter"])
+		assert.Nil(t, archivedErr)
+		assert.Equal(t, []byte("1"), archived.Data["counter"])
+	})
+	t.Run("It rebuilds the state from a snapshot after a reorg past its undo records", func(t *testing.T) {
+		// Arrange
+		useRegtest(t)
+		useStateLimits(t, 1, 2)
+		appendCounterBlocks(8, "old")
+		// Act
+		Blockchain = Blockchain[:7]
+		appendCounterBlocks(2, "new")
+		state := CalculateCurrentState()
+		snapshots, err := filepath.Glob(filepath.Join(DataDir, StateDir, "snapshot-*.json"))
+		// Assert
+		assert.Equal(t, []byte("8"), state.Data["counter"])
+		assert.Equal(t, []byte("new"), state.Data["label"])
+		assert.Nil(t, err)
+		assert.Len(t, snapshots, StateSnapshotsKept)
+	})
+	t.Run("It saves and loads the state with the blockchain", func(t *testing.T) {
+		// Arrange
+		useRegtest(t)
+		useStateLimits(t, 10, 1000)
+		appendCounterBlocks(3, "saved")
+		SaveStateDB()
+		chain := Blockchain
+		// Act
+		Blockchain = nil
+		Append(GenesisBlock())
+		LoadStateDB()
+		Blockchain = chain
+		counter, _ := GetStateValue("counter")
+		// Assert
+		_, err := os.Stat(filepath.Join(DataDir, StateDir, "state.json"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("3"), counter)
+	})
+}
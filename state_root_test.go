@@ -0,0 +1,119 @@
+// Copyright 2024, Asher Wrobel
+/*
+This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	. "cryptocurrency/node_util"
+	"github.com/stretchr/testify/assert"
+)
+
+// appendStateBlock appends a block with the given transition, committing to the state after it.
+func appendStateBlock(transition StateTransition) {
+	state := TransitionState(CalculateCurrentState(), transition)
+	Append(Block{
+		BlockHeader: BlockHeader{
+			Version:        BlockVersionAt(len(Blockchain)),
+			TransitionHash: HashStateTransition(transition),
+			StateRoot:      StateRoot(state),
+		},
+		Transition: transition,
+	})
+}
+
+func TestStateRoot(t *testing.T) {
+	t.Run("It proves keys that are in the state and keys that aren't", func(t *testing.T) {
+		// Arrange
+		state := State{Data: map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": {}}}
+		root := StateRoot(state)
+		// Act
+		included := NewStateMerkleProof(state, "b")
+		missing := NewStateMerkleProof(state, "d")
+		// Assert
+		assert.True(t, VerifyStateMerkleProof("b", []byte("2"), true, included, root))
+		assert.False(t, VerifyStateMerkleProof("b", []byte("3"), true, included, root))
+		assert.False(t, VerifyStateMerkleProof("b", nil, false, included, root))
+		assert.True(t, VerifyStateMerkleProof("d", nil, false, missing, root))
+		assert.False(t, VerifyStateMerkleProof("d", nil, true, missing, root))
+		assert.False(t, VerifyStateMerkleProof("b", []byte("2"), true, included, StateRoot(State{Data: map[string][]byte{"b": []byte("2")}})))
+	})
+	t.Run("It updates the cached state tree to the same root as one built from the whole state", func(t *testing.T) {
+		// Arrange
+		state := State{Data: map[string][]byte{}}
+		tree := NewStateTree(state)
+		var updatedRoots, fullRoots, oldRoots, oldRootsAfter [][32]byte
+		// Act
+		for i := 0; i < 50; i++ {
+			transition := StateTransition{UpdatedData: map[string][]byte{
+				strconv.Itoa(i):     []byte("new"),
+				strconv.Itoa(i / 2): []byte(strconv.Itoa(i)),
+			}}
+			old := tree
+			oldRoots = append(oldRoots, old.Root())
+			tree = tree.Update(transition)
+			state = TransitionState(state, transition)
+			updatedRoots = append(updatedRoots, tree.Root())
+			fullRoots = append(fullRoots, StateRoot(state))
+			oldRootsAfter = append(oldRootsAfter, old.Root())
+		}
+		// Assert
+		assert.Equal(t, fullRoots, updatedRoots)
+		assert.Equal(t, oldRoots, oldRootsAfter)
+		assert.Equal(t, StateRoot(state), NewStateTree(state).Root())
+	})
+	t.Run("It rejects blocks with a wrong state root, or one before Paris", func(t *testing.T) {
+		// Arrange
+		useRegtest(t)
+		Env.Upgrades.Paris = 5
+		state := State{Data: map[string][]byte{"a": []byte("b")}}
+		block := Block{BlockHeader: BlockHeader{StateRoot: StateRoot(state)}}
+		// Act
+		valid := VerifyStateRoot(block, 5, state)
+		wrongValid := VerifyStateRoot(block, 5, State{Data: map[string][]byte{"a": []byte("c")}})
+		beforeParisValid := VerifyStateRoot(block, 4, state)
+		// Assert
+		assert.True(t, valid)
+		assert.False(t, wrongValid)
+		assert.False(t, beforeParisValid)
+		assert.True(t, VerifyStateRoot(Block{}, 4, state))
+	})
+	t.Run("It serves proofs of the state after any block from Paris", func(t *testing.T) {
+		// Arrange
+		useRegtest(t)
+		Env.Upgrades.Paris = 2
+		appendStateBlock(StateTransition{UpdatedData: map[string][]byte{"a": []byte("1")}})
+		appendStateBlock(StateTransition{UpdatedData: map[string][]byte{"a": []byte("2")}})
+		appendStateBlock(StateTransition{UpdatedData: map[string][]byte{"b": []byte("3")}})
+		// Act
+		w := httptest.NewRecorder()
+		HandleStateProofRequest(w, httptest.NewRequest(http.MethodGet, "/stateProof?key=a", nil))
+		var tip StateProof
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &tip))
+		old, oldErr := GetStateProof("a", 2)
+		missing, missingErr := GetStateProof("c", 3)
+		_, beforeParisErr := GetStateProof("a", 1)
+		// Assert
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 3, tip.BlockHeight)
+		assert.Equal(t, []byte("2"), tip.Value)
+		assert.True(t, VerifyStateProof(tip))
+		assert.Nil(t, oldErr)
+		assert.Equal(t, []byte("2"), old.Value)
+		assert.True(t, VerifyStateProof(old))
+		assert.Nil(t, missingErr)
+		assert.False(t, missing.Included)
+		assert.True(t, VerifyStateProof(missing))
+		assert.ErrorIs(t, beforeParisErr, ErrNoStateRoot)
+	})
+}
@@ -1,6 +1,7 @@
 package testing
 
 import (
+	. "cryptocurrency/node_util"
 	"fmt"
 	"os"
 	"os/exec"
@@ -10,11 +11,11 @@ func MoveBlockchain(start bool) {
 	var from string
 	var to string
 	if start {
-		from = "blockchain.json"
-		to = "blockchain_moved.json"
+		from = DataPath("blockchain.json")
+		to = DataPath("blockchain_moved.json")
 	} else {
-		from = "blockchain_moved.json"
-		to = "blockchain.json"
+		from = DataPath("blockchain_moved.json")
+		to = DataPath("blockchain.json")
 	}
 	err := os.Rename(from, to)
 	if err != nil {
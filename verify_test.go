@@ -20,9 +20,19 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// useLegacySigning moves the Oslo upgrade past the test's blocks, so transactions are signed the legacy way.
+func useLegacySigning(t *testing.T) {
+	env := Env
+	Env.Upgrades.Oslo = 100
+	t.Cleanup(func() {
+		Env = env
+	})
+}
+
 func TestVerifyTransaction(t *testing.T) {
 	t.Run("It should return true if the transaction is valid", func(t *testing.T) {
 		key := GetKey("")
+		useLegacySigning(t)
 		Blockchain = nil
 		Append(GenesisBlock())
 		sender := key.PublicKey.Y
@@ -65,6 +75,33 @@ func TestVerifyTransaction(t *testing.T) {
 		result := VerifyTransaction(key.PublicKey, key.PublicKey, amount, time.Now(), sig)
 		assert.False(t, result)
 	})
+	t.Run("It should require binary signatures from Oslo, except for transactions signed before it", func(t *testing.T) {
+		key := GetKey("")
+		env := Env
+		t.Cleanup(func() {
+			Env = env
+		})
+		Env.Upgrades.Oslo = 2
+		Blockchain = nil
+		Append(GenesisBlock())
+		pending := time.Now()
+		Append(Block{BlockHeader: BlockHeader{Timestamp: pending.Add(time.Second)}})
+		late := pending.Add(2 * time.Second)
+		sign := func(version int, timestamp time.Time) []byte {
+			hash := TransactionSigningHashFor(version, key.PublicKey, key.PublicKey, "0", timestamp.UnixNano(), 0)
+			sig, err := key.X.Sign(hash[:])
+			if err != nil {
+				panic(err)
+			}
+			return sig
+		}
+		binary := VerifyTransaction(key.PublicKey, key.PublicKey, "0", late, sign(BinaryBlockVersion, late))
+		legacyPending := VerifyTransaction(key.PublicKey, key.PublicKey, "0", pending, sign(LegacyBlockVersion, pending))
+		legacyLate := VerifyTransaction(key.PublicKey, key.PublicKey, "0", late, sign(LegacyBlockVersion, late))
+		assert.True(t, binary)
+		assert.True(t, legacyPending)
+		assert.False(t, legacyLate)
+	})
 }
 
 func TestVerifyMiner(t *testing.T) {
@@ -83,10 +120,12 @@ func TestVerifyMiner(t *testing.T) {
 		LoadEnv()
 		Append(GenesisBlock())
 		Append(Block{
-			Transactions:      []Transaction{},
-			Miner:             miner,
-			PreviousBlockHash: HashBlock(GenesisBlock()),
-			Difficulty:        1,
+			BlockHeader: BlockHeader{
+				Miner:             miner,
+				PreviousBlockHash: HashBlock(GenesisBlock()),
+				Difficulty:        1,
+			},
+			Transactions: []Transaction{},
 		})
 		result := VerifyMiner(key.PublicKey)
 		assert.False(t, result)
@@ -139,6 +178,11 @@ func TestVerifyBlock(t *testing.T) {
 			panic(err)
 		}
 		block := Block{
+			BlockHeader: BlockHeader{
+				PreviousBlockHash: HashBlock(GenesisBlock()),
+				Miner:             key.PublicKey,
+				Difficulty:        1,
+			},
 			Transactions: []Transaction{
 				{
 					Sender:          sender,
@@ -147,11 +191,42 @@ func TestVerifyBlock(t *testing.T) {
 					SenderSignature: Signature{S: sig},
 				},
 			},
-			PreviousBlockHash: HashBlock(GenesisBlock()),
-			Miner:             key.PublicKey,
-			Difficulty:        1,
 		}
 		result := VerifyBlock(block)
 		assert.False(t, result)
 	})
 }
+
+func TestVerifyDifficulty(t *testing.T) {
+	t.Run("It checks a block's difficulty against its own miner's last block", func(t *testing.T) {
+		// Arrange
+		useRegtest(t)
+		CurrentNetwork.FixedDifficulty = 0
+		miner := PublicKey{Y: []byte("miner")}
+		Append(Block{BlockHeader: BlockHeader{Miner: miner, Difficulty: 5000}, MiningTime: 30 * time.Second})
+		Append(Block{BlockHeader: BlockHeader{Miner: PublicKey{Y: []byte("other")}, Difficulty: 7000}, MiningTime: 90 * time.Second})
+		// Act
+		followsMiner := VerifyDifficulty(Block{BlockHeader: BlockHeader{Miner: miner, Difficulty: GetDifficulty(30*time.Second, 5000)}})
+		followsOther := VerifyDifficulty(Block{BlockHeader: BlockHeader{Miner: miner, Difficulty: GetDifficulty(90*time.Second, 7000)}})
+		newMiner := VerifyDifficulty(Block{BlockHeader: BlockHeader{Miner: PublicKey{Y: []byte("new")}, Difficulty: GetDifficulty(time.Minute, InitialBlockDifficulty)}})
+		// Assert
+		assert.True(t, followsMiner)
+		assert.False(t, followsOther)
+		assert.True(t, newMiner)
+	})
+	t.Run("It checks a block's difficulty against the previous block before Quito", func(t *testing.T) {
+		// Arrange
+		useRegtest(t)
+		CurrentNetwork.FixedDifficulty = 0
+		Env.Upgrades.Quito = 10
+		miner := PublicKey{Y: []byte("miner")}
+		Append(Block{BlockHeader: BlockHeader{Miner: miner, Difficulty: 5000}, MiningTime: 30 * time.Second})
+		Append(Block{BlockHeader: BlockHeader{Miner: PublicKey{Y: []byte("other")}, Difficulty: 7000}, MiningTime: 90 * time.Second})
+		// Act
+		followsMiner := VerifyDifficulty(Block{BlockHeader: BlockHeader{Miner: miner, Difficulty: GetDifficulty(30*time.Second, 5000)}})
+		followsPrevious := VerifyDifficulty(Block{BlockHeader: BlockHeader{Miner: miner, Difficulty: GetDifficulty(90*time.Second, 7000)}})
+		// Assert
+		assert.False(t, followsMiner)
+		assert.True(t, followsPrevious)
+	})
+}